@@ -0,0 +1,112 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+func main() {
+	remoteAddr := flag.String("remote", "", "Remote SCION address of a helloworld server (e.g. 17-ffaa:1:1,[127.0.0.1]:12345)")
+	interval := flag.Duration("interval", time.Second, "Interval between messages")
+	redial := flag.Int("redial", 10, "Open a new connection every N messages, to trigger fresh path lookups (0 to disable)")
+	flag.Parse()
+	if *remoteAddr == "" {
+		check(errors.New("-remote is required"))
+	}
+
+	err := run(*remoteAddr, *interval, *redial)
+	check(err)
+}
+
+func run(address string, interval time.Duration, redial int) error {
+	ctx := context.Background()
+	addr, err := pan.ResolveUDPAddr(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	// Report what happens in the path pool while the daemon is restarting.
+	events, err := pan.SubscribeEvents(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for e := range events {
+			if e.Type == pan.EventRefreshFailed {
+				fmt.Printf("Path refresh to %s failed (will be retried): %v\n", e.Destination, e.Err)
+			} else {
+				fmt.Printf("Path event: %s %s\n", e.Type, e.Fingerprint)
+			}
+		}
+	}()
+
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer func() { conn.Close() }()
+
+	buffer := make([]byte, 16*1024)
+	for i := 1; ; i++ {
+		if redial > 0 && i%redial == 0 {
+			// A new connection needs a path lookup from the daemon. While the
+			// daemon is restarting, the lookup is retried until it is back.
+			conn.Close()
+			if conn, err = dial(ctx, addr); err != nil {
+				return err
+			}
+		}
+
+		msg := fmt.Sprintf("hello %d %s", i, time.Now().Format("15:04:05.0"))
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			fmt.Println("Write failed:", err)
+		} else if err := conn.SetReadDeadline(time.Now().Add(interval)); err != nil {
+			return err
+		} else if n, err := conn.Read(buffer); err == nil {
+			fmt.Printf("Received reply via %s: %s\n", conn.GetPath(), buffer[:n])
+		} else if !errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+		time.Sleep(interval)
+	}
+}
+
+func dial(ctx context.Context, addr pan.UDPAddr) (pan.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	start := time.Now()
+	conn, err := pan.DialUDP(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Dialed %s in %s\n", addr, time.Since(start).Round(time.Millisecond))
+	return conn, nil
+}
+
+// Check just ensures the error is nil, or complains and quits
+func check(e error) {
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "Fatal error:", e)
+		os.Exit(1)
+	}
+}
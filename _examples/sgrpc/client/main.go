@@ -7,12 +7,10 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"net/netip"
 	"time"
 
 	"github.com/netsec-ethz/scion-apps/pkg/pan"
 	"github.com/netsec-ethz/scion-apps/pkg/quicutil"
-	"github.com/quic-go/quic-go"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
@@ -31,8 +29,7 @@ func NewPanQuicDialer(tlsCfg *tls.Config) func(context.Context, string) (net.Con
 			return nil, err
 		}
 
-		clientQuicConfig := &quic.Config{KeepAlivePeriod: 15 * time.Second}
-		session, err := pan.DialQUIC(ctx, netip.AddrPort{}, panAddr, nil, nil, "", tlsCfg, clientQuicConfig)
+		session, err := pan.DialQUIC(ctx, panAddr, "", tlsCfg, nil, quicutil.WithPathTimeouts())
 		if err != nil {
 			return nil, fmt.Errorf("did not dial: %w", err)
 		}
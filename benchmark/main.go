@@ -0,0 +1,314 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// scion-benchmark compares path selectors. It runs the same traffic pattern
+// against an echo server (see scion-udp-echo) once per selector, and reports
+// latency, loss and the longest outage for each of them.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+const (
+	// headerLen is the length of the request header: sequence number and send
+	// timestamp (unix nanoseconds), as for scion-udp-echo.
+	headerLen = 16
+	// maxLatency is the highest latency tracked in the histograms.
+	maxLatency = time.Minute
+)
+
+// availableSelectors are the names of the selectors that can be compared.
+var availableSelectors = []string{"default", "ping", "round-robin", "redundant"}
+
+func main() {
+	var (
+		remote      string
+		selectors   string
+		rate        int
+		duration    time.Duration
+		size        int
+		timeout     time.Duration
+		pause       time.Duration
+		sequence    string
+		preference  string
+		interactive bool
+	)
+	flag.StringVar(&remote, "remote", "", "Address of the echo server, e.g. a scion-udp-echo server")
+	flag.StringVar(&selectors, "selectors", strings.Join(availableSelectors, ","),
+		"Comma-separated list of the selectors to compare: "+strings.Join(availableSelectors, "|"))
+	flag.IntVar(&rate, "rate", 100, "Requests per second")
+	flag.DurationVar(&duration, "duration", 10*time.Second, "Duration of the test for each selector")
+	flag.IntVar(&size, "size", 64, fmt.Sprintf("Size of the requests in bytes, at least %d", headerLen))
+	flag.DurationVar(&timeout, "timeout", time.Second, "Time to wait for outstanding replies")
+	flag.DurationVar(&pause, "pause", time.Second, "Pause between the tests of two selectors")
+	flag.BoolVar(&interactive, "interactive", false, "Prompt user for interactive path selection")
+	flag.StringVar(&sequence, "sequence", "", "Sequence of space separated hop predicates to specify path")
+	flag.StringVar(&preference, "preference", "", "Preference sorting order for paths. "+
+		"Comma-separated list of available sorting options: "+
+		strings.Join(pan.AvailablePreferencePolicies, "|"))
+	flag.Parse()
+
+	if remote == "" {
+		fmt.Fprintln(os.Stderr, "Missing -remote")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if rate <= 0 || size < headerLen || duration <= 0 {
+		log.Fatalf("invalid parameters: rate must be positive, size at least %d bytes", headerLen)
+	}
+	names := strings.Split(selectors, ",")
+	for _, name := range names {
+		if _, err := dialerByName(name); err != nil {
+			log.Fatal(err)
+		}
+	}
+	policy, err := pan.PolicyFromCommandline(sequence, preference, interactive)
+	if err != nil {
+		log.Fatal(err)
+	}
+	remoteAddr, err := pan.ResolveUDPAddr(context.Background(), remote)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var results []*result
+	for i, name := range names {
+		if i > 0 {
+			time.Sleep(pause)
+		}
+		fmt.Fprintf(os.Stderr, "Running %s for %v\n", name, duration)
+		r, err := run(remoteAddr, name, policy, rate, duration, size, timeout)
+		if err != nil {
+			log.Fatalf("selector %s: %v", name, err)
+		}
+		results = append(results, r)
+	}
+	report(os.Stdout, results)
+}
+
+// dialer dials a connection to remote using a specific selector.
+type dialer func(ctx context.Context, remote pan.UDPAddr, policy pan.Policy) (pan.Conn, error)
+
+func dialerByName(name string) (dialer, error) {
+	switch name {
+	case "default":
+		return func(ctx context.Context, remote pan.UDPAddr, policy pan.Policy) (pan.Conn, error) {
+			return pan.DialUDP(ctx, remote, pan.WithPolicy(policy))
+		}, nil
+	case "ping":
+		return func(ctx context.Context, remote pan.UDPAddr, policy pan.Policy) (pan.Conn, error) {
+			selector := &pan.PingingSelector{
+				Interval: 500 * time.Millisecond,
+				Timeout:  500 * time.Millisecond,
+			}
+			selector.SetActive(4)
+			return pan.DialUDP(ctx, remote, pan.WithPolicy(policy), pan.WithSelector(selector))
+		}, nil
+	case "round-robin":
+		return func(ctx context.Context, remote pan.UDPAddr, policy pan.Policy) (pan.Conn, error) {
+			return pan.DialMultiPathUDP(ctx, remote, pan.NewRoundRobinScheduler(), pan.WithPolicy(policy))
+		}, nil
+	case "redundant":
+		return func(ctx context.Context, remote pan.UDPAddr, policy pan.Policy) (pan.Conn, error) {
+			return pan.DialMultiPathUDP(ctx, remote, pan.NewRedundantScheduler(2), pan.WithPolicy(policy))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown selector %q, available: %s",
+			name, strings.Join(availableSelectors, ", "))
+	}
+}
+
+// result collects the results for one selector.
+type result struct {
+	name      string
+	histogram *hdrhistogram.Histogram
+	// sent is the send time of each request, indexed by sequence number.
+	sent []time.Time
+	// received records whether a reply was received for each request.
+	received []bool
+	// paths is the set of paths on which replies were received.
+	paths map[pan.PathFingerprint]struct{}
+	// switches counts the changes of the connection's current path.
+	switches int
+}
+
+type client struct {
+	conn pan.Conn
+
+	mutex  sync.Mutex
+	result *result
+}
+
+// run sends requests with the selector name at the given rate, for the given
+// duration, and collects the replies.
+func run(remote pan.UDPAddr, name string, policy pan.Policy,
+	rate int, duration time.Duration, size int, timeout time.Duration) (*result, error) {
+
+	dial, err := dialerByName(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial(context.Background(), remote, policy)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	c := &client{
+		conn: conn,
+		result: &result{
+			name:      name,
+			histogram: hdrhistogram.New(1, maxLatency.Microseconds(), 3),
+			paths:     make(map[pan.PathFingerprint]struct{}),
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.receive()
+	}()
+
+	err = c.send(rate, duration, size)
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	<-done
+	if err != nil {
+		return nil, err
+	}
+	return c.result, nil
+}
+
+// send sends requests at the given rate, for the given duration.
+func (c *client) send(rate int, duration time.Duration, size int) error {
+	buf := make([]byte, size)
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	var current pan.PathFingerprint
+	end := time.Now().Add(duration)
+	for seq := uint64(0); time.Now().Before(end); seq++ {
+		<-ticker.C
+		var pf pan.PathFingerprint
+		if path := c.conn.GetPath(); path != nil {
+			pf = path.Fingerprint
+		}
+		now := time.Now()
+		binary.BigEndian.PutUint64(buf[0:8], seq)
+		binary.BigEndian.PutUint64(buf[8:16], uint64(now.UnixNano()))
+
+		c.mutex.Lock()
+		if seq > 0 && pf != current {
+			c.result.switches++
+		}
+		current = pf
+		c.result.sent = append(c.result.sent, now)
+		c.result.received = append(c.result.received, false)
+		c.mutex.Unlock()
+
+		if _, err := c.conn.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive reads replies until the read deadline expires. Duplicate replies,
+// e.g. with the redundant scheduler, are only counted once.
+func (c *client) receive() {
+	buf := make([]byte, 9000)
+	for {
+		n, path, err := c.conn.ReadVia(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			log.Println("error reading reply:", err)
+			continue
+		}
+		if n < headerLen {
+			continue
+		}
+		now := time.Now()
+		seq := binary.BigEndian.Uint64(buf[0:8])
+
+		c.mutex.Lock()
+		r := c.result
+		if seq < uint64(len(r.received)) && !r.received[seq] {
+			r.received[seq] = true
+			_ = r.histogram.RecordValue(now.Sub(r.sent[seq]).Microseconds())
+			var pf pan.PathFingerprint
+			if path != nil {
+				pf = path.Fingerprint
+			}
+			r.paths[pf] = struct{}{}
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// longestOutage returns the longest time without any reply, from the sending
+// of the first unanswered request until the sending of the next answered one
+// (or the last request, if no later request was answered). This is the
+// failover time if a path failed during the test.
+func (r *result) longestOutage() time.Duration {
+	var longest time.Duration
+	start := -1
+	for i, ok := range r.received {
+		if !ok && start < 0 {
+			start = i
+		} else if ok && start >= 0 {
+			longest = max(longest, r.sent[i].Sub(r.sent[start]))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		longest = max(longest, r.sent[len(r.sent)-1].Sub(r.sent[start]))
+	}
+	return longest
+}
+
+// report prints a table comparing the results of the selectors.
+func report(w io.Writer, results []*result) {
+	us := func(v int64) time.Duration { return time.Duration(v) * time.Microsecond }
+	fmt.Fprintf(w, "%-12s %8s %8s %7s %10s %10s %10s %10s %10s %6s %8s\n",
+		"selector", "sent", "received", "loss", "min", "p50", "p90", "p99", "outage", "paths", "switches")
+	for _, r := range results {
+		sent := int64(len(r.sent))
+		received := r.histogram.TotalCount()
+		loss := 0.0
+		if sent > 0 {
+			loss = 100 * float64(sent-received) / float64(sent)
+		}
+		fmt.Fprintf(w, "%-12s %8d %8d %6.2f%% %10v %10v %10v %10v %10v %6d %8d\n",
+			r.name, sent, received, loss,
+			us(r.histogram.Min()),
+			us(r.histogram.ValueAtQuantile(50)),
+			us(r.histogram.ValueAtQuantile(90)),
+			us(r.histogram.ValueAtQuantile(99)),
+			r.longestOutage(), len(r.paths), r.switches)
+	}
+}
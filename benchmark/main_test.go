@@ -0,0 +1,84 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testResult(name string, received []bool) *result {
+	r := &result{
+		name:      name,
+		histogram: hdrhistogram.New(1, maxLatency.Microseconds(), 3),
+		received:  received,
+	}
+	start := time.Unix(1700000000, 0)
+	for i, ok := range received {
+		r.sent = append(r.sent, start.Add(time.Duration(i)*10*time.Millisecond))
+		if ok {
+			_ = r.histogram.RecordValue(1000)
+		}
+	}
+	return r
+}
+
+func TestLongestOutage(t *testing.T) {
+	cases := []struct {
+		name     string
+		received []bool
+		expected time.Duration
+	}{
+		{"none", nil, 0},
+		{"no loss", []bool{true, true, true}, 0},
+		{"single", []bool{true, false, true}, 10 * time.Millisecond},
+		{"longest", []bool{false, true, false, false, false, true, false, true}, 30 * time.Millisecond},
+		{"until end", []bool{true, false, false, false}, 20 * time.Millisecond},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, testResult(c.name, c.received).longestOutage())
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	var out bytes.Buffer
+	report(&out, []*result{
+		testResult("default", []bool{true, true, true, true}),
+		testResult("ping", []bool{true, false, false, true}),
+	})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"default", "4", "4", "0.00%", "1ms", "1ms", "1ms", "1ms", "0s", "0", "0"},
+		strings.Fields(lines[1]))
+	assert.Equal(t, []string{"ping", "4", "2", "50.00%", "1ms", "1ms", "1ms", "1ms", "20ms", "0", "0"},
+		strings.Fields(lines[2]))
+}
+
+func TestDialerByName(t *testing.T) {
+	for _, name := range availableSelectors {
+		_, err := dialerByName(name)
+		assert.NoError(t, err, name)
+	}
+	_, err := dialerByName("fastest")
+	assert.Error(t, err)
+}
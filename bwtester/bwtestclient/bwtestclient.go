@@ -21,12 +21,14 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"math"
 	"net"
 	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -66,23 +68,29 @@ func printUsage() {
 		"all but the last one are set to the default values, e.g. ?,1000,?,5Mbps will run the test for the " +
 		"default duration and send as many packets as required to reach a bandwidth of 5 Mbps with the given " +
 		"packet size.")
-	fmt.Println("\tSupported bandwidth unit prefixes are: none (e.g. 1500bps for 1.5kbps), k, M, G, T.")
+	fmt.Println("\tThe duration is given in seconds, or with a unit, e.g. 10 or 10s or 1m.")
+	fmt.Println("\tSupported bandwidth unit prefixes are: none (e.g. 1500bps for 1.5kbps), k, M, G, T. " +
+		"Decimal values are allowed, e.g. 1.5Mbps.")
+	fmt.Println("\tThe bandwidth can also be given in percent of a previously measured maximum bandwidth, " +
+		"set with -maxbw, e.g. -maxbw 100Mbps -cs 10%")
 	fmt.Println("\tYou can also only set the target bandwidth, e.g. -cs 1Mbps")
 	fmt.Println("\tWhen only the cs or sc flag is set, the other flag is set to the same value.")
 }
 
 // Input format (time duration,packet size,number of packets,target bandwidth), no spaces, question mark ? is wildcard
 // The value of the wildcard is computed from the other values, if more than one wildcard is used,
-// all but the last one are set to the defaults values
-func parseBwtestParameters(s string, defaultPktSize int64) (bwtest.Parameters, error) {
+// all but the last one are set to the defaults values.
+// A bandwidth in percent is relative to maxBandwidth, which is 0 if not known.
+func parseBwtestParameters(s string, defaultPktSize, maxBandwidth int64) (bwtest.Parameters, error) {
 	if !strings.Contains(s, ",") {
 		// Using simple bandwidth setting with all defaults except bandwidth
 		s = "?,?,?," + s
 	}
 	a := strings.Split(s, ",")
 	if len(a) != 4 {
-		usageErr("incorrect number of arguments, need 4 values for bwtestparameters. " +
-			"You can use ? as wildcard, e.g. " + DefaultBwtestParameters)
+		return bwtest.Parameters{}, fmt.Errorf("invalid test parameters %q, need 4 values "+
+			"(duration,packet size,number of packets,bandwidth). "+
+			"You can use ? as wildcard, e.g. %s", s, DefaultBwtestParameters)
 	}
 	wildcards := 0
 	for _, v := range a {
@@ -92,12 +100,19 @@ func parseBwtestParameters(s string, defaultPktSize int64) (bwtest.Parameters, e
 	}
 
 	var a1, a2, a3, a4 int64
+	var err error
 	if a[0] == WildcardChar {
 		wildcards -= 1
 		if wildcards == 0 {
-			a2 = getPacketSize(a[1], defaultPktSize)
-			a3 = getPacketCount(a[2])
-			a4 = parseBandwidth(a[3])
+			if a2, err = getPacketSize(a[1]); err != nil {
+				return bwtest.Parameters{}, err
+			}
+			if a3, err = getPacketCount(a[2]); err != nil {
+				return bwtest.Parameters{}, err
+			}
+			if a4, err = parseBandwidth(a[3], maxBandwidth); err != nil {
+				return bwtest.Parameters{}, err
+			}
 			a1 = (a2 * 8 * a3) / a4
 			if time.Second*time.Duration(a1) > bwtest.MaxDuration {
 				fmt.Printf("Duration exceeds max: %v > %v, using default value %d\n",
@@ -114,31 +129,37 @@ func parseBwtestParameters(s string, defaultPktSize int64) (bwtest.Parameters, e
 		} else {
 			a1 = DefaultDuration
 		}
-	} else {
-		a1 = getDuration(a[0])
+	} else if a1, err = getDuration(a[0]); err != nil {
+		return bwtest.Parameters{}, err
 	}
 	if a[1] == WildcardChar {
 		wildcards -= 1
 		if wildcards == 0 {
-			a3 = getPacketCount(a[2])
-			a4 = parseBandwidth(a[3])
+			if a3, err = getPacketCount(a[2]); err != nil {
+				return bwtest.Parameters{}, err
+			}
+			if a4, err = parseBandwidth(a[3], maxBandwidth); err != nil {
+				return bwtest.Parameters{}, err
+			}
 			a2 = (a4 * a1) / (a3 * 8)
 		} else {
 			a2 = defaultPktSize
 		}
-	} else {
-		a2 = getPacketSize(a[1], defaultPktSize)
+	} else if a2, err = getPacketSize(a[1]); err != nil {
+		return bwtest.Parameters{}, err
 	}
 	if a[2] == WildcardChar {
 		wildcards -= 1
 		if wildcards == 0 {
-			a4 = parseBandwidth(a[3])
+			if a4, err = parseBandwidth(a[3], maxBandwidth); err != nil {
+				return bwtest.Parameters{}, err
+			}
 			a3 = (a4 * a1) / (a2 * 8)
 		} else {
 			a3 = DefaultPktCount
 		}
-	} else {
-		a3 = getPacketCount(a[2])
+	} else if a3, err = getPacketCount(a[2]); err != nil {
+		return bwtest.Parameters{}, err
 	}
 	if a[3] == WildcardChar {
 		wildcards -= 1
@@ -146,7 +167,9 @@ func parseBwtestParameters(s string, defaultPktSize int64) (bwtest.Parameters, e
 			fmt.Printf("Target bandwidth is %d\n", a2*a3*8/a1)
 		}
 	} else {
-		a4 = parseBandwidth(a[3])
+		if a4, err = parseBandwidth(a[3], maxBandwidth); err != nil {
+			return bwtest.Parameters{}, err
+		}
 		// allow a deviation of up to one packet per 1 second interval, since we do not send half-packets
 		expected := a2 * a3 * 8 / a1
 		leeway := 8 * a2
@@ -159,6 +182,14 @@ func parseBwtestParameters(s string, defaultPktSize int64) (bwtest.Parameters, e
 					lo, hi, a4)
 		}
 	}
+	if a2 < bwtest.MinPacketSize || a2 > bwtest.MaxPacketSize {
+		return bwtest.Parameters{}, fmt.Errorf("packet size %d out of range, must be between %d and %d bytes, "+
+			"adjust the number of packets or the bandwidth", a2, bwtest.MinPacketSize, bwtest.MaxPacketSize)
+	}
+	if a3 < 1 {
+		return bwtest.Parameters{}, fmt.Errorf("bandwidth too low to send a single packet of %d bytes "+
+			"in %d seconds", a2, a1)
+	}
 	key := prepareAESKey()
 	return bwtest.Parameters{
 		BwtestDuration: time.Second * time.Duration(a1),
@@ -169,79 +200,90 @@ func parseBwtestParameters(s string, defaultPktSize int64) (bwtest.Parameters, e
 	}, nil
 }
 
-func parseBandwidth(bw string) int64 {
-	rawBw := strings.Split(bw, "bps")
-	if len(rawBw[0]) < 1 {
-		fmt.Printf("Invalid bandwidth %v provided, using default value %d\n", bw, DefaultBW)
-		return DefaultBW
-	}
-
-	var m int64
-	val := rawBw[0][:len(rawBw[0])-1]
-	suffix := rawBw[0][len(rawBw[0])-1:]
-	switch suffix {
-	case "k":
-		m = 1e3
-	case "M":
-		m = 1e6
-	case "G":
-		m = 1e9
-	case "T":
-		m = 1e12
-	default:
-		m = 1
-		val = rawBw[0]
-		// ensure that the string ends with a digit
-		if !unicode.IsDigit(([]rune(suffix))[0]) {
-			fmt.Printf("Invalid bandwidth %v provided, using default value %d\n", val, DefaultBW)
-			return DefaultBW
+// bandwidthPrefixes are the supported unit prefixes for bandwidths.
+var bandwidthPrefixes = map[string]float64{
+	"":  1,
+	"k": 1e3,
+	"K": 1e3,
+	"M": 1e6,
+	"G": 1e9,
+	"T": 1e12,
+}
+
+// parseBandwidth parses a bandwidth in bits per second, e.g. 1500bps, 250Mbps
+// or 1.5Gbps, or a percentage of maxBandwidth, e.g. 10%.
+func parseBandwidth(bw string, maxBandwidth int64) (int64, error) {
+	if percent, ok := strings.CutSuffix(bw, "%"); ok {
+		if maxBandwidth <= 0 {
+			return 0, fmt.Errorf("bandwidth %q in percent requires the maximum bandwidth, "+
+				"set it with -maxbw, e.g. -maxbw 100Mbps", bw)
+		}
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, fmt.Errorf("invalid bandwidth %q, the percentage must be in (0, 100]", bw)
 		}
+		v := p / 100 * float64(maxBandwidth)
+		if v < 1 {
+			return 0, fmt.Errorf("invalid bandwidth %q of %dbps, must be at least 1bps", bw, maxBandwidth)
+		}
+		return int64(v), nil
 	}
-
-	a4, err := strconv.ParseInt(val, 10, 64)
-	if err != nil || a4 < 0 {
-		fmt.Printf("Invalid bandwidth %v provided, using default value %d\n", val, DefaultBW)
-		return DefaultBW
+	invalid := fmt.Errorf("invalid bandwidth %q, use e.g. 80kbps, 250Mbps, 1Gbps or 10%%", bw)
+	value, ok := strings.CutSuffix(bw, "bps")
+	if !ok {
+		return 0, invalid
 	}
-
-	return a4 * m
+	num := strings.TrimRightFunc(value, func(r rune) bool { return !unicode.IsDigit(r) })
+	m, ok := bandwidthPrefixes[value[len(num):]]
+	if !ok {
+		return 0, fmt.Errorf("invalid bandwidth %q, supported unit prefixes are k, M, G and T", bw)
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, invalid
+	}
+	if v*m < 1 {
+		return 0, fmt.Errorf("invalid bandwidth %q, must be at least 1bps", bw)
+	}
+	return int64(v * m), nil
 }
 
-func getDuration(duration string) int64 {
-	a1, err := strconv.ParseInt(duration, 10, 64)
-	if err != nil || a1 <= 0 {
-		fmt.Printf("Invalid duration %v provided, using default value %d\n", a1, DefaultDuration)
-		a1 = DefaultDuration
+// getDuration parses the test duration, in seconds (e.g. 10) or with a unit
+// (e.g. 10s, 2m).
+func getDuration(duration string) (int64, error) {
+	var d time.Duration
+	if secs, err := strconv.ParseInt(duration, 10, 64); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if d, err = time.ParseDuration(duration); err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use seconds or a unit, e.g. 10 or 10s", duration)
+	}
+	if d < time.Second || d%time.Second != 0 {
+		return 0, fmt.Errorf("invalid duration %q, must be a positive number of whole seconds", duration)
 	}
-	if time.Second*time.Duration(a1) > bwtest.MaxDuration {
-		usageErr(fmt.Sprintf("duration exceeds max: %d > %d", a1, bwtest.MaxDuration/time.Second))
+	if d > bwtest.MaxDuration {
+		return 0, fmt.Errorf("duration %q exceeds the maximum of %v", duration, bwtest.MaxDuration)
 	}
-	return a1
+	return int64(d / time.Second), nil
 }
 
-func getPacketSize(size string, defaultPktSize int64) int64 {
+func getPacketSize(size string) (int64, error) {
 	a2, err := strconv.ParseInt(size, 10, 64)
 	if err != nil {
-		fmt.Printf("Invalid packet size %v provided, using default value %d\n", a2, defaultPktSize)
-		a2 = defaultPktSize
+		return 0, fmt.Errorf("invalid packet size %q, must be a number of bytes", size)
 	}
-
-	if a2 < bwtest.MinPacketSize {
-		a2 = bwtest.MinPacketSize
-	}
-	if a2 > bwtest.MaxPacketSize {
-		a2 = bwtest.MaxPacketSize
+	if a2 < bwtest.MinPacketSize || a2 > bwtest.MaxPacketSize {
+		return 0, fmt.Errorf("invalid packet size %q, must be between %d and %d bytes",
+			size, bwtest.MinPacketSize, bwtest.MaxPacketSize)
 	}
-	return a2
+	return a2, nil
 }
 
-func getPacketCount(count string) int64 {
+func getPacketCount(count string) (int64, error) {
 	a3, err := strconv.ParseInt(count, 10, 64)
 	if err != nil || a3 <= 0 {
-		fmt.Printf("Invalid packet count %v provided, using default value %d\n", a3, DefaultPktCount)
-		a3 = DefaultPktCount
+		return 0, fmt.Errorf("invalid number of packets %q, must be a positive number", count)
 	}
-	return a3
+	return a3, nil
 }
 
 func usageErr(msg string) {
@@ -264,9 +306,11 @@ func main() {
 		serverCCAddr pan.UDPAddr
 		clientBwpStr string
 		serverBwpStr string
+		maxBwStr     string
 		interactive  bool
 		sequence     string
 		preference   string
+		printPaths   bool
 	)
 
 	flag.Usage = printUsage
@@ -274,11 +318,14 @@ func main() {
 	flag.Var(&serverCCAddr, "s", "Server SCION Address")
 	flag.StringVar(&serverBwpStr, "sc", DefaultBwtestParameters, "Server->Client test parameter")
 	flag.StringVar(&clientBwpStr, "cs", DefaultBwtestParameters, "Client->Server test parameter")
+	flag.StringVar(&maxBwStr, "maxbw", "", "Previously measured maximum bandwidth, e.g. 100Mbps, "+
+		"the reference for bandwidths in percent in the test parameters")
 	flag.BoolVar(&interactive, "i", false, "Interactive path selection, prompt to choose path")
 	flag.StringVar(&sequence, "sequence", "", "Sequence of space separated hop predicates to specify path")
 	flag.StringVar(&preference, "preference", "", "Preference sorting order for paths. "+
 		"Comma-separated list of available sorting options: "+
 		strings.Join(pan.AvailablePreferencePolicies, "|"))
+	flag.BoolVar(&printPaths, "paths", false, "Print the candidate paths to the server after applying the path policy and exit")
 
 	flag.Parse()
 	flagset := make(map[string]bool)
@@ -291,8 +338,19 @@ func main() {
 	if !serverCCAddr.IsValid() {
 		usageErr("server address needs to be specified with -s")
 	}
+	if printPaths {
+		policy, err := pan.PolicyFromCommandline(sequence, preference, false)
+		checkUsageErr(err)
+		bwtest.Check(pan.PrintPaths(context.Background(), os.Stdout, serverCCAddr.IA, policy))
+		return
+	}
 	policy, err := pan.PolicyFromCommandline(sequence, preference, interactive)
 	checkUsageErr(err)
+	var maxBw int64
+	if maxBwStr != "" {
+		maxBw, err = parseBandwidth(maxBwStr, 0)
+		checkUsageErr(err)
+	}
 
 	// use default packet size when within same AS
 	inferedPktSize := int64(DefaultPktSize)
@@ -306,13 +364,13 @@ func main() {
 		clientBwpStr = serverBwpStr
 		fmt.Println("Only sc parameter set, using same values for cs")
 	}
-	clientBwp, err := parseBwtestParameters(clientBwpStr, inferedPktSize)
+	clientBwp, err := parseBwtestParameters(clientBwpStr, inferedPktSize, maxBw)
 	checkUsageErr(err)
 	if !flagset["sc"] && flagset["cs"] { // Only one direction set, used same for reverse
 		serverBwpStr = clientBwpStr
 		fmt.Println("Only cs parameter set, using same values for sc")
 	}
-	serverBwp, err := parseBwtestParameters(serverBwpStr, inferedPktSize)
+	serverBwp, err := parseBwtestParameters(serverBwpStr, inferedPktSize, maxBw)
 	checkUsageErr(err)
 	fmt.Println("\nTest parameters:")
 	fmt.Printf("client->server: %d seconds, %d bytes, %d packets\n",
@@ -320,22 +378,29 @@ func main() {
 	fmt.Printf("server->client: %d seconds, %d bytes, %d packets\n",
 		int(serverBwp.BwtestDuration/time.Second), serverBwp.PacketSize, serverBwp.NumPackets)
 
-	clientRes, serverRes, err := runBwtest(local.Get(), serverCCAddr, policy, clientBwp, serverBwp)
+	events, err := recordEvents()
+	bwtest.Check(err)
+	clientRes, serverRes, startTime, err := runBwtest(local.Get(), serverCCAddr, policy, clientBwp, serverBwp)
 	bwtest.Check(err)
 
 	fmt.Println("\nS->C results")
 	printBwtestResult(serverBwp, clientRes)
 	fmt.Println("\nC->S results")
 	printBwtestResult(clientBwp, serverRes)
+	fmt.Println("\nPath events")
+	printEvents(os.Stdout, startTime, events.stop())
 }
 
 // runBwtest runs the bandwidth test with the given parameters against the server at serverCCAddr.
+// The returned startTime is the time at which the test started, after the
+// request was accepted by the server.
 func runBwtest(local netip.AddrPort, serverCCAddr pan.UDPAddr, policy pan.Policy,
-	clientBwp, serverBwp bwtest.Parameters) (clientRes, serverRes bwtest.Result, err error) {
+	clientBwp, serverBwp bwtest.Parameters) (clientRes, serverRes bwtest.Result, startTime time.Time, err error) {
 
 	// Control channel connection
 	ccSelector := pan.NewDefaultSelector()
-	ccConn, err := pan.DialUDP(context.Background(), local, serverCCAddr, policy, ccSelector)
+	ccConn, err := pan.DialUDP(context.Background(), serverCCAddr,
+		pan.WithLocalAddr(local), pan.WithPolicy(policy), pan.WithSelector(ccSelector))
 	if err != nil {
 		return
 	}
@@ -345,7 +410,8 @@ func runBwtest(local netip.AddrPort, serverCCAddr pan.UDPAddr, policy pan.Policy
 	serverDCAddr := serverCCAddr.WithPort(serverCCAddr.Port + 1)
 
 	// Data channel connection
-	dcConn, err := pan.DialUDP(context.Background(), dcLocal, serverDCAddr, policy, nil)
+	dcConn, err := pan.DialUDP(context.Background(), serverDCAddr,
+		pan.WithLocalAddr(dcLocal), pan.WithPolicy(policy))
 	if err != nil {
 		return
 	}
@@ -365,7 +431,7 @@ func runBwtest(local netip.AddrPort, serverCCAddr pan.UDPAddr, policy pan.Policy
 	if err != nil {
 		return
 	}
-	startTime := time.Now()
+	startTime = time.Now()
 	finishTimeReceive := startTime.Add(serverBwp.BwtestDuration + bwtest.StragglerWaitPeriod)
 	finishTimeSend := startTime.Add(clientBwp.BwtestDuration + bwtest.GracePeriodSend)
 	if err = dcConn.SetReadDeadline(finishTimeReceive); err != nil {
@@ -534,3 +600,73 @@ func printBwtestResult(bwp bwtest.Parameters, res bwtest.Result) {
 		math.Sqrt(float64(res.IPAvar)/1e6),
 	)
 }
+
+// eventRecorder collects the pan path events, e.g. path switches and SCMP
+// path down notifications, that occur during the test.
+type eventRecorder struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	mutex  sync.Mutex
+	events []pan.Event
+}
+
+func recordEvents() (*eventRecorder, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := pan.SubscribeEvents(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r := &eventRecorder{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		for e := range ch {
+			r.mutex.Lock()
+			r.events = append(r.events, e)
+			r.mutex.Unlock()
+		}
+	}()
+	return r, nil
+}
+
+// stop stops recording and returns the recorded events.
+func (r *eventRecorder) stop() []pan.Event {
+	r.cancel()
+	<-r.done
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.events
+}
+
+// printEvents prints the events with their time relative to the start of the
+// test. Events before the start have a negative offset.
+func printEvents(w io.Writer, startTime time.Time, events []pan.Event) {
+	if len(events) == 0 {
+		fmt.Fprintln(w, "none")
+		return
+	}
+	for _, e := range events {
+		fmt.Fprintln(w, formatEvent(startTime, e))
+	}
+}
+
+func formatEvent(startTime time.Time, e pan.Event) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%+.3fs %s", e.Time.Sub(startTime).Seconds(), e.Type)
+	if e.Destination != 0 {
+		fmt.Fprintf(b, " dst=%s", e.Destination)
+	}
+	if e.PreviousFingerprint != "" {
+		fmt.Fprintf(b, " from=[%s]", e.PreviousFingerprint)
+	}
+	if e.Fingerprint != "" {
+		fmt.Fprintf(b, " path=[%s]", e.Fingerprint)
+	}
+	if e.Interface != (pan.PathInterface{}) {
+		fmt.Fprintf(b, " interface=%s#%d", e.Interface.IA, e.Interface.IfID)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(b, " err=%q", e.Err.Error())
+	}
+	return b.String()
+}
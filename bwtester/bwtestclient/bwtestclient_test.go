@@ -15,11 +15,14 @@
 package main
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
 )
 
 func TestParseParameters(t *testing.T) {
@@ -32,6 +35,7 @@ func TestParseParameters(t *testing.T) {
 		expectedDuration   time.Duration
 		expectedPacketSize int
 		expectedNumPackets int
+		maxBandwidth       int
 		expectErr          bool
 	}{
 		{
@@ -126,11 +130,56 @@ func TestParseParameters(t *testing.T) {
 			expectedPacketSize: 1000,
 			expectedNumPackets: 3000,
 		},
+		{
+			name:               "duration unit",
+			input:              "1m,1000,?,80kbps",
+			expectedDuration:   time.Minute,
+			expectedPacketSize: 1000,
+			expectedNumPackets: 600,
+		},
+		{
+			name:               "percent of max bw",
+			input:              "1,1000,?,10%",
+			maxBandwidth:       80000000,
+			expectedDuration:   time.Second,
+			expectedPacketSize: 1000,
+			expectedNumPackets: 1000,
+		},
+		{
+			name:      "percent without max bw",
+			input:     "10%",
+			expectErr: true,
+		},
+		{
+			name:      "fractional duration",
+			input:     "1500ms,1000,?,1Mbps",
+			expectErr: true,
+		},
+		{
+			name:      "duration exceeds max",
+			input:     "10m,1000,?,1Mbps",
+			expectErr: true,
+		},
+		{
+			name:      "invalid bw",
+			input:     "1,1000,?,1Mb",
+			expectErr: true,
+		},
+		{
+			name:      "invalid packet size",
+			input:     "1,1,?,1Mbps",
+			expectErr: true,
+		},
+		{
+			name:      "wrong number of values",
+			input:     "1,1000,1Mbps",
+			expectErr: true,
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			ret, err := parseBwtestParameters(c.input, int64(c.inferedPktSize))
+			ret, err := parseBwtestParameters(c.input, int64(c.inferedPktSize), int64(c.maxBandwidth))
 			if c.expectErr {
 				assert.Error(t, err)
 			} else {
@@ -142,3 +191,82 @@ func TestParseParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBandwidth(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected int64
+	}{
+		{"1500bps", 1500},
+		{"80kbps", 80000},
+		{"250Mbps", 250000000},
+		{"1Gbps", 1000000000},
+		{"1.5Gbps", 1500000000},
+		{"2Tbps", 2000000000000},
+		{"50%", 50000000},
+		{"0.5%", 500000},
+	}
+	for _, c := range cases {
+		bw, err := parseBandwidth(c.input, 100000000)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, bw, c.input)
+	}
+	for _, invalid := range []string{"", "bps", "Mbps", "1Xbps", "1 Mbps", "1M", "-1Mbps", "0bps", "0%", "101%"} {
+		_, err := parseBandwidth(invalid, 100000000)
+		assert.Error(t, err, invalid)
+	}
+	// percentages of low maximum bandwidths must not round down to 0
+	_, err := parseBandwidth("1%", 50)
+	assert.Error(t, err)
+	bw, err := parseBandwidth("2%", 50)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), bw)
+}
+
+func TestFormatEvent(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ia := pan.MustParseIA("1-ff00:0:110")
+
+	cases := []struct {
+		name     string
+		event    pan.Event
+		expected string
+	}{
+		{
+			name: "switched",
+			event: pan.Event{
+				Type:                pan.EventPathSwitched,
+				Time:                start.Add(1500 * time.Millisecond),
+				Destination:         ia,
+				Fingerprint:         "2 3",
+				PreviousFingerprint: "1 2",
+			},
+			expected: "+1.500s PathSwitched dst=1-ff00:0:110 from=[1 2] path=[2 3]",
+		},
+		{
+			name: "down before start",
+			event: pan.Event{
+				Type:        pan.EventPathDown,
+				Time:        start.Add(-250 * time.Millisecond),
+				Fingerprint: "1 2",
+				Interface:   pan.PathInterface{IA: ia, IfID: 2},
+			},
+			expected: "-0.250s PathDown path=[1 2] interface=1-ff00:0:110#2",
+		},
+		{
+			name: "refresh failed",
+			event: pan.Event{
+				Type:        pan.EventRefreshFailed,
+				Time:        start,
+				Destination: ia,
+				Err:         errors.New("timeout"),
+			},
+			expected: "+0.000s RefreshFailed dst=1-ff00:0:110 err=\"timeout\"",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, formatEvent(start, c.event))
+		})
+	}
+}
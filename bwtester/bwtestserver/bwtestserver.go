@@ -20,9 +20,16 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/netip"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/netsec-ethz/scion-apps/bwtester/bwtest"
@@ -36,13 +43,59 @@ const (
 func main() {
 	var listen pan.IPPortValue
 	kingpin.Flag("listen", "Address to listen on").Default(":40002").SetValue(&listen)
+	configFile := kingpin.Flag("config", "Configuration file (TOML). Reloaded on SIGHUP.").String()
 	kingpin.Parse()
 
-	err := runServer(listen.Get())
+	defaults := defaultConfig(listen.Get())
+	cfg := defaults
+	if *configFile != "" {
+		var err error
+		cfg, err = loadConfig(*configFile, defaults)
+		bwtest.Check(err)
+	}
+	var config atomic.Pointer[serverConfig]
+	config.Store(cfg)
+
+	if *configFile != "" {
+		go reloadOnSIGHUP(*configFile, defaults, &config)
+	}
+	if cfg.Metrics.IsValid() {
+		bwtest.Check(pan.EnableMetrics(prometheus.DefaultRegisterer))
+		go func() {
+			err := http.ListenAndServe(cfg.Metrics.String(), promhttp.Handler())
+			bwtest.Check(err)
+		}()
+	}
+
+	err := runServer(&config)
 	bwtest.Check(err)
 }
 
-func runServer(listen netip.AddrPort) error {
+// reloadOnSIGHUP reloads the configuration file whenever SIGHUP is received.
+// The allowed IAs and the limits take effect for the next test request.
+// Changes to the listen or metrics addresses require a restart.
+func reloadOnSIGHUP(filename string, defaults *serverConfig, config *atomic.Pointer[serverConfig]) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	for range sighup {
+		cfg, err := loadConfig(filename, defaults)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reloading configuration, keeping previous:", err)
+			continue
+		}
+		prev := config.Load()
+		if cfg.Listen != prev.Listen || cfg.Metrics != prev.Metrics {
+			fmt.Fprintln(os.Stderr, "Changes to listen or metrics address require a restart, ignored")
+			cfg.Listen = prev.Listen
+			cfg.Metrics = prev.Metrics
+		}
+		config.Store(cfg)
+		fmt.Println("Reloaded configuration", filename)
+	}
+}
+
+func runServer(config *atomic.Pointer[serverConfig]) error {
+	listen := config.Load().Listen
 	receivePacketBuffer := make([]byte, 2500)
 
 	var currentBwtest string
@@ -52,7 +105,8 @@ func runServer(listen netip.AddrPort) error {
 	results := make(resultsMap)
 
 	ccSelector := pan.NewDefaultReplySelector()
-	ccConn, err := pan.ListenUDP(context.Background(), listen, ccSelector)
+	ccConn, err := pan.ListenUDP(context.Background(),
+		pan.WithLocalAddr(listen), pan.WithReplySelector(ccSelector))
 	if err != nil {
 		return err
 	}
@@ -101,6 +155,10 @@ func runServer(listen netip.AddrPort) error {
 			if err != nil {
 				continue
 			}
+			if err := config.Load().checkClient(clientCCAddr.(pan.UDPAddr), clientBwp, serverBwp); err != nil {
+				fmt.Println("Rejected request:", clientCCAddrStr, err)
+				continue
+			}
 			path := ccSelector.Path(clientCCAddr.(pan.UDPAddr))
 			finishTime, err := startBwtestBackground(serverCCAddr, clientCCAddr.(pan.UDPAddr), path,
 				clientBwp, serverBwp, currentResult)
@@ -287,7 +345,8 @@ func (r resultsMap) purgeExpired() {
 }
 
 func listenConnected(local netip.AddrPort, remote pan.UDPAddr, selector pan.ReplySelector) (net.Conn, error) {
-	conn, err := pan.ListenUDP(context.Background(), local, selector)
+	conn, err := pan.ListenUDP(context.Background(),
+		pan.WithLocalAddr(local), pan.WithReplySelector(selector))
 	return connectedPacketConn{
 		ListenConn: conn,
 		remote:     remote,
@@ -0,0 +1,147 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/pelletier/go-toml"
+
+	"github.com/netsec-ethz/scion-apps/bwtester/bwtest"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+// serverConfig is the configuration of the bwtestserver, as read from the
+// configuration file. See the example in the README.
+type serverConfig struct {
+	// Listen is the address of the control connection.
+	Listen netip.AddrPort
+	// Metrics is the address of the HTTP server exposing prometheus metrics.
+	// Invalid (disabled) if not set.
+	Metrics netip.AddrPort
+	// MaxBandwidth is the maximum bandwidth, in bits per second, that a
+	// client may request in either direction. 0 means unlimited.
+	MaxBandwidth int64
+	// MaxDuration is the maximum duration of a test.
+	MaxDuration time.Duration
+	// AllowedIAs is the set of client ISD-ASes that are allowed to run tests.
+	// All clients are allowed if empty.
+	AllowedIAs map[pan.IA]struct{}
+}
+
+// configFile is the raw format of the TOML configuration file.
+type configFile struct {
+	Listen       string   `toml:"listen"`
+	Metrics      string   `toml:"metrics"`
+	MaxBandwidth int64    `toml:"max_bandwidth"`
+	MaxDuration  string   `toml:"max_duration"`
+	AllowedIAs   []string `toml:"allowed_ias"`
+}
+
+func defaultConfig(listen netip.AddrPort) *serverConfig {
+	return &serverConfig{
+		Listen:      listen,
+		MaxDuration: bwtest.MaxDuration,
+	}
+}
+
+// loadConfig reads the configuration file. Unset values are taken from
+// defaults.
+func loadConfig(filename string, defaults *serverConfig) (*serverConfig, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(raw, defaults)
+}
+
+func parseConfig(raw []byte, defaults *serverConfig) (*serverConfig, error) {
+	var f configFile
+	if err := toml.Unmarshal(raw, &f); err != nil {
+		return nil, err
+	}
+	cfg := *defaults
+	cfg.AllowedIAs = nil
+	if f.Listen != "" {
+		listen, err := pan.ParseOptionalIPPort(f.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address: %w", err)
+		}
+		cfg.Listen = listen
+	}
+	if f.Metrics != "" {
+		metrics, err := netip.ParseAddrPort(f.Metrics)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics address: %w", err)
+		}
+		cfg.Metrics = metrics
+	}
+	if f.MaxBandwidth < 0 {
+		return nil, fmt.Errorf("invalid max_bandwidth: %d", f.MaxBandwidth)
+	}
+	cfg.MaxBandwidth = f.MaxBandwidth
+	if f.MaxDuration != "" {
+		d, err := time.ParseDuration(f.MaxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_duration: %w", err)
+		}
+		if d <= 0 || d > bwtest.MaxDuration {
+			return nil, fmt.Errorf("invalid max_duration: must be in (0, %s]", bwtest.MaxDuration)
+		}
+		cfg.MaxDuration = d
+	}
+	if len(f.AllowedIAs) > 0 {
+		cfg.AllowedIAs = make(map[pan.IA]struct{}, len(f.AllowedIAs))
+		for _, s := range f.AllowedIAs {
+			ia, err := pan.ParseIA(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry in allowed_ias: %w", err)
+			}
+			cfg.AllowedIAs[ia] = struct{}{}
+		}
+	}
+	return &cfg, nil
+}
+
+// checkClient returns an error if the client is not allowed to run tests with
+// the given parameters.
+func (c *serverConfig) checkClient(client pan.UDPAddr, clientBwp, serverBwp bwtest.Parameters) error {
+	if len(c.AllowedIAs) > 0 {
+		if _, ok := c.AllowedIAs[client.IA]; !ok {
+			return fmt.Errorf("client IA %s not allowed", client.IA)
+		}
+	}
+	for _, bwp := range []bwtest.Parameters{clientBwp, serverBwp} {
+		if bwp.BwtestDuration > c.MaxDuration {
+			return fmt.Errorf("duration exceeds max: %s > %s", bwp.BwtestDuration, c.MaxDuration)
+		}
+		if bw := bandwidth(bwp); c.MaxBandwidth > 0 && bw > c.MaxBandwidth {
+			return fmt.Errorf("bandwidth exceeds max: %d > %d bps", bw, c.MaxBandwidth)
+		}
+	}
+	return nil
+}
+
+// bandwidth returns the requested bandwidth in bits per second.
+func bandwidth(bwp bwtest.Parameters) int64 {
+	if bwp.BwtestDuration <= 0 {
+		return 0
+	}
+	bits := bwp.PacketSize * bwp.NumPackets * 8
+	return int64(float64(bits) / bwp.BwtestDuration.Seconds())
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/bwtester/bwtest"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+func TestParseConfig(t *testing.T) {
+	defaults := defaultConfig(netip.MustParseAddrPort("0.0.0.0:40002"))
+
+	cfg, err := parseConfig([]byte(""), defaults)
+	require.NoError(t, err)
+	assert.Equal(t, defaults.Listen, cfg.Listen)
+	assert.False(t, cfg.Metrics.IsValid())
+	assert.Equal(t, bwtest.MaxDuration, cfg.MaxDuration)
+
+	cfg, err = parseConfig([]byte(`
+listen = ":40012"
+metrics = "127.0.0.1:9102"
+max_bandwidth = 10000000
+max_duration = "10s"
+allowed_ias = ["1-ff00:0:110", "1-ff00:0:111"]
+`), defaults)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(40012), cfg.Listen.Port())
+	assert.Equal(t, netip.MustParseAddrPort("127.0.0.1:9102"), cfg.Metrics)
+	assert.Equal(t, int64(10000000), cfg.MaxBandwidth)
+	assert.Equal(t, 10*time.Second, cfg.MaxDuration)
+	assert.Len(t, cfg.AllowedIAs, 2)
+
+	for _, invalid := range []string{
+		`listen = "foo"`,
+		`max_bandwidth = -1`,
+		`max_duration = "1h"`,
+		`allowed_ias = ["1-ff00:0:110", "foo"]`,
+	} {
+		_, err := parseConfig([]byte(invalid), defaults)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCheckClient(t *testing.T) {
+	cfg := &serverConfig{
+		MaxBandwidth: 1e6,
+		MaxDuration:  10 * time.Second,
+		AllowedIAs:   map[pan.IA]struct{}{pan.MustParseIA("1-ff00:0:110"): {}},
+	}
+	allowed := pan.MustParseUDPAddr("1-ff00:0:110,127.0.0.1:1234")
+	other := pan.MustParseUDPAddr("1-ff00:0:111,127.0.0.1:1234")
+	// 1000 packets of 100 bytes in 1s: 800kbps
+	ok := bwtest.Parameters{BwtestDuration: time.Second, PacketSize: 100, NumPackets: 1000}
+	tooFast := bwtest.Parameters{BwtestDuration: time.Second, PacketSize: 1000, NumPackets: 1000}
+	tooLong := bwtest.Parameters{BwtestDuration: time.Minute, PacketSize: 100, NumPackets: 1000}
+
+	assert.NoError(t, cfg.checkClient(allowed, ok, ok))
+	assert.Error(t, cfg.checkClient(other, ok, ok))
+	assert.Error(t, cfg.checkClient(allowed, ok, tooFast))
+	assert.Error(t, cfg.checkClient(allowed, tooLong, ok))
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers/path"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+// testSCIONPacket returns a SCION/UDP packet on a path with two hops.
+func testSCIONPacket(t *testing.T) []byte {
+	var decoded scion.Decoded
+	decoded.PathMeta.SegLen[0] = 2
+	decoded.InfoFields = []path.InfoField{{ConsDir: true, Timestamp: uint32(time.Now().Unix())}}
+	decoded.HopFields = []path.HopField{
+		{ExpTime: 63, ConsEgress: 1},
+		{ExpTime: 63, ConsIngress: 2},
+	}
+	decoded.NumINF, decoded.NumHops = 1, 2
+	raw := make([]byte, decoded.Len())
+	require.NoError(t, decoded.SerializeTo(raw))
+
+	pkt := &snet.Packet{
+		PacketInfo: snet.PacketInfo{
+			Source: snet.SCIONAddress{
+				IA:   addr.IA(pan.MustParseIA("1-ff00:0:111")),
+				Host: addr.HostIP(netip.MustParseAddr("10.0.0.1")),
+			},
+			Destination: snet.SCIONAddress{
+				IA:   addr.IA(pan.MustParseIA("1-ff00:0:112")),
+				Host: addr.HostIP(netip.MustParseAddr("10.0.0.2")),
+			},
+			Path:    snetpath.SCION{Raw: raw},
+			Payload: snet.UDPPayload{SrcPort: 1234, DstPort: 5678, Payload: []byte("hello")},
+		},
+	}
+	require.NoError(t, pkt.Serialize())
+	return pkt.Bytes
+}
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, "1-ff00:0:111,10.0.0.1 -> 1-ff00:0:112,10.0.0.2 UDP path=[1 2]",
+		describe(testSCIONPacket(t)))
+}
+
+func TestWritePcapng(t *testing.T) {
+	scionPkt := testSCIONPacket(t)
+	r := pan.TapRecord{
+		Time:     time.Unix(1700000000, 123456789),
+		Outgoing: true,
+		Local:    netip.MustParseAddrPort("127.0.0.1:31000"),
+		Remote:   netip.MustParseAddrPort("127.0.0.2:30042"),
+		Packet:   scionPkt,
+	}
+	data, err := underlayPacket(r)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w, err := newPcapngWriter(&out)
+	require.NoError(t, err)
+	require.NoError(t, w.writePacket(r.Time, data, r.Outgoing, describe(scionPkt)))
+	require.NoError(t, w.Flush())
+
+	reader, err := pcapgo.NewNgReader(&out, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+	assert.Equal(t, layers.LinkTypeRaw, reader.LinkType())
+	read, ci, err := reader.ReadPacketData()
+	require.NoError(t, err)
+	assert.True(t, r.Time.Equal(ci.Timestamp), "timestamp %v", ci.Timestamp)
+	assert.Equal(t, data, read)
+
+	p := gopacket.NewPacket(read, layers.LayerTypeIPv4, gopacket.Default)
+	ip, ok := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	require.True(t, ok)
+	assert.Equal(t, "127.0.0.1", ip.SrcIP.String())
+	assert.Equal(t, "127.0.0.2", ip.DstIP.String())
+	udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	require.True(t, ok)
+	assert.Equal(t, layers.UDPPort(31000), udp.SrcPort)
+	assert.Equal(t, layers.UDPPort(30042), udp.DstPort)
+	assert.Equal(t, scionPkt, udp.Payload)
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// scion-capture writes the SCION packets sent and received by pan
+// applications to a pcapng file. The applications mirror their packets to the
+// tap socket of scion-capture when started with the SCION_PAN_TAP environment
+// variable set to its path. This does not require root or pcap access to the
+// underlay.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+func main() {
+	var (
+		socket string
+		output string
+		count  int
+	)
+	flag.StringVar(&socket, "socket", "/tmp/scion-pan-tap.sock",
+		"path of the tap socket; start the applications with "+pan.TapSocketEnv+" set to this path")
+	flag.StringVar(&output, "w", "-", "pcapng file to write, - for stdout")
+	flag.IntVar(&count, "c", 0, "stop after this number of packets (0: no limit)")
+	flag.Parse()
+
+	if err := run(socket, output, count); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(socket, output string, count int) error {
+	// Remove a stale socket of a previous run.
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	defer conn.Close()
+
+	var out io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w, err := newPcapngWriter(out)
+	if err != nil {
+		return err
+	}
+	defer w.Flush()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		conn.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Capturing on %s, start applications with %s=%s\n",
+		socket, pan.TapSocketEnv, socket)
+	buf := make([]byte, 65536)
+	for n := 0; count == 0 || n < count; {
+		k, err := conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		r, err := pan.ParseTapRecord(buf[:k])
+		if err != nil {
+			log.Println("invalid tap record:", err)
+			continue
+		}
+		data, err := underlayPacket(r)
+		if err != nil {
+			log.Println("invalid tap record:", err)
+			continue
+		}
+		if err := w.writePacket(r.Time, data, r.Outgoing, describe(r.Packet)); err != nil {
+			return err
+		}
+		// Flush each packet, so that the file can be followed while capturing.
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		n++
+	}
+	return nil
+}
+
+// underlayPacket returns the SCION packet of the record, encapsulated in
+// IP/UDP with the underlay addresses, as it was sent or received on the
+// underlay.
+func underlayPacket(r pan.TapRecord) ([]byte, error) {
+	src, dst := r.Remote, r.Local
+	if r.Outgoing {
+		src, dst = r.Local, r.Remote
+	}
+	udp := &layers.UDP{
+		SrcPort: layers.UDPPort(src.Port()),
+		DstPort: layers.UDPPort(dst.Port()),
+	}
+	var ip gopacket.SerializableLayer
+	if src.Addr().Is4() && dst.Addr().Is4() {
+		ip4 := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    src.Addr().AsSlice(),
+			DstIP:    dst.Addr().AsSlice(),
+		}
+		if err := udp.SetNetworkLayerForChecksum(ip4); err != nil {
+			return nil, err
+		}
+		ip = ip4
+	} else {
+		ip6 := &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolUDP,
+			SrcIP:      as16(src.Addr()),
+			DstIP:      as16(dst.Addr()),
+		}
+		if err := udp.SetNetworkLayerForChecksum(ip6); err != nil {
+			return nil, err
+		}
+		ip = ip6
+	}
+	b := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	if err := gopacket.SerializeLayers(b, opts, ip, udp, gopacket.Payload(r.Packet)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func as16(a netip.Addr) net.IP {
+	ip := a.As16()
+	return ip[:]
+}
+
+// describe returns a comment for the SCION packet, with the addresses, the
+// L4 protocol and the path, as the sequence of interface IDs in order of
+// traversal.
+func describe(pkt []byte) string {
+	var scn slayers.SCION
+	if err := scn.DecodeFromBytes(pkt, gopacket.NilDecodeFeedback); err != nil {
+		return fmt.Sprintf("undecodable SCION packet: %v", err)
+	}
+	src, dst := "?", "?"
+	if a, err := scn.SrcAddr(); err == nil {
+		src = fmt.Sprintf("%s,%s", scn.SrcIA, a)
+	}
+	if a, err := scn.DstAddr(); err == nil {
+		dst = fmt.Sprintf("%s,%s", scn.DstIA, a)
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s -> %s %s", src, dst, scn.NextHdr)
+	if raw, ok := scn.Path.(*scion.Raw); ok {
+		if decoded, err := raw.ToDecoded(); err == nil {
+			fmt.Fprintf(b, " path=[%s]", interfaceIDs(decoded))
+		}
+	}
+	return b.String()
+}
+
+// interfaceIDs returns the interface IDs of the path in order of traversal,
+// in the format of pan.PathFingerprint.
+func interfaceIDs(sp *scion.Decoded) string {
+	var ifIDs []string
+	add := func(ifID uint16) {
+		ifIDs = append(ifIDs, fmt.Sprint(ifID))
+	}
+	hop := 0
+	for i, info := range sp.InfoFields {
+		seglen := int(sp.PathMeta.SegLen[i])
+		for h := 0; h < seglen; h++ {
+			hf := sp.HopFields[hop]
+			first, second := hf.ConsEgress, hf.ConsIngress
+			if info.ConsDir {
+				first, second = hf.ConsIngress, hf.ConsEgress
+			}
+			if h > 0 || (info.Peer && i == 1) {
+				add(first)
+			}
+			if h < seglen-1 || (info.Peer && i == 0) {
+				add(second)
+			}
+			hop++
+		}
+	}
+	return strings.Join(ifIDs, " ")
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// pcapng block types and options, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html.
+// gopacket's pcapgo.NgWriter does not support per-packet options, which are
+// needed for the comments.
+const (
+	blockSectionHeader  = 0x0A0D0D0A
+	blockInterface      = 0x00000001
+	blockEnhancedPacket = 0x00000006
+	byteOrderMagic      = 0x1A2B3C4D
+	optEndOfOpt         = 0
+	optComment          = 1
+	optEPBFlags         = 2
+	optIfTsResol        = 9
+	epbFlagInbound      = 1
+	epbFlagOutbound     = 2
+	linkTypeRaw         = 101 // raw IPv4 or IPv6 packets
+	tsResolNanos        = 9   // 10^-9 s
+	snapLenUnlimited    = 0
+)
+
+// pcapngWriter writes a pcapng file with a single interface with link type
+// raw IP, and nanosecond timestamps.
+type pcapngWriter struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+func newPcapngWriter(w io.Writer) (*pcapngWriter, error) {
+	pw := &pcapngWriter{w: bufio.NewWriter(w)}
+	// Section header block: byte order magic, version 1.0, unknown length
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	if err := pw.writeBlock(blockSectionHeader, shb); err != nil {
+		return nil, err
+	}
+	// Interface description block
+	idb := binary.LittleEndian.AppendUint16(nil, linkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, snapLenUnlimited)
+	idb = appendOption(idb, optIfTsResol, []byte{tsResolNanos})
+	idb = appendOption(idb, optEndOfOpt, nil)
+	if err := pw.writeBlock(blockInterface, idb); err != nil {
+		return nil, err
+	}
+	return pw, pw.w.Flush()
+}
+
+// writePacket writes an enhanced packet block with the packet data, the
+// direction and a comment.
+func (pw *pcapngWriter) writePacket(ts time.Time, data []byte, outbound bool, comment string) error {
+	ns := uint64(ts.UnixNano())
+	b := pw.buf[:0]
+	b = binary.LittleEndian.AppendUint32(b, 0) // interface ID
+	b = binary.LittleEndian.AppendUint32(b, uint32(ns>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ns))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data))) // captured length
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data))) // original length
+	b = append(b, data...)
+	b = appendPadding(b)
+	flags := uint32(epbFlagInbound)
+	if outbound {
+		flags = epbFlagOutbound
+	}
+	b = appendOption(b, optEPBFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	if comment != "" {
+		b = appendOption(b, optComment, []byte(comment))
+	}
+	b = appendOption(b, optEndOfOpt, nil)
+	pw.buf = b
+	return pw.writeBlock(blockEnhancedPacket, b)
+}
+
+func (pw *pcapngWriter) Flush() error {
+	return pw.w.Flush()
+}
+
+// writeBlock writes a block with the given type and body. The body must be
+// padded to 32 bits.
+func (pw *pcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(4 + 4 + len(body) + 4) // type, length, body, length
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], blockType)
+	binary.LittleEndian.PutUint32(hdr[4:8], length)
+	if _, err := pw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := pw.w.Write(body); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(hdr[4:8])
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return appendPadding(b)
+}
+
+// appendPadding pads b to a multiple of 32 bits.
+func appendPadding(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
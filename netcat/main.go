@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -47,6 +48,7 @@ var (
 	interactive bool
 	sequence    string
 	preference  string
+	printPaths  bool
 )
 
 func printUsage() {
@@ -68,6 +70,7 @@ func printUsage() {
 	fmt.Println("  -u: UDP mode")
 	fmt.Println("  -b: Send or expect an extra (throw-away) byte before the actual data")
 	fmt.Println("  -v: Enable verbose mode")
+	fmt.Println("  -paths: Print the candidate paths to the host after applying the path policy and exit")
 }
 
 func main() {
@@ -86,6 +89,7 @@ func main() {
 		"Comma-separated list of available sorting options: "+
 		strings.Join(pan.AvailablePreferencePolicies, "|"))
 	flag.BoolVar(&verboseMode, "v", false, "Verbose mode")
+	flag.BoolVar(&printPaths, "paths", false, "Print the candidate paths and exit")
 	flag.Parse()
 
 	tail := flag.Args()
@@ -97,6 +101,16 @@ func main() {
 		log.Fatalf("Incorrect number of arguments! Expected %s, got: %v", expected, tail)
 	}
 
+	if printPaths {
+		if listen {
+			log.Fatalf("-paths flag is incompatible with -l flag!")
+		}
+		if err := doPrintPaths(tail[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if repeatAfter && repeatDuring {
 		log.Fatalf("-k and -K flags are exclusive!")
 	}
@@ -275,6 +289,20 @@ func doDial(remoteAddr string, policy pan.Policy) (io.ReadWriteCloser, error) {
 	return conn, nil
 }
 
+// doPrintPaths prints the paths to the remote address accepted by the path
+// policy.
+func doPrintPaths(remote string) error {
+	remoteAddr, err := pan.ResolveUDPAddr(context.TODO(), remote)
+	if err != nil {
+		return err
+	}
+	policy, err := pan.PolicyFromCommandline(sequence, preference, false)
+	if err != nil {
+		return err
+	}
+	return pan.PrintPaths(context.Background(), os.Stdout, remoteAddr.IA, policy)
+}
+
 func doListen(port uint16) (chan io.ReadWriteCloser, error) {
 	var conns chan io.ReadWriteCloser
 	var err error
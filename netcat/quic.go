@@ -35,13 +35,12 @@ var (
 func DoListenQUIC(port uint16) (chan io.ReadWriteCloser, error) {
 	quicListener, err := pan.ListenQUIC(
 		context.Background(),
-		netip.AddrPortFrom(netip.Addr{}, port),
-		nil,
 		&tls.Config{
 			Certificates: quicutil.MustGenerateSelfSignedCert(),
 			NextProtos:   nextProtos,
 		},
 		&quic.Config{KeepAlivePeriod: 15 * time.Second},
+		pan.WithLocalAddr(netip.AddrPortFrom(netip.Addr{}, port)),
 	)
 	if err != nil {
 		return nil, err
@@ -72,16 +71,15 @@ func DoDialQUIC(remote string, policy pan.Policy) (io.ReadWriteCloser, error) {
 	}
 	sess, err := pan.DialQUIC(
 		context.Background(),
-		netip.AddrPort{},
 		remoteAddr,
-		policy,
-		nil,
 		pan.MangleSCIONAddr(remote),
 		&tls.Config{
 			InsecureSkipVerify: true,
 			NextProtos:         nextProtos,
 		},
-		&quic.Config{KeepAlivePeriod: 15 * time.Second},
+		nil,
+		pan.WithPolicy(policy),
+		quicutil.WithPathTimeouts(),
 	)
 	if err != nil {
 		return nil, err
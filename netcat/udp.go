@@ -58,7 +58,7 @@ func DoDialUDP(remote string, policy pan.Policy) (io.ReadWriteCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn, err := pan.DialUDP(context.Background(), netip.AddrPort{}, remoteAddr, policy, nil)
+	conn, err := pan.DialUDP(context.Background(), remoteAddr, pan.WithPolicy(policy))
 	if err != nil {
 		return nil, err
 	}
@@ -70,8 +70,7 @@ func DoDialUDP(remote string, policy pan.Policy) (io.ReadWriteCloser, error) {
 func DoListenUDP(port uint16) (chan io.ReadWriteCloser, error) {
 	conn, err := pan.ListenUDP(
 		context.Background(),
-		netip.AddrPortFrom(netip.Addr{}, port),
-		nil,
+		pan.WithLocalAddr(netip.AddrPortFrom(netip.Addr{}, port)),
 	)
 	if err != nil {
 		return nil, err
@@ -25,6 +25,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -50,6 +51,11 @@ type ScionAppsIntegration struct {
 	ClientErrMatch func(stderrr string) error
 	ClientDelay    time.Duration
 	ClientTimeout  time.Duration
+	// MatchTimeout is the time to wait for the server output to match
+	// ServerOutMatch and ServerErrMatch before the server is stopped, for
+	// output that is produced asynchronously. If zero, the server output is
+	// only checked once the server has been stopped.
+	MatchTimeout time.Duration
 }
 
 // NewAppsIntegration returns an implementation of the Integration interface.
@@ -93,8 +99,8 @@ func (sai *ScionAppsIntegration) StartServer(ctx context.Context,
 	id := fmt.Sprintf("server_%s", addr.FormatIA(dst.IA, addr.WithFileSeparator()))
 	stdoutLog := sai.openLogFile(id, ".log")
 	stderrLog := sai.openLogFile(id, ".err")
-	stdoutBuf := &bytes.Buffer{}
-	stderrBuf := &bytes.Buffer{}
+	stdoutBuf := newOutputBuffer()
+	stderrBuf := newOutputBuffer()
 	readyDetector := &detectingWriter{
 		Needle: []byte(ReadySignal),
 		Signal: make(chan struct{}),
@@ -121,6 +127,7 @@ func (sai *ScionAppsIntegration) StartServer(ctx context.Context,
 		stderrBuf,
 		sai.ServerOutMatch,
 		sai.ServerErrMatch,
+		sai.MatchTimeout,
 	}
 	return aw, nil
 }
@@ -148,8 +155,8 @@ func (sai *ScionAppsIntegration) StartClient(ctx context.Context,
 	id := fmt.Sprintf("client_%s", clientID(src, dst))
 	stdoutLog := sai.openLogFile(id, ".log")
 	stderrLog := sai.openLogFile(id, ".err")
-	stdoutBuf := &bytes.Buffer{}
-	stderrBuf := &bytes.Buffer{}
+	stdoutBuf := newOutputBuffer()
+	stderrBuf := newOutputBuffer()
 	cmd.Stdout = io.MultiWriter(stdoutLog, stdoutBuf)
 	cmd.Stderr = io.MultiWriter(stderrLog, stderrBuf)
 
@@ -160,6 +167,7 @@ func (sai *ScionAppsIntegration) StartClient(ctx context.Context,
 		stderrBuf,
 		sai.ClientOutMatch,
 		sai.ClientErrMatch,
+		0,
 	}
 	return aw, cmd.Start()
 }
@@ -227,12 +235,13 @@ func clientID(src, dst *snet.UDPAddr) string {
 }
 
 type appsWaiter struct {
-	id        string
-	cmd       *exec.Cmd
-	stdoutBuf *bytes.Buffer
-	stderrBuf *bytes.Buffer
-	outMatch  func(stdout string) error
-	errMatch  func(stderrr string) error
+	id           string
+	cmd          *exec.Cmd
+	stdoutBuf    *outputBuffer
+	stderrBuf    *outputBuffer
+	outMatch     func(stdout string) error
+	errMatch     func(stderrr string) error
+	matchTimeout time.Duration
 }
 
 func (aw *appsWaiter) Wait() error {
@@ -244,8 +253,8 @@ func (aw *appsWaiter) Wait() error {
 	if state.ExitCode() > 0 { // Ignore servers killed by the framework
 		return fmt.Errorf("program %s returned non-zero exit code:\n%s [exit code=%d]\nstdout:\n%s\nstderr:\n%s",
 			aw.id, aw.cmd.String(), state.ExitCode(),
-			quotedOutput(aw.stdoutBuf.String()),
-			quotedOutput(aw.stderrBuf.String()),
+			quotedOutput(aw.stdoutBuf.Timestamped()),
+			quotedOutput(aw.stderrBuf.Timestamped()),
 		)
 	}
 	err = aw.checkOutputMatches()
@@ -255,22 +264,92 @@ func (aw *appsWaiter) Wait() error {
 	return nil
 }
 
+// AwaitOutput waits until the output matches, while the program is running,
+// for at most the match timeout. Implements sintegration.OutputAwaiter.
+func (aw *appsWaiter) AwaitOutput() error {
+	if aw.matchTimeout == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(aw.matchTimeout)
+	for {
+		err := aw.checkOutputMatches()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(matchRetryInterval)
+	}
+}
+
 func (aw *appsWaiter) checkOutputMatches() error {
 	if aw.outMatch != nil {
 		if err := aw.outMatch(aw.stdoutBuf.String()); err != nil {
 			return fmt.Errorf("program %s did not produce the expected standard output: %w Got:\n%s",
-				aw.id, err, quotedOutput(aw.stdoutBuf.String()))
+				aw.id, err, quotedOutput(aw.stdoutBuf.Timestamped()))
 		}
 	}
 	if aw.errMatch != nil {
-		if err := aw.outMatch(aw.stderrBuf.String()); err != nil {
+		if err := aw.errMatch(aw.stderrBuf.String()); err != nil {
 			return fmt.Errorf("program %s did not produce the expected error output: %w Got:\n%s",
-				aw.id, err, quotedOutput(aw.stderrBuf.String()))
+				aw.id, err, quotedOutput(aw.stderrBuf.Timestamped()))
 		}
 	}
 	return nil
 }
 
+// outputBuffer collects the output of a program. It is safe for concurrent
+// use, so that the output can be matched while the program is running, and
+// records the time at which each line was written.
+type outputBuffer struct {
+	mutex sync.Mutex
+	start time.Time
+	buf   bytes.Buffer
+	times []time.Time // time at which each line was started
+}
+
+func newOutputBuffer() *outputBuffer {
+	return &outputBuffer{start: time.Now()}
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	for i := range p {
+		if i == 0 && (b.buf.Len() == 0 || b.buf.Bytes()[b.buf.Len()-1] == '\n') ||
+			i > 0 && p[i-1] == '\n' {
+			b.times = append(b.times, now)
+		}
+	}
+	return b.buf.Write(p)
+}
+
+func (b *outputBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// Timestamped returns the output with each line prefixed by the time, since
+// the program was started, at which it was written.
+func (b *outputBuffer) Timestamped() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.buf.Len() == 0 {
+		return ""
+	}
+	lines := strings.SplitAfter(b.buf.String(), "\n")
+	var sb strings.Builder
+	for i, line := range lines {
+		if i >= len(b.times) {
+			break // empty remainder after a final newline
+		}
+		fmt.Fprintf(&sb, "[%9.3fs] %s", b.times[i].Sub(b.start).Seconds(), line)
+	}
+	return sb.String()
+}
+
 // detectingWriter is a "black hole" writer that searches the written data for
 // a given Needle and closes Signal when found.
 type detectingWriter struct {
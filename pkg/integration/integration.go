@@ -63,6 +63,9 @@ const (
 
 	// Default client startup timeout
 	DefaultClientTimeout = 10 * time.Second
+	// matchRetryInterval is the interval at which the output is matched again
+	// while waiting for it to match.
+	matchRetryInterval = 100 * time.Millisecond
 )
 
 var (
@@ -98,6 +98,13 @@ type Waiter interface {
 	Wait() error
 }
 
+// OutputAwaiter can optionally be implemented by the Waiter of a server, to
+// wait for output that the server produces asynchronously. AwaitOutput is
+// invoked before the server is stopped.
+type OutputAwaiter interface {
+	AwaitOutput() error
+}
+
 // Init initializes the integration test, it adds and validates the command line flags,
 // and initializes logging.
 func Init(projectRoot string) error {
@@ -249,6 +256,10 @@ func GetSCIONDAddress(networksFile string, ia addr.IA) (string, error) {
 }
 
 func (s *serverStop) Close() error {
+	var awaitErr error
+	if a, ok := s.wait.(OutputAwaiter); ok {
+		awaitErr = a.AwaitOutput()
+	}
 	s.cancel()
 
 	c := make(chan error)
@@ -257,6 +268,9 @@ func (s *serverStop) Close() error {
 	}()
 	select {
 	case err := <-c:
+		if awaitErr != nil {
+			return awaitErr
+		}
 		return err
 	case <-time.After(KillServerTimeout):
 		return fmt.Errorf("timed out waiting for process to finish. May be hung up copying stdout/stderr")
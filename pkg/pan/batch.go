@@ -0,0 +1,320 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/private/common"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/slayers/path/empty"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/private/topology/underlay"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Message is a single message for ReadBatch and WriteBatch.
+type Message struct {
+	// Buffer holds the payload. For ReadBatch, the payload is read into Buffer.
+	Buffer []byte
+	// N is the length of the payload read into Buffer by ReadBatch.
+	N int
+	// Addr is the remote address. Set by ReadBatch; for WriteBatch, it is the
+	// destination on a ListenConn and ignored on a Conn.
+	Addr UDPAddr
+	// Path is the path for WriteBatch, bypassing the selector. If nil, the
+	// path is chosen as for Write (Conn) or WriteTo (ListenConn).
+	// ReadBatch on a ListenConn sets the (return-)path via which the message
+	// was received.
+	Path *Path
+}
+
+// batchConn is the batch I/O interface of the golang.org/x/net/ipv4 and
+// golang.org/x/net/ipv6 PacketConns, which use the recvmmsg/sendmmsg system
+// calls where available.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// batchState is the state of baseUDPConn for batch reads and writes.
+type batchState struct {
+	conn        batchConn
+	readBuffers []ipv4.Message
+	// interfaces of the local AS, to determine the last hop of packets
+	// forwarded by the shim dispatcher. Loaded on demand.
+	interfaces   map[uint16]netip.AddrPort
+	writeBuffers []ipv4.Message
+}
+
+// supportsBatch returns whether the underlying connection supports batch
+// reads and writes. This does not change when the raw connection is replaced.
+func (c *baseUDPConn) supportsBatch() bool {
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	return c.batch() != nil
+}
+
+// batch returns the batchConn for the underlying UDP socket, or nil if the
+// underlying connection does not support it. Must be called with the
+// underlayMutex held for reading.
+func (c *baseUDPConn) batch() batchConn {
+	c.batchOnce.Do(func() {
+		conn := c.udpConn()
+		if conn == nil {
+			return
+		}
+		if isIPv4Socket(conn) {
+			c.batchState.conn = ipv4.NewPacketConn(conn)
+		} else {
+			c.batchState.conn = ipv6.NewPacketConn(conn)
+		}
+	})
+	return c.batchState.conn
+}
+
+// isIPv4Socket returns whether conn is bound to an IPv4 address.
+func isIPv4Socket(conn *net.UDPConn) bool {
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4() != nil
+}
+
+// readBatch reads up to len(msgs) messages, blocking until at least one
+// message is read. For each UDP packet received, the payload is copied into
+// the next message and accept is invoked; if accept returns false, the
+// message is dropped. accept is invoked while the read buffers are locked;
+// the forwarding path is only valid during this call, and only extracted if
+// withPath is set.
+// SCMP packets are passed to the SCMP handler. An SCMP error, or a
+// PacketAuthError, is returned only if no message was read in the same batch.
+// Malformed packets are dropped.
+func (c *baseUDPConn) readBatch(msgs []Message, withPath bool,
+	accept func(m *Message, remote UDPAddr, fw ForwardingPath) bool) (int, error) {
+
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	if !c.supportsBatch() {
+		for {
+			n, remote, fw, err := c.readMsg(msgs[0].Buffer, withPath)
+			if err != nil {
+				return 0, err
+			}
+			msgs[0].N = n
+			if accept(&msgs[0], remote, fw) {
+				return 1, nil
+			}
+		}
+	}
+
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	ms := c.batchState.readBuffers
+	for len(ms) < len(msgs) {
+		ms = append(ms, ipv4.Message{Buffers: [][]byte{make([]byte, common.SupportedMTU)}})
+	}
+	c.batchState.readBuffers = ms
+	ms = ms[:len(msgs)]
+
+	for {
+		n, err := c.readBatchUnderlay(msgs, ms, withPath, accept)
+		if errors.Is(err, errReadInterrupted) {
+			continue // the raw connection was replaced, read from the new one
+		}
+		return n, err
+	}
+}
+
+// readBatchUnderlay is readBatch on the current raw connection, using the
+// read buffers ms. Must be called with the readMutex held.
+func (c *baseUDPConn) readBatchUnderlay(msgs []Message, ms []ipv4.Message, withPath bool,
+	accept func(m *Message, remote UDPAddr, fw ForwardingPath) bool) (int, error) {
+
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	bc := c.batch()
+	for {
+		k, err := bc.ReadBatch(ms, 0)
+		if err != nil {
+			return 0, c.readErr(err)
+		}
+		n := 0
+		// pktErr is the first SCMP or packet authentication error, returned
+		// if no message is accepted.
+		var pktErr error
+		for _, m := range ms[:k] {
+			from, ok := m.Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			pkt, ok, err := c.decodePacket(m.Buffers[0][:m.N], from.AddrPort(), withPath)
+			if err != nil {
+				var e SCMPError
+				var ae PacketAuthError
+				if (errors.As(err, &e) || errors.As(err, &ae)) && pktErr == nil {
+					pktErr = err
+				}
+				continue
+			}
+			if !ok {
+				continue
+			}
+			msgs[n].N = copy(msgs[n].Buffer, pkt.payload)
+			if !accept(&msgs[n], pkt.remote, pkt.fw) {
+				continue
+			}
+			c.metrics.recordReceived(msgs[n].N)
+			n++
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if pktErr != nil {
+			return 0, pktErr
+		}
+	}
+}
+
+// lastHop returns the underlay address of the last hop of a packet received
+// from the underlay address from, with the SCION header scn and UDP source
+// port srcPort. This mirrors snet.SCIONPacketConn: packets forwarded by the
+// shim dispatcher are attributed to the sender (in the local AS) or to the
+// border router interface on the path.
+func (c *baseUDPConn) lastHop(from netip.AddrPort, scn *slayers.SCION, srcPort uint16) (netip.AddrPort, error) {
+	from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+	local := c.raw.LocalAddr().(*net.UDPAddr).AddrPort()
+	isShim := from.Addr() == local.Addr().Unmap() ||
+		from.Addr().IsLoopback() && from.Port() == underlay.EndhostPort
+	if !isShim {
+		return from, nil
+	}
+	switch scn.PathType {
+	case empty.PathType:
+		src, err := scn.SrcAddr()
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		if src.Type() != addr.HostTypeIP {
+			return netip.AddrPort{}, errors.New("unexpected source address type")
+		}
+		return netip.AddrPortFrom(src.IP(), srcPort), nil
+	case scion.PathType:
+		sp, ok := scn.Path.(*scion.Raw)
+		if !ok {
+			return netip.AddrPort{}, errors.New("unsupported path type")
+		}
+		info, err := sp.GetCurrentInfoField()
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		hf, err := sp.GetCurrentHopField()
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		ifID := hf.ConsIngress
+		if !info.ConsDir {
+			ifID = hf.ConsEgress
+		}
+		if c.batchState.interfaces == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+			defer cancel()
+			interfaces, err := host().sciond.Interfaces(ctx)
+			if err != nil {
+				return netip.AddrPort{}, err
+			}
+			c.batchState.interfaces = interfaces
+		}
+		a, ok := c.batchState.interfaces[ifID]
+		if !ok {
+			return netip.AddrPort{}, errors.New("unknown interface")
+		}
+		return a, nil
+	default:
+		return netip.AddrPort{}, errors.New("unsupported path type")
+	}
+}
+
+// batchRoute is the destination and path of a message in writeBatch.
+type batchRoute struct {
+	dst  UDPAddr
+	path *Path
+}
+
+// writeBatch writes the messages from src to the corresponding routes, as
+// many as possible per system call. Returns the number of messages written;
+// if this is less than len(msgs), the error explains why.
+func (c *baseUDPConn) writeBatch(src UDPAddr, msgs []Message, routes []batchRoute) (int, error) {
+	if !c.supportsBatch() {
+		for i, m := range msgs {
+			if _, err := c.writeMsg(src, routes[i].dst, routes[i].path, m.Buffer); err != nil {
+				return i, err
+			}
+		}
+		return len(msgs), nil
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	bc := c.batch()
+	if err := c.setUnderlayTrafficClass(c.trafficClass); err != nil {
+		return 0, err
+	}
+	ms := c.batchState.writeBuffers
+	for len(ms) < len(msgs) {
+		ms = append(ms, ipv4.Message{Buffers: [][]byte{make([]byte, common.SupportedMTU)}})
+	}
+	c.batchState.writeBuffers = ms
+
+	// serialize the packets up to the first invalid message
+	var prepareErr error
+	k := 0
+	for i, m := range msgs {
+		pkt, nextHop, err := c.serializer.serialize(src, routes[i].dst, routes[i].path, c.trafficClass, m.Buffer, c.auth)
+		if err != nil {
+			prepareErr = err
+			break
+		}
+		buf := ms[i].Buffers[0]
+		ms[i].Buffers[0] = buf[:copy(buf[:cap(buf)], pkt)]
+		ms[i].Addr = net.UDPAddrFromAddrPort(nextHop)
+		k++
+	}
+
+	n := 0
+	for n < k {
+		written, err := bc.WriteBatch(ms[n:k], 0)
+		for i, m := range msgs[n : n+written] {
+			c.mirrorToTap(true, ms[n+i].Addr.(*net.UDPAddr).AddrPort(), ms[n+i].Buffers[0])
+			c.recordWrite(len(m.Buffer))
+		}
+		n += written
+		if err != nil {
+			return n, c.closedErr(err)
+		}
+	}
+	return n, prepareErr
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch(t *testing.T) {
+	sender, src := testLoopbackConn(t, "127.0.0.1")
+	receiver, dst := testLoopbackConn(t, "127.0.0.2")
+	path := testLoopbackPath(t, &src, &dst)
+
+	const numMsgs = 5
+	msgs := make([]Message, numMsgs)
+	routes := make([]batchRoute, numMsgs)
+	for i := range msgs {
+		msgs[i].Buffer = []byte(fmt.Sprintf("message %d", i))
+		routes[i] = batchRoute{dst: dst, path: path}
+	}
+	n, err := sender.writeBatch(src, msgs, routes)
+	require.NoError(t, err)
+	assert.Equal(t, numMsgs, n)
+
+	var received []string
+	for len(received) < numMsgs {
+		recvMsgs := make([]Message, numMsgs)
+		for i := range recvMsgs {
+			recvMsgs[i].Buffer = make([]byte, 100)
+		}
+		n, err := receiver.readBatch(recvMsgs, true, func(m *Message, remote UDPAddr, fw ForwardingPath) bool {
+			assert.Equal(t, src, remote)
+			assert.Equal(t, netip.AddrPortFrom(src.IP, src.Port), fw.underlay)
+			return true
+		})
+		require.NoError(t, err)
+		for _, m := range recvMsgs[:n] {
+			received = append(received, string(m.Buffer[:m.N]))
+		}
+	}
+	for i, m := range msgs {
+		assert.Equal(t, string(m.Buffer), received[i])
+	}
+
+	// messages up to the first invalid message are written
+	msgs[2].Buffer = make([]byte, 2000)
+	n, err = sender.writeBatch(src, msgs, routes)
+	assert.Equal(t, 2, n)
+	assert.ErrorAs(t, err, &ErrMsgTooLarge{})
+}
@@ -15,8 +15,11 @@
 package pan
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 )
 
 var (
@@ -73,3 +76,57 @@ func PolicyFromCommandline(sequence string, preference string, interactive bool)
 		return chain, nil
 	}
 }
+
+// PrintPaths writes the paths to dst accepted by the policy, in the order of
+// the policy, with their metadata to w. This is intended for a --paths flag,
+// which prints the candidate paths of an application and exits, for debugging
+// the path policy given on the command line. The policy should not contain an
+// interactive selection.
+func PrintPaths(ctx context.Context, w io.Writer, dst IA, policy Policy) error {
+	paths, err := QueryPaths(ctx, dst, WithPolicy(policy))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Paths to %v\n", dst)
+	for i, p := range paths {
+		fmt.Fprintf(w, "[%2d] %s\n", i, p)
+		fmt.Fprintf(w, "     %s\n", fmtPathDetails(p))
+	}
+	return nil
+}
+
+// fmtPathDetails formats the MTU, latency, bandwidth and expiry of the path.
+// Latencies and bandwidths not announced on all hops are shown as bounds.
+func fmtPathDetails(p *Path) string {
+	details := []string{fmt.Sprintf("MTU: %d", p.MTU())}
+	if pm := p.Metadata; pm != nil && len(pm.Interfaces) > 0 {
+		hops := len(pm.Interfaces) - 1
+		if len(pm.Latency) == hops {
+			latency, unknown := pm.latencySum()
+			if len(unknown) < hops {
+				details = append(details, "latency: "+fmtBound(">=", len(unknown) > 0, latency.String()))
+			}
+		}
+		if len(pm.Bandwidth) == hops {
+			bandwidth, unknown := pm.bandwidthMin()
+			if len(unknown) < hops {
+				details = append(details, "bandwidth: "+
+					fmtBound("<=", len(unknown) > 0, fmt.Sprintf("%d Kbit/s", bandwidth)))
+			}
+		}
+		if pm.Synthetic {
+			details = append(details, "synthetic")
+		}
+	}
+	if !p.Expiry.IsZero() {
+		details = append(details, "expiry: "+p.Expiry.Format(time.RFC3339))
+	}
+	return strings.Join(details, ", ")
+}
+
+func fmtBound(bound string, partial bool, value string) string {
+	if partial {
+		return bound + value
+	}
+	return value
+}
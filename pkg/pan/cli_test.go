@@ -0,0 +1,78 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFmtPathDetails(t *testing.T) {
+	asA := MustParseIA("1-ff00:0:a")
+	asB := MustParseIA("1-ff00:0:b")
+	asC := MustParseIA("1-ff00:0:c")
+	interfaces := []PathInterface{
+		{IA: asA, IfID: 1}, {IA: asB, IfID: 11}, {IA: asB, IfID: 22}, {IA: asC, IfID: 2},
+	}
+	expiry := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		metadata *PathMetadata
+		expected string
+	}{
+		{
+			name:     "no metadata",
+			expected: "MTU: 0, expiry: 2024-06-01T12:00:00Z",
+		},
+		{
+			name: "all known",
+			metadata: &PathMetadata{
+				Interfaces: interfaces,
+				MTU:        1472,
+				Latency:    []time.Duration{time.Millisecond, 0, 2 * time.Millisecond},
+				Bandwidth:  []uint64{1000, 2000, 500},
+			},
+			expected: "MTU: 1472, latency: >=3ms, bandwidth: 500 Kbit/s, expiry: 2024-06-01T12:00:00Z",
+		},
+		{
+			name: "partially known",
+			metadata: &PathMetadata{
+				Interfaces: interfaces,
+				MTU:        1472,
+				Latency:    []time.Duration{0, 0, 0},
+				Bandwidth:  []uint64{1000, 0, 0},
+			},
+			expected: "MTU: 1472, bandwidth: <=1000 Kbit/s, expiry: 2024-06-01T12:00:00Z",
+		},
+		{
+			name: "synthetic",
+			metadata: &PathMetadata{
+				Interfaces: interfaces,
+				MTU:        1400,
+				Synthetic:  true,
+			},
+			expected: "MTU: 1400, synthetic, expiry: 2024-06-01T12:00:00Z",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Path{Metadata: c.metadata, Fingerprint: "test-fingerprint", Expiry: expiry}
+			assert.Equal(t, c.expected, fmtPathDetails(p))
+		})
+	}
+}
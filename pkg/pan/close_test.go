@@ -0,0 +1,127 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCloseDuringTraffic closes dialed connections while they are read from,
+// written to and reconfigured concurrently. All operations must either succeed
+// or fail with net.ErrClosed.
+func TestCloseDuringTraffic(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		c, remote, _ := testKeepaliveConn(t)
+		core := MustParseIA("1-ff00:0:110")
+		reverse := testPathFromSegments(t, c.remote.IA, c.local.IA, []testSegment{
+			{consDir: false, interfaces: []PathInterface{{c.remote.IA, 4}, {core, 3}}},
+			{consDir: true, interfaces: []PathInterface{{core, 2}, {c.local.IA, 1}}},
+		})
+		reverse.ForwardingPath.underlay = netip.AddrPortFrom(c.local.IP, c.local.Port)
+		// The remote echoes the messages, so that there is traffic to read.
+		go func() {
+			buf := make([]byte, 100)
+			for {
+				n, from, _, err := remote.readMsg(buf, false)
+				if err != nil {
+					return
+				}
+				_, _ = remote.writeMsg(c.remote, from, reverse, buf[:n])
+			}
+		}()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 100)
+		run := func(op func() error) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					err := op()
+					if errors.Is(err, net.ErrClosed) {
+						return
+					} else if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		run(func() error {
+			_, err := c.Write([]byte("hello"))
+			return err
+		})
+		run(func() error {
+			_, err := c.WriteBatch([]Message{{Buffer: []byte("a")}, {Buffer: []byte("b")}})
+			return err
+		})
+		run(func() error {
+			_, err := c.WriteWithTrafficClass(1, []byte("hello"))
+			return err
+		})
+		run(func() error {
+			_, err := c.Read(make([]byte, 100))
+			return err
+		})
+		run(func() error {
+			_, err := c.ReadBatch([]Message{{Buffer: make([]byte, 100)}})
+			return err
+		})
+		run(func() error {
+			c.SetKeepalive(time.Millisecond, KeepaliveEmpty)
+			if c.closed.Load() {
+				return net.ErrClosed
+			}
+			return nil
+		})
+
+		time.Sleep(5 * time.Millisecond)
+		require.NoError(t, c.Close())
+		wg.Wait()
+		require.NoError(t, remote.Close())
+		close(errs)
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+
+		assert.ErrorIs(t, c.Close(), net.ErrClosed)
+		_, err := c.Write([]byte("hello"))
+		assert.ErrorIs(t, err, net.ErrClosed)
+		_, err = c.Read(make([]byte, 100))
+		assert.ErrorIs(t, err, net.ErrClosed)
+		c.SetKeepalive(time.Millisecond, KeepaliveEmpty)
+		assert.Zero(t, c.KeepaliveStatus().Interval)
+	}
+}
+
+func TestPingerClosed(t *testing.T) {
+	p := &Pinger{
+		cancel:  func() {},
+		pending: make(map[uint16]*pendingPing),
+		closed:  true,
+	}
+	r := p.Ping(context.Background(), UDPAddr{}, nil, 0)
+	assert.ErrorIs(t, r.Err, net.ErrClosed)
+	assert.ErrorIs(t, p.Close(), net.ErrClosed)
+}
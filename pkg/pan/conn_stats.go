@@ -0,0 +1,133 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ConnPathStats are the statistics of a dialed connection for one path, see
+// Conn.Stats.
+// Received packets are attributed to the path that is the reverse of the path
+// on which they arrived. As determining this path has a cost, only packets
+// read with ReadVia, or with ReadBatch on a MultiPathConn, are attributed;
+// packets read with Read are not counted here.
+type ConnPathStats struct {
+	Path            *Path
+	SentPackets     uint64
+	SentBytes       uint64
+	SendErrors      uint64
+	ReceivedPackets uint64
+	ReceivedBytes   uint64
+	// SCMPErrors is the number of SCMP error messages received for packets
+	// sent on the path, e.g. destination unreachable. Path down notifications
+	// are not included, they are handled by the selector.
+	SCMPErrors uint64
+	// LastRTT is the most recent round trip time sample for the path to the
+	// remote host, e.g. measured by a PingingSelector. Zero if there is none.
+	LastRTT time.Duration
+	// LastUsed is the time at which a packet was last sent or received on the
+	// path.
+	LastUsed time.Time
+}
+
+// MultiPathStats are the per-path statistics of a MultiPathConn.
+type MultiPathStats = ConnPathStats
+
+// connStats tracks the per-path statistics of a dialed connection. Packets
+// received on a path are only recorded once the path has been used for
+// sending, as the reverse path of a received packet is not a full Path with
+// metadata.
+type connStats struct {
+	mutex sync.Mutex
+	paths map[PathFingerprint]*ConnPathStats
+	order []PathFingerprint
+}
+
+func (s *connStats) recordSent(path *Path, n int, err error) {
+	if path == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ps, ok := s.paths[path.Fingerprint]
+	if !ok {
+		if s.paths == nil {
+			s.paths = make(map[PathFingerprint]*ConnPathStats)
+		}
+		ps = &ConnPathStats{Path: path}
+		s.paths[path.Fingerprint] = ps
+		s.order = append(s.order, path.Fingerprint)
+	}
+	if err != nil {
+		ps.SendErrors++
+		return
+	}
+	ps.SentPackets++
+	ps.SentBytes += uint64(n)
+	ps.LastUsed = time.Now()
+}
+
+func (s *connStats) recordReceived(path *Path, n int) {
+	if path == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ps, ok := s.paths[path.Fingerprint]
+	if !ok {
+		return
+	}
+	ps.ReceivedPackets++
+	ps.ReceivedBytes += uint64(n)
+	ps.LastUsed = time.Now()
+}
+
+// recordError records err, returned when reading, if it is an SCMP error for
+// one of the paths.
+func (s *connStats) recordError(err error) {
+	var scmpErr SCMPError
+	if !errors.As(err, &scmpErr) || scmpErr.path == "" {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ps, ok := s.paths[scmpErr.path]; ok {
+		ps.SCMPErrors++
+	}
+}
+
+// snapshot returns the statistics of all paths used, in the order in which
+// they were first used, with the latest RTT samples to remote.
+func (s *connStats) snapshot(remote scionAddr) []ConnPathStats {
+	s.mutex.Lock()
+	snapshot := make([]ConnPathStats, len(s.order))
+	for i, pf := range s.order {
+		snapshot[i] = *s.paths[pf]
+	}
+	s.mutex.Unlock()
+
+	for i := range snapshot {
+		if sample, ok := stats.lastLatency(remote, snapshot[i].Path.Fingerprint); ok {
+			snapshot[i].LastRTT = sample.Value
+		}
+	}
+	return snapshot
+}
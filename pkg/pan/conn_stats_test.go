@@ -0,0 +1,60 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnStats(t *testing.T) {
+	remote := MustParseUDPAddr("1-ff00:0:112,127.0.0.1:1234").scionAddr()
+	p0 := &Path{Fingerprint: "connstats-p0"}
+	p1 := &Path{Fingerprint: "connstats-p1"}
+	unused := &Path{Fingerprint: "connstats-unused"}
+
+	var s connStats
+	s.recordSent(p1, 10, nil)
+	s.recordSent(p0, 20, nil)
+	s.recordSent(p1, 0, errors.New("send failed"))
+	s.recordSent(nil, 30, nil)
+	s.recordReceived(p1, 5)
+	s.recordReceived(unused, 5)
+	s.recordError(fmt.Errorf("wrapped: %w", SCMPError{path: p0.Fingerprint}))
+	s.recordError(SCMPError{path: unused.Fingerprint})
+	s.recordError(errors.New("other error"))
+	stats.RecordLatency(remote, p0.Fingerprint, 5*time.Millisecond)
+
+	snapshot := s.snapshot(remote)
+	require.Len(t, snapshot, 2)
+	assert.Equal(t, p1, snapshot[0].Path)
+	assert.Equal(t, uint64(1), snapshot[0].SentPackets)
+	assert.Equal(t, uint64(10), snapshot[0].SentBytes)
+	assert.Equal(t, uint64(1), snapshot[0].SendErrors)
+	assert.Equal(t, uint64(1), snapshot[0].ReceivedPackets)
+	assert.Equal(t, uint64(5), snapshot[0].ReceivedBytes)
+	assert.Zero(t, snapshot[0].LastRTT)
+	assert.WithinDuration(t, time.Now(), snapshot[0].LastUsed, time.Second)
+
+	assert.Equal(t, p0, snapshot[1].Path)
+	assert.Equal(t, uint64(1), snapshot[1].SentPackets)
+	assert.Equal(t, uint64(1), snapshot[1].SCMPErrors)
+	assert.Equal(t, 5*time.Millisecond, snapshot[1].LastRTT)
+}
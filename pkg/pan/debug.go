@@ -0,0 +1,223 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DebugInfoHandler returns an http.Handler serving debugging information
+// about this package:
+//
+//   - /debug/pan/pool: the paths in the path pool, per destination IA
+//   - /debug/pan/stats: the path and interface statistics, e.g. down
+//     notifications and discovered MTUs
+//   - /debug/pan/conns: the open connections, with their per-path statistics
+//   - /debug/pan/goroutines: the goroutines of this package, see Goroutines
+//
+// The endpoints return JSON. The handler exposes internals of the
+// application and should only be served on trusted addresses. The pandebug
+// package serves it together with the Go runtime profiles.
+func DebugInfoHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pan/pool", serveJSON(debugPool))
+	mux.HandleFunc("/debug/pan/stats", serveJSON(debugStats))
+	mux.HandleFunc("/debug/pan/conns", serveJSON(openConns.snapshot))
+	mux.HandleFunc("/debug/pan/goroutines", serveJSON(func() any { return Goroutines() }))
+	return mux
+}
+
+func serveJSON[T any](f func() T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// debugPath is the JSON representation of a path.
+type debugPath struct {
+	Fingerprint PathFingerprint
+	Path        string
+	Expiry      time.Time
+}
+
+func newDebugPath(p *Path) debugPath {
+	return debugPath{Fingerprint: p.Fingerprint, Path: p.String(), Expiry: p.Expiry}
+}
+
+type debugPoolEntry struct {
+	LastQuery time.Time
+	Paths     []debugPath
+}
+
+func debugPool() map[string]debugPoolEntry {
+	pool.entriesMutex.RLock()
+	defer pool.entriesMutex.RUnlock()
+
+	entries := make(map[string]debugPoolEntry, len(pool.entries))
+	for ia, e := range pool.entries {
+		entry := debugPoolEntry{LastQuery: e.lastQuery, Paths: make([]debugPath, len(e.paths))}
+		for i, p := range e.paths {
+			entry.Paths[i] = newDebugPath(p)
+		}
+		entries[ia.String()] = entry
+	}
+	return entries
+}
+
+type debugStatsSnapshot struct {
+	Paths      map[PathFingerprint]PathStats
+	Interfaces map[string]PathInterfaceStats
+}
+
+func debugStats() debugStatsSnapshot {
+	stats.mutex.RLock()
+	defer stats.mutex.RUnlock()
+
+	snapshot := debugStatsSnapshot{
+		Paths:      make(map[PathFingerprint]PathStats, len(stats.paths)),
+		Interfaces: make(map[string]PathInterfaceStats, len(stats.interfaces)),
+	}
+	for pf, s := range stats.paths {
+		snapshot.Paths[pf] = s
+	}
+	for pi, s := range stats.interfaces {
+		snapshot.Interfaces[fmt.Sprintf("%s#%d", pi.IA, pi.IfID)] = s
+	}
+	return snapshot
+}
+
+// openConns tracks the open connections, for the debug handler and for
+// address migration.
+var openConns connRegistry
+
+// debugConn is the JSON representation of an open connection.
+type debugConn struct {
+	Type   string // "dialed" or "listen"
+	Local  string
+	Remote string `json:",omitempty"`
+	Opened time.Time
+	Paths  []debugConnPath `json:",omitempty"`
+}
+
+type debugConnPath struct {
+	debugPath
+	SentPackets     uint64
+	SentBytes       uint64
+	SendErrors      uint64
+	ReceivedPackets uint64
+	ReceivedBytes   uint64
+	SCMPErrors      uint64
+	LastRTT         time.Duration
+	LastUsed        time.Time
+}
+
+type connRegistry struct {
+	mutex sync.Mutex
+	conns map[any]debugConn
+}
+
+func (r *connRegistry) add(c *dialedConn) {
+	r.register(c, debugConn{Type: "dialed", Local: c.localAddr().String(), Remote: c.remote.String()})
+}
+
+func (r *connRegistry) addListen(c *listenConn) {
+	r.register(c, debugConn{Type: "listen", Local: c.local.String()})
+}
+
+func (r *connRegistry) register(c any, info debugConn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.conns == nil {
+		r.conns = make(map[any]debugConn)
+	}
+	info.Opened = time.Now()
+	r.conns[c] = info
+}
+
+// updateLocal updates the local address of a dialed connection that migrated
+// to a new local address.
+func (r *connRegistry) updateLocal(c *dialedConn, local UDPAddr) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if info, ok := r.conns[c]; ok {
+		info.Local = local.String()
+		r.conns[c] = info
+	}
+}
+
+// dialed returns the open dialed connections.
+func (r *connRegistry) dialed() []*dialedConn {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var conns []*dialedConn
+	for c := range r.conns {
+		if dc, ok := c.(*dialedConn); ok {
+			conns = append(conns, dc)
+		}
+	}
+	return conns
+}
+
+func (r *connRegistry) remove(c any) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.conns, c)
+}
+
+// snapshot returns the open connections, in the order in which they were
+// opened.
+func (r *connRegistry) snapshot() []debugConn {
+	r.mutex.Lock()
+	conns := make([]any, 0, len(r.conns))
+	infos := make([]debugConn, 0, len(r.conns))
+	for c, info := range r.conns {
+		conns = append(conns, c)
+		infos = append(infos, info)
+	}
+	r.mutex.Unlock()
+
+	// The stats are collected without holding the registry lock.
+	for i, c := range conns {
+		dc, ok := c.(*dialedConn)
+		if !ok {
+			continue
+		}
+		for _, s := range dc.Stats() {
+			infos[i].Paths = append(infos[i].Paths, debugConnPath{
+				debugPath:       newDebugPath(s.Path),
+				SentPackets:     s.SentPackets,
+				SentBytes:       s.SentBytes,
+				SendErrors:      s.SendErrors,
+				ReceivedPackets: s.ReceivedPackets,
+				ReceivedBytes:   s.ReceivedBytes,
+				SCMPErrors:      s.SCMPErrors,
+				LastRTT:         s.LastRTT,
+				LastUsed:        s.LastUsed,
+			})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Opened.Before(infos[j].Opened) })
+	return infos
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugInfoHandler(t *testing.T) {
+	c := &dialedConn{
+		local:  MustParseUDPAddr("1-ff00:0:111,127.0.0.1:1234"),
+		remote: MustParseUDPAddr("1-ff00:0:112,127.0.0.2:1234"),
+	}
+	p := &Path{Fingerprint: "debug-p0", Source: c.local.IA, Destination: c.remote.IA}
+	c.pathStats.recordSent(p, 10, nil)
+	openConns.add(c)
+	defer openConns.remove(c)
+
+	server := httptest.NewServer(DebugInfoHandler())
+	defer server.Close()
+
+	get := func(path string, v any) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	var conns []debugConn
+	get("/debug/pan/conns", &conns)
+	var found *debugConn
+	for i := range conns {
+		if conns[i].Remote == c.remote.String() {
+			found = &conns[i]
+		}
+	}
+	require.NotNil(t, found)
+	assert.Equal(t, "dialed", found.Type)
+	require.Len(t, found.Paths, 1)
+	assert.Equal(t, p.Fingerprint, found.Paths[0].Fingerprint)
+	assert.Equal(t, uint64(10), found.Paths[0].SentBytes)
+
+	get("/debug/pan/pool", &map[string]debugPoolEntry{})
+	get("/debug/pan/stats", &debugStatsSnapshot{})
+	get("/debug/pan/goroutines", &map[string]int{})
+}
@@ -0,0 +1,283 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// dedupHeaderLen is the length of the header prepended to the payload of each
+// packet when deduplication is enabled: a 4 byte epoch and an 8 byte sequence
+// number.
+const dedupHeaderLen = 12
+
+// DedupStats are the statistics of the deduplication of received packets,
+// see EnableDeduplication.
+type DedupStats struct {
+	// Delivered is the number of unique packets delivered to the application.
+	Delivered uint64
+	// Duplicates is the number of duplicate packets suppressed.
+	Duplicates uint64
+	// Late is the number of packets dropped because they arrived too late to
+	// be checked for duplicates, i.e. after more than dedupWindowSize newer
+	// packets.
+	Late uint64
+	// Lost is the number of packets that were not received on any path, i.e.
+	// the effective loss after combining the paths. A packet is counted as
+	// lost once dedupWindowSize newer packets have been received.
+	Lost uint64
+	// Malformed is the number of packets dropped because they were too short
+	// to contain the header.
+	Malformed uint64
+}
+
+// deduplicator prepends a sequence number to each packet sent and suppresses
+// duplicates of packets received, e.g. the copies of packets sent over
+// multiple paths by a RedundantScheduler. The sequence numbers are tracked
+// separately for each remote.
+// The sequence numbers sent to a remote start at 0 in a random epoch, sent
+// along with them. When the state of a remote is discarded after
+// dedupStateTimeout, the sequence numbers restart in a new epoch, for which
+// the remote resets its receive window; otherwise the restarted sequence
+// numbers would be dropped as late by the remote.
+// The methods are no-ops on a nil receiver, i.e. if deduplication is disabled.
+type deduplicator struct {
+	metrics *connMetrics
+
+	mutex     sync.Mutex
+	remotes   map[UDPAddr]*dedupState
+	lastSweep time.Time
+	stats     DedupStats
+}
+
+// dedupState is the deduplication state for one remote.
+type dedupState struct {
+	sendEpoch uint32
+	sendSeq   uint64
+	// recvEpoch is the epoch of the receive window, prevEpoch the epoch
+	// before it, if hasPrevEpoch.
+	recvEpoch    uint32
+	prevEpoch    uint32
+	hasPrevEpoch bool
+	recv         dedupWindow
+	seen         time.Time
+}
+
+// check checks the sequence number seq of the epoch, see dedupWindow.check.
+// A new epoch resets the window. Packets of the previous epoch are late.
+func (s *dedupState) check(epoch uint32, seq uint64) (dedupResult, uint64) {
+	if s.recv.initialized && epoch != s.recvEpoch {
+		if s.hasPrevEpoch && epoch == s.prevEpoch {
+			return dedupLate, 0
+		}
+		s.prevEpoch, s.hasPrevEpoch = s.recvEpoch, true
+		s.recv = dedupWindow{}
+	}
+	s.recvEpoch = epoch
+	return s.recv.check(seq)
+}
+
+func newDeduplicator(metrics *connMetrics) *deduplicator {
+	return &deduplicator{
+		metrics:   metrics,
+		remotes:   make(map[UDPAddr]*dedupState),
+		lastSweep: time.Now(),
+	}
+}
+
+// write prepends the next sequence number for dst to b and writes the result
+// with write. Returns len(b) on success.
+func (d *deduplicator) write(dst UDPAddr, b []byte, write func([]byte) (int, error)) (int, error) {
+	if d == nil {
+		return write(b)
+	}
+	if _, err := write(d.frame(dst, b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// frame returns a copy of b, prefixed with the epoch and the next sequence
+// number for dst.
+func (d *deduplicator) frame(dst UDPAddr, b []byte) []byte {
+	d.mutex.Lock()
+	s := d.state(dst)
+	epoch, seq := s.sendEpoch, s.sendSeq
+	s.sendSeq++
+	d.mutex.Unlock()
+
+	framed := make([]byte, dedupHeaderLen+len(b))
+	binary.BigEndian.PutUint32(framed, epoch)
+	binary.BigEndian.PutUint64(framed[4:], seq)
+	copy(framed[dedupHeaderLen:], b)
+	return framed
+}
+
+// frameBatch returns a copy of the messages with the sequence numbers
+// prepended, for the destination returned by dst for each message.
+func (d *deduplicator) frameBatch(msgs []Message, dst func(*Message) UDPAddr) []Message {
+	if d == nil {
+		return msgs
+	}
+	framed := make([]Message, len(msgs))
+	for i := range msgs {
+		framed[i] = msgs[i]
+		framed[i].Buffer = d.frame(dst(&msgs[i]), msgs[i].Buffer)
+	}
+	return framed
+}
+
+// receive checks the sequence number of the packet p received from remote.
+// If the packet is not a duplicate, the sequence number is removed, i.e. the
+// payload is moved to the start of p, and the length of the payload is
+// returned. Returns false if the packet is to be dropped.
+func (d *deduplicator) receive(remote UDPAddr, p []byte) (int, bool) {
+	if d == nil {
+		return len(p), true
+	}
+	if len(p) < dedupHeaderLen {
+		d.mutex.Lock()
+		d.stats.Malformed++
+		d.mutex.Unlock()
+		return 0, false
+	}
+	epoch := binary.BigEndian.Uint32(p)
+	seq := binary.BigEndian.Uint64(p[4:])
+
+	d.mutex.Lock()
+	result, lost := d.state(remote).check(epoch, seq)
+	d.stats.Lost += lost
+	switch result {
+	case dedupNew:
+		d.stats.Delivered++
+	case dedupDuplicate:
+		d.stats.Duplicates++
+	case dedupLate:
+		d.stats.Late++
+	}
+	d.mutex.Unlock()
+
+	d.metrics.recordDedupLost(lost)
+	if result == dedupDuplicate {
+		d.metrics.recordDuplicate()
+	}
+	if result != dedupNew {
+		return 0, false
+	}
+	return copy(p, p[dedupHeaderLen:]), true
+}
+
+func (d *deduplicator) Stats() DedupStats {
+	if d == nil {
+		return DedupStats{}
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.stats
+}
+
+// state returns the state for remote, creating it if necessary. Discards the
+// state of remotes not heard from for dedupStateTimeout.
+// Must be called with the mutex held.
+func (d *deduplicator) state(remote UDPAddr) *dedupState {
+	now := time.Now()
+	s, ok := d.remotes[remote]
+	if !ok {
+		if now.Sub(d.lastSweep) > dedupStateTimeout {
+			for r, rs := range d.remotes {
+				if now.Sub(rs.seen) > dedupStateTimeout {
+					delete(d.remotes, r)
+				}
+			}
+			d.lastSweep = now
+		}
+		s = &dedupState{sendEpoch: rand.Uint32()}
+		d.remotes[remote] = s
+	}
+	s.seen = now
+	return s
+}
+
+type dedupResult int
+
+const (
+	dedupNew dedupResult = iota
+	dedupDuplicate
+	dedupLate
+)
+
+// dedupWindow is a sliding window over the received sequence numbers, as for
+// the IPsec anti-replay check (RFC 4303, Appendix A). The window covers the
+// dedupWindowSize sequence numbers up to and including the highest received.
+type dedupWindow struct {
+	initialized bool
+	first       uint64 // first sequence number received
+	highest     uint64
+	received    [dedupWindowSize / 64]uint64 // bit seq%dedupWindowSize
+}
+
+// check records the sequence number seq as received. Also returns the number
+// of sequence numbers that left the window without having been received.
+func (w *dedupWindow) check(seq uint64) (dedupResult, uint64) {
+	if !w.initialized {
+		*w = dedupWindow{initialized: true, first: seq, highest: seq}
+		w.set(seq)
+		return dedupNew, 0
+	}
+	if seq <= w.highest {
+		if w.highest-seq >= dedupWindowSize {
+			return dedupLate, 0
+		}
+		if w.isSet(seq) {
+			return dedupDuplicate, 0
+		}
+		w.set(seq)
+		return dedupNew, 0
+	}
+
+	// Advance the window to seq. The sequence numbers leaving the window are
+	// counted as lost if they have not been received, unless they are before
+	// the first sequence number received.
+	var lost uint64
+	for s := w.highest + 1; s <= seq && s-w.highest <= dedupWindowSize; s++ {
+		// The bit of s is the bit of s-dedupWindowSize, leaving the window.
+		if s >= dedupWindowSize && s-dedupWindowSize >= w.first && !w.isSet(s) {
+			lost++
+		}
+		w.clear(s)
+	}
+	if advance := seq - w.highest; advance > dedupWindowSize {
+		// Sequence numbers that skipped the window entirely.
+		lost += advance - dedupWindowSize
+	}
+	w.highest = seq
+	w.set(seq)
+	return dedupNew, lost
+}
+
+func (w *dedupWindow) isSet(seq uint64) bool {
+	return w.received[seq/64%uint64(len(w.received))]&(1<<(seq%64)) != 0
+}
+
+func (w *dedupWindow) set(seq uint64) {
+	w.received[seq/64%uint64(len(w.received))] |= 1 << (seq % 64)
+}
+
+func (w *dedupWindow) clear(seq uint64) {
+	w.received[seq/64%uint64(len(w.received))] &^= 1 << (seq % 64)
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupWindow(t *testing.T) {
+	var w dedupWindow
+	check := func(seq uint64, expected dedupResult, expectedLost uint64) {
+		t.Helper()
+		result, lost := w.check(seq)
+		assert.Equal(t, expected, result, "seq %d", seq)
+		assert.Equal(t, expectedLost, lost, "seq %d", seq)
+	}
+
+	// Sequence numbers before the first one received are not lost.
+	check(2000, dedupNew, 0)
+	check(2000, dedupDuplicate, 0)
+	check(2002, dedupNew, 0)
+	check(2001, dedupNew, 0)
+	check(2001, dedupDuplicate, 0)
+	check(2004, dedupNew, 0)
+	check(2000+dedupWindowSize+1, dedupNew, 0)
+	// 2003 leaves the window.
+	check(2000+dedupWindowSize+3, dedupNew, 1)
+	check(2003, dedupLate, 0)
+	check(2004, dedupDuplicate, 0)
+	check(2000+dedupWindowSize+2, dedupNew, 0)
+	check(2000+dedupWindowSize+2, dedupDuplicate, 0)
+
+	// Jump ahead by more than the window: everything not received in the
+	// window (all but 4 sequence numbers) and everything skipped is lost.
+	highest := uint64(2000 + dedupWindowSize + 3)
+	check(highest+2*dedupWindowSize, dedupNew, 2*dedupWindowSize-4)
+}
+
+func TestDeduplicator(t *testing.T) {
+	a := MustParseUDPAddr("1-ff00:0:111,127.0.0.1:1234")
+	b := MustParseUDPAddr("1-ff00:0:112,127.0.0.1:1234")
+	d := newDeduplicator(nil)
+
+	var sent [][]byte
+	send := func(b []byte) (int, error) {
+		sent = append(sent, append([]byte(nil), b...))
+		return len(b), nil
+	}
+	n, err := d.write(a, []byte("hello"), send)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, _ = d.write(a, []byte("world"), send)
+	_, _ = d.write(b, []byte("hello"), send)
+	require.Len(t, sent, 3)
+	assert.Len(t, sent[0], dedupHeaderLen+5)
+	// Sequence numbers are per remote.
+	assert.Equal(t, sent[0][4:dedupHeaderLen], sent[2][4:dedupHeaderLen])
+
+	receive := func(remote UDPAddr, p []byte) (string, bool) {
+		buf := append([]byte(nil), p...)
+		n, ok := d.receive(remote, buf)
+		return string(buf[:n]), ok
+	}
+	for _, p := range [][]byte{sent[0], sent[0], sent[1], sent[0], sent[1]} {
+		_, _ = receive(a, p)
+	}
+	payload, ok := receive(b, sent[2])
+	assert.True(t, ok)
+	assert.Equal(t, "hello", payload)
+	_, ok = receive(b, []byte{1, 2, 3})
+	assert.False(t, ok)
+	assert.Equal(t, DedupStats{Delivered: 3, Duplicates: 3, Malformed: 1}, d.Stats())
+
+	// Disabled, i.e. nil deduplicator.
+	var disabled *deduplicator
+	n, ok = disabled.receive(a, []byte("abc"))
+	assert.True(t, ok)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, DedupStats{}, disabled.Stats())
+}
+
+func TestDeduplicatorEviction(t *testing.T) {
+	client := MustParseUDPAddr("1-ff00:0:111,127.0.0.1:1234")
+	server := MustParseUDPAddr("1-ff00:0:112,127.0.0.1:1234")
+	other := MustParseUDPAddr("1-ff00:0:113,127.0.0.1:1234")
+	listener := newDeduplicator(nil)
+	dialer := newDeduplicator(nil)
+
+	var sent []byte
+	send := func(b []byte) (int, error) {
+		sent = append([]byte(nil), b...)
+		return len(b), nil
+	}
+	reply := func(msg string) bool {
+		t.Helper()
+		_, err := listener.write(client, []byte(msg), send)
+		require.NoError(t, err)
+		p := append([]byte(nil), sent...)
+		n, ok := dialer.receive(server, p)
+		if ok {
+			assert.Equal(t, msg, string(p[:n]))
+		}
+		return ok
+	}
+	for i := 0; i < 2*dedupWindowSize; i++ {
+		require.True(t, reply(fmt.Sprint(i)))
+	}
+	old := append([]byte(nil), sent...)
+
+	// The client is idle, and its state on the listener is evicted when the
+	// state of another remote is created.
+	listener.remotes[client].seen = time.Now().Add(-2 * dedupStateTimeout)
+	listener.lastSweep = time.Now().Add(-2 * dedupStateTimeout)
+	_, _ = listener.write(other, []byte("x"), send)
+	require.NotContains(t, listener.remotes, client)
+
+	// The replies restart at sequence number 0, in a new epoch.
+	assert.True(t, reply("resumed"))
+	assert.True(t, reply("resumed again"))
+	// Packets of the previous epoch are late.
+	_, ok := dialer.receive(server, old)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), dialer.Stats().Late)
+}
@@ -35,16 +35,55 @@ const (
 	// pathRefreshLeadTime specifies when a refresh is triggered for a
 	// path, relative to its expiry.
 	pathRefreshLeadTime = 2 * time.Minute
-	// pathPruneLeadTime specifies when, relative to its expiry, a path
-	// that is no longer returned from a path query is dropped from the cache.
-	pathPruneLeadTime = pathRefreshMinInterval
+	// pathLookupTimeout is the timeout for on-demand path lookups when writing
+	// to a remote without a known path, see ListenConn.WriteToIA.
+	pathLookupTimeout = 10 * time.Second
+
+	// daemonRetryTimeout is the maximum time for which requests to the SCION
+	// daemon are retried while it is unavailable, e.g. during a restart.
+	daemonRetryTimeout    = 30 * time.Second
+	daemonRetryBackoffMin = 100 * time.Millisecond
+	daemonRetryBackoffMax = 5 * time.Second
 
 	pathDownNotificationTimeout         = 10 * time.Second
 	pathDownNotificationChannelCapacity = 8
 
+	// pathMTUTimeout is the time after which a path MTU learned from an SCMP
+	// packet too big message is discarded, so that an increase of the path
+	// MTU is eventually detected (see RFC 1191).
+	pathMTUTimeout = 10 * time.Minute
+	// minPathMTU is the minimum MTU of SCION links. SCMP packet too big
+	// messages reporting a lower MTU are ignored, as they are not
+	// authenticated (see RFC 8201).
+	minPathMTU = 1280
+
+	// dialProbeCandidates is the number of paths probed by DialUDPProbed.
+	dialProbeCandidates = 3
+	// dialProbeStagger is the delay between the probes on successive paths in
+	// DialUDPProbed, as the connection attempt delay of RFC 8305.
+	dialProbeStagger = 250 * time.Millisecond
+	// dialProbeTimeout is the time DialUDPProbed waits for a probe reply.
+	dialProbeTimeout = 2 * time.Second
+
 	defaultSelectorMaxReplyPaths = 4
 
 	statsNumLatencySamples = 4
+
+	eventChannelCapacity = 32
+
+	// dedupWindowSize is the number of sequence numbers per remote tracked for
+	// the deduplication of received packets, see EnableDeduplication.
+	dedupWindowSize = 1024
+	// dedupStateTimeout is the time after which the deduplication state of a
+	// remote that has not been heard from is discarded.
+	dedupStateTimeout = 5 * time.Minute
+
+	// fecWindowSize is the number of blocks per remote tracked for the
+	// recovery of lost packets, see NewFECConn.
+	fecWindowSize = 16
+	// fecStateTimeout is the time after which the forward error correction
+	// state of a remote that has not been heard from is discarded.
+	fecStateTimeout = 5 * time.Minute
 )
 
 // maxTime is the maximum usable time value (https://stackoverflow.com/a/32620397)
@@ -0,0 +1,255 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discovery announces and browses SCION service endpoints on the
+// local network with multicast DNS (mDNS) and DNS service discovery (DNS-SD),
+// so that e.g. servers in lab and demo deployments can be found without
+// exchanging addresses manually.
+//
+// A service is announced as a DNS-SD instance of its service type, e.g.
+// "my-server._scion._udp.local.", with a TXT record "scion=<address>" holding
+// its SCION address. Only IPv4 multicast is used.
+// Announcements are not authenticated; any host on the local network can
+// announce any service.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+// DefaultServiceType is the service type used if Service.Type is empty.
+const DefaultServiceType = "_scion._udp"
+
+const (
+	// recordTTL is the TTL of the announced records, in seconds.
+	recordTTL = 120
+	// queryInterval is the interval in which Browse repeats its query.
+	queryInterval = time.Second
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is a SCION service endpoint.
+type Service struct {
+	// Name is the instance name of the service, a single DNS label, e.g.
+	// "my-server".
+	Name string
+	// Type is the DNS-SD service type, e.g. "_scion-udp-echo._udp".
+	// DefaultServiceType if empty.
+	Type string
+	// Addr is the SCION address of the service.
+	Addr pan.UDPAddr
+}
+
+func (s Service) String() string {
+	return fmt.Sprintf("%s (%s) %s", s.Name, serviceType(s.Type), s.Addr)
+}
+
+func (s Service) validate() error {
+	if s.Name == "" || len(s.Name) > 63 || strings.ContainsAny(s.Name, ".\\") {
+		return fmt.Errorf("invalid service name %q, must be a DNS label", s.Name)
+	}
+	if err := validateType(serviceType(s.Type)); err != nil {
+		return err
+	}
+	if s.Addr.Port == 0 {
+		return errors.New("invalid service address, port must be set")
+	}
+	return nil
+}
+
+func serviceType(t string) string {
+	if t == "" {
+		return DefaultServiceType
+	}
+	return t
+}
+
+// validateType checks that t is of the form "_<service>._udp" or
+// "_<service>._tcp".
+func validateType(t string) error {
+	service, proto, ok := strings.Cut(t, ".")
+	if !ok || len(service) < 2 || len(service) > 16 || service[0] != '_' ||
+		strings.Contains(proto, ".") || (proto != "_udp" && proto != "_tcp") {
+		return fmt.Errorf("invalid service type %q, expected e.g. %q", t, DefaultServiceType)
+	}
+	return nil
+}
+
+// Announcer announces a service on the local network, see Announce.
+type Announcer struct {
+	records   records
+	conn      net.PacketConn
+	group     net.Addr
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Announce announces the service on the local network, until the Announcer
+// is closed. The service is announced once when starting, and then included
+// in the replies to the queries for its service type.
+func Announce(service Service) (*Announcer, error) {
+	if err := service.validate(); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	return newAnnouncer(service, conn, mdnsGroup), nil
+}
+
+func newAnnouncer(service Service, conn net.PacketConn, group net.Addr) *Announcer {
+	a := &Announcer{
+		records: newRecords(service, hostDomain()),
+		conn:    conn,
+		group:   group,
+		done:    make(chan struct{}),
+	}
+	a.announce(recordTTL)
+	go a.serve()
+	return a
+}
+
+// Close stops the announcement and informs the other hosts that the service
+// is gone.
+func (a *Announcer) Close() error {
+	err := net.ErrClosed
+	a.closeOnce.Do(func() {
+		a.announce(0)
+		err = a.conn.Close()
+		<-a.done
+	})
+	return err
+}
+
+// announce sends an unsolicited response with the records, with the given
+// TTL. A TTL of 0 withdraws the records.
+func (a *Announcer) announce(ttl uint32) {
+	if b, err := a.records.response(0, nil, ttl, true); err == nil {
+		_, _ = a.conn.WriteTo(b, a.group)
+	}
+}
+
+// serve answers the queries for the service until the connection is closed.
+func (a *Announcer) serve() {
+	defer close(a.done)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			continue
+		}
+		id, questions, ok := a.records.match(buf[:n])
+		if !ok {
+			continue
+		}
+		// Queries from a port other than the mDNS port are legacy unicast
+		// queries, which are answered directly with a short TTL (RFC 6762,
+		// 6.7).
+		if udp, ok := from.(*net.UDPAddr); ok && udp.Port != mdnsGroup.Port {
+			if b, err := a.records.response(id, questions, 10, false); err == nil {
+				_, _ = a.conn.WriteTo(b, from)
+			}
+			continue
+		}
+		if b, err := a.records.response(0, nil, recordTTL, true); err == nil {
+			_, _ = a.conn.WriteTo(b, a.group)
+		}
+	}
+}
+
+// Browse queries the local network for services of the given type,
+// DefaultServiceType if empty, and returns the services found until ctx is
+// done, sorted by name. The query is repeated every second.
+// Use a context with a timeout, e.g. of a few seconds.
+func Browse(ctx context.Context, typ string) ([]Service, error) {
+	typ = serviceType(typ)
+	if err := validateType(typ); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return browse(ctx, conn, mdnsGroup, typ)
+}
+
+func browse(ctx context.Context, conn net.PacketConn, dst net.Addr, typ string) ([]Service, error) {
+	query, err := newQuery(typ)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]Service)
+	buf := make([]byte, 9000)
+	for ctx.Err() == nil {
+		if _, err := conn.WriteTo(query, dst); err != nil {
+			return nil, err
+		}
+		nextQuery := time.Now().Add(queryInterval)
+		deadline := nextQuery
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			for _, u := range parseResponse(buf[:n], typ) {
+				if u.ttl == 0 {
+					delete(found, u.service.Name)
+				} else {
+					found[u.service.Name] = u.service
+				}
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	services := make([]Service, 0, len(found))
+	for _, s := range found {
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// hostDomain returns the mDNS domain name of this host, used as the target of
+// the SRV records.
+func hostDomain() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "scion-host"
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return host + ".local."
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+func TestAnnounceBrowse(t *testing.T) {
+	// The announcer and browser talk directly over the loopback instead of
+	// the multicast group.
+	group, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer group.Close()
+	announcerConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	service := Service{
+		Name: "echo",
+		Type: "_scion-echo._udp",
+		Addr: pan.MustParseUDPAddr("1-ff00:0:110,[192.0.2.1]:40003"),
+	}
+	a := newAnnouncer(service, announcerConn, group.LocalAddr())
+
+	// The service is announced to the group when starting.
+	buf := make([]byte, 9000)
+	require.NoError(t, group.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := group.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []serviceUpdate{{service: service, ttl: recordTTL}},
+		parseResponse(buf[:n], service.Type))
+
+	browser, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer browser.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	services, err := browse(ctx, browser, announcerConn.LocalAddr(), service.Type)
+	require.NoError(t, err)
+	assert.Equal(t, []Service{service}, services)
+
+	// Other service types are not found.
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	services, err = browse(ctx, browser, announcerConn.LocalAddr(), DefaultServiceType)
+	require.NoError(t, err)
+	assert.Empty(t, services)
+
+	// Closing withdraws the service.
+	require.NoError(t, a.Close())
+	n, _, err = group.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []serviceUpdate{{service: service, ttl: 0}},
+		parseResponse(buf[:n], service.Type))
+	assert.ErrorIs(t, a.Close(), net.ErrClosed)
+}
+
+func TestServiceValidate(t *testing.T) {
+	addr := pan.MustParseUDPAddr("1-ff00:0:110,[192.0.2.1]:40003")
+	assert.NoError(t, Service{Name: "server", Addr: addr}.validate())
+	assert.NoError(t, Service{Name: "server", Type: "_http._tcp", Addr: addr}.validate())
+	assert.Error(t, Service{Name: "", Addr: addr}.validate())
+	assert.Error(t, Service{Name: "my.server", Addr: addr}.validate())
+	assert.Error(t, Service{Name: "server", Type: "_http", Addr: addr}.validate())
+	assert.Error(t, Service{Name: "server", Type: "http._udp", Addr: addr}.validate())
+	assert.Error(t, Service{Name: "server", Type: "_http._sctp", Addr: addr}.validate())
+	assert.Error(t, Service{Name: "server", Addr: pan.UDPAddr{IA: addr.IA, IP: addr.IP}}.validate())
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"math/rand"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+const (
+	// txtAddrPrefix is the prefix of the TXT record with the SCION address.
+	txtAddrPrefix = "scion="
+	// classCacheFlush is the bit in the class of a record in an mDNS
+	// response, indicating that the record replaces all cached records of the
+	// same name and type (RFC 6762, 10.2).
+	classCacheFlush = 1 << 15
+	// classUnicastResponse is the bit in the class of an mDNS question,
+	// requesting a unicast response (RFC 6762, 5.4).
+	classUnicastResponse = 1 << 15
+)
+
+// records are the DNS-SD records of an announced service.
+type records struct {
+	service  Service
+	typ      dnsmessage.Name // e.g. _scion._udp.local.
+	instance dnsmessage.Name // e.g. my-server._scion._udp.local.
+	host     dnsmessage.Name // e.g. my-host.local.
+}
+
+func newRecords(service Service, host string) records {
+	typ := serviceType(service.Type) + ".local."
+	return records{
+		service:  service,
+		typ:      dnsmessage.MustNewName(typ),
+		instance: dnsmessage.MustNewName(service.Name + "." + typ),
+		host:     dnsmessage.MustNewName(host),
+	}
+}
+
+// match parses the query msg and returns its ID and questions, and whether
+// any of the questions asks for the records.
+func (r records) match(msg []byte) (uint16, []dnsmessage.Question, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || h.Response {
+		return 0, nil, false
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return 0, nil, false
+	}
+	matched := false
+	for _, q := range questions {
+		class := q.Class &^ classUnicastResponse
+		if class != dnsmessage.ClassINET && class != dnsmessage.ClassANY {
+			continue
+		}
+		switch {
+		case equalNames(q.Name, r.typ):
+			matched = matched || q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL
+		case equalNames(q.Name, r.instance):
+			matched = matched || q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeTXT ||
+				q.Type == dnsmessage.TypeALL
+		}
+	}
+	return h.ID, questions, matched
+}
+
+// response returns a response with the records, with the given TTL. The
+// questions are included for legacy unicast responses; multicast responses
+// set the cache flush bit on the records unique to this host.
+func (r records) response(id uint16, questions []dnsmessage.Question, ttl uint32,
+	multicast bool) ([]byte, error) {
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	unique := dnsmessage.ClassINET
+	if multicast {
+		unique |= classCacheFlush
+	}
+	header := func(name dnsmessage.Name, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: class, TTL: ttl}
+	}
+	if err := b.PTRResource(header(r.typ, dnsmessage.ClassINET),
+		dnsmessage.PTRResource{PTR: r.instance}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(header(r.instance, unique),
+		dnsmessage.SRVResource{Target: r.host, Port: r.service.Addr.Port}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(header(r.instance, unique),
+		dnsmessage.TXTResource{TXT: []string{txtAddrPrefix + r.service.Addr.String()}}); err != nil {
+		return nil, err
+	}
+	if ip := r.service.Addr.IP; ip.Is4() {
+		err := b.AResource(header(r.host, unique), dnsmessage.AResource{A: ip.As4()})
+		if err != nil {
+			return nil, err
+		}
+	} else if ip.Is6() {
+		err := b.AAAAResource(header(r.host, unique), dnsmessage.AAAAResource{AAAA: ip.As16()})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// newQuery returns a query for the instances of the service type typ.
+func newQuery(typ string) ([]byte, error) {
+	name, err := dnsmessage.NewName(typ + ".local.")
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(rand.Uint32())})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  name,
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// serviceUpdate is a service found in a response, or withdrawn if ttl is 0.
+type serviceUpdate struct {
+	service Service
+	ttl     uint32
+}
+
+// parseResponse returns the services of the type typ in the response msg,
+// i.e. the TXT records with a SCION address of the instances of typ.
+func parseResponse(msg []byte, typ string) []serviceUpdate {
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil || !m.Header.Response {
+		return nil
+	}
+	suffix := "." + typ + ".local."
+	var updates []serviceUpdate
+	for _, r := range append(m.Answers, m.Additionals...) {
+		txt, ok := r.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		name := r.Header.Name.String()
+		if len(name) <= len(suffix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+			continue
+		}
+		instance := name[:len(name)-len(suffix)]
+		if strings.Contains(instance, ".") {
+			continue
+		}
+		for _, s := range txt.TXT {
+			addr, err := pan.ParseUDPAddr(strings.TrimPrefix(s, txtAddrPrefix))
+			if !strings.HasPrefix(s, txtAddrPrefix) || err != nil {
+				continue
+			}
+			updates = append(updates, serviceUpdate{
+				service: Service{Name: instance, Type: typ, Addr: addr},
+				ttl:     r.Header.TTL,
+			})
+			break
+		}
+	}
+	return updates
+}
+
+// equalNames compares DNS names case-insensitively.
+func equalNames(a, b dnsmessage.Name) bool {
+	return strings.EqualFold(a.String(), b.String())
+}
@@ -0,0 +1,328 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnsTypeNAPTR is the NAPTR resource record type, see RFC 3403. It is not
+	// defined in the dnsmessage package.
+	dnsTypeNAPTR dnsmessage.Type = 35
+	// dnsQueryTimeout is the timeout for a query to a single name server.
+	dnsQueryTimeout = 2 * time.Second
+	// dnsMaxMessageSize is the maximum size of a DNS response over UDP, as
+	// announced with EDNS(0).
+	dnsMaxMessageSize = 4096
+)
+
+// errDNSUnavailable is returned by the dnsClient if the records can not be
+// queried directly, e.g. as no name servers are configured or the response
+// was truncated. The records are then looked up with the system resolver,
+// without TTL.
+var errDNSUnavailable = errors.New("DNS client unavailable")
+
+// dnsRecord is a TXT or NAPTR record.
+type dnsRecord struct {
+	txt   []string
+	naptr naptrRecord
+	ttl   time.Duration
+}
+
+// naptrRecord is the content of a NAPTR record relevant for the SCION address
+// lookup.
+type naptrRecord struct {
+	order      uint16
+	preference uint16
+	services   string
+	regexp     string
+}
+
+// dnsClient queries records directly from the name servers in resolv.conf,
+// which, unlike the net package, allows to obtain the TTL of the records and
+// to query NAPTR records.
+type dnsClient struct {
+	resolvConf string
+
+	once    sync.Once
+	servers []netip.AddrPort
+	search  []string
+}
+
+func (c *dnsClient) init() {
+	c.once.Do(func() {
+		c.servers = readNameservers(c.resolvConf)
+		c.search = readSearchDomains(c.resolvConf)
+	})
+}
+
+// names returns the fully qualified names to query for name, in order. As
+// with the system resolver, names containing a dot are queried as they are,
+// and single labels are expanded with the search domains of resolv.conf.
+// Returns errDNSUnavailable for single labels if there are no search domains,
+// so that the system resolver is used.
+func (c *dnsClient) names(name string) ([]string, error) {
+	c.init()
+	if strings.HasSuffix(name, ".") {
+		return []string{name}, nil
+	}
+	if strings.Contains(name, ".") {
+		return []string{name + "."}, nil
+	}
+	if len(c.search) == 0 {
+		return nil, errDNSUnavailable
+	}
+	names := make([]string, len(c.search))
+	for i, domain := range c.search {
+		names[i] = name + "." + strings.TrimSuffix(domain, ".") + "."
+	}
+	return names, nil
+}
+
+// query queries the records of type qtype for the fully qualified name. The
+// name servers are queried in order until one answers. Returns a
+// HostNotFoundError if the name does not exist.
+func (c *dnsClient) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsRecord, error) {
+	c.init()
+	if len(c.servers) == 0 {
+		return nil, errDNSUnavailable
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, server := range c.servers {
+		records, err := c.queryServer(ctx, server, qname, qtype)
+		if err == nil || errors.As(err, &HostNotFoundError{}) {
+			return records, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (c *dnsClient) queryServer(ctx context.Context, server netip.AddrPort, name dnsmessage.Name,
+	qtype dnsmessage.Type) ([]dnsRecord, error) {
+
+	// The ID must be unpredictable, as it is the only protection against
+	// spoofed responses besides the source port.
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+	query, err := buildDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server.String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(dnsQueryTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dnsMaxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		records, ok, err := parseDNSResponse(buf[:n], id, name, qtype)
+		if !ok {
+			continue // not the response to this query
+		}
+		return records, err
+	}
+}
+
+func buildDNSQuery(id uint16, name dnsmessage.Name, qtype dnsmessage.Type) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(dnsMaxMessageSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseDNSResponse parses the response to the query with the id, name and
+// qtype. Returns false if the message is not a response to this query.
+func parseDNSResponse(msg []byte, id uint16, name dnsmessage.Name,
+	qtype dnsmessage.Type) ([]dnsRecord, bool, error) {
+
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || !h.Response || h.ID != id {
+		return nil, false, nil
+	}
+	q, err := p.Question()
+	if err != nil || !strings.EqualFold(q.Name.String(), name.String()) || q.Type != qtype {
+		return nil, false, nil
+	}
+	switch {
+	case h.RCode == dnsmessage.RCodeNameError:
+		return nil, true, HostNotFoundError{Host: name.String()}
+	case h.RCode != dnsmessage.RCodeSuccess:
+		return nil, true, fmt.Errorf("DNS query for %s failed: %s", name, h.RCode)
+	case h.Truncated:
+		// Retrying over TCP is left to the system resolver.
+		return nil, true, errDNSUnavailable
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, true, err
+	}
+	var records []dnsRecord
+	for {
+		rh, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return records, true, nil
+		} else if err != nil {
+			return nil, true, err
+		}
+		// CNAMEs are followed by the recursive resolver; only the records of
+		// the queried type are relevant.
+		if rh.Type != qtype {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, true, err
+			}
+			continue
+		}
+		r := dnsRecord{ttl: time.Duration(rh.TTL) * time.Second}
+		if qtype == dnsmessage.TypeTXT {
+			txt, err := p.TXTResource()
+			if err != nil {
+				return nil, true, err
+			}
+			r.txt = txt.TXT
+		} else {
+			unknown, err := p.UnknownResource()
+			if err != nil {
+				return nil, true, err
+			}
+			if r.naptr, err = parseNAPTR(unknown.Data); err != nil {
+				continue // skip malformed records
+			}
+		}
+		records = append(records, r)
+	}
+}
+
+// parseNAPTR parses the data of a NAPTR record, up to the regexp field. The
+// replacement field is not used.
+func parseNAPTR(data []byte) (naptrRecord, error) {
+	if len(data) < 4 {
+		return naptrRecord{}, errors.New("NAPTR record too short")
+	}
+	r := naptrRecord{
+		order:      binary.BigEndian.Uint16(data[0:2]),
+		preference: binary.BigEndian.Uint16(data[2:4]),
+	}
+	data = data[4:]
+	var fields [3]string // flags, services and regexp
+	for i := range fields {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return naptrRecord{}, errors.New("NAPTR record too short")
+		}
+		fields[i] = string(data[1 : 1+data[0]])
+		data = data[1+data[0]:]
+	}
+	r.services, r.regexp = fields[1], fields[2]
+	return r, nil
+}
+
+// readNameservers returns the addresses of the name servers configured in the
+// resolv.conf file.
+func readNameservers(resolvConf string) []netip.AddrPort {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []netip.AddrPort
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip, err := netip.ParseAddr(fields[1]); err == nil {
+			servers = append(servers, netip.AddrPortFrom(ip, 53))
+		}
+	}
+	return servers
+}
+
+// readSearchDomains returns the search domains in the resolv.conf file, from
+// the last "search" or "domain" line, as the system resolver does.
+func readSearchDomains(resolvConf string) []string {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var search []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || (fields[0] != "search" && fields[0] != "domain") {
+			continue
+		}
+		search = fields[1:]
+		if fields[0] == "domain" {
+			search = fields[1:2]
+		}
+	}
+	return search
+}
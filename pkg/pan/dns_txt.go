@@ -19,44 +19,213 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
 )
 
+// dnsResolver resolves names to SCION addresses published in DNS, in TXT
+// records of the form "scion=<address>", or in NAPTR records with the service
+// "x-scion" and the address as substitution, e.g.
+//
+//	example.org. IN NAPTR 100 10 "" "x-scion" "!^.*$!1-ff00:0:110,[192.0.2.1]!" .
+//
+// TXT records are preferred; NAPTR records are ordered by their order and
+// preference fields. The results are cached for the TTL of the records, at
+// most dnsMaxTTL. Names that were not found are cached for dnsNegativeTTL.
 type dnsResolver struct {
 	res dnsTXTResolver
+	// client queries the records directly from the name servers, which allows
+	// to use their TTL and to query NAPTR records. If it is nil or unavailable,
+	// only TXT records are looked up with res and cached for dnsDefaultTTL.
+	client *dnsClient
+
+	mutex sync.Mutex
+	cache map[string]dnsCacheEntry
 }
 
 type dnsTXTResolver interface {
 	LookupTXT(context.Context, string) ([]string, error)
 }
 
+// dnsCacheEntry is a cached result of a lookup, either an address or a
+// HostNotFoundError.
+type dnsCacheEntry struct {
+	addr    scionAddr
+	err     error
+	expires time.Time
+}
+
 var _ resolver = &dnsResolver{}
 
-const scionAddrTXTTag = "scion="
+const (
+	scionAddrTXTTag    = "scion="
+	scionNAPTRServices = "x-scion"
+
+	// dnsMaxTTL caps the time for which an address is cached.
+	dnsMaxTTL = time.Hour
+	// dnsDefaultTTL is the time for which an address is cached if the TTL of
+	// the records is unknown.
+	dnsDefaultTTL = 5 * time.Minute
+	// dnsNegativeTTL is the time for which a name that was not found is cached.
+	dnsNegativeTTL = 30 * time.Second
+	// dnsMaxCacheEntries bounds the size of the cache.
+	dnsMaxCacheEntries = 1024
+)
 
 // Resolve the name via DNS to return one scionAddr or an error.
+// Names are cached as given; see dnsClient.names for how they are qualified.
 func (d *dnsResolver) Resolve(ctx context.Context, name string) (saddr scionAddr, err error) {
-	addresses, err := d.queryTXTRecord(ctx, name)
-	if err != nil {
+	if e, ok := d.cached(name, time.Now()); ok {
+		return e.addr, e.err
+	}
+	addresses, ttl, err := d.queryRecords(ctx, name)
+	if errors.As(err, &HostNotFoundError{}) {
+		d.store(name, dnsCacheEntry{err: err, expires: time.Now().Add(dnsNegativeTTL)})
+		return scionAddr{}, err
+	} else if err != nil {
 		return scionAddr{}, err
 	}
 	var perr error
 	for _, addr := range addresses {
 		saddr, perr = parseSCIONAddr(addr)
 		if perr == nil {
+			d.store(name, dnsCacheEntry{addr: saddr, expires: time.Now().Add(min(ttl, dnsMaxTTL))})
 			return saddr, nil
 		}
 	}
 	return scionAddr{}, fmt.Errorf("error parsing TXT SCION address records: %w", perr)
 }
 
+func (d *dnsResolver) cached(name string, now time.Time) (dnsCacheEntry, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	e, ok := d.cache[name]
+	if !ok || now.After(e.expires) {
+		return dnsCacheEntry{}, false
+	}
+	return e, true
+}
+
+func (d *dnsResolver) store(name string, e dnsCacheEntry) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.cache == nil {
+		d.cache = make(map[string]dnsCacheEntry)
+	}
+	if len(d.cache) >= dnsMaxCacheEntries {
+		now := time.Now()
+		for n, e := range d.cache {
+			if now.After(e.expires) {
+				delete(d.cache, n)
+			}
+		}
+		if len(d.cache) >= dnsMaxCacheEntries {
+			clear(d.cache)
+		}
+	}
+	d.cache[name] = e
+}
+
+// queryRecords queries the DNS for the TXT and NAPTR records specifying the
+// SCION address(es) for host, in order of preference, and returns them with
+// the minimum TTL of the records. If the records can not be queried directly,
+// only the TXT records are queried with the system resolver.
+// Returns either at least one address, or else an error, of type HostNotFoundError if no matching record was found.
+func (d *dnsResolver) queryRecords(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if d.client != nil {
+		addresses, ttl, err := d.queryClient(ctx, host)
+		if !errors.Is(err, errDNSUnavailable) {
+			return addresses, ttl, err
+		}
+	}
+	addresses, err := d.queryTXTRecord(ctx, host)
+	return addresses, dnsDefaultTTL, err
+}
+
+// queryClient queries the TXT and NAPTR records with the dnsClient, for each
+// of the qualified names of host in turn until one is found.
+func (d *dnsResolver) queryClient(ctx context.Context, host string) ([]string, time.Duration, error) {
+	names, err := d.client.names(host)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, name := range names {
+		addresses, ttl, err := d.queryClientName(ctx, name)
+		if !errors.As(err, &HostNotFoundError{}) {
+			return addresses, ttl, err
+		}
+	}
+	return nil, 0, HostNotFoundError{Host: host}
+}
+
+// queryClientName queries the TXT and NAPTR records of the fully qualified
+// name with the dnsClient.
+func (d *dnsResolver) queryClientName(ctx context.Context, host string) ([]string, time.Duration, error) {
+	ttl := dnsMaxTTL
+	var addresses []string
+	txtRecords, err := d.client.query(ctx, host, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, r := range txtRecords {
+		// A TXT record may consist of multiple strings, which are concatenated.
+		txt := strings.Join(r.txt, "")
+		if strings.HasPrefix(txt, scionAddrTXTTag) {
+			addresses = append(addresses, strings.TrimPrefix(txt, scionAddrTXTTag))
+			ttl = min(ttl, r.ttl)
+		}
+	}
+	naptrRecords, err := d.client.query(ctx, host, dnsTypeNAPTR)
+	if err != nil && !errors.As(err, &HostNotFoundError{}) && len(addresses) == 0 {
+		return nil, 0, err
+	}
+	sort.SliceStable(naptrRecords, func(i, j int) bool {
+		a, b := naptrRecords[i].naptr, naptrRecords[j].naptr
+		return a.order < b.order || (a.order == b.order && a.preference < b.preference)
+	})
+	for _, r := range naptrRecords {
+		if !strings.EqualFold(r.naptr.services, scionNAPTRServices) {
+			continue
+		}
+		if addr, ok := naptrSubstitution(r.naptr.regexp); ok {
+			addresses = append(addresses, addr)
+			ttl = min(ttl, r.ttl)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, 0, HostNotFoundError{Host: host}
+	}
+	return addresses, ttl, nil
+}
+
+// naptrSubstitution returns the substitution of the regexp field of a NAPTR
+// record, e.g. "1-ff00:0:110,[192.0.2.1]" for
+// "!^.*$!1-ff00:0:110,[192.0.2.1]!". The substitution is used literally, the
+// regular expression is not evaluated.
+func naptrSubstitution(regexp string) (string, bool) {
+	if len(regexp) < 3 {
+		return "", false
+	}
+	parts := strings.Split(regexp[1:], regexp[:1])
+	if len(parts) != 3 || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
 // queryTXTRecord queries the DNS for DNS TXT record(s) specifying the SCION address(es) for host.
+// Names containing a dot are qualified; single labels are passed as they are,
+// so that the system resolver applies the search domains.
 // Returns either at least one address, or else an error, of type HostNotFoundError if no matching record was found.
 func (d *dnsResolver) queryTXTRecord(ctx context.Context, host string) (addresses []string, err error) {
 	if d.res == nil {
 		return addresses, fmt.Errorf("invalid DNS resolver: %v", d.res)
 	}
-	if !strings.HasSuffix(host, ".") {
+	if strings.Contains(host, ".") && !strings.HasSuffix(host, ".") {
 		host += "."
 	}
 	txtRecords, err := d.res.LookupTXT(ctx, host)
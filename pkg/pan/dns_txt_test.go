@@ -16,10 +16,18 @@ package pan
 
 import (
 	"context"
+	"encoding/binary"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 func TestDNSResolver(t *testing.T) {
@@ -38,6 +46,7 @@ func TestDNSResolver(t *testing.T) {
 		{"empty.example.edu", assertErrHostNotFound, scionAddr{}},
 		{"dummy4", assertErrHostNotFound, scionAddr{}},
 		{"barbaz", assertErrHostNotFound, scionAddr{}},
+		{"shorthost", assert.NoError, mustParse("1-ff00:0:f00,[192.0.2.7]")},
 	}
 	var m mockResolver
 	resolver := &dnsResolver{res: &m}
@@ -56,6 +65,164 @@ func TestDNSResolverInvalid(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestDNSResolverCache(t *testing.T) {
+	var m countingResolver
+	resolver := &dnsResolver{res: &m}
+	for i := 0; i < 3; i++ {
+		addr, err := resolver.Resolve(context.TODO(), "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, mustParse("1-ff00:0:f00,[192.0.2.1]"), addr)
+		_, err = resolver.Resolve(context.TODO(), "barbaz")
+		assertErrHostNotFound(t, err)
+	}
+	assert.Equal(t, int32(2), m.lookups.Load())
+
+	// Expired entries are looked up again.
+	resolver.cache["example.com"] = dnsCacheEntry{expires: time.Now().Add(-time.Second)}
+	_, err := resolver.Resolve(context.TODO(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), m.lookups.Load())
+}
+
+func TestDNSClient(t *testing.T) {
+	server := newTestDNSServer(t, map[dnsmessage.Type][]dnsmessage.Resource{
+		dnsmessage.TypeTXT: {
+			testTXTResource("example.com.", 60, "v=spf1 -all"),
+			testTXTResource("example.com.", 300, "scion=1-ff00:0:f00,", "[192.0.2.1]"),
+		},
+		dnsTypeNAPTR: {
+			testNAPTRResource("example.com.", 30, 100, 20, "x-scion", "!^.*$!1-ff00:0:f02,[192.0.2.3]!"),
+			testNAPTRResource("example.com.", 30, 100, 10, "X-SCION", "!^.*$!1-ff00:0:f01,[192.0.2.2]!"),
+			testNAPTRResource("example.com.", 30, 10, 10, "x-other", "!^.*$!1-ff00:0:f03,[192.0.2.4]!"),
+		},
+	})
+	client := &dnsClient{}
+	client.once.Do(func() {
+		// The test server does not listen on port 53, as configured in
+		// resolv.conf.
+		client.servers = []netip.AddrPort{server}
+	})
+	resolver := &dnsResolver{client: client}
+
+	addresses, ttl, err := resolver.queryRecords(context.TODO(), "example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"1-ff00:0:f00,[192.0.2.1]",
+		"1-ff00:0:f01,[192.0.2.2]",
+		"1-ff00:0:f02,[192.0.2.3]",
+	}, addresses)
+	assert.Equal(t, 30*time.Second, ttl)
+
+	_, _, err = resolver.queryRecords(context.TODO(), "example.net.")
+	assertErrHostNotFound(t, err)
+
+	// Single labels are expanded with the search domains.
+	client.search = []string{"invalid.test", "com"}
+	addresses, _, err = resolver.queryRecords(context.TODO(), "example")
+	require.NoError(t, err)
+	assert.Len(t, addresses, 3)
+	_, _, err = resolver.queryRecords(context.TODO(), "other")
+	assertErrHostNotFound(t, err)
+}
+
+func TestReadNameservers(t *testing.T) {
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(resolvConf, []byte(
+		"search example.com\nnameserver 192.0.2.53\nnameserver 2001:db8::53\nnameserver invalid\n"), 0600))
+	assert.Equal(t, []netip.AddrPort{
+		netip.MustParseAddrPort("192.0.2.53:53"),
+		netip.MustParseAddrPort("[2001:db8::53]:53"),
+	}, readNameservers(resolvConf))
+	assert.Empty(t, readNameservers(filepath.Join(t.TempDir(), "missing")))
+	assert.Equal(t, []string{"example.com"}, readSearchDomains(resolvConf))
+
+	require.NoError(t, os.WriteFile(resolvConf, []byte(
+		"domain example.net\nsearch example.org example.com.\n"), 0600))
+	assert.Equal(t, []string{"example.org", "example.com."}, readSearchDomains(resolvConf))
+}
+
+// newTestDNSServer starts a DNS server on the loopback address, answering the
+// queries for any name with the records of the queried type, or with a name
+// error if there are none. Returns the address of the server.
+func newTestDNSServer(t *testing.T, records map[dnsmessage.Type][]dnsmessage.Resource) netip.AddrPort {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			answers := records[q.Type]
+			if q.Name.String() != "example.com." {
+				answers = nil
+			}
+			rcode := dnsmessage.RCodeSuccess
+			if len(answers) == 0 {
+				rcode = dnsmessage.RCodeNameError
+			}
+			msg := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: h.ID, Response: true, RCode: rcode},
+				Questions: []dnsmessage.Question{q},
+				Answers:   answers,
+			}
+			resp, err := msg.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(resp, from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func testTXTResource(name string, ttl uint32, txt ...string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: ttl,
+		},
+		Body: &dnsmessage.TXTResource{TXT: txt},
+	}
+}
+
+func testNAPTRResource(name string, ttl uint32, order, preference uint16,
+	services, regexp string) dnsmessage.Resource {
+
+	data := binary.BigEndian.AppendUint16(nil, order)
+	data = binary.BigEndian.AppendUint16(data, preference)
+	for _, s := range []string{"u", services, regexp} {
+		data = append(append(data, byte(len(s))), s...)
+	}
+	data = append(data, 0) // root domain as replacement
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name: dnsmessage.MustNewName(name), Type: dnsTypeNAPTR, Class: dnsmessage.ClassINET, TTL: ttl,
+		},
+		Body: &dnsmessage.UnknownResource{Type: dnsTypeNAPTR, Data: data},
+	}
+}
+
+type countingResolver struct {
+	mockResolver
+	lookups atomic.Int32
+}
+
+func (r *countingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.lookups.Add(1)
+	return r.mockResolver.LookupTXT(ctx, name)
+}
+
 type mockResolver struct {
 	net.Resolver
 }
@@ -91,6 +258,10 @@ func (r *mockResolver) LookupTXT(ctx context.Context, name string) ([]string, er
 		"empty.example.edu.": {
 			"",
 		},
+		// Single labels are passed unqualified, for the search domains.
+		"shorthost": {
+			"scion=1-ff00:0:f00,[192.0.2.7]",
+		},
 	}[name]
 	if !ok {
 		return nil, &net.DNSError{IsNotFound: true}
@@ -0,0 +1,362 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package drkey provides a cache for the DRKey host-host keys fetched from the
+// SCION daemon, shared by all connections of a process. Keys are cached until
+// their epoch ends, for at most 1024 pairs of hosts, evicting the least
+// recently used; the key of the next epoch is prefetched shortly before,
+// so that packets are not delayed by fetching keys at epoch changes.
+// Concurrent requests for the same key are served by a single fetch.
+// Failed fetches are not retried for a while, and the fetches for hosts
+// without a cached key are rate limited, so that packets with spoofed source
+// addresses do not flood the daemon with requests.
+package drkey
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/drkey"
+)
+
+const (
+	// DefaultPrefetch is the time before the end of an epoch at which the key
+	// of the next epoch is prefetched.
+	DefaultPrefetch = time.Minute
+	// fetchTimeout bounds the time of a prefetch.
+	fetchTimeout = 5 * time.Second
+	// maxKeys is the maximum number of pairs of hosts whose keys are cached.
+	// Once reached, the expired keys are removed, and then the keys of the
+	// least recently used pairs.
+	maxKeys = 1024
+	// failureTimeout is the time for which a failed fetch of the keys between
+	// two hosts is not retried; the error of the fetch is returned instead.
+	failureTimeout = 10 * time.Second
+	// fetchRate is the number of fetches per second, with bursts of up to
+	// fetchBurst, for hosts without a cached key.
+	fetchRate  = 10
+	fetchBurst = 20
+)
+
+var (
+	// ErrKeyPending is returned by Lookup if the key is not cached. It is
+	// being fetched in the background.
+	ErrKeyPending = errors.New("drkey: key is being fetched")
+	// ErrRateLimited is returned if a key is not fetched because too many keys
+	// for hosts without a cached key were fetched recently.
+	ErrRateLimited = errors.New("drkey: too many key fetches")
+)
+
+// Fetcher fetches host-host keys, e.g. from the SCION daemon. A
+// daemon.Connector is a Fetcher.
+type Fetcher interface {
+	DRKeyGetHostHostKey(ctx context.Context, meta drkey.HostHostMeta) (drkey.HostHostKey, error)
+}
+
+// Manager caches the host-host keys fetched with a Fetcher. Safe for
+// concurrent use.
+type Manager struct {
+	fetcher  Fetcher
+	prefetch time.Duration
+	now      func() time.Time
+
+	mutex sync.Mutex
+	// keys holds the unexpired keys of each pair of hosts, i.e. those of the
+	// current and, if prefetched, the next epoch.
+	keys map[keyID][]drkey.HostHostKey
+	// used holds the time of the last use of the keys of each pair of hosts,
+	// as a counter incremented by each use, for the LRU eviction.
+	used    map[keyID]uint64
+	useTime uint64
+	// pending holds the fetches in progress.
+	pending map[fetchID]*fetch
+	// failures holds the recently failed fetches.
+	failures map[keyID]failure
+	// tokens is the number of fetches currently allowed for hosts without a
+	// cached key, last refilled at lastRefill.
+	tokens     float64
+	lastRefill time.Time
+}
+
+// keyID identifies the host-host keys of a protocol between two hosts.
+type keyID struct {
+	proto            drkey.Protocol
+	srcIA, dstIA     addr.IA
+	srcHost, dstHost string
+}
+
+// fetchID identifies a fetch of the key valid at a given time. The time is
+// in unix nanoseconds.
+type fetchID struct {
+	keyID
+	validity int64
+}
+
+// fetch is a fetch in progress; done is closed when it completes.
+type fetch struct {
+	done chan struct{}
+	key  drkey.HostHostKey
+	err  error
+}
+
+// failure is a failed fetch, not retried until the given time.
+type failure struct {
+	err   error
+	until time.Time
+}
+
+// NewManager returns a Manager fetching keys with fetcher. The key of the
+// next epoch is prefetched DefaultPrefetch before an epoch ends.
+func NewManager(fetcher Fetcher) *Manager {
+	return &Manager{
+		fetcher:    fetcher,
+		prefetch:   DefaultPrefetch,
+		now:        time.Now,
+		keys:       make(map[keyID][]drkey.HostHostKey),
+		used:       make(map[keyID]uint64),
+		pending:    make(map[fetchID]*fetch),
+		failures:   make(map[keyID]failure),
+		tokens:     fetchBurst,
+		lastRefill: time.Now(),
+	}
+}
+
+// HostHostKey returns the key described by meta, valid at meta.Validity.
+// The key is fetched unless it is cached. If the last fetch of a key between
+// the same hosts failed recently, its error is returned; if too many keys
+// for hosts without a cached key were fetched recently, ErrRateLimited is
+// returned.
+func (m *Manager) HostHostKey(ctx context.Context,
+	meta drkey.HostHostMeta) (drkey.HostHostKey, error) {
+
+	id := metaKeyID(meta)
+	m.mutex.Lock()
+	key, ok := m.cached(id, meta.Validity)
+	if ok {
+		m.maybePrefetch(id, key)
+		m.mutex.Unlock()
+		return key, nil
+	}
+	f, err := m.fetchUncached(id, meta.Validity)
+	m.mutex.Unlock()
+	if err != nil {
+		return drkey.HostHostKey{}, err
+	}
+
+	select {
+	case <-f.done:
+		return f.key, f.err
+	case <-ctx.Done():
+		return drkey.HostHostKey{}, ctx.Err()
+	}
+}
+
+// Lookup returns the key described by meta if it is cached, without blocking.
+// Otherwise, the key is fetched in the background and ErrKeyPending is
+// returned, or the error of a recently failed fetch, or ErrRateLimited, as
+// for HostHostKey.
+func (m *Manager) Lookup(meta drkey.HostHostMeta) (drkey.HostHostKey, error) {
+	id := metaKeyID(meta)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key, ok := m.cached(id, meta.Validity)
+	if ok {
+		m.maybePrefetch(id, key)
+		return key, nil
+	}
+	if _, err := m.fetchUncached(id, meta.Validity); err != nil {
+		return drkey.HostHostKey{}, err
+	}
+	return drkey.HostHostKey{}, ErrKeyPending
+}
+
+func metaKeyID(meta drkey.HostHostMeta) keyID {
+	return keyID{
+		proto:   meta.ProtoId,
+		srcIA:   meta.SrcIA,
+		dstIA:   meta.DstIA,
+		srcHost: meta.SrcHost,
+		dstHost: meta.DstHost,
+	}
+}
+
+// fetchUncached starts fetching the key of id valid at t, which is not cached,
+// unless a fetch of id failed recently or the fetch is rate limited. Must be
+// called with the mutex held.
+func (m *Manager) fetchUncached(id keyID, t time.Time) (*fetch, error) {
+	now := m.now()
+	if f, ok := m.failures[id]; ok {
+		if now.Before(f.until) {
+			return nil, f.err
+		}
+		delete(m.failures, id)
+	}
+	if f, ok := m.pending[fetchID{keyID: id, validity: t.UnixNano()}]; ok {
+		return f, nil
+	}
+	if len(m.keys[id]) == 0 && !m.allowFetch(now) {
+		return nil, ErrRateLimited
+	}
+	return m.startFetch(id, t), nil
+}
+
+// allowFetch returns whether a fetch for a host without a cached key is
+// allowed, and consumes a token if so. Must be called with the mutex held.
+func (m *Manager) allowFetch(now time.Time) bool {
+	if elapsed := now.Sub(m.lastRefill); elapsed > 0 {
+		m.tokens = min(fetchBurst, m.tokens+elapsed.Seconds()*fetchRate)
+		m.lastRefill = now
+	}
+	if m.tokens < 1 {
+		return false
+	}
+	m.tokens--
+	return true
+}
+
+// cached returns the cached key of id valid at t. Must be called with the
+// mutex held.
+func (m *Manager) cached(id keyID, t time.Time) (drkey.HostHostKey, bool) {
+	for _, k := range m.keys[id] {
+		if k.Epoch.Contains(t) {
+			m.useTime++
+			m.used[id] = m.useTime
+			return k, true
+		}
+	}
+	return drkey.HostHostKey{}, false
+}
+
+// maybePrefetch starts fetching the key following key, if key is about to
+// expire and the next key is neither cached nor being fetched. Must be called
+// with the mutex held.
+func (m *Manager) maybePrefetch(id keyID, key drkey.HostHostKey) {
+	if m.now().Add(m.prefetch).Before(key.Epoch.NotAfter) {
+		return
+	}
+	next := key.Epoch.NotAfter.Add(time.Second)
+	if _, ok := m.cached(id, next); ok {
+		return
+	}
+	m.startFetch(id, next)
+}
+
+// startFetch starts fetching the key of id valid at t, unless the same fetch
+// is already in progress, and returns the fetch. Must be called with the
+// mutex held.
+func (m *Manager) startFetch(id keyID, t time.Time) *fetch {
+	fid := fetchID{keyID: id, validity: t.UnixNano()}
+	if f, ok := m.pending[fid]; ok {
+		return f
+	}
+	f := &fetch{done: make(chan struct{})}
+	m.pending[fid] = f
+	go func() {
+		// The fetch outlives the context of the caller that started it, as
+		// other callers may wait for it.
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		f.key, f.err = m.fetcher.DRKeyGetHostHostKey(ctx, drkey.HostHostMeta{
+			ProtoId:  id.proto,
+			Validity: t,
+			SrcIA:    id.srcIA,
+			DstIA:    id.dstIA,
+			SrcHost:  id.srcHost,
+			DstHost:  id.dstHost,
+		})
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.pending, fid)
+		if f.err == nil {
+			m.add(id, f.key)
+		} else {
+			m.fail(id, f.err)
+		}
+		close(f.done)
+	}()
+	return f
+}
+
+// add caches the key, replacing the expired keys of id. If the keys of
+// maxKeys pairs of hosts are cached, the expired keys are removed, and then
+// those of the least recently used pairs. Must be called with the mutex held.
+func (m *Manager) add(id keyID, key drkey.HostHostKey) {
+	now := m.now()
+	if _, ok := m.keys[id]; !ok && len(m.keys) >= maxKeys {
+		for id, keys := range m.keys {
+			if !now.Before(latest(keys).Epoch.NotAfter) {
+				m.remove(id)
+			}
+		}
+		for len(m.keys) >= maxKeys {
+			m.removeLeastRecentlyUsed()
+		}
+	}
+	m.useTime++
+	m.used[id] = m.useTime
+	keys := m.keys[id][:0:0]
+	for _, k := range m.keys[id] {
+		if now.Before(k.Epoch.NotAfter) && !k.Epoch.NotBefore.Equal(key.Epoch.NotBefore) {
+			keys = append(keys, k)
+		}
+	}
+	m.keys[id] = append(keys, key)
+}
+
+// fail records the failed fetch of a key of id, removing the expired
+// failures. Must be called with the mutex held.
+func (m *Manager) fail(id keyID, err error) {
+	now := m.now()
+	if len(m.failures) >= maxKeys {
+		for id, f := range m.failures {
+			if !now.Before(f.until) {
+				delete(m.failures, id)
+			}
+		}
+	}
+	m.failures[id] = failure{err: err, until: now.Add(failureTimeout)}
+}
+
+// remove removes the keys of id. Must be called with the mutex held.
+func (m *Manager) remove(id keyID) {
+	delete(m.keys, id)
+	delete(m.used, id)
+}
+
+// removeLeastRecentlyUsed removes the keys of the least recently used pair of
+// hosts. Must be called with the mutex held.
+func (m *Manager) removeLeastRecentlyUsed() {
+	var lru keyID
+	first := true
+	for id := range m.keys {
+		if first || m.used[id] < m.used[lru] {
+			lru, first = id, false
+		}
+	}
+	m.remove(lru)
+}
+
+// latest returns the key with the latest epoch.
+func latest(keys []drkey.HostHostKey) drkey.HostHostKey {
+	l := keys[0]
+	for _, k := range keys[1:] {
+		if k.Epoch.NotAfter.After(l.Epoch.NotAfter) {
+			l = k
+		}
+	}
+	return l
+}
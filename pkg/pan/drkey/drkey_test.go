@@ -0,0 +1,258 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/drkey"
+	"github.com/scionproto/scion/pkg/scrypto/cppki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// epochLen is the length of the epochs of the keys of countingFetcher.
+const epochLen = time.Hour
+
+// countingFetcher returns keys for epochs of an hour, starting at the full
+// hour, and counts the fetches. The key bytes hold the epoch start.
+type countingFetcher struct {
+	fetches atomic.Int32
+	err     error
+	// block, if set, blocks the fetches until it is closed.
+	block chan struct{}
+}
+
+func (f *countingFetcher) DRKeyGetHostHostKey(ctx context.Context,
+	meta drkey.HostHostMeta) (drkey.HostHostKey, error) {
+
+	f.fetches.Add(1)
+	if f.block != nil {
+		<-f.block
+	}
+	if f.err != nil {
+		return drkey.HostHostKey{}, f.err
+	}
+	start := meta.Validity.Truncate(epochLen)
+	key := drkey.HostHostKey{
+		ProtoId: meta.ProtoId,
+		Epoch: drkey.Epoch{Validity: cppki.Validity{
+			NotBefore: start,
+			NotAfter:  start.Add(epochLen - time.Second),
+		}},
+		SrcIA:   meta.SrcIA,
+		DstIA:   meta.DstIA,
+		SrcHost: meta.SrcHost,
+		DstHost: meta.DstHost,
+	}
+	key.Key[0] = byte(start.Unix() / int64(epochLen.Seconds()))
+	return key, nil
+}
+
+func testMeta(t time.Time) drkey.HostHostMeta {
+	return drkey.HostHostMeta{
+		ProtoId:  42,
+		Validity: t,
+		SrcIA:    addr.MustIAFrom(1, 0xff00_0000_0111),
+		DstIA:    addr.MustIAFrom(1, 0xff00_0000_0112),
+		SrcHost:  "10.0.0.1",
+		DstHost:  "10.0.0.2",
+	}
+}
+
+func TestManagerCache(t *testing.T) {
+	f := &countingFetcher{}
+	m := NewManager(f)
+	now := time.Date(2024, 6, 1, 12, 10, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	key, err := m.HostHostKey(ctx, testMeta(now))
+	require.NoError(t, err)
+	assert.True(t, key.Epoch.Contains(now))
+	_, err = m.HostHostKey(ctx, testMeta(now.Add(time.Minute)))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), f.fetches.Load())
+
+	// Other hosts and epochs are fetched separately.
+	other := testMeta(now)
+	other.DstHost = "10.0.0.3"
+	_, err = m.HostHostKey(ctx, other)
+	require.NoError(t, err)
+	_, err = m.HostHostKey(ctx, testMeta(now.Add(-time.Hour)))
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), f.fetches.Load())
+
+	// Errors are cached for failureTimeout.
+	f.err = errors.New("no key")
+	_, err = m.HostHostKey(ctx, testMeta(now.Add(2*time.Hour)))
+	assert.ErrorIs(t, err, f.err)
+	_, err = m.HostHostKey(ctx, testMeta(now.Add(2*time.Hour)))
+	assert.ErrorIs(t, err, f.err)
+	assert.Equal(t, int32(4), f.fetches.Load())
+	now = now.Add(failureTimeout)
+	_, err = m.HostHostKey(ctx, testMeta(now.Add(2*time.Hour)))
+	assert.ErrorIs(t, err, f.err)
+	assert.Equal(t, int32(5), f.fetches.Load())
+}
+
+func TestManagerLookup(t *testing.T) {
+	f := &countingFetcher{block: make(chan struct{})}
+	m := NewManager(f)
+	now := time.Now()
+
+	// The key is fetched in the background.
+	_, err := m.Lookup(testMeta(now))
+	assert.ErrorIs(t, err, ErrKeyPending)
+	_, err = m.Lookup(testMeta(now))
+	assert.ErrorIs(t, err, ErrKeyPending)
+	close(f.block)
+	require.Eventually(t, func() bool {
+		_, err := m.Lookup(testMeta(now))
+		return err == nil
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(1), f.fetches.Load())
+}
+
+func TestManagerRateLimit(t *testing.T) {
+	f := &countingFetcher{err: errors.New("no key")}
+	m := NewManager(f)
+	now := time.Date(2024, 6, 1, 12, 10, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	m.lastRefill = now
+
+	// Keys for new hosts, e.g. spoofed sources, are fetched at a limited
+	// rate.
+	meta := func(i int) drkey.HostHostMeta {
+		meta := testMeta(now)
+		meta.SrcHost = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		return meta
+	}
+	for i := 0; i < fetchBurst; i++ {
+		_, err := m.HostHostKey(context.Background(), meta(i))
+		assert.ErrorIs(t, err, f.err)
+	}
+	_, err := m.Lookup(meta(fetchBurst))
+	assert.ErrorIs(t, err, ErrRateLimited)
+	_, err = m.HostHostKey(context.Background(), meta(fetchBurst))
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, int32(fetchBurst), f.fetches.Load())
+
+	now = now.Add(time.Second)
+	for i := 0; i < fetchRate; i++ {
+		_, err := m.HostHostKey(context.Background(), meta(fetchBurst+i))
+		assert.ErrorIs(t, err, f.err)
+	}
+	_, err = m.HostHostKey(context.Background(), meta(fetchBurst+fetchRate))
+	assert.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestManagerSingleFetch(t *testing.T) {
+	f := &countingFetcher{block: make(chan struct{})}
+	m := NewManager(f)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.HostHostKey(context.Background(), testMeta(now))
+			assert.NoError(t, err)
+		}()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := m.HostHostKey(ctx, testMeta(now))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(f.block)
+	wg.Wait()
+	assert.Equal(t, int32(1), f.fetches.Load())
+}
+
+func TestManagerPrefetch(t *testing.T) {
+	f := &countingFetcher{}
+	m := NewManager(f)
+	now := time.Date(2024, 6, 1, 12, 10, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	key, err := m.HostHostKey(ctx, testMeta(now))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), f.fetches.Load())
+
+	// Shortly before the end of the epoch, the next key is prefetched.
+	now = key.Epoch.NotAfter.Add(-DefaultPrefetch / 2)
+	_, err = m.HostHostKey(ctx, testMeta(now))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		_, ok := m.cached(keyID{
+			proto:   42,
+			srcIA:   testMeta(now).SrcIA,
+			dstIA:   testMeta(now).DstIA,
+			srcHost: "10.0.0.1",
+			dstHost: "10.0.0.2",
+		}, key.Epoch.NotAfter.Add(time.Minute))
+		return ok
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(2), f.fetches.Load())
+
+	now = key.Epoch.NotAfter.Add(time.Minute)
+	next, err := m.HostHostKey(ctx, testMeta(now))
+	require.NoError(t, err)
+	assert.Equal(t, key.Key[0]+1, next.Key[0])
+	assert.Equal(t, int32(2), f.fetches.Load())
+}
+
+func TestManagerEviction(t *testing.T) {
+	f := &countingFetcher{}
+	m := NewManager(f)
+	now := time.Date(2024, 6, 1, 12, 10, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	// Unexpired keys are evicted once the keys of maxKeys pairs of hosts are
+	// cached, the least recently used first.
+	meta := func(i int) drkey.HostHostMeta {
+		meta := testMeta(now)
+		meta.SrcHost = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		return meta
+	}
+	add := func(i int) {
+		key, err := f.DRKeyGetHostHostKey(context.Background(), meta(i))
+		require.NoError(t, err)
+		m.add(metaKeyID(meta(i)), key)
+	}
+	for i := 0; i < maxKeys; i++ {
+		add(i)
+	}
+	_, err := m.Lookup(meta(0))
+	require.NoError(t, err)
+	add(maxKeys)
+	assert.Len(t, m.keys, maxKeys)
+	assert.Len(t, m.used, maxKeys)
+	assert.Contains(t, m.keys, metaKeyID(meta(0)))
+	assert.NotContains(t, m.keys, metaKeyID(meta(1)))
+	assert.Contains(t, m.keys, metaKeyID(meta(maxKeys)))
+}
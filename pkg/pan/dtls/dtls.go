@@ -0,0 +1,230 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dtls runs DTLS over pan connections, using pion/dtls, so that
+// applications get encrypted and authenticated datagrams without switching to
+// QUIC.
+//
+// The DTLS records are written with Write and WriteTo of the pan connections,
+// so the paths are still chosen by the selector of the connection; the path
+// policy can be changed at any time with SetPolicy on the underlying
+// connection.
+package dtls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/protocol"
+	"github.com/pion/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/transport/v2/packetio"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+const (
+	// acceptQueueLen is the number of new remotes that can wait in Accept.
+	acceptQueueLen = 16
+	// receiveBufferSize is the maximum number of bytes buffered per remote
+	// until they are read by the DTLS connection.
+	receiveBufferSize = 1 << 20
+)
+
+// Client runs the DTLS client handshake over conn and returns the DTLS
+// connection. Closing it closes conn.
+func Client(ctx context.Context, conn pan.Conn, config *dtls.Config) (*dtls.Conn, error) {
+	return dtls.ClientWithContext(ctx, conn, config)
+}
+
+// Server returns a listener that accepts DTLS connections from the remotes
+// sending to conn. The handshake is run in Accept. Closing the listener
+// closes conn; the connections accepted before remain usable until then.
+func Server(conn pan.ListenConn, config *dtls.Config) (net.Listener, error) {
+	return newListener(conn, config)
+}
+
+func newListener(conn net.PacketConn, config *dtls.Config) (net.Listener, error) {
+	l := &packetListener{
+		conn:     conn,
+		accepted: make(chan *remoteConn, acceptQueueLen),
+		remotes:  make(map[string]*remoteConn),
+		done:     make(chan struct{}),
+	}
+	listener, err := dtls.NewListener(l, config)
+	if err != nil {
+		return nil, err
+	}
+	go l.readLoop()
+	return listener, nil
+}
+
+// packetListener demultiplexes the packets read from a net.PacketConn by
+// remote address, into one remoteConn per remote.
+type packetListener struct {
+	conn     net.PacketConn
+	accepted chan *remoteConn
+	done     chan struct{}
+
+	mutex   sync.Mutex
+	remotes map[string]*remoteConn
+	closed  bool
+}
+
+// Accept waits for and returns the connection of the next new remote.
+func (l *packetListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accepted:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener and the underlying connection.
+func (l *packetListener) Close() error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	remotes := make([]*remoteConn, 0, len(l.remotes))
+	for _, c := range l.remotes {
+		remotes = append(remotes, c)
+	}
+	l.mutex.Unlock()
+	for _, c := range remotes {
+		_ = c.buffer.Close()
+	}
+	return l.conn.Close()
+}
+
+// Addr returns the local address of the listener.
+func (l *packetListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *packetListener) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := l.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			_ = l.Close()
+			return
+		} else if err != nil {
+			continue
+		}
+		if c := l.remote(from, buf[:n]); c != nil {
+			// Packets are dropped if the buffer is full, as on the network.
+			_, _ = c.buffer.Write(buf[:n])
+		}
+	}
+}
+
+// remote returns the connection for the remote address from. A new
+// connection is created and queued for Accept when a handshake record is
+// received from a new remote; other packets from unknown remotes are dropped.
+func (l *packetListener) remote(from net.Addr, packet []byte) *remoteConn {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	key := from.String()
+	if c, ok := l.remotes[key]; ok {
+		return c
+	}
+	if l.closed || !isHandshake(packet) {
+		return nil
+	}
+	c := &remoteConn{
+		listener: l,
+		key:      key,
+		remote:   from,
+		buffer:   packetio.NewBuffer(),
+	}
+	c.buffer.SetLimitSize(receiveBufferSize)
+	select {
+	case l.accepted <- c:
+	default:
+		return nil // accept queue full, the remote retransmits
+	}
+	l.remotes[key] = c
+	return c
+}
+
+// isHandshake returns whether the datagram starts with a DTLS handshake
+// record.
+func isHandshake(packet []byte) bool {
+	pkts, err := recordlayer.UnpackDatagram(packet)
+	if err != nil || len(pkts) < 1 {
+		return false
+	}
+	h := &recordlayer.Header{}
+	if err := h.Unmarshal(pkts[0]); err != nil {
+		return false
+	}
+	return h.ContentType == protocol.ContentTypeHandshake
+}
+
+// remoteConn is the net.Conn to a single remote of a packetListener, on
+// which a DTLS server connection runs.
+type remoteConn struct {
+	listener *packetListener
+	key      string
+	remote   net.Addr
+	buffer   *packetio.Buffer
+}
+
+func (c *remoteConn) Read(b []byte) (int, error) {
+	return c.buffer.Read(b)
+}
+
+func (c *remoteConn) Write(b []byte) (int, error) {
+	return c.listener.conn.WriteTo(b, c.remote)
+}
+
+// Close closes the connection to the remote. Packets received from the remote
+// afterwards are dropped, unless they start a new handshake.
+func (c *remoteConn) Close() error {
+	c.listener.mutex.Lock()
+	if c.listener.remotes[c.key] == c {
+		delete(c.listener.remotes, c.key)
+	}
+	c.listener.mutex.Unlock()
+	return c.buffer.Close()
+}
+
+func (c *remoteConn) LocalAddr() net.Addr {
+	return c.listener.conn.LocalAddr()
+}
+
+func (c *remoteConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *remoteConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *remoteConn) SetReadDeadline(t time.Time) error {
+	return c.buffer.SetReadDeadline(t)
+}
+
+// SetWriteDeadline is a no-op, writes do not block.
+func (c *remoteConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dtls
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() *dtls.Config {
+	return &dtls.Config{
+		PSK: func([]byte) ([]byte, error) {
+			return []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab}, nil
+		},
+		PSKIdentityHint: []byte("test"),
+		CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_GCM_SHA256},
+	}
+}
+
+// TestHandshakeEcho runs a handshake and echoes a message over loopback UDP.
+// A pan.ListenConn is a net.PacketConn like the UDP socket used here; the
+// client side is dtls.ClientWithContext, as in Client.
+func TestHandshakeEcho(t *testing.T) {
+	serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener, err := newListener(serverConn, testConfig())
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, err = conn.Write(buf[:n])
+		assert.NoError(t, err)
+	}()
+
+	// A packet that does not start a handshake is dropped, without
+	// creating a connection for the remote.
+	stray, err := net.Dial("udp", serverConn.LocalAddr().String())
+	require.NoError(t, err)
+	defer stray.Close()
+	_, err = stray.Write([]byte("not dtls"))
+	require.NoError(t, err)
+
+	clientConn, err := net.Dial("udp", serverConn.LocalAddr().String())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := dtls.ClientWithContext(ctx, clientConn, testConfig())
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 1024)
+	n, err := client.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+
+	assert.Equal(t, serverConn.LocalAddr(), listener.Addr())
+}
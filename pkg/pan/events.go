@@ -0,0 +1,139 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var events eventBus
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	// EventPathDown is emitted for each SCMP path down notification.
+	// Fingerprint and Interface identify the affected path and interface.
+	EventPathDown EventType = iota
+	// EventPathRecovered is emitted when a path that was previously notified
+	// down is observed to work again (e.g. a ping reply was received).
+	EventPathRecovered
+	// EventPathSwitched is emitted when a selector switches from the path
+	// PreviousFingerprint to Fingerprint.
+	EventPathSwitched
+	// EventRefreshFailed is emitted when the background path refresh for
+	// Destination failed with Err.
+	EventRefreshFailed
+	// EventLocalAddrChanged is emitted when a connection to Destination
+	// migrated to the new local address Local, see EnableAddressMigration. Err
+	// is set if the migration failed.
+	EventLocalAddrChanged
+	// EventStalePaths is emitted when a connection to Destination is dialed
+	// with cached, possibly outdated paths because the path lookup failed
+	// with Err, see WithStalePathFallback.
+	EventStalePaths
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPathDown:
+		return "PathDown"
+	case EventPathRecovered:
+		return "PathRecovered"
+	case EventPathSwitched:
+		return "PathSwitched"
+	case EventRefreshFailed:
+		return "RefreshFailed"
+	case EventLocalAddrChanged:
+		return "LocalAddrChanged"
+	case EventStalePaths:
+		return "StalePaths"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event describes a path related event in this process. Which of the fields
+// are set depends on the Type, see EventType.
+type Event struct {
+	Type                EventType
+	Time                time.Time
+	Destination         IA
+	Fingerprint         PathFingerprint
+	PreviousFingerprint PathFingerprint
+	Interface           PathInterface
+	Local               UDPAddr
+	Err                 error
+}
+
+// SubscribeEvents returns a channel on which all path events across all
+// connections in this process are delivered, until ctx is done. The channel
+// is closed after ctx is done.
+// The events are delivered on a best effort basis; if the subscriber does not
+// keep up, events are dropped instead of blocking the emitter.
+func SubscribeEvents(ctx context.Context) (<-chan Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ch := make(chan Event, eventChannelCapacity)
+	events.subscribe(ch)
+	goroutines.goroutine(goroutineEvents, func() {
+		<-ctx.Done()
+		events.unsubscribe(ch)
+		close(ch)
+	})
+	return ch, nil
+}
+
+type eventBus struct {
+	mutex       sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+func (b *eventBus) subscribe(ch chan Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+}
+
+func (b *eventBus) unsubscribe(ch chan Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// emit sends the event to all subscribers, without blocking.
+func (b *eventBus) emit(e Event) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if len(b.subscribers) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeEvents(t *testing.T) {
+	stats := newPathStatsDB()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := SubscribeEvents(ctx)
+	require.NoError(t, err)
+
+	dst := MustParseIA("1-ff00:0:110")
+	pf := PathFingerprint("events-test")
+	pi := PathInterface{IA: dst, IfID: 5}
+
+	// not notified down, not a recovery
+	stats.RecordAlive(dst, pf)
+
+	stats.NotifyPathDown(pf, pi)
+	e := receiveEvent(t, ch)
+	assert.Equal(t, EventPathDown, e.Type)
+	assert.Equal(t, pf, e.Fingerprint)
+	assert.Equal(t, pi, e.Interface)
+
+	stats.RecordAlive(dst, pf)
+	stats.RecordAlive(dst, pf) // only reported once
+	e = receiveEvent(t, ch)
+	assert.Equal(t, EventPathRecovered, e.Type)
+	assert.Equal(t, dst, e.Destination)
+	assert.Equal(t, pf, e.Fingerprint)
+
+	emitPathSwitched(&Path{Destination: dst, Fingerprint: "a"}, &Path{Destination: dst, Fingerprint: "b"})
+	e = receiveEvent(t, ch)
+	assert.Equal(t, EventPathSwitched, e.Type)
+	assert.Equal(t, PathFingerprint("a"), e.PreviousFingerprint)
+	assert.Equal(t, PathFingerprint("b"), e.Fingerprint)
+
+	cancel()
+	for range ch {
+		// drain until closed
+	}
+	assert.Empty(t, events.subscribers)
+
+	_, err = SubscribeEvents(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func receiveEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		require.FailNow(t, "no event received before timeout")
+	}
+	return Event{}
+}
@@ -0,0 +1,535 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxFECBlockSize is the maximum number of packets protected by one parity
+	// packet, see NewFECConn.
+	MaxFECBlockSize = 64
+	// fecHeaderLen is the length of the header prepended to each packet: the
+	// epoch (4 bytes), the block number (4 bytes), the index of the packet in
+	// the block or, for a parity packet, the number of packets in the block
+	// (1 byte), and the packet type (1 byte).
+	fecHeaderLen = 10
+	// fecOverhead is the reduction of the maximum payload size, as the parity
+	// packet additionally carries the XOR of the payload lengths.
+	fecOverhead = fecHeaderLen + 2
+)
+
+const (
+	fecData   = 0
+	fecParity = 1
+)
+
+// FECStats are the statistics of the forward error correction of a
+// connection, see NewFECConn.
+type FECStats struct {
+	// DataSent is the number of data packets sent.
+	DataSent uint64
+	// ParitySent is the number of parity packets sent.
+	ParitySent uint64
+	// Received is the number of data packets received.
+	Received uint64
+	// Recovered is the number of lost data packets reconstructed from the
+	// parity packet of their block.
+	Recovered uint64
+	// Unrecoverable is the number of data packets that could not be
+	// recovered, because more than one packet of their block was lost. Only
+	// counted for blocks whose parity packet was received, when the block
+	// leaves the window of tracked blocks.
+	Unrecoverable uint64
+	// Malformed is the number of packets dropped because they were too short
+	// or had an invalid header.
+	Malformed uint64
+}
+
+// FECConn is a dialed connection with forward error correction, see
+// NewFECConn.
+type FECConn interface {
+	Conn
+	// FECStats returns the statistics of the forward error correction.
+	FECStats() FECStats
+}
+
+// FECListenConn is a listening connection with forward error correction, see
+// NewFECListenConn.
+type FECListenConn interface {
+	ListenConn
+	// FECStats returns the statistics of the forward error correction, for
+	// all remotes.
+	FECStats() FECStats
+}
+
+// NewFECConn adds forward error correction to the messages written with Write
+// and read with Read on conn, e.g. a connection returned by DialUDP or
+// DialMultiPathUDP, so that a lost packet can be recovered without
+// retransmission. After every blockSize messages, a parity packet with the XOR
+// of the messages of the block is sent; a single lost message per block is
+// reconstructed from the parity packet and the other messages of the block.
+// This trades bandwidth, one packet per block, for loss resilience on paths
+// with known, uncorrelated loss; with a MultiPathConn, the messages of a block
+// are spread over the paths scheduled.
+// Messages are delivered as soon as they are received, a recovered message
+// once the parity packet and all other messages of its block are received,
+// i.e. possibly out of order. As the parity packet of a block is only sent
+// after its last message, a small block size should be used for sparse
+// traffic. Each packet carries a 10 byte header, and the maximum message size
+// (MTU) is reduced by 12 bytes; larger messages are rejected with
+// ErrMsgTooLarge.
+// Both ends of a connection must enable forward error correction, e.g. with
+// NewFECListenConn; the other reads and writes, e.g. WriteVia and ReadBatch,
+// are not protected and must not be used. Not to be combined with
+// WithFragmentation. The block size must be between 1 and MaxFECBlockSize.
+func NewFECConn(conn Conn, blockSize int) (FECConn, error) {
+	if err := checkFECBlockSize(blockSize); err != nil {
+		return nil, err
+	}
+	return &fecConn{Conn: conn, coder: newFECCoder(blockSize)}, nil
+}
+
+// NewFECListenConn adds forward error correction to the messages written with
+// WriteTo and read with ReadFrom on conn, as NewFECConn. The blocks are
+// tracked separately for each remote.
+func NewFECListenConn(conn ListenConn, blockSize int) (FECListenConn, error) {
+	if err := checkFECBlockSize(blockSize); err != nil {
+		return nil, err
+	}
+	return &fecListenConn{ListenConn: conn, coder: newFECCoder(blockSize)}, nil
+}
+
+func checkFECBlockSize(blockSize int) error {
+	if blockSize < 1 || blockSize > MaxFECBlockSize {
+		return fmt.Errorf("invalid FEC block size %d, must be between 1 and %d",
+			blockSize, MaxFECBlockSize)
+	}
+	return nil
+}
+
+type fecConn struct {
+	Conn
+	coder *fecCoder
+
+	readMutex sync.Mutex
+	buf       []byte
+	// recovered holds the messages recovered but not yet read.
+	recovered [][]byte
+}
+
+func (c *fecConn) FECStats() FECStats {
+	return c.coder.Stats()
+}
+
+// MTU returns the maximum message size, excluding the overhead of the forward
+// error correction.
+func (c *fecConn) MTU() int {
+	mtu := c.Conn.MTU()
+	if mtu <= fecOverhead {
+		return 0
+	}
+	return mtu - fecOverhead
+}
+
+func (c *fecConn) Write(b []byte) (int, error) {
+	// The message is checked before it is added to the parity of its block,
+	// which would otherwise be corrupted if the message is not sent.
+	if mtu := c.Conn.MTU(); mtu > 0 && len(b) > c.MTU() {
+		return 0, ErrMsgTooLarge{MaxSize: c.MTU()}
+	} else if len(b) > math.MaxUint16 {
+		return 0, ErrMsgTooLarge{MaxSize: math.MaxUint16}
+	}
+	data, parity := c.coder.encode(UDPAddr{}, b)
+	if _, err := c.Conn.Write(data); err != nil {
+		return 0, err
+	}
+	if parity != nil {
+		// The message was sent; a lost parity packet only prevents recovery.
+		_, _ = c.Conn.Write(parity)
+	}
+	return len(b), nil
+}
+
+// Read reads the next message, received or recovered. As for a UDP socket,
+// the message is truncated if b is too small.
+func (c *fecConn) Read(b []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	for {
+		if len(c.recovered) > 0 {
+			msg := c.recovered[0]
+			c.recovered = c.recovered[1:]
+			return copy(b, msg), nil
+		}
+		buf := fecReadBuffer(&c.buf, len(b))
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		msg, recovered := c.coder.decode(UDPAddr{}, buf[:n])
+		if recovered != nil {
+			c.recovered = append(c.recovered, recovered)
+		}
+		if msg != nil {
+			return copy(b, msg), nil
+		}
+	}
+}
+
+type fecListenConn struct {
+	ListenConn
+	coder *fecCoder
+
+	readMutex sync.Mutex
+	buf       []byte
+	// recovered holds the messages recovered but not yet read.
+	recovered []fecRecovered
+}
+
+type fecRecovered struct {
+	msg    []byte
+	remote UDPAddr
+}
+
+func (c *fecListenConn) FECStats() FECStats {
+	return c.coder.Stats()
+}
+
+func (c *fecListenConn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	sdst, ok := dst.(UDPAddr)
+	if !ok {
+		return 0, errBadDstAddress
+	}
+	data, parity := c.coder.encode(sdst, b)
+	if _, err := c.ListenConn.WriteTo(data, dst); err != nil {
+		return 0, err
+	}
+	if parity != nil {
+		// The message was sent; a lost parity packet only prevents recovery.
+		_, _ = c.ListenConn.WriteTo(parity, dst)
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads the next message, received or recovered. As for a UDP
+// socket, the message is truncated if b is too small.
+func (c *fecListenConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	for {
+		if len(c.recovered) > 0 {
+			r := c.recovered[0]
+			c.recovered = c.recovered[1:]
+			return copy(b, r.msg), r.remote, nil
+		}
+		buf := fecReadBuffer(&c.buf, len(b))
+		n, from, err := c.ListenConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		remote, ok := from.(UDPAddr)
+		if !ok {
+			continue
+		}
+		msg, recovered := c.coder.decode(remote, buf[:n])
+		if recovered != nil {
+			c.recovered = append(c.recovered, fecRecovered{msg: recovered, remote: remote})
+		}
+		if msg != nil {
+			return copy(b, msg), remote, nil
+		}
+	}
+}
+
+// fecCoder frames the messages sent into blocks with a parity packet, and
+// recovers lost messages of the blocks received. The blocks are tracked
+// separately for each remote.
+// The blocks sent to a remote are numbered from 0 in a random epoch, sent
+// along with them. When the state of a remote is discarded after
+// fecStateTimeout, the numbers restart in a new epoch, for which the remote
+// resets the blocks received; otherwise the restarted blocks would be mixed
+// up with the old blocks of the same number, or dropped as too old.
+type fecCoder struct {
+	blockSize int
+
+	mutex     sync.Mutex
+	remotes   map[UDPAddr]*fecState
+	lastSweep time.Time
+	stats     FECStats
+}
+
+// fecState is the forward error correction state for one remote.
+type fecState struct {
+	sendEpoch uint32
+	// sendBlock is the number of the block being sent, sendCount the number
+	// of messages sent in it, and sendParity the XOR of their lengths and
+	// payloads.
+	sendBlock  uint32
+	sendCount  int
+	sendParity []byte
+	// recv holds the blocks received within the window ending at recvHighest.
+	recv        map[uint32]*fecBlock
+	recvHighest uint32
+	// recvEpoch is the epoch of the blocks in recv, prevEpoch the epoch
+	// before it, if hasPrevEpoch.
+	recvEpoch    uint32
+	prevEpoch    uint32
+	hasPrevEpoch bool
+	seen         time.Time
+}
+
+// fecBlock is a block received. xor is the XOR of the lengths and payloads of
+// the messages received and, if received, of the parity packet.
+type fecBlock struct {
+	received [MaxFECBlockSize / 64]uint64 // bit i set if message i received
+	numRecv  int
+	parity   bool
+	size     int // number of messages in the block, known from the parity
+	done     bool
+	xor      []byte
+}
+
+func newFECCoder(blockSize int) *fecCoder {
+	return &fecCoder{
+		blockSize: blockSize,
+		remotes:   make(map[UDPAddr]*fecState),
+		lastSweep: time.Now(),
+	}
+}
+
+func (f *fecCoder) Stats() FECStats {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.stats
+}
+
+// encode returns the data packet for the message b to dst and, if b completes
+// a block, the parity packet of the block.
+func (f *fecCoder) encode(dst UDPAddr, b []byte) (data, parity []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	s := f.state(dst)
+
+	data = make([]byte, fecHeaderLen+len(b))
+	putFECHeader(data, s.sendEpoch, s.sendBlock, s.sendCount, fecData)
+	copy(data[fecHeaderLen:], b)
+	s.sendParity = xorMessage(s.sendParity, b)
+	s.sendCount++
+	f.stats.DataSent++
+
+	if s.sendCount == f.blockSize {
+		parity = make([]byte, fecHeaderLen+len(s.sendParity))
+		putFECHeader(parity, s.sendEpoch, s.sendBlock, s.sendCount, fecParity)
+		copy(parity[fecHeaderLen:], s.sendParity)
+		s.sendBlock++
+		s.sendCount = 0
+		s.sendParity = s.sendParity[:0]
+		f.stats.ParitySent++
+	}
+	return data, parity
+}
+
+// decode processes the packet p received from remote. Returns the message of
+// a data packet, nil if p is to be dropped, and the message recovered with p,
+// if any. The returned slices may refer to p.
+func (f *fecCoder) decode(remote UDPAddr, p []byte) (msg, recovered []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(p) < fecHeaderLen {
+		f.stats.Malformed++
+		return nil, nil
+	}
+	epoch := binary.BigEndian.Uint32(p)
+	blockNum := binary.BigEndian.Uint32(p[4:])
+	index := int(p[8])
+	typ := p[9]
+	payload := p[fecHeaderLen:]
+	valid := typ == fecData && index < MaxFECBlockSize ||
+		typ == fecParity && index > 0 && index <= MaxFECBlockSize && len(payload) >= 2
+	if !valid {
+		f.stats.Malformed++
+		return nil, nil
+	}
+
+	s := f.state(remote)
+	if s.recv != nil && epoch != s.recvEpoch {
+		if s.hasPrevEpoch && epoch == s.prevEpoch {
+			// A late packet of the previous epoch, whose blocks are no longer
+			// tracked; the message is delivered without recovery.
+			if typ != fecData {
+				return nil, nil
+			}
+			f.stats.Received++
+			return payload, nil
+		}
+		s.prevEpoch, s.hasPrevEpoch = s.recvEpoch, true
+		s.recv = nil
+	}
+	s.recvEpoch = epoch
+	block := f.block(s, blockNum)
+	if typ == fecData {
+		if block != nil && block.received[index/64]&(1<<(index%64)) != 0 {
+			// Duplicate, e.g. of a recovered message that arrived late.
+			return nil, nil
+		}
+		f.stats.Received++
+		msg = payload
+		if block == nil || block.done {
+			return msg, nil
+		}
+		block.received[index/64] |= 1 << (index % 64)
+		block.numRecv++
+		block.xor = xorMessage(block.xor, payload)
+	} else {
+		if block == nil || block.done || block.parity {
+			return nil, nil
+		}
+		block.parity = true
+		block.size = index
+		block.xor = xorBytes(block.xor, payload)
+	}
+	if !block.parity || block.numRecv < block.size-1 {
+		return msg, nil
+	}
+	if block.numRecv == block.size-1 {
+		recovered = recoverMessage(block.xor)
+		if recovered == nil {
+			f.stats.Malformed++
+		} else {
+			f.stats.Recovered++
+		}
+	}
+	// Copies of the messages of the block that arrive late are duplicates.
+	block.done = true
+	block.xor = nil
+	for i := 0; i < block.size; i++ {
+		block.received[i/64] |= 1 << (i % 64)
+	}
+	return msg, recovered
+}
+
+// block returns the received block with number n, creating it if necessary.
+// Returns nil if the block is older than the window of tracked blocks.
+// Must be called with the mutex held.
+func (f *fecCoder) block(s *fecState, n uint32) *fecBlock {
+	if s.recv == nil {
+		s.recv = make(map[uint32]*fecBlock)
+		s.recvHighest = n
+	}
+	if d := int32(n - s.recvHighest); d > 0 {
+		s.recvHighest = n
+		for k, b := range s.recv {
+			if int32(s.recvHighest-k) >= fecWindowSize {
+				if b.parity && !b.done {
+					f.stats.Unrecoverable += uint64(b.size - b.numRecv)
+				}
+				delete(s.recv, k)
+			}
+		}
+	} else if -d >= fecWindowSize {
+		return nil
+	}
+	b, ok := s.recv[n]
+	if !ok {
+		b = &fecBlock{}
+		s.recv[n] = b
+	}
+	return b
+}
+
+// state returns the state for remote, creating it if necessary. Discards the
+// state of remotes not heard from for fecStateTimeout.
+// Must be called with the mutex held.
+func (f *fecCoder) state(remote UDPAddr) *fecState {
+	now := time.Now()
+	s, ok := f.remotes[remote]
+	if !ok {
+		if now.Sub(f.lastSweep) > fecStateTimeout {
+			for r, rs := range f.remotes {
+				if now.Sub(rs.seen) > fecStateTimeout {
+					delete(f.remotes, r)
+				}
+			}
+			f.lastSweep = now
+		}
+		s = &fecState{sendEpoch: rand.Uint32()}
+		f.remotes[remote] = s
+	}
+	s.seen = now
+	return s
+}
+
+// fecReadBuffer returns a buffer for reading a packet with a message of up to
+// n bytes, reusing buf if it is large enough.
+func fecReadBuffer(buf *[]byte, n int) []byte {
+	if len(*buf) < n+fecOverhead {
+		*buf = make([]byte, n+fecOverhead)
+	}
+	return *buf
+}
+
+func putFECHeader(b []byte, epoch, block uint32, index int, typ byte) {
+	binary.BigEndian.PutUint32(b, epoch)
+	binary.BigEndian.PutUint32(b[4:], block)
+	b[8] = byte(index)
+	b[9] = typ
+}
+
+// xorMessage XORs the length and the payload of the message into x, as
+// carried by a parity packet.
+func xorMessage(x, msg []byte) []byte {
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(msg)))
+	x = xorBytes(x, l[:])
+	if len(x) < 2+len(msg) {
+		x = append(x, make([]byte, 2+len(msg)-len(x))...)
+	}
+	for i, v := range msg {
+		x[2+i] ^= v
+	}
+	return x
+}
+
+// xorBytes XORs b into x, extending x if it is shorter.
+func xorBytes(x, b []byte) []byte {
+	if len(x) < len(b) {
+		x = append(x, make([]byte, len(b)-len(x))...)
+	}
+	for i, v := range b {
+		x[i] ^= v
+	}
+	return x
+}
+
+// recoverMessage returns the message whose length and payload are XORed in x,
+// nil if the length is invalid.
+func recoverMessage(x []byte) []byte {
+	if len(x) < 2 {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(x))
+	if 2+n > len(x) {
+		return nil
+	}
+	return x[2 : 2+n]
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFECCoder(t *testing.T) {
+	sender := newFECCoder(4)
+	receiver := newFECCoder(4)
+	remote := UDPAddr{Port: 1}
+
+	var packets [][]byte
+	for i := 0; i < 8; i++ {
+		data, parity := sender.encode(remote, []byte(fmt.Sprintf("message %d%s", i, make([]byte, i))))
+		packets = append(packets, data)
+		if parity != nil {
+			packets = append(packets, parity)
+		}
+	}
+	require.Len(t, packets, 10)
+
+	// The second message of the first block is lost and recovered with the
+	// parity packet; the second block loses two messages, which can not be
+	// recovered.
+	var delivered []string
+	for i, p := range packets {
+		if i == 1 || i == 6 || i == 7 {
+			continue
+		}
+		msg, recovered := receiver.decode(remote, p)
+		if msg != nil {
+			delivered = append(delivered, string(msg))
+		}
+		if recovered != nil {
+			delivered = append(delivered, string(recovered))
+		}
+	}
+	assert.Equal(t, []string{
+		"message 0",
+		"message 2\x00\x00",
+		"message 3\x00\x00\x00",
+		"message 1\x00",
+		"message 4\x00\x00\x00\x00",
+		"message 7\x00\x00\x00\x00\x00\x00\x00",
+	}, delivered)
+
+	// A late copy of the recovered message is a duplicate.
+	msg, recovered := receiver.decode(remote, packets[1])
+	assert.Nil(t, msg)
+	assert.Nil(t, recovered)
+	msg, _ = receiver.decode(remote, []byte{1, 2})
+	assert.Nil(t, msg)
+
+	// The second block is counted as unrecoverable once it leaves the window.
+	for i := 0; i < 4*fecWindowSize; i++ {
+		data, _ := sender.encode(remote, []byte("later"))
+		receiver.decode(remote, data)
+	}
+	assert.Equal(t, FECStats{DataSent: 8 + 4*fecWindowSize, ParitySent: 2 + fecWindowSize}, sender.Stats())
+	assert.Equal(t, FECStats{
+		Received:      5 + 4*fecWindowSize,
+		Recovered:     1,
+		Unrecoverable: 2,
+		Malformed:     1,
+	}, receiver.Stats())
+}
+
+func TestFECConn(t *testing.T) {
+	dialed, remote, _ := testKeepaliveConn(t)
+	c, err := NewFECConn(dialed, 2)
+	require.NoError(t, err)
+	l, err := NewFECListenConn(&listenConn{
+		baseUDPConn: baseUDPConn{raw: remote.raw},
+		local:       dialed.remote,
+		selector:    NewDefaultReplySelector(),
+	}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, dialed.MTU()-fecOverhead, c.MTU())
+
+	for _, msg := range []string{"hello", "world"} {
+		n, err := c.Write([]byte(msg))
+		require.NoError(t, err)
+		assert.Equal(t, len(msg), n)
+	}
+	buf := make([]byte, 100)
+	for _, msg := range []string{"hello", "world"} {
+		n, from, err := l.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(buf[:n]))
+		assert.Equal(t, dialed.local, from)
+	}
+
+	// The reply is recovered from the parity packet.
+	from := dialed.local
+	n, err := l.WriteTo([]byte("lost"), from)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	_, err = dialed.Read(buf) // drop the data packet
+	require.NoError(t, err)
+	_, err = l.WriteTo([]byte("reply"), from)
+	require.NoError(t, err)
+	for _, msg := range []string{"reply", "lost"} {
+		n, err := c.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(buf[:n]))
+	}
+	assert.Equal(t, uint64(1), c.FECStats().Recovered)
+	assert.Equal(t, FECStats{DataSent: 2, ParitySent: 1, Received: 2}, l.FECStats())
+
+	// Messages that do not fit are rejected, without being added to the
+	// parity of the block.
+	_, err = c.Write(make([]byte, c.MTU()+1))
+	assert.Equal(t, ErrMsgTooLarge{MaxSize: c.MTU()}, err)
+	assert.Equal(t, uint64(2), c.FECStats().DataSent)
+
+	_, err = NewFECConn(dialed, MaxFECBlockSize+1)
+	assert.Error(t, err)
+}
+
+func TestFECCoderEviction(t *testing.T) {
+	sender := newFECCoder(2)
+	receiver := newFECCoder(2)
+	remote := UDPAddr{Port: 1}
+
+	encode := func(msg string) [][]byte {
+		data, parity := sender.encode(remote, []byte(msg))
+		if parity != nil {
+			return [][]byte{data, parity}
+		}
+		return [][]byte{data}
+	}
+	for _, p := range append(encode("a"), encode("b")...) {
+		receiver.decode(remote, p)
+	}
+	late := encode("late")[0]
+
+	// After the state of the receiver is discarded by the sender, the blocks
+	// restart at 0 in a new epoch. They are neither dropped as duplicates of
+	// the old blocks, nor mixed up with them.
+	delete(sender.remotes, remote)
+	packets := append(encode("c"), encode("d")...)
+	require.Len(t, packets, 3)
+	msg, _ := receiver.decode(remote, packets[1]) // "c" is lost
+	assert.Equal(t, "d", string(msg))
+	_, recovered := receiver.decode(remote, packets[2])
+	assert.Equal(t, "c", string(recovered))
+
+	// A late message of the previous epoch is delivered, without recovery.
+	msg, _ = receiver.decode(remote, late)
+	assert.Equal(t, "late", string(msg))
+	packets = append(encode("e"), encode("f")...)
+	receiver.decode(remote, packets[0])
+	_, recovered = receiver.decode(remote, packets[2])
+	assert.Equal(t, "f", string(recovered))
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"net"
+	"sync"
+)
+
+// fragmentedConn is a dialed connection with fragmentation enabled, see
+// WithFragmentation.
+type fragmentedConn struct {
+	Conn
+	maxSize int
+
+	readMutex sync.Mutex
+	receiver  *SegmentReceiver
+}
+
+// fragmentedListenConn is a listening connection with fragmentation enabled,
+// see WithFragmentation.
+type fragmentedListenConn struct {
+	ListenConn
+	maxSize int
+
+	readMutex sync.Mutex
+	receiver  *SegmentReceiver
+}
+
+// fragmentConn wraps conn if fragmentation is enabled in the options.
+func fragmentConn(conn Conn, o connOptions) Conn {
+	if o.fragmentMaxSize == 0 {
+		return conn
+	}
+	c := &fragmentedConn{Conn: conn, maxSize: o.fragmentMaxSize}
+	c.receiver = newSegmentReceiver(func(b []byte) (int, net.Addr, error) {
+		n, err := conn.Read(b)
+		return n, conn.RemoteAddr(), err
+	}, o.fragmentMaxSize)
+	return c
+}
+
+// fragmentListenConn wraps conn if fragmentation is enabled in the options.
+func fragmentListenConn(conn ListenConn, o connOptions) ListenConn {
+	if o.fragmentMaxSize == 0 {
+		return conn
+	}
+	c := &fragmentedListenConn{ListenConn: conn, maxSize: o.fragmentMaxSize}
+	c.receiver = newSegmentReceiver(conn.ReadFrom, o.fragmentMaxSize)
+	return c
+}
+
+func (c *fragmentedConn) Write(b []byte) (int, error) {
+	if len(b) > c.maxSize {
+		return 0, ErrMsgTooLarge{MaxSize: c.maxSize}
+	}
+	if err := sendSegmented(c.Conn.WriteBatch, UDPAddr{}, c.GetPath(), c.MTU(), b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read reads a reassembled message. As for a UDP socket, the message is
+// truncated if b is too small.
+func (c *fragmentedConn) Read(b []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	msg, _, err := c.receiver.ReceiveSegmented()
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, msg), nil
+}
+
+func (c *fragmentedListenConn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	sdst, ok := dst.(UDPAddr)
+	if !ok {
+		return 0, errBadDstAddress
+	}
+	if len(b) > c.maxSize {
+		return 0, ErrMsgTooLarge{MaxSize: c.maxSize}
+	}
+	// The path is chosen by WriteBatch, as for WriteTo; the MTU of the reply
+	// path is not known, so the fallback segment size is used.
+	if err := sendSegmented(c.ListenConn.WriteBatch, sdst, nil, 0, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads a reassembled message. As for a UDP socket, the message is
+// truncated if b is too small.
+func (c *fragmentedListenConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	msg, remote, err := c.receiver.ReceiveSegmented()
+	if err != nil {
+		return 0, nil, err
+	}
+	return copy(b, msg), remote, nil
+}
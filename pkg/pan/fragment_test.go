@@ -0,0 +1,60 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFragmentation(t *testing.T) {
+	dialed, remote, _ := testKeepaliveConn(t)
+	o := connOptions{fragmentMaxSize: 10000}
+	c := fragmentConn(dialed, o)
+	l := fragmentListenConn(&listenConn{
+		baseUDPConn: baseUDPConn{raw: remote.raw},
+		local:       dialed.remote,
+		selector:    NewDefaultReplySelector(),
+	}, o)
+
+	msg := bytes.Repeat([]byte("fragment"), 1000)
+	require.Greater(t, len(msg), dialed.MTU())
+	n, err := c.Write(msg)
+	require.NoError(t, err)
+	assert.Equal(t, len(msg), n)
+	buf := make([]byte, 20000)
+	n, from, err := l.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, msg, buf[:n])
+	assert.Equal(t, dialed.local, from)
+
+	// The reply is fragmented with the fallback size, as the MTU of the reply
+	// path is not known.
+	n, err = l.WriteTo(msg[:3000], from)
+	require.NoError(t, err)
+	assert.Equal(t, 3000, n)
+	n, err = c.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, msg[:3000], buf[:n])
+
+	_, err = c.Write(make([]byte, 10001))
+	assert.ErrorIs(t, err, ErrMsgTooLarge{MaxSize: 10000})
+
+	// Without the option, the connection is not wrapped.
+	assert.Same(t, dialed, fragmentConn(dialed, connOptions{}))
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"sync"
+)
+
+// Purposes of the goroutines started by this package, see Goroutines.
+const (
+	goroutineRefresher      = "refresher"
+	goroutineNotifier       = "notifier"
+	goroutineEvents         = "events"
+	goroutineSelectorPinger = "selector_pinger"
+	goroutineRecoveryProber = "recovery_prober"
+	goroutineSyntheticPaths = "synthetic_paths"
+	goroutineDialProber     = "dial_prober"
+	goroutinePinger         = "pinger"
+	goroutineKeepalive      = "keepalive"
+	goroutineTap            = "tap"
+	goroutineAddrMigration  = "address_migration"
+)
+
+// goroutines counts the goroutines started by this package.
+var goroutines goroutineCounter
+
+type goroutineCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// goroutine runs f in a new goroutine, which is counted under purpose until
+// f returns.
+func (g *goroutineCounter) goroutine(purpose string, f func()) {
+	g.add(purpose, 1)
+	go func() {
+		defer g.add(purpose, -1)
+		f()
+	}()
+}
+
+func (g *goroutineCounter) add(purpose string, delta int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.counts == nil {
+		g.counts = make(map[string]int)
+	}
+	g.counts[purpose] += delta
+	currentMetrics().recordGoroutines(purpose, g.counts[purpose])
+}
+
+// recordAll records the current counts in the metrics m.
+func (g *goroutineCounter) recordAll(m *panMetrics) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for purpose, n := range g.counts {
+		m.recordGoroutines(purpose, n)
+	}
+}
+
+func (g *goroutineCounter) snapshot() map[string]int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	s := make(map[string]int, len(g.counts))
+	for purpose, n := range g.counts {
+		s[purpose] = n
+	}
+	return s
+}
+
+// Goroutines returns the number of goroutines currently running in this
+// package, by purpose. This is intended for debugging and monitoring, e.g.
+// of servers managing many connections. The purposes are:
+//
+//   - "refresher": path pool refresher, one per process
+//   - "notifier": delivery of path down notifications, one per process
+//   - "events": one per event subscription, see SubscribeEvents
+//   - "selector_pinger": two per connection using a PingingSelector with
+//     active pinging
+//   - "recovery_prober": two per connection while probing paths that are
+//     down, see EnableRecoveryProbing
+//   - "synthetic_paths": synthesis of paths after down notifications, at
+//     most one per destination IA, see EnableSyntheticPaths
+//   - "dial_prober": one per DialUDPProbed call, while probing
+//   - "pinger": two per open Pinger
+//   - "keepalive": one per connection with keepalives enabled, see
+//     Conn.SetKeepalive
+//   - "tap": mirroring of packets to the tap socket, one per process if
+//     enabled, see TapSocketEnv
+//   - "address_migration": one per process if enabled, see
+//     EnableAddressMigration
+//
+// A dialed connection thus runs at most five goroutines of this package.
+// There is no limit on the total number; it grows with the number of
+// connections, subscriptions and Pingers.
+// Goroutines of the underlying libraries, e.g. quic-go, are not included.
+func Goroutines() map[string]int {
+	return goroutines.snapshot()
+}
@@ -20,15 +20,76 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
 )
 
+// RainsServerEnv is the environment variable with the SCION UDP address of the
+// RAINS server used by ResolveUDPAddr, e.g. "17-ffaa:0:1,[192.0.2.1]:55553".
+// It takes precedence over the server configured in /etc/scion/rains.cfg. If
+// set to "off" or to the empty string, RAINS is not queried.
+// Ignored if built with norains.
+const RainsServerEnv = "SCION_RAINS_SERVER"
+
 var (
-	resolveEtcHosts      resolver = &hostsfileResolver{"/etc/hosts"}
-	resolveEtcScionHosts resolver = &hostsfileResolver{"/etc/scion/hosts"}
+	resolveStatic                 = &staticResolver{}
+	resolveEtcHosts      resolver = &hostsfileResolver{path: "/etc/hosts"}
+	resolveUserHosts     resolver = &hostsfileResolver{path: userHostsFile()}
+	resolveEtcScionHosts resolver = &hostsfileResolver{path: "/etc/scion/hosts"}
 	resolveRains         resolver = nil
-	resolveDNSTxt        resolver = &dnsResolver{net.DefaultResolver}
+	resolveDNSTxt        resolver = &dnsResolver{
+		res:    net.DefaultResolver,
+		client: &dnsClient{resolvConf: "/etc/resolv.conf"},
+	}
+
+	registeredResolversMutex sync.Mutex
+	registeredResolvers      []resolver
 )
 
+// AddHost adds a mapping of the host name to the SCION address for
+// ResolveUDPAddr, taking precedence over all other sources. The port of the
+// address is ignored. An existing mapping for the name is replaced.
+func AddHost(name string, address UDPAddr) {
+	resolveStatic.add(name, address.scionAddr())
+}
+
+// RemoveHost removes the mapping for the host name added with AddHost.
+func RemoveHost(name string) {
+	resolveStatic.remove(name)
+}
+
+// Resolver resolves host names to SCION addresses for ResolveUDPAddr. It
+// allows to add other naming systems to the resolver chain, see
+// RegisterResolver.
+type Resolver interface {
+	// Resolve finds an address for the name. The port of the address is
+	// ignored.
+	// Returns a HostNotFoundError if the name was not found, but otherwise no
+	// error occurred.
+	Resolve(ctx context.Context, name string) (UDPAddr, error)
+}
+
+// RegisterResolver adds r to the sources used by ResolveUDPAddr. Registered
+// resolvers are queried in the order of registration, after the hosts added
+// with AddHost, the hosts files and RAINS, and before DNS.
+func RegisterResolver(r Resolver) {
+	registeredResolversMutex.Lock()
+	defer registeredResolversMutex.Unlock()
+	registeredResolvers = append(registeredResolvers, registeredResolver{r})
+}
+
+// registeredResolver adapts a Resolver to the resolver interface.
+type registeredResolver struct {
+	Resolver
+}
+
+func (r registeredResolver) Resolve(ctx context.Context, name string) (scionAddr, error) {
+	a, err := r.Resolver.Resolve(ctx, name)
+	if err != nil {
+		return scionAddr{}, err
+	}
+	return a.scionAddr(), nil
+}
+
 // resolveUDPAddrAt parses the address and resolves the hostname.
 // The address can be of the form of a SCION address (i.e. of the form "ISD-AS,[IP]:port")
 // or in the form of "hostname:port".
@@ -57,22 +118,39 @@ func resolveUDPAddrAt(ctx context.Context, address string, resolver resolver) (U
 // It will use the following sources, in the given order of precedence, to
 // resolve a name:
 //
+//   - the hosts added with AddHost
 //   - /etc/hosts
+//   - the user's SCION hosts file, $XDG_CONFIG_HOME/scion/hosts (see os.UserConfigDir)
 //   - /etc/scion/hosts
-//   - RAINS, if a server is configured in /etc/scion/rains.cfg. Disabled if built with !norains.
-//   - DNS TXT records using the local DNS resolver (depending on OS config, see "Name Resolution" in net package docs)
+//   - RAINS, if a server is configured in RainsServerEnv or /etc/scion/rains.cfg. Disabled if built with norains.
+//   - the resolvers added with RegisterResolver
+//   - DNS TXT or NAPTR records, queried from the name servers in /etc/resolv.conf, or DNS TXT records using the
+//     local DNS resolver if these are not available (depending on OS config, see "Name Resolution" in net package docs).
+//     The results are cached for the TTL of the records.
 func defaultResolver() resolver {
+	registeredResolversMutex.Lock()
+	defer registeredResolversMutex.Unlock()
+	resolvers := append(localResolver(), resolveRains)
+	resolvers = append(resolvers, registeredResolvers...)
+	return append(resolvers, resolveDNSTxt)
+}
+
+// localResolver returns the resolvers of defaultResolver that do not query
+// the network, i.e. the hosts added with AddHost and the hosts files.
+func localResolver() resolverList {
 	return resolverList{
+		resolveStatic,
 		resolveEtcHosts,
+		resolveUserHosts,
 		resolveEtcScionHosts,
-		resolveRains,
-		resolveDNSTxt,
 	}
 }
 
 // resolver is the interface to resolve a host name to a SCION host address.
-// Currently, this is implemented for reading the system hosts file, a SCION specific hosts file,
-// RAINS, and DNS TXT records for SCION of the format "scion=ia,ip"
+// Currently, this is implemented for the hosts added with AddHost, reading the system hosts file,
+// the SCION specific hosts files,
+// RAINS, DNS TXT records for SCION of the format "scion=ia,ip", and the Resolvers
+// added with RegisterResolver.
 type resolver interface {
 	// Resolve finds an address for the name.
 	// Returns a HostNotFoundError if the name was not found, but otherwise no
@@ -18,9 +18,12 @@ import (
 	"context"
 	"fmt"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const hostsTestFile = "hosts_test_file"
@@ -35,7 +38,7 @@ func TestCount(t *testing.T) {
 }
 
 func TestHostsfileResolver(t *testing.T) {
-	resolver := &hostsfileResolver{hostsTestFile}
+	resolver := &hostsfileResolver{path: hostsTestFile}
 
 	cases := []struct {
 		name      string
@@ -63,11 +66,50 @@ func TestHostsfileResolver(t *testing.T) {
 }
 
 func TestHostsfileResolverNonexisting(t *testing.T) {
-	resolver := &hostsfileResolver{"non_existing_hosts_file"}
+	resolver := &hostsfileResolver{path: "non_existing_hosts_file"}
 	_, err := resolver.Resolve(context.TODO(), "something")
 	assert.Error(t, err)
 }
 
+func TestHostsfileResolverReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	resolver := &hostsfileResolver{path: path}
+	_, err := resolver.Resolve(context.Background(), "host1")
+	assertErrHostNotFound(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("17-ffaa:0:1,[192.0.2.1] host1\n"), 0600))
+	addr, err := resolver.Resolve(context.Background(), "host1")
+	require.NoError(t, err)
+	assert.Equal(t, mustParse("17-ffaa:0:1,[192.0.2.1]"), addr)
+
+	require.NoError(t, os.WriteFile(path, []byte("17-ffaa:0:1,[192.0.2.1] host1\n"+
+		"17-ffaa:0:2,[192.0.2.2] host2\n"), 0600))
+	addr, err = resolver.Resolve(context.Background(), "host2")
+	require.NoError(t, err)
+	assert.Equal(t, mustParse("17-ffaa:0:2,[192.0.2.2]"), addr)
+
+	require.NoError(t, os.Remove(path))
+	_, err = resolver.Resolve(context.Background(), "host1")
+	assertErrHostNotFound(t, err)
+}
+
+func TestAddHost(t *testing.T) {
+	defer RemoveHost("added.example")
+	AddHost("added.example", MustParseUDPAddr("1-ff00:0:110,[192.0.2.1]:80"))
+	a, err := ResolveUDPAddr(context.Background(), "added.example:443")
+	require.NoError(t, err)
+	assert.Equal(t, MustParseUDPAddr("1-ff00:0:110,[192.0.2.1]:443"), a)
+
+	AddHost("added.example", MustParseUDPAddr("1-ff00:0:111,[192.0.2.2]:0"))
+	a, err = ResolveUDPAddr(context.Background(), "added.example:443")
+	require.NoError(t, err)
+	assert.Equal(t, MustParseUDPAddr("1-ff00:0:111,[192.0.2.2]:443"), a)
+
+	RemoveHost("added.example")
+	_, err = resolveStatic.Resolve(context.Background(), "added.example")
+	assertErrHostNotFound(t, err)
+}
+
 func TestResolverList(t *testing.T) {
 	primary := map[string]scionAddr{
 		"foo": mustParse("1-ff00:0:f00,[192.0.2.1]"),
@@ -99,6 +141,36 @@ func TestResolverList(t *testing.T) {
 	}
 }
 
+func TestRegisterResolver(t *testing.T) {
+	registered := registeredResolvers
+	defer func() { registeredResolvers = registered }()
+
+	RegisterResolver(udpAddrResolver{"first.example": MustParseUDPAddr("1-ff00:0:f00,[192.0.2.1]:80")})
+	RegisterResolver(udpAddrResolver{
+		"first.example":  MustParseUDPAddr("1-ff00:0:ba3,[192.0.2.2]:0"), // shadowed by the first resolver
+		"second.example": MustParseUDPAddr("1-ff00:0:ba5,[192.0.2.3]:0"),
+	})
+	resolvers := defaultResolver().(resolverList)
+	assert.Equal(t, resolveDNSTxt, resolvers[len(resolvers)-1], "DNS must be queried last")
+
+	a, err := resolveUDPAddrAt(context.Background(), "first.example:443", resolvers)
+	assert.NoError(t, err)
+	assert.Equal(t, MustParseUDPAddr("1-ff00:0:f00,[192.0.2.1]:443"), a)
+	a, err = resolveUDPAddrAt(context.Background(), "second.example:443", resolvers)
+	assert.NoError(t, err)
+	assert.Equal(t, MustParseUDPAddr("1-ff00:0:ba5,[192.0.2.3]:443"), a)
+}
+
+// udpAddrResolver is a Resolver with a static table.
+type udpAddrResolver map[string]UDPAddr
+
+func (r udpAddrResolver) Resolve(ctx context.Context, name string) (UDPAddr, error) {
+	if a, ok := r[name]; ok {
+		return a, nil
+	}
+	return UDPAddr{}, HostNotFoundError{Host: name}
+}
+
 func assertErrHostNotFound(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
 	target := HostNotFoundError{}
 	return assert.ErrorAs(t, err, &target, msgAndArgs...)
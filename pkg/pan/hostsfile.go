@@ -19,23 +19,29 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 type hostsTable map[string]scionAddr
 
 // hostsfileResolver is an implementation of the resolver interface, backed
 // by an /etc/hosts-like file.
+// The parsed file is cached. The file is checked for changes on each lookup,
+// so that changes are picked up without restarting the application.
 type hostsfileResolver struct {
 	path string
+
+	mutex   sync.Mutex
+	table   hostsTable
+	modTime time.Time
+	size    int64
 }
 
 func (r *hostsfileResolver) Resolve(ctx context.Context, name string) (scionAddr, error) {
-	// Note: obviously not perfectly elegant to parse the entire file for
-	// every query. However, properly caching this and still always provide
-	// fresh results after changes to the hosts file seems like a bigger task and
-	// for now that would be overkill.
-	table, err := loadHostsFile(r.path)
+	table, err := r.load()
 	if err != nil {
 		return scionAddr{}, fmt.Errorf("error loading %s: %w", r.path, err)
 	}
@@ -46,6 +52,74 @@ func (r *hostsfileResolver) Resolve(ctx context.Context, name string) (scionAddr
 	return addr, nil
 }
 
+// load returns the cached table, reloading the file if its modification time
+// or size changed since it was last loaded.
+func (r *hostsfileResolver) load() (hostsTable, error) {
+	if r.path == "" {
+		return nil, nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		r.table, r.modTime, r.size = nil, time.Time{}, 0
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if r.table != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return r.table, nil
+	}
+	table, err := loadHostsFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	r.table, r.modTime, r.size = table, info.ModTime(), info.Size()
+	return table, nil
+}
+
+// userHostsFile returns the path of the user's SCION hosts file,
+// $XDG_CONFIG_HOME/scion/hosts or the equivalent on the OS (see
+// os.UserConfigDir). Returns the empty string if there is no such directory.
+func userHostsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "scion", "hosts")
+}
+
+// staticResolver resolves the names added with AddHost.
+type staticResolver struct {
+	mutex sync.RWMutex
+	hosts hostsTable
+}
+
+func (r *staticResolver) Resolve(ctx context.Context, name string) (scionAddr, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	addr, ok := r.hosts[name]
+	if !ok {
+		return scionAddr{}, HostNotFoundError{name}
+	}
+	return addr, nil
+}
+
+func (r *staticResolver) add(name string, addr scionAddr) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.hosts == nil {
+		r.hosts = make(hostsTable)
+	}
+	r.hosts[name] = addr
+}
+
+func (r *staticResolver) remove(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.hosts, name)
+}
+
 func loadHostsFile(path string) (hostsTable, error) {
 	file, err := os.Open(path)
 	if os.IsNotExist(err) {
@@ -97,11 +97,26 @@ func (p *Pinger) Send(ctx context.Context, remote *snet.UDPAddr,
 		p.pld = make([]byte, size)
 	}
 	binary.BigEndian.PutUint64(p.pld[:size], uint64(time.Now().UnixNano()))
-	pkt, err := pack(p.local, remote, snet.SCMPEchoRequest{
+	return p.send(remote, snet.SCMPEchoRequest{
 		Identifier: uint16(p.id),
 		SeqNumber:  sequence,
 		Payload:    p.pld[:size],
 	})
+}
+
+// SendTraceroute sends an SCMP traceroute request. The path of remote must
+// have the router alert flag set for the interface to probe.
+func (p *Pinger) SendTraceroute(ctx context.Context, remote *snet.UDPAddr,
+	sequence uint16) error {
+
+	return p.send(remote, snet.SCMPTracerouteRequest{
+		Identifier: uint16(p.id),
+		Sequence:   sequence,
+	})
+}
+
+func (p *Pinger) send(remote *snet.UDPAddr, pld snet.Payload) error {
+	pkt, err := pack(p.local, remote, pld)
 	if err != nil {
 		return err
 	}
@@ -149,7 +164,9 @@ type Reply struct {
 	Path     snet.RawPath
 	Size     int
 	Reply    snet.SCMPEchoReply
-	Error    error
+	// Traceroute is set for replies to traceroute requests, instead of Reply.
+	Traceroute *snet.SCMPTracerouteReply
+	Error      error
 }
 
 func (r *Reply) RTT() time.Duration {
@@ -183,15 +200,22 @@ func (h *scmpHandler) SetID(id int) {
 }
 
 func (h scmpHandler) Handle(pkt *snet.Packet) error {
-	echo, err := h.handle(pkt)
-	h.replies <- Reply{
+	reply := Reply{
 		Received: time.Now(),
 		Source:   pkt.Source,
 		Path:     pkt.Path.(snet.RawPath),
 		Size:     len(pkt.Bytes),
-		Reply:    echo,
-		Error:    err,
 	}
+	if tr, ok := pkt.Payload.(snet.SCMPTracerouteReply); ok {
+		if tr.Identifier != h.id {
+			reply.Error = serrors.New("wrong SCMP ID",
+				"expected", h.id, "actual", tr.Identifier)
+		}
+		reply.Traceroute = &tr
+	} else {
+		reply.Reply, reply.Error = h.handle(pkt)
+	}
+	h.replies <- reply
 	return nil
 }
 
@@ -218,7 +242,7 @@ func (h scmpHandler) handle(pkt *snet.Packet) (snet.SCMPEchoReply, error) {
 	return r, nil
 }
 
-func pack(local, remote *snet.UDPAddr, req snet.SCMPEchoRequest) (*snet.Packet, error) {
+func pack(local, remote *snet.UDPAddr, req snet.Payload) (*snet.Packet, error) {
 	if _, ok := remote.Path.(path.Empty); (remote.Path == nil || ok) && !local.IA.Equal(remote.IA) {
 		return nil, serrors.New("no path for remote ISD-AS", "local", local.IA, "remote", remote.IA)
 	}
@@ -0,0 +1,235 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+)
+
+// KeepaliveMode is the kind of packets sent as keepalives, see
+// Conn.SetKeepalive.
+type KeepaliveMode int
+
+const (
+	// KeepaliveEmpty sends UDP packets with an empty payload to the remote
+	// address. The remote application reads these as empty messages.
+	KeepaliveEmpty KeepaliveMode = iota
+	// KeepaliveSCMPEcho sends SCMP echo requests to the remote host. These are
+	// not delivered to the remote application, and the replies are not
+	// delivered to the local application.
+	KeepaliveSCMPEcho
+)
+
+func (m KeepaliveMode) String() string {
+	switch m {
+	case KeepaliveEmpty:
+		return "empty"
+	case KeepaliveSCMPEcho:
+		return "scmp_echo"
+	default:
+		return fmt.Sprintf("KeepaliveMode(%d)", int(m))
+	}
+}
+
+// KeepaliveStatus is the status of the keepalives of a connection.
+type KeepaliveStatus struct {
+	// Interval is the keepalive interval; 0 if keepalives are disabled.
+	Interval time.Duration
+	Mode     KeepaliveMode
+	// Sent is the number of keepalives sent.
+	Sent uint64
+	// LastSent is the time at which the last keepalive was sent.
+	LastSent time.Time
+	// LastReply is the time at which the last reply to an SCMP echo
+	// keepalive was received. Replies are only processed while the connection
+	// is read from.
+	LastReply time.Time
+	// Err is the error of the last keepalive, if it could not be sent.
+	Err error
+}
+
+// keepalive sends keepalives on a dialed connection whenever nothing was
+// written for the interval.
+type keepalive struct {
+	mutex    sync.Mutex
+	status   KeepaliveStatus
+	sequence uint16
+	stop     context.CancelFunc
+	closed   bool // no keepalives are sent once the connection is closed
+}
+
+// set restarts sending keepalives with the given interval and mode, using
+// send to send each keepalive. An interval of 0 stops sending keepalives.
+func (k *keepalive) set(interval time.Duration, mode KeepaliveMode,
+	lastWrite func() time.Time, send func(KeepaliveMode, uint16) error) {
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.stop != nil {
+		k.stop()
+		k.stop = nil
+	}
+	k.status.Interval, k.status.Mode = 0, mode
+	if interval <= 0 || k.closed {
+		return
+	}
+	k.status.Interval = interval
+	ctx, stop := context.WithCancel(context.Background())
+	k.stop = stop
+	goroutines.goroutine(goroutineKeepalive, func() {
+		k.run(ctx, interval, mode, lastWrite, send)
+	})
+}
+
+func (k *keepalive) run(ctx context.Context, interval time.Duration, mode KeepaliveMode,
+	lastWrite func() time.Time, send func(KeepaliveMode, uint16) error) {
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		// Only send a keepalive if nothing was written for the interval,
+		// otherwise wait until the interval after the last write has passed.
+		if idle := time.Since(lastWrite()); idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+		k.mutex.Lock()
+		k.sequence++
+		seq := k.sequence
+		k.mutex.Unlock()
+
+		err := send(mode, seq)
+
+		k.mutex.Lock()
+		if ctx.Err() == nil {
+			k.status.Err = err
+			if err == nil {
+				k.status.Sent++
+				k.status.LastSent = time.Now()
+			}
+		}
+		k.mutex.Unlock()
+		timer.Reset(interval)
+	}
+}
+
+// reply records a reply to an SCMP echo keepalive.
+func (k *keepalive) reply(snet.SCMPEchoReply) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.status.Mode != KeepaliveSCMPEcho || k.status.Sent == 0 {
+		return
+	}
+	k.status.LastReply = time.Now()
+}
+
+func (k *keepalive) get() KeepaliveStatus {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.status
+}
+
+func (k *keepalive) close() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.closed = true
+	if k.stop != nil {
+		k.stop()
+		k.stop = nil
+	}
+}
+
+func (c *dialedConn) SetKeepalive(interval time.Duration, mode KeepaliveMode) {
+	c.keepalive.set(interval, mode, c.lastWriteTime, c.sendKeepalive)
+}
+
+func (c *dialedConn) KeepaliveStatus() KeepaliveStatus {
+	return c.keepalive.get()
+}
+
+func (c *dialedConn) lastWriteTime() time.Time {
+	return time.Unix(0, c.lastWrite.Load())
+}
+
+// sendKeepalive sends a keepalive on the current path.
+func (c *dialedConn) sendKeepalive(mode KeepaliveMode, seq uint16) error {
+	var path *Path
+	if c.localAddr().IA != c.remote.IA {
+		path = c.selector.Path()
+		if path == nil {
+			return errNoPathTo(c.remote.IA)
+		}
+	}
+	if mode == KeepaliveSCMPEcho {
+		return c.writeEchoRequest(c.localAddr(), c.remote, path, seq)
+	}
+	_, err := c.writeMsg(c.localAddr(), c.remote, path, nil)
+	return err
+}
+
+// writeEchoRequest writes an SCMP echo request from src to the host of dst.
+// As the identifier, the port of src is used, so that the reply is delivered
+// to this connection.
+func (c *baseUDPConn) writeEchoRequest(src, dst UDPAddr, path *Path, seq uint16) error {
+	dataplanePath, nextHop := route(src, dst, path)
+	// snet modifies the raw path when serializing; use a copy, as the path is
+	// shared with concurrent writers.
+	if sp, ok := dataplanePath.(snetpath.SCION); ok {
+		dataplanePath = snetpath.SCION{Raw: append([]byte(nil), sp.Raw...)}
+	}
+	pkt := &snet.Packet{
+		PacketInfo: snet.PacketInfo{
+			Source: snet.SCIONAddress{
+				IA:   addr.IA(src.IA),
+				Host: addr.HostIP(src.IP),
+			},
+			Destination: snet.SCIONAddress{
+				IA:   addr.IA(dst.IA),
+				Host: addr.HostIP(dst.IP),
+			},
+			Path: dataplanePath,
+			Payload: snet.SCMPEchoRequest{
+				Identifier: src.Port,
+				SeqNumber:  seq,
+			},
+		},
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+	if err := c.raw.WriteTo(pkt, net.UDPAddrFromAddrPort(nextHop)); err != nil {
+		return c.closedErr(err)
+	}
+	c.mirrorToTap(true, nextHop, pkt.Bytes)
+	c.lastWrite.Store(time.Now().UnixNano())
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeepaliveConn returns a dialed connection on the loopback interface and
+// the raw connection of its remote.
+func testKeepaliveConn(t *testing.T) (*dialedConn, *baseUDPConn, *Path) {
+	local, localAddr := testLoopbackConn(t, "127.0.0.1")
+	remote, remoteAddr := testLoopbackConn(t, "127.0.0.2")
+	path := testLoopbackPath(t, &localAddr, &remoteAddr)
+	selector := NewDefaultSelector()
+	selector.Initialize(localAddr, remoteAddr, []*Path{path})
+
+	ka := &keepalive{}
+	c := &dialedConn{
+		baseUDPConn: baseUDPConn{
+			raw:  local.raw,
+			scmp: scmpHandler{echoReply: ka.reply},
+		},
+		local:     localAddr,
+		remote:    remoteAddr,
+		selector:  selector,
+		keepalive: ka,
+	}
+	t.Cleanup(func() { c.keepalive.close() })
+	return c, remote, path
+}
+
+func TestKeepaliveEmpty(t *testing.T) {
+	c, remote, _ := testKeepaliveConn(t)
+	assert.Equal(t, KeepaliveStatus{}, c.KeepaliveStatus())
+
+	c.SetKeepalive(20*time.Millisecond, KeepaliveEmpty)
+	buf := make([]byte, 100)
+	n, from, _, err := remote.readMsg(buf, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, c.local, from)
+
+	assert.Eventually(t, func() bool { return c.KeepaliveStatus().Sent > 0 },
+		time.Second, 5*time.Millisecond)
+	status := c.KeepaliveStatus()
+	assert.Equal(t, 20*time.Millisecond, status.Interval)
+	assert.Equal(t, KeepaliveEmpty, status.Mode)
+	assert.NoError(t, status.Err)
+	assert.False(t, status.LastSent.IsZero())
+
+	c.SetKeepalive(0, KeepaliveEmpty)
+	assert.Equal(t, time.Duration(0), c.KeepaliveStatus().Interval)
+}
+
+func TestKeepaliveNotSentWhileWriting(t *testing.T) {
+	c, _, _ := testKeepaliveConn(t)
+	c.SetKeepalive(50*time.Millisecond, KeepaliveEmpty)
+	for end := time.Now().Add(150 * time.Millisecond); time.Now().Before(end); {
+		_, err := c.Write([]byte("data"))
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, uint64(0), c.KeepaliveStatus().Sent)
+}
+
+func TestKeepaliveSCMPEcho(t *testing.T) {
+	c, remote, path := testKeepaliveConn(t)
+	c.SetKeepalive(20*time.Millisecond, KeepaliveSCMPEcho)
+
+	buf := make([]byte, 1500)
+	n, _, err := remote.udpConn().ReadFromUDPAddrPort(buf)
+	require.NoError(t, err)
+	pkt := snet.Packet{Bytes: buf[:n]}
+	require.NoError(t, pkt.Decode())
+	req, ok := pkt.Payload.(snet.SCMPEchoRequest)
+	require.True(t, ok, "unexpected payload %T", pkt.Payload)
+	assert.Equal(t, c.local.Port, req.Identifier)
+
+	// The reply is recorded in the status and not returned to the reader.
+	// The path of the reply is any valid path.
+	raw := path.ForwardingPath.dataplanePath.(snetpath.SCION).Raw
+	reply := &snet.Packet{
+		PacketInfo: snet.PacketInfo{
+			Source:      pkt.Destination,
+			Destination: pkt.Source,
+			Path:        snetpath.SCION{Raw: append([]byte(nil), raw...)},
+			Payload:     snet.SCMPEchoReply{Identifier: req.Identifier, SeqNumber: req.SeqNumber},
+		},
+	}
+	require.NoError(t, remote.raw.WriteTo(reply, net.UDPAddrFromAddrPort(netip.AddrPortFrom(c.local.IP, c.local.Port))))
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = c.Read(make([]byte, 100))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "unexpected error %v", err)
+	assert.False(t, c.KeepaliveStatus().LastReply.IsZero())
+}
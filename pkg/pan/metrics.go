@@ -0,0 +1,268 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "pan"
+
+// metrics holds the prometheus metrics of this package. Nil until
+// EnableMetrics is called; all the recording methods are no-ops on a nil
+// receiver.
+var (
+	metricsMutex sync.RWMutex
+	metrics      *panMetrics
+)
+
+type panMetrics struct {
+	pathQueries           *prometheus.CounterVec
+	pathDownNotifications prometheus.Counter
+	paths                 *prometheus.GaugeVec
+	pathLatency           *prometheus.GaugeVec
+	connBytes             *prometheus.CounterVec
+	connPackets           *prometheus.CounterVec
+	goroutines            *prometheus.GaugeVec
+	dedupDuplicates       *prometheus.CounterVec
+	dedupLost             *prometheus.CounterVec
+}
+
+// EnableMetrics creates the prometheus metrics for the path pool and the
+// connections of this package and registers them with the given registerer.
+// Metrics are only recorded after this has been called. Calling this more
+// than once is an error.
+//
+// The exported metrics are:
+//
+//   - pan_path_queries_total{dst,result}: path lookups from the SCION daemon
+//   - pan_path_down_notifications_total: SCMP path down notifications received
+//   - pan_paths{dst}: number of paths in the pool, per destination IA
+//   - pan_path_latency_seconds{dst,path}: last latency sample, per destination IA and path,
+//     removed when the path is dropped from the pool
+//   - pan_conn_bytes_total{local,remote,direction}: payload bytes, per connection
+//   - pan_conn_packets_total{local,remote,direction}: packets, per connection
+//   - pan_goroutines{purpose}: goroutines running in this package, see Goroutines
+//   - pan_dedup_duplicates_total{local,remote}: duplicate packets suppressed, per
+//     connection with deduplication enabled
+//   - pan_dedup_lost_packets_total{local,remote}: packets not received on any
+//     path, per connection with deduplication enabled
+func EnableMetrics(registerer prometheus.Registerer) error {
+	m := &panMetrics{
+		pathQueries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "path_queries_total",
+			Help:      "Number of path lookups from the SCION daemon.",
+		}, []string{"dst", "result"}),
+		pathDownNotifications: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "path_down_notifications_total",
+			Help:      "Number of SCMP path down notifications received.",
+		}),
+		paths: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "paths",
+			Help:      "Number of paths in the path pool, per destination IA.",
+		}, []string{"dst"}),
+		pathLatency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "path_latency_seconds",
+			Help:      "Last recorded round trip time, per destination IA and path.",
+		}, []string{"dst", "path"}),
+		connBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "conn_bytes_total",
+			Help:      "Payload bytes sent/received, per connection.",
+		}, []string{"local", "remote", "direction"}),
+		connPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "conn_packets_total",
+			Help:      "Packets sent/received, per connection.",
+		}, []string{"local", "remote", "direction"}),
+		goroutines: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "goroutines",
+			Help:      "Number of goroutines running in this package, per purpose.",
+		}, []string{"purpose"}),
+		dedupDuplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dedup_duplicates_total",
+			Help:      "Duplicate packets suppressed, per connection.",
+		}, []string{"local", "remote"}),
+		dedupLost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dedup_lost_packets_total",
+			Help:      "Packets not received on any path, per connection.",
+		}, []string{"local", "remote"}),
+	}
+	collectors := []prometheus.Collector{
+		m.pathQueries,
+		m.pathDownNotifications,
+		m.paths,
+		m.pathLatency,
+		m.connBytes,
+		m.connPackets,
+		m.goroutines,
+		m.dedupDuplicates,
+		m.dedupLost,
+	}
+	for i, c := range collectors {
+		if err := registerer.Register(c); err != nil {
+			for _, r := range collectors[:i] {
+				registerer.Unregister(r)
+			}
+			return err
+		}
+	}
+
+	metricsMutex.Lock()
+	metrics = m
+	metricsMutex.Unlock()
+	goroutines.recordAll(m)
+	return nil
+}
+
+func currentMetrics() *panMetrics {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	return metrics
+}
+
+func (m *panMetrics) recordPathQuery(dst IA, numPaths int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.pathQueries.WithLabelValues(dst.String(), "error").Inc()
+		return
+	}
+	m.pathQueries.WithLabelValues(dst.String(), "ok").Inc()
+	m.paths.WithLabelValues(dst.String()).Set(float64(numPaths))
+}
+
+func (m *panMetrics) recordPathDown() {
+	if m == nil {
+		return
+	}
+	m.pathDownNotifications.Inc()
+}
+
+// recordLatency records the latency of the path to a host in dst. The series
+// are per destination IA, not per host, to bound their number; hosts in the
+// same IA share the series of a path.
+func (m *panMetrics) recordLatency(dst IA, p PathFingerprint, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.pathLatency.WithLabelValues(dst.String(), string(p)).Set(latency.Seconds())
+}
+
+// removePaths deletes the series of the paths to dst that were dropped from
+// the path pool.
+func (m *panMetrics) removePaths(dst IA, paths []PathFingerprint) {
+	if m == nil {
+		return
+	}
+	for _, p := range paths {
+		m.pathLatency.DeleteLabelValues(dst.String(), string(p))
+	}
+}
+
+func (m *panMetrics) recordGoroutines(purpose string, n int) {
+	if m == nil {
+		return
+	}
+	m.goroutines.WithLabelValues(purpose).Set(float64(n))
+}
+
+// connMetrics are the per-connection counters. A nil *connMetrics is valid
+// and does not record anything.
+type connMetrics struct {
+	m            *panMetrics
+	labels       prometheus.Labels
+	bytesSent    prometheus.Counter
+	bytesRecv    prometheus.Counter
+	packetsSent  prometheus.Counter
+	packetsRecvd prometheus.Counter
+}
+
+// newConnMetrics returns the counters for a connection, or nil if metrics
+// are not enabled. The remote is the zero UDPAddr for listening connections.
+func newConnMetrics(local, remote UDPAddr) *connMetrics {
+	m := currentMetrics()
+	if m == nil {
+		return nil
+	}
+	remoteStr := ""
+	if !remote.IsZero() {
+		remoteStr = remote.String()
+	}
+	labels := prometheus.Labels{"local": local.String(), "remote": remoteStr}
+	with := func(vec *prometheus.CounterVec, direction string) prometheus.Counter {
+		return vec.WithLabelValues(labels["local"], labels["remote"], direction)
+	}
+	return &connMetrics{
+		m:            m,
+		labels:       labels,
+		bytesSent:    with(m.connBytes, "sent"),
+		bytesRecv:    with(m.connBytes, "received"),
+		packetsSent:  with(m.connPackets, "sent"),
+		packetsRecvd: with(m.connPackets, "received"),
+	}
+}
+
+func (c *connMetrics) recordSent(n int) {
+	if c == nil {
+		return
+	}
+	c.bytesSent.Add(float64(n))
+	c.packetsSent.Inc()
+}
+
+func (c *connMetrics) recordReceived(n int) {
+	if c == nil {
+		return
+	}
+	c.bytesRecv.Add(float64(n))
+	c.packetsRecvd.Inc()
+}
+
+func (c *connMetrics) recordDuplicate() {
+	if c == nil {
+		return
+	}
+	c.m.dedupDuplicates.With(c.labels).Inc()
+}
+
+func (c *connMetrics) recordDedupLost(n uint64) {
+	if c == nil || n == 0 {
+		return
+	}
+	c.m.dedupLost.With(c.labels).Add(float64(n))
+}
+
+// close removes the series of this connection.
+func (c *connMetrics) close() {
+	if c == nil {
+		return
+	}
+	c.m.connBytes.DeletePartialMatch(c.labels)
+	c.m.connPackets.DeletePartialMatch(c.labels)
+	c.m.dedupDuplicates.DeletePartialMatch(c.labels)
+	c.m.dedupLost.DeletePartialMatch(c.labels)
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	defer func() { metrics = nil }()
+
+	// disabled: nothing recorded, no panics
+	currentMetrics().recordPathQuery(MustParseIA("1-ff00:0:1"), 3, nil)
+	newConnMetrics(MustParseUDPAddr("1-ff00:0:1,127.0.0.1:1"), UDPAddr{}).recordSent(10)
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, EnableMetrics(registry))
+	assert.Error(t, EnableMetrics(registry), "registering twice")
+
+	dst := MustParseIA("1-ff00:0:2")
+	currentMetrics().recordPathQuery(dst, 3, nil)
+	currentMetrics().recordPathQuery(dst, 0, errors.New("boom"))
+	currentMetrics().recordPathDown()
+	currentMetrics().recordLatency(dst, "1 2", 20*time.Millisecond)
+
+	local := MustParseUDPAddr("1-ff00:0:1,127.0.0.1:1")
+	remote := MustParseUDPAddr("1-ff00:0:2,127.0.0.2:2")
+	cm := newConnMetrics(local, remote)
+	cm.recordSent(10)
+	cm.recordSent(5)
+	cm.recordReceived(7)
+
+	values := gatherMetrics(t, registry)
+	assert.Equal(t, 1.0, values["pan_path_queries_total"][labelKey("1-ff00:0:2", "ok")])
+	assert.Equal(t, 1.0, values["pan_path_queries_total"][labelKey("1-ff00:0:2", "error")])
+	assert.Equal(t, 3.0, values["pan_paths"][labelKey("1-ff00:0:2")])
+	assert.Equal(t, 1.0, values["pan_path_down_notifications_total"][""])
+	assert.Equal(t, 0.02, values["pan_path_latency_seconds"][labelKey("1-ff00:0:2", "1 2")])
+	connLabels := func(direction string) string {
+		// labels are sorted by name: direction, local, remote
+		return labelKey(direction, "1-ff00:0:1,127.0.0.1:1", "1-ff00:0:2,127.0.0.2:2")
+	}
+	assert.Equal(t, 15.0, values["pan_conn_bytes_total"][connLabels("sent")])
+	assert.Equal(t, 2.0, values["pan_conn_packets_total"][connLabels("sent")])
+	assert.Equal(t, 7.0, values["pan_conn_bytes_total"][connLabels("received")])
+
+	cm.close()
+	currentMetrics().removePaths(dst, []PathFingerprint{"1 2"})
+	values = gatherMetrics(t, registry)
+	assert.Empty(t, values["pan_conn_bytes_total"])
+	assert.Empty(t, values["pan_path_latency_seconds"])
+}
+
+// gatherMetrics returns the values of all metrics in the registry, indexed
+// by metric name and the concatenated label values.
+func gatherMetrics(t *testing.T, registry *prometheus.Registry) map[string]map[string]float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	values := make(map[string]map[string]float64)
+	for _, f := range families {
+		values[f.GetName()] = make(map[string]float64)
+		for _, m := range f.GetMetric() {
+			labels := make([]string, 0, len(m.GetLabel()))
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetValue())
+			}
+			var v float64
+			switch {
+			case m.GetCounter() != nil:
+				v = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				v = m.GetGauge().GetValue()
+			}
+			values[f.GetName()][labelKey(labels...)] = v
+		}
+	}
+	return values
+}
+
+func labelKey(values ...string) string {
+	return strings.Join(values, "|")
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+var addressMigration addressWatcher
+
+// EnableAddressMigration enables the migration of dialed connections to a new
+// local address, e.g. when a laptop switches to another Wi-Fi network.
+// For each dialed connection for which the local IP was chosen automatically,
+// the local IP from which the underlay next hop of its current path is reached
+// (see DialUDP) is checked in the given interval. When it changed, the
+// connection is rebound to the new IP, keeping the port if possible.
+// Its local address is updated and its selector is initialized again with
+// the new address. Reads and writes on the connection continue on the new
+// socket; only packets in flight are lost.
+// An EventLocalAddrChanged is emitted for each migrated connection.
+// The connection metrics keep the labels of the original local address.
+// An interval of 0 disables migration, which is the default.
+func EnableAddressMigration(interval time.Duration) {
+	addressMigration.setInterval(interval)
+}
+
+// addressWatcher periodically checks the local IPs and migrates the
+// dialed connections when their IP changed.
+type addressWatcher struct {
+	mutex sync.Mutex
+	stop  context.CancelFunc
+}
+
+func (w *addressWatcher) setInterval(interval time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stop != nil {
+		w.stop()
+		w.stop = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	w.stop = stop
+	goroutines.goroutine(goroutineAddrMigration, func() { w.run(ctx, interval) })
+}
+
+func (w *addressWatcher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			migrateConns(ctx, openConns.dialed())
+		}
+	}
+}
+
+// migrateConns migrates the connections with an automatically chosen local
+// IP to the IP from which their current underlay next hop is reached, the
+// same IP as chosen when dialing, see dialLocalAddr.
+func migrateConns(ctx context.Context, conns []*dialedConn) {
+	for _, c := range conns {
+		if !c.autoLocalIP {
+			continue
+		}
+		ip, err := localIPFor(c.remote, c.GetPath())
+		if err != nil || c.localAddr().IP == ip {
+			// No route to the next hop, e.g. while switching networks; keep
+			// the current address until there is a new one.
+			continue
+		}
+		err = c.migrate(ctx, ip)
+		if errors.Is(err, net.ErrClosed) {
+			continue
+		}
+		events.emit(Event{
+			Type:        EventLocalAddrChanged,
+			Destination: c.remote.IA,
+			Local:       c.localAddr(),
+			Err:         err,
+		})
+	}
+}
+
+// migrate rebinds the connection to the local IP, keeping the port if
+// possible.
+func (c *dialedConn) migrate(ctx context.Context, ip netip.Addr) error {
+	raw, local, err := openRaw(ctx, netip.AddrPortFrom(ip, c.localAddr().Port), c.scmp)
+	if err != nil {
+		// The port may be in use on the new address, use any port instead.
+		raw, local, err = openRaw(ctx, netip.AddrPortFrom(ip, 0), c.scmp)
+		if err != nil {
+			return err
+		}
+	}
+	return c.migrateTo(raw, local)
+}
+
+// migrateTo replaces the raw connection with raw, bound to the address local,
+// and reinitializes the selector for the new address.
+func (c *dialedConn) migrateTo(raw snet.PacketConn, local UDPAddr) error {
+	err := c.replaceRaw(raw, func() {
+		c.localMutex.Lock()
+		defer c.localMutex.Unlock()
+		c.local = local
+	})
+	if err != nil {
+		return err
+	}
+	if c.subscriber != nil {
+		c.subscriber.setLocal(local, c.remote)
+	}
+	for _, s := range c.classSubscribers() {
+		s.setLocal(local, c.remote)
+	}
+	openConns.updateLocal(c, local)
+	return nil
+}
+
+// replaceRaw replaces the raw connection with raw and closes the previous one.
+// Blocked reads are interrupted and continue on the new connection. update is
+// called while no reads or writes are in progress.
+// If the connection is closed, raw is closed too and net.ErrClosed is
+// returned.
+func (c *baseUDPConn) replaceRaw(raw snet.PacketConn, update func()) error {
+	// Interrupt the blocked reads, so that they release the underlayMutex.
+	// The deadline is not reset before the raw connection is replaced.
+	c.deadlineMutex.Lock()
+	c.interrupted.Store(true)
+	_ = c.raw.SetReadDeadline(time.Unix(1, 0))
+	c.deadlineMutex.Unlock()
+
+	c.underlayMutex.Lock()
+	defer c.underlayMutex.Unlock()
+	defer c.interrupted.Store(false)
+	if c.closed.Load() {
+		_ = raw.Close()
+		return net.ErrClosed
+	}
+
+	old := c.raw
+	c.raw = raw
+	c.udpOnce = sync.Once{}
+	c.udp = nil
+	c.batchOnce = sync.Once{}
+	c.batchState.conn = nil
+	c.underlayTrafficClass = 0
+	c.repoll(raw)
+
+	c.deadlineMutex.Lock()
+	_ = raw.SetReadDeadline(c.readDeadline)
+	_ = raw.SetWriteDeadline(c.writeDeadline)
+	c.deadlineMutex.Unlock()
+	update()
+	return old.Close()
+}
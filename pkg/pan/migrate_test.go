@@ -0,0 +1,115 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceRaw(t *testing.T) {
+	c, _ := testLoopbackConn(t, "127.0.0.1")
+	remote, remoteAddr := testLoopbackConn(t, "127.0.0.2")
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	type result struct {
+		msg string
+		err error
+	}
+	read := make(chan result, 1)
+	go func() {
+		buf := make([]byte, 100)
+		n, _, _, err := c.readMsg(buf, false)
+		read <- result{string(buf[:n]), err}
+	}()
+	time.Sleep(10 * time.Millisecond) // let the read block on the old socket
+
+	next, nextAddr := testLoopbackConn(t, "127.0.0.1")
+	updated := false
+	require.NoError(t, c.replaceRaw(next.raw, func() { updated = true }))
+	assert.True(t, updated)
+
+	path := testLoopbackPath(t, &remoteAddr, &nextAddr)
+	_, err := remote.writeMsg(remoteAddr, nextAddr, path, []byte("hello"))
+	require.NoError(t, err)
+	select {
+	case r := <-read:
+		require.NoError(t, r.err)
+		assert.Equal(t, "hello", r.msg)
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "read not continued on the new socket")
+	}
+
+	require.NoError(t, c.Close())
+	replacement, _ := testLoopbackConn(t, "127.0.0.1")
+	assert.ErrorIs(t, c.replaceRaw(replacement.raw, func() {}), net.ErrClosed)
+}
+
+func TestMigrateTo(t *testing.T) {
+	base, localAddr := testLoopbackConn(t, "127.0.0.1")
+	remote, remoteAddr := testLoopbackConn(t, "127.0.0.2")
+	path := testLoopbackPath(t, &localAddr, &remoteAddr)
+	selector := &initRecordingSelector{path: path}
+	c := &dialedConn{
+		baseUDPConn: baseUDPConn{raw: base.raw},
+		local:       localAddr,
+		autoLocalIP: true,
+		remote:      remoteAddr,
+		subscriber:  &pathRefreshSubscriber{remoteIA: remoteAddr.IA, target: selector},
+		selector:    selector,
+		keepalive:   &keepalive{},
+	}
+	defer c.Close()
+
+	next, nextAddr := testLoopbackConn(t, "127.0.0.1")
+	nextAddr.IA = localAddr.IA
+	require.NoError(t, c.migrateTo(next.raw, nextAddr))
+	assert.Equal(t, nextAddr, c.LocalAddr())
+	assert.Equal(t, nextAddr, selector.local)
+
+	_, err := c.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 100)
+	n, from, _, err := remote.readMsg(buf, false)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, nextAddr, from)
+
+	// Connections bound to a specific IP are not migrated.
+	c.autoLocalIP = false
+	migrateConns(context.Background(), []*dialedConn{c})
+	assert.Equal(t, nextAddr, c.LocalAddr())
+}
+
+// initRecordingSelector always selects path and records the local address
+// it was last initialized with.
+type initRecordingSelector struct {
+	DefaultSelector
+	path  *Path
+	local UDPAddr
+}
+
+func (s *initRecordingSelector) Initialize(local, remote UDPAddr, paths []*Path) {
+	s.local = local
+}
+
+func (s *initRecordingSelector) Path() *Path {
+	return s.path
+}
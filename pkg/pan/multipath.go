@@ -0,0 +1,386 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// MultiPathConn is a dialed connection that transmits over multiple paths
+// simultaneously. For each packet sent with Write, the Scheduler determines
+// the path or paths over which it is sent.
+type MultiPathConn interface {
+	Conn
+	// PathStats returns the statistics for each path used by this connection,
+	// in the order in which the paths were first used. Same as Stats.
+	PathStats() []MultiPathStats
+	// EnableDeduplication enables the deduplication of received packets, for
+	// use with a RedundantScheduler. A sequence number is prepended to each
+	// packet sent, so that only the first copy of a packet received over
+	// multiple paths is delivered. Copies of a packet are only recognized if
+	// sent with a single call to Write or WriteBatch.
+	// Deduplication must be enabled on both ends, i.e. also on the ListenConn
+	// of the remote. Must be called before any packet is sent or received.
+	EnableDeduplication()
+	// DedupStats returns the statistics of the deduplication of received
+	// packets. Zero if deduplication is not enabled.
+	DedupStats() DedupStats
+}
+
+// Scheduler controls the paths used by a MultiPathConn. Like a Selector, it
+// is informed about the available paths and path down notifications.
+// Path returns the scheduler's primary path, e.g. for GetPath.
+type Scheduler interface {
+	Selector
+	// Schedule returns the paths over which the next packet is sent. If more
+	// than one path is returned, the packet is duplicated on each of them.
+	// Invoked for each packet sent with Write.
+	Schedule() []*Path
+}
+
+// DialMultiPathUDP opens a SCION/UDP socket, connected to the remote address,
+// that uses the scheduler to transmit over multiple paths simultaneously.
+// Paths are looked up and filtered by the policy as for DialUDP; the
+// scheduler replaces the selector, a WithSelector option is ignored.
+// If the scheduler is nil, a RoundRobinScheduler is used.
+func DialMultiPathUDP(ctx context.Context, remote UDPAddr, scheduler Scheduler,
+	opts ...DialOption) (MultiPathConn, error) {
+
+	if scheduler == nil {
+		scheduler = NewRoundRobinScheduler()
+	}
+	o := applyDialOptions(opts)
+	o.selector = scheduler
+	conn, err := dialUDP(ctx, remote, o)
+	if err != nil {
+		return nil, err
+	}
+	return &multiPathConn{
+		dialedConn: conn.(*dialedConn),
+		scheduler:  scheduler,
+	}, nil
+}
+
+type multiPathConn struct {
+	*dialedConn
+	scheduler Scheduler
+
+	dedup atomic.Pointer[deduplicator] // nil unless deduplication is enabled
+}
+
+func (c *multiPathConn) EnableDeduplication() {
+	c.dedup.CompareAndSwap(nil, newDeduplicator(c.metrics))
+}
+
+func (c *multiPathConn) DedupStats() DedupStats {
+	return c.dedup.Load().Stats()
+}
+
+// MTU returns the maximum payload size, excluding the sequence number if
+// deduplication is enabled.
+func (c *multiPathConn) MTU() int {
+	mtu := c.dialedConn.MTU()
+	if c.dedup.Load() != nil && mtu > dedupHeaderLen {
+		mtu -= dedupHeaderLen
+	}
+	return mtu
+}
+
+func (c *multiPathConn) Write(b []byte) (int, error) {
+	return c.dedup.Load().write(c.remote, b, c.write)
+}
+
+// write sends b, with the sequence number already prepended if deduplication
+// is enabled, on the paths chosen by the scheduler.
+func (c *multiPathConn) write(b []byte) (int, error) {
+	if c.localAddr().IA == c.remote.IA {
+		return c.dialedConn.Write(b)
+	}
+	paths := c.scheduler.Schedule()
+	if len(paths) == 0 {
+		return 0, errNoPathTo(c.remote.IA)
+	}
+	var lastErr error
+	sent := false
+	for _, path := range paths {
+		_, err := c.baseUDPConn.writeMsg(c.localAddr(), c.remote, path, b)
+		c.pathStats.recordSent(path, len(b), err)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return 0, lastErr
+	}
+	return len(b), nil
+}
+
+func (c *multiPathConn) WriteVia(path *Path, b []byte) (int, error) {
+	return c.dedup.Load().write(c.remote, b, func(b []byte) (int, error) {
+		return c.dialedConn.WriteVia(path, b)
+	})
+}
+
+func (c *multiPathConn) WriteViaWithTrafficClass(path *Path, tc TrafficClass, b []byte) (int, error) {
+	return c.dedup.Load().write(c.remote, b, func(b []byte) (int, error) {
+		return c.dialedConn.WriteViaWithTrafficClass(path, tc, b)
+	})
+}
+
+func (c *multiPathConn) WriteWithTrafficClass(tc TrafficClass, b []byte) (int, error) {
+	return c.dedup.Load().write(c.remote, b, func(b []byte) (int, error) {
+		return c.dialedConn.WriteWithTrafficClass(tc, b)
+	})
+}
+
+func (c *multiPathConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadVia(b)
+	return n, err
+}
+
+// ReadVia reads the next packet; if deduplication is enabled, duplicates are
+// skipped.
+func (c *multiPathConn) ReadVia(b []byte) (int, *Path, error) {
+	for {
+		n, path, err := c.dialedConn.ReadVia(b)
+		if err != nil {
+			return n, path, err
+		}
+		n, ok := c.dedup.Load().receive(c.remote, b[:n])
+		if ok {
+			return n, path, nil
+		}
+	}
+}
+
+func (c *multiPathConn) ReadBatch(msgs []Message) (int, error) {
+	n, err := c.baseUDPConn.readBatch(msgs, true, func(m *Message, remote UDPAddr, fw ForwardingPath) bool {
+		if remote != c.remote {
+			return false // connected! Ignore spurious packets from wrong source
+		}
+		m.Addr = remote
+		if path, err := reversePathFromForwardingPath(c.remote.IA, c.localAddr().IA, fw); err == nil && path != nil {
+			c.pathStats.recordReceived(path, m.N)
+		}
+		var ok bool
+		m.N, ok = c.dedup.Load().receive(remote, m.Buffer[:m.N])
+		return ok
+	})
+	c.pathStats.recordError(err)
+	return n, err
+}
+
+// WriteBatch writes the messages, each on the paths chosen by the scheduler.
+// Messages sent over multiple paths are counted as written only if all
+// copies were written.
+func (c *multiPathConn) WriteBatch(msgs []Message) (int, error) {
+	msgs = c.dedup.Load().frameBatch(msgs, func(*Message) UDPAddr { return c.remote })
+	if c.localAddr().IA == c.remote.IA {
+		return c.dialedConn.WriteBatch(msgs)
+	}
+	var expanded []Message
+	var origin []int // index in msgs of each expanded message
+	for i, m := range msgs {
+		paths := []*Path{m.Path}
+		if m.Path == nil {
+			paths = c.scheduler.Schedule()
+			if len(paths) == 0 {
+				return 0, errNoPathTo(c.remote.IA)
+			}
+		}
+		for _, p := range paths {
+			expanded = append(expanded, Message{Buffer: m.Buffer, Path: p})
+			origin = append(origin, i)
+		}
+	}
+	n, err := c.dialedConn.WriteBatch(expanded)
+	written := 0
+	for written < len(msgs) && (n == len(expanded) || origin[n] > written) {
+		written++
+	}
+	return written, err
+}
+
+func (c *multiPathConn) PathStats() []MultiPathStats {
+	return c.Stats()
+}
+
+// schedulerBase implements the Selector part of the Scheduler interface,
+// keeping track of the paths in the order defined by the policy.
+type schedulerBase struct {
+	mutex sync.Mutex
+	paths []*Path
+}
+
+// Path returns the first path that is not notified down.
+func (s *schedulerBase) Path() *Path {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	alive := s.alivePaths()
+	if len(alive) == 0 {
+		return nil
+	}
+	return alive[0]
+}
+
+func (s *schedulerBase) Initialize(local, remote UDPAddr, paths []*Path) {
+	s.Refresh(paths)
+}
+
+func (s *schedulerBase) Refresh(paths []*Path) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.paths = paths
+}
+
+// PathDown does nothing; down notifications are taken into account for each
+// scheduling decision.
+func (s *schedulerBase) PathDown(PathFingerprint, PathInterface) {}
+
+func (s *schedulerBase) Close() error {
+	return nil
+}
+
+// alivePaths returns the paths that are not notified down. If all paths are
+// notified down, all paths are returned, as there is nothing better to do.
+// Must be called with the mutex held.
+func (s *schedulerBase) alivePaths() []*Path {
+	alive := make([]*Path, 0, len(s.paths))
+	for _, p := range s.paths {
+		if !stats.IsNotifiedDown(p) {
+			alive = append(alive, p)
+		}
+	}
+	if len(alive) == 0 {
+		return s.paths
+	}
+	return alive
+}
+
+// RoundRobinScheduler is a Scheduler that sprays packets over all paths that
+// are not notified down, sending each packet over the next path in turn.
+type RoundRobinScheduler struct {
+	schedulerBase
+	next int
+}
+
+func NewRoundRobinScheduler() *RoundRobinScheduler {
+	return &RoundRobinScheduler{}
+}
+
+func (s *RoundRobinScheduler) Schedule() []*Path {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	alive := s.alivePaths()
+	if len(alive) == 0 {
+		return nil
+	}
+	s.next %= len(alive)
+	p := alive[s.next]
+	s.next++
+	return []*Path{p}
+}
+
+// RedundantScheduler is a Scheduler that duplicates each packet over several
+// paths, for lower loss and latency at the cost of bandwidth.
+// Packets are sent over the first N paths, in the order defined by the policy,
+// that are not notified down.
+type RedundantScheduler struct {
+	schedulerBase
+	n int
+}
+
+// NewRedundantScheduler creates a RedundantScheduler sending each packet over
+// n paths. If n is not positive, packets are sent over all paths.
+func NewRedundantScheduler(n int) *RedundantScheduler {
+	return &RedundantScheduler{n: n}
+}
+
+func (s *RedundantScheduler) Schedule() []*Path {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	alive := s.alivePaths()
+	if s.n > 0 && len(alive) > s.n {
+		alive = alive[:s.n]
+	}
+	return append([]*Path(nil), alive...)
+}
+
+// WeightedScheduler is a Scheduler that stripes packets over the paths that
+// are not notified down, in proportion to the weight of each path.
+// The packets are interleaved as evenly as possible (smooth weighted
+// round-robin).
+type WeightedScheduler struct {
+	schedulerBase
+	weight  func(*Path) float64
+	current map[PathFingerprint]float64
+}
+
+// NewWeightedScheduler creates a WeightedScheduler with the given weight
+// function. Paths with a weight that is not positive are not used. If weight
+// is nil, all paths have the same weight.
+func NewWeightedScheduler(weight func(*Path) float64) *WeightedScheduler {
+	if weight == nil {
+		weight = func(*Path) float64 { return 1 }
+	}
+	return &WeightedScheduler{
+		weight:  weight,
+		current: make(map[PathFingerprint]float64),
+	}
+}
+
+func (s *WeightedScheduler) Refresh(paths []*Path) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.paths = paths
+	s.current = make(map[PathFingerprint]float64, len(paths))
+}
+
+func (s *WeightedScheduler) Initialize(local, remote UDPAddr, paths []*Path) {
+	s.Refresh(paths)
+}
+
+func (s *WeightedScheduler) Schedule() []*Path {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var best *Path
+	var total float64
+	for _, p := range s.alivePaths() {
+		w := s.weight(p)
+		if w <= 0 {
+			continue
+		}
+		total += w
+		s.current[p.Fingerprint] += w
+		if best == nil || s.current[p.Fingerprint] > s.current[best.Fingerprint] {
+			best = p
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	s.current[best.Fingerprint] -= total
+	return []*Path{best}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulers(t *testing.T) {
+	dst := MustParseIA("1-ff00:0:112")
+	newPath := func(pf PathFingerprint, ifID IfID) *Path {
+		return &Path{
+			Destination: dst,
+			Fingerprint: pf,
+			Metadata: &PathMetadata{
+				Interfaces: []PathInterface{{IA: dst, IfID: ifID}},
+			},
+		}
+	}
+	p0 := newPath("multipath-p0", 101)
+	p1 := newPath("multipath-p1", 102)
+	p2 := newPath("multipath-p2", 103)
+	paths := []*Path{p0, p1, p2}
+
+	schedule := func(s Scheduler, n int) []PathFingerprint {
+		var fingerprints []PathFingerprint
+		for i := 0; i < n; i++ {
+			fingerprints = append(fingerprints, pathFingerprints(s.Schedule())...)
+		}
+		return fingerprints
+	}
+
+	rr := NewRoundRobinScheduler()
+	assert.Empty(t, rr.Schedule())
+	rr.Initialize(UDPAddr{}, UDPAddr{}, paths)
+	assert.Equal(t, pathFingerprints([]*Path{p0, p1, p2, p0}), schedule(rr, 4))
+
+	redundant := NewRedundantScheduler(2)
+	redundant.Initialize(UDPAddr{}, UDPAddr{}, paths)
+	assert.Equal(t, []*Path{p0, p1}, redundant.Schedule())
+	all := NewRedundantScheduler(0)
+	all.Initialize(UDPAddr{}, UDPAddr{}, paths)
+	assert.Equal(t, paths, all.Schedule())
+
+	weighted := NewWeightedScheduler(func(p *Path) float64 {
+		switch p {
+		case p0:
+			return 2
+		case p1:
+			return 1
+		}
+		return 0
+	})
+	weighted.Initialize(UDPAddr{}, UDPAddr{}, paths)
+	assert.Equal(t, pathFingerprints([]*Path{p0, p1, p0, p0, p1, p0}), schedule(weighted, 6))
+
+	// Note: only modify the global stats directly, without notifications, to
+	// avoid racing with other tests replacing the global stats.
+	stats.recordPathDown(p1.Fingerprint, p1.Metadata.Interfaces[0])
+	defer stats.recordPathRecovered(p1)
+	assert.NotContains(t, schedule(rr, 4), p1.Fingerprint)
+	assert.Equal(t, []*Path{p0, p2}, redundant.Schedule())
+	assert.Equal(t, pathFingerprints([]*Path{p0, p0}), schedule(weighted, 2))
+	assert.Equal(t, p0, rr.Path())
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+// DialOption is an option for DialUDP and the functions built on it, e.g.
+// DialQUIC.
+type DialOption interface {
+	applyDial(*dialOptions)
+}
+
+// ListenOption is an option for ListenUDP and the functions built on it, e.g.
+// ListenQUIC.
+type ListenOption interface {
+	applyListen(*listenOptions)
+}
+
+// ConnOption is an option for both dialed and listening connections.
+type ConnOption func(*connOptions)
+
+func (o ConnOption) applyDial(d *dialOptions) {
+	o(&d.connOptions)
+}
+
+func (o ConnOption) applyListen(l *listenOptions) {
+	o(&l.connOptions)
+}
+
+type dialOption func(*dialOptions)
+
+func (o dialOption) applyDial(d *dialOptions) {
+	o(d)
+}
+
+type listenOption func(*listenOptions)
+
+func (o listenOption) applyListen(l *listenOptions) {
+	o(l)
+}
+
+type connOptions struct {
+	local           netip.AddrPort
+	scmpHandlers    []snet.SCMPHandler
+	fragmentMaxSize int
+	// packetAuthProtocol is the DRKey protocol for packet authentication, 0
+	// if disabled.
+	packetAuthProtocol uint16
+}
+
+type dialOptions struct {
+	connOptions
+	policy   Policy
+	selector Selector
+	lookup   pathLookupOptions
+	// quicConfig adjusts the QUIC config of DialQUIC, nil if not set.
+	quicConfig func(Conn, *quic.Config) *quic.Config
+}
+
+type listenOptions struct {
+	connOptions
+	selector ReplySelector
+}
+
+func applyDialOptions(opts []DialOption) dialOptions {
+	var o dialOptions
+	for _, opt := range opts {
+		opt.applyDial(&o)
+	}
+	return o
+}
+
+func applyListenOptions(opts []ListenOption) listenOptions {
+	var o listenOptions
+	for _, opt := range opts {
+		opt.applyListen(&o)
+	}
+	return o
+}
+
+// WithLocalAddr sets the local address of the connection. If the address, or
+// either its IP or port, are left unspecified, they are chosen automatically,
+// which is the default; see DialUDP and ListenUDP.
+func WithLocalAddr(local netip.AddrPort) ConnOption {
+	return func(o *connOptions) {
+		o.local = local
+	}
+}
+
+// WithSCMPHandler installs handler for the SCMP messages received on the
+// connection, e.g. to log or export them. The handler is called after the
+// built-in handling, which records the path MTU and notifies the selectors of
+// path down messages. The option can be given multiple times to install a
+// chain of handlers, which are called in the given order.
+// If the built-in handling or any of the handlers returns an error, the first
+// such error is returned by the read that received the SCMP message; for
+// example, the built-in handling returns an SCMPError for packet too big and
+// destination unreachable messages. Handlers are called from the reading
+// goroutine and must not block.
+func WithSCMPHandler(handler snet.SCMPHandler) ConnOption {
+	return func(o *connOptions) {
+		o.scmpHandlers = append(o.scmpHandlers, handler)
+	}
+}
+
+// WithFragmentation enables the fragmentation of the messages written with
+// Write (Conn) or WriteTo (ListenConn), so that messages larger than the path
+// MTU can be sent. A message is split into datagrams that fit the MTU of the
+// current path, or 1000 bytes if it is unknown, e.g. for the reply paths of a
+// ListenConn. Each datagram carries an 8 byte header: a message ID (4 bytes),
+// the index of the datagram (2 bytes) and the number of datagrams of the
+// message (2 bytes); this is the format of SendSegmented. Read and ReadFrom
+// return the reassembled messages.
+// Messages larger than maxSize bytes are rejected with ErrMsgTooLarge when
+// written, and dropped when received. A message is lost if any of its
+// datagrams is lost; incomplete messages are dropped after 10 seconds.
+// Both ends of a connection must enable fragmentation, as every message
+// carries the header. The other reads and writes, e.g. WriteVia and
+// ReadBatch, are not fragmented. Only for DialUDP, DialUDPProbed, ListenUDP
+// and ListenUDPMulti; a maxSize of 0 disables fragmentation, which is the
+// default.
+func WithFragmentation(maxSize int) ConnOption {
+	return func(o *connOptions) {
+		o.fragmentMaxSize = maxSize
+	}
+}
+
+// PolicyOption is the option returned by WithPolicy, for QueryPaths and for
+// dialed connections.
+type PolicyOption struct {
+	policy Policy
+}
+
+func (o PolicyOption) applyQuery(q *queryOptions) {
+	q.policy = o.policy
+}
+
+func (o PolicyOption) applyDial(d *dialOptions) {
+	d.policy = o.policy
+}
+
+// WithPolicy filters and orders the paths returned by QueryPaths, or the
+// paths used by a dialed connection, with the given policy. By default, all
+// paths are allowed.
+func WithPolicy(policy Policy) PolicyOption {
+	return PolicyOption{policy: policy}
+}
+
+// WithSelector sets the selector that chooses the path for each write on a
+// dialed connection. By default, a DefaultSelector is used.
+func WithSelector(selector Selector) DialOption {
+	return dialOption(func(o *dialOptions) {
+		o.selector = selector
+	})
+}
+
+// WithPathLookupTimeout limits the time for the initial path lookup when
+// dialing, independently of the context passed to the dial, which limits the
+// dial as a whole. This allows e.g. interactive applications to give up on a
+// slow SCION daemon early, and to fall back to cached paths with
+// WithStalePathFallback.
+// A timeout of 0 means no limit other than the dial context, which is the
+// default. The QueryTimeout of the PoolOptions applies in addition.
+func WithPathLookupTimeout(timeout time.Duration) DialOption {
+	return dialOption(func(o *dialOptions) {
+		o.lookup.timeout = timeout
+	})
+}
+
+// WithStalePathFallback makes a dial use the cached paths to the destination,
+// from earlier path lookups in this process, if the initial path lookup fails,
+// e.g. because it exceeded the timeout set with WithPathLookupTimeout.
+// The cached paths may be outdated, but expired paths are never used. An
+// EventStalePaths is emitted as a warning when the cached paths are used. The
+// paths are refreshed in the background as usual.
+// There is no fallback if the dial context is done.
+func WithStalePathFallback() DialOption {
+	return dialOption(func(o *dialOptions) {
+		o.lookup.staleFallback = true
+	})
+}
+
+// WithQUICConfig sets a function that adjusts the QUIC config of DialQUIC and
+// DialQUICEarly for the dialed connection, before the QUIC handshake. It is
+// called with the underlying connection and a copy of the QUIC config passed
+// to the dial (nil if none was passed), and returns the config to use. This
+// allows to derive parameters from the path, see e.g.
+// quicutil.WithPathTimeouts. Ignored by DialUDP.
+func WithQUICConfig(adjust func(conn Conn, conf *quic.Config) *quic.Config) DialOption {
+	return dialOption(func(o *dialOptions) {
+		o.quicConfig = adjust
+	})
+}
+
+// WithReplySelector sets the selector that chooses the reply paths on a
+// listening connection. By default, a DefaultReplySelector is used.
+func WithReplySelector(selector ReplySelector) ListenOption {
+	return listenOption(func(o *listenOptions) {
+		o.selector = selector
+	})
+}
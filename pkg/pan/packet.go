@@ -0,0 +1,256 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"errors"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/slayers/path/empty"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"github.com/scionproto/scion/private/topology/underlay"
+)
+
+// packetSerializer serializes SCION/UDP packets. The layers and buffers are
+// reused for each packet, so that serializing does not allocate in the common
+// case of SCION and empty paths.
+// Not safe for concurrent use.
+type packetSerializer struct {
+	scion   slayers.SCION
+	udp     slayers.UDP
+	path    scion.Raw
+	srcAddr [16]byte
+	dstAddr [16]byte
+	buffer  gopacket.SerializeBuffer
+	// e2e, authOption and macBuffer are only used for authenticated packets,
+	// see WithPacketAuthentication.
+	e2e        slayers.EndToEndExtn
+	authOption slayers.PacketAuthOption
+	macBuffer  []byte
+}
+
+var serializeOptions = gopacket.SerializeOptions{
+	ComputeChecksums: true,
+	FixLengths:       true,
+}
+
+// serialize serializes a SCION/UDP packet with traffic class tc and payload
+// b and returns it, together with the next hop on the underlay. The packet is
+// authenticated with auth, unless it is nil. The returned slice is valid until
+// the next call.
+func (s *packetSerializer) serialize(src, dst UDPAddr, path *Path, tc TrafficClass,
+	b []byte, auth *packetAuthenticator) ([]byte, netip.AddrPort, error) {
+
+	dataplanePath, nextHop := route(src, dst, path)
+
+	s.scion.Version = 0
+	s.scion.TrafficClass = uint8(tc)
+	// Like snet, use a pseudo value for the flow ID.
+	s.scion.FlowID = 1
+	s.scion.NextHdr = slayers.L4UDP
+	s.scion.DstIA = addr.IA(dst.IA)
+	s.scion.SrcIA = addr.IA(src.IA)
+	if err := setAddr(&s.scion.DstAddrType, &s.scion.RawDstAddr, s.dstAddr[:], dst.IP); err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	if err := setAddr(&s.scion.SrcAddrType, &s.scion.RawSrcAddr, s.srcAddr[:], src.IP); err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	switch p := dataplanePath.(type) {
+	case snetpath.SCION:
+		if err := s.path.DecodeFromBytes(p.Raw); err != nil {
+			return nil, netip.AddrPort{}, err
+		}
+		s.scion.Path, s.scion.PathType = &s.path, scion.PathType
+	case snetpath.Empty:
+		s.scion.Path, s.scion.PathType = empty.Path{}, empty.PathType
+	default:
+		if err := dataplanePath.SetPath(&s.scion); err != nil {
+			return nil, netip.AddrPort{}, err
+		}
+	}
+	if path != nil {
+		if mtu := path.MTU(); mtu > 0 {
+			max := maxPayload(mtu, &s.scion)
+			if auth != nil {
+				max -= packetAuthExtLen
+			}
+			if len(b) > max {
+				return nil, netip.AddrPort{}, ErrMsgTooLarge{MaxSize: max}
+			}
+		}
+	}
+	s.udp.SrcPort = src.Port
+	s.udp.DstPort = dst.Port
+	s.udp.SetNetworkLayerForChecksum(&s.scion)
+
+	if s.buffer == nil {
+		s.buffer = gopacket.NewSerializeBuffer()
+	}
+	if err := s.buffer.Clear(); err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	payload, err := s.buffer.PrependBytes(len(b))
+	if err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	copy(payload, b)
+	if err := s.udp.SerializeTo(s.buffer, serializeOptions); err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	if auth != nil {
+		if err := auth.sign(s, src, dst); err != nil {
+			return nil, netip.AddrPort{}, err
+		}
+	}
+	if err := s.scion.SerializeTo(s.buffer, serializeOptions); err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	return s.buffer.Bytes(), nextHop, nil
+}
+
+// route returns the dataplane path and the next hop on the underlay for a
+// packet from src to dst via path.
+func route(src, dst UDPAddr, path *Path) (snet.DataplanePath, netip.AddrPort) {
+	// assert:
+	if src.IA != dst.IA && path == nil {
+		panic("writeMsg: need path when src.IA != dst.IA")
+	}
+	if path != nil && src.IA != path.Source {
+		panic("writeMsg: src.IA != path.Source")
+	}
+	if path != nil && dst.IA != path.Destination {
+		panic("writeMsg: dst.IA != path.Destination")
+	}
+
+	if src.IA == dst.IA {
+		return snetpath.Empty{}, netip.AddrPortFrom(dst.IP, endhostUnderlayPort(dst.Port))
+	}
+	return path.ForwardingPath.dataplanePath, path.ForwardingPath.underlay
+}
+
+// portRange is an inclusive range of ports.
+type portRange struct {
+	start, end uint16
+}
+
+func (r *portRange) contains(port uint16) bool {
+	return r != nil && port >= r.start && port <= r.end
+}
+
+// localEndhostPorts is the endhost port range of the local AS, set when the
+// host context is initialized.
+var localEndhostPorts atomic.Pointer[portRange]
+
+// endhostUnderlayPort returns the underlay port to which packets to a host in
+// the local AS with the SCION/UDP port are sent. Without a dispatcher, end
+// hosts bind their SCION/UDP port on the underlay and receive packets directly
+// if the port is within the endhost port range of the AS. Packets to other
+// ports, as well as all packets if the range is unknown, are sent to the shim
+// dispatcher, which forwards them.
+func endhostUnderlayPort(port uint16) uint16 {
+	if localEndhostPorts.Load().contains(port) {
+		return port
+	}
+	return underlay.EndhostPort
+}
+
+// setAddr sets the address type and raw address of a SCION header to the IP
+// address, using buf as the storage for the raw address.
+func setAddr(addrType *slayers.AddrType, raw *[]byte, buf []byte, ip netip.Addr) error {
+	ip = ip.Unmap()
+	switch {
+	case ip.Is4():
+		a := ip.As4()
+		*addrType, *raw = slayers.T4Ip, buf[:copy(buf, a[:])]
+	case ip.Is6():
+		a := ip.As16()
+		*addrType, *raw = slayers.T16Ip, buf[:copy(buf, a[:])]
+	default:
+		return errors.New("invalid IP address")
+	}
+	return nil
+}
+
+// maxPayload returns the maximum UDP payload size for the given MTU and a
+// SCION header with addresses and path set.
+func maxPayload(mtu uint16, scn *slayers.SCION) int {
+	hdrLen := slayers.CmnHdrLen + scn.AddrHdrLen() + scn.Path.Len() + udpHdrLen
+	return int(mtu) - hdrLen
+}
+
+// packetParser decodes received SCION packets. The layers are reused for each
+// packet, so that parsing does not allocate.
+// Not safe for concurrent use.
+type packetParser struct {
+	scion   slayers.SCION
+	hbh     slayers.HopByHopExtnSkipper
+	e2e     slayers.EndToEndExtnSkipper
+	udp     slayers.UDP
+	scmp    slayers.SCMP
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+	// macBuffer is only used for authenticated packets, see
+	// WithPacketAuthentication.
+	macBuffer []byte
+}
+
+// parse decodes the packet in data and returns the type of the L4 layer
+// (SCION/UDP or SCMP). The layers reference data.
+func (p *packetParser) parse(data []byte) (gopacket.LayerType, error) {
+	if p.parser == nil {
+		p.scion.RecyclePaths()
+		p.parser = gopacket.NewDecodingLayerParser(
+			slayers.LayerTypeSCION, &p.scion, &p.hbh, &p.e2e, &p.udp, &p.scmp,
+		)
+		p.parser.IgnoreUnsupported = true
+		p.decoded = make([]gopacket.LayerType, 0, 4)
+	}
+	if err := p.parser.DecodeLayers(data, &p.decoded); err != nil {
+		return gopacket.LayerTypeZero, err
+	}
+	if len(p.decoded) < 2 {
+		return gopacket.LayerTypeZero, errors.New("L4 not decoded")
+	}
+	return p.decoded[len(p.decoded)-1], nil
+}
+
+// decodedLayer returns whether the last parsed packet contains the layer.
+func (p *packetParser) decodedLayer(t gopacket.LayerType) bool {
+	for _, d := range p.decoded {
+		if d == t {
+			return true
+		}
+	}
+	return false
+}
+
+// rawPath returns a copy of the path of the last parsed packet.
+func (p *packetParser) rawPath() (snet.RawPath, error) {
+	rp := snet.RawPath{PathType: p.scion.Path.Type()}
+	if l := p.scion.Path.Len(); l != 0 {
+		rp.Raw = make([]byte, l)
+		if err := p.scion.Path.SerializeTo(rp.Raw); err != nil {
+			return snet.RawPath{}, err
+		}
+	}
+	return rp, nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/drkey"
+	"github.com/scionproto/scion/pkg/slayers"
+	"github.com/scionproto/scion/pkg/spao"
+
+	pandrkey "github.com/netsec-ethz/scion-apps/pkg/pan/drkey"
+)
+
+const (
+	// packetAuthExtLen is the length of the end-to-end extension carrying the
+	// packet authenticator option with an AES-CMAC.
+	packetAuthExtLen = 32
+	// packetAuthMACLen is the length of the AES-CMAC authenticator.
+	packetAuthMACLen = 16
+	// packetAuthMaxSkew is the maximum difference between the timestamp of an
+	// authenticated packet and the time it is received.
+	packetAuthMaxSkew = 10 * time.Second
+	// packetAuthKeyTimeout bounds the time to fetch a DRKey from the daemon.
+	packetAuthKeyTimeout = 2 * time.Second
+)
+
+// WithPacketAuthentication authenticates the packets of the connection with
+// the SCION Packet Authenticator Option (SPAO), using DRKey host-host keys of
+// the given DRKey protocol. An AES-CMAC over the SCION header and the UDP
+// datagram is added to each packet written; the key is derived by the
+// sender's AS, as for the SPAO sender-side direction. Received packets without
+// a valid authenticator, or with a timestamp differing more than 10 seconds
+// from the local time, are dropped, and the read returns a PacketAuthError.
+// Replayed packets are not detected within this window; see
+// RequestAuthenticator for requests that must not be replayed.
+// Both ends of the connection must enable packet authentication with the same
+// protocol, and the daemons of both hosts must serve DRKeys. Keys are fetched
+// from the daemon on the first packet to or from a peer, and cached by a
+// manager shared by all connections, see package pan/drkey. Reads never wait
+// for a key: packets from a peer whose key is not cached yet are dropped
+// while the key is fetched in the background, the read returning a
+// PacketAuthError wrapping drkey.ErrKeyPending. Failed fetches are not
+// retried for a while and fetches for new peers are rate limited, so that
+// spoofed packets do not flood the daemon. Packets sent around the change of
+// a key epoch may be dropped.
+// The authenticator reduces the maximum payload size by 32 bytes.
+func WithPacketAuthentication(protocol uint16) ConnOption {
+	return func(o *connOptions) {
+		o.packetAuthProtocol = protocol
+	}
+}
+
+// PacketAuthError is returned by reads on a connection with packet
+// authentication when a packet from Remote was dropped, because its
+// authenticator is missing or invalid.
+type PacketAuthError struct {
+	Remote UDPAddr
+	Err    error
+}
+
+func (e PacketAuthError) Error() string {
+	return fmt.Sprintf("packet authentication failed for packet from %s: %s", e.Remote, e.Err)
+}
+
+func (e PacketAuthError) Unwrap() error {
+	return e.Err
+}
+
+var errPacketAuthUnsupported = errors.New("packet authentication requires a UDP socket")
+
+// packetAuthenticator computes and verifies the authenticators of the packets
+// of a connection. Safe for concurrent use.
+type packetAuthenticator struct {
+	protocol drkey.Protocol
+	spi      slayers.PacketAuthSPI
+	// keys is the DRKey manager; the shared one, see drkeys, if nil.
+	keys *pandrkey.Manager
+}
+
+// drkeys is the DRKey manager shared by all connections of this process.
+var drkeys = sync.OnceValue(func() *pandrkey.Manager {
+	return pandrkey.NewManager(host().sciond)
+})
+
+// newPacketAuthenticator returns the authenticator for the connection
+// options, nil if packet authentication is disabled.
+func newPacketAuthenticator(o connOptions) *packetAuthenticator {
+	if o.packetAuthProtocol == 0 {
+		return nil
+	}
+	// Only fails for protocol 0.
+	spi, _ := slayers.MakePacketAuthSPIDRKey(o.packetAuthProtocol,
+		slayers.PacketAuthHostHost, slayers.PacketAuthSenderSide)
+	return &packetAuthenticator{
+		protocol: drkey.Protocol(o.packetAuthProtocol),
+		spi:      spi,
+	}
+}
+
+// hostHostKey returns the key of the protocol from src to dst, valid at time
+// t, from the manager keys or, if nil, the shared one.
+func hostHostKey(keys *pandrkey.Manager, protocol drkey.Protocol,
+	src, dst UDPAddr, t time.Time) (drkey.HostHostKey, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), packetAuthKeyTimeout)
+	defer cancel()
+	if keys == nil {
+		keys = drkeys()
+	}
+	return keys.HostHostKey(ctx, hostHostMeta(protocol, src, dst, t))
+}
+
+// cachedHostHostKey is hostHostKey without blocking; if the key is not cached,
+// it is fetched in the background and drkey.ErrKeyPending is returned.
+func cachedHostHostKey(keys *pandrkey.Manager, protocol drkey.Protocol,
+	src, dst UDPAddr, t time.Time) (drkey.HostHostKey, error) {
+
+	if keys == nil {
+		keys = drkeys()
+	}
+	return keys.Lookup(hostHostMeta(protocol, src, dst, t))
+}
+
+func hostHostMeta(protocol drkey.Protocol, src, dst UDPAddr, t time.Time) drkey.HostHostMeta {
+	return drkey.HostHostMeta{
+		ProtoId:  protocol,
+		Validity: t,
+		SrcIA:    addr.IA(src.IA),
+		DstIA:    addr.IA(dst.IA),
+		SrcHost:  src.IP.String(),
+		DstHost:  dst.IP.String(),
+	}
+}
+
+// sign adds the packet authenticator option to the packet of s, whose SCION
+// header is set and whose UDP datagram is serialized to the buffer.
+func (a *packetAuthenticator) sign(s *packetSerializer, src, dst UDPAddr) error {
+	now := time.Now()
+	key, err := hostHostKey(a.keys, a.protocol, src, dst, now)
+	if err != nil {
+		return err
+	}
+	ts, err := spao.RelativeTimestamp(key.Epoch, now)
+	if err != nil {
+		return err
+	}
+	if s.authOption.EndToEndOption == nil {
+		s.authOption.EndToEndOption = new(slayers.EndToEndOption)
+		s.macBuffer = make([]byte, spao.MACBufferSize)
+	}
+	var zero [packetAuthMACLen]byte
+	err = s.authOption.Reset(slayers.PacketAuthOptionParams{
+		SPI:         a.spi,
+		Algorithm:   slayers.PacketAuthCMAC,
+		TimestampSN: ts,
+		Auth:        zero[:],
+	})
+	if err != nil {
+		return err
+	}
+	_, err = spao.ComputeAuthCMAC(spao.MACInput{
+		Key:        key.Key[:],
+		Header:     s.authOption,
+		ScionLayer: &s.scion,
+		PldType:    slayers.L4UDP,
+		Pld:        s.buffer.Bytes(),
+	}, s.macBuffer, s.authOption.Authenticator())
+	if err != nil {
+		return err
+	}
+	s.scion.NextHdr = slayers.End2EndClass
+	s.e2e.NextHdr = slayers.L4UDP
+	s.e2e.Options = append(s.e2e.Options[:0], s.authOption.EndToEndOption)
+	return s.e2e.SerializeTo(s.buffer, serializeOptions)
+}
+
+// verify checks the packet authenticator option of the SCION/UDP packet last
+// parsed by p, received from src.
+func (a *packetAuthenticator) verify(p *packetParser, src UDPAddr) error {
+	if !p.decodedLayer(slayers.LayerTypeEndToEndExtn) {
+		return errors.New("missing authenticator")
+	}
+	var e2e slayers.EndToEndExtn
+	if err := e2e.DecodeFromBytes(p.e2e.Contents, gopacket.NilDecodeFeedback); err != nil {
+		return err
+	}
+	o, err := e2e.FindOption(slayers.OptTypeAuthenticator)
+	if err != nil {
+		return errors.New("missing authenticator")
+	}
+	opt, err := slayers.ParsePacketAuthOption(o)
+	if err != nil {
+		return err
+	}
+	if opt.SPI() != a.spi || opt.Algorithm() != slayers.PacketAuthCMAC ||
+		len(opt.Authenticator()) != packetAuthMACLen {
+		return errors.New("unexpected authenticator type")
+	}
+	dstAddr, err := p.scion.DstAddr()
+	if err != nil || dstAddr.Type() != addr.HostTypeIP {
+		return errors.New("non-IP destination")
+	}
+	dst := UDPAddr{IA: IA(p.scion.DstIA), IP: dstAddr.IP(), Port: p.udp.DstPort}
+
+	now := time.Now()
+	key, err := cachedHostHostKey(a.keys, a.protocol, src, dst, now)
+	if err != nil {
+		return err
+	}
+	sent := spao.AbsoluteTimestamp(key.Epoch, opt.TimestampSN())
+	if skew := now.Sub(sent); skew > packetAuthMaxSkew || skew < -packetAuthMaxSkew {
+		return errors.New("timestamp out of range")
+	}
+	if p.macBuffer == nil {
+		p.macBuffer = make([]byte, spao.MACBufferSize)
+	}
+	var mac [packetAuthMACLen]byte
+	_, err = spao.ComputeAuthCMAC(spao.MACInput{
+		Key:        key.Key[:],
+		Header:     opt,
+		ScionLayer: &p.scion,
+		PldType:    slayers.L4UDP,
+		Pld:        p.e2e.Payload,
+	}, p.macBuffer, mac[:])
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(mac[:], opt.Authenticator()) != 1 {
+		return errors.New("invalid authenticator")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/drkey"
+	"github.com/scionproto/scion/pkg/scrypto/cppki"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pandrkey "github.com/netsec-ethz/scion-apps/pkg/pan/drkey"
+)
+
+// testPacketAuthenticator returns an authenticator whose keys are derived
+// from the secret and the key metadata, valid for an hour around the
+// requested time.
+func testPacketAuthenticator(secret string) *packetAuthenticator {
+	a := newPacketAuthenticator(connOptions{packetAuthProtocol: 42})
+	a.keys = pandrkey.NewManager(testKeyFetcher(secret))
+	return a
+}
+
+type testKeyFetcher string
+
+func (secret testKeyFetcher) DRKeyGetHostHostKey(ctx context.Context,
+	meta drkey.HostHostMeta) (drkey.HostHostKey, error) {
+
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s %d %s %s %s %s", secret, meta.ProtoId,
+		meta.SrcIA, meta.SrcHost, meta.DstIA, meta.DstHost)))
+	key := drkey.HostHostKey{
+		ProtoId: meta.ProtoId,
+		Epoch: drkey.Epoch{Validity: cppki.Validity{
+			NotBefore: meta.Validity.Add(-time.Hour),
+			NotAfter:  meta.Validity.Add(time.Hour),
+		}},
+		SrcIA:   meta.SrcIA,
+		DstIA:   meta.DstIA,
+		SrcHost: meta.SrcHost,
+		DstHost: meta.DstHost,
+	}
+	copy(key.Key[:], h[:])
+	return key, nil
+}
+
+func TestPacketAuthentication(t *testing.T) {
+	sender, src := testLoopbackConn(t, "127.0.0.1")
+	receiver, dst := testLoopbackConn(t, "127.0.0.2")
+	path := testLoopbackPath(t, &src, &dst)
+	sender.auth = testPacketAuthenticator("secret")
+	receiver.auth = testPacketAuthenticator("secret")
+
+	plain, _, err := sender.serializer.serialize(src, dst, path, 0, make([]byte, 100), nil)
+	require.NoError(t, err)
+	plainLen := len(plain)
+	authenticated, _, err := sender.serializer.serialize(src, dst, path, 0, make([]byte, 100), sender.auth)
+	require.NoError(t, err)
+	assert.Equal(t, plainLen+packetAuthExtLen, len(authenticated))
+
+	read := func() (string, error) {
+		buf := make([]byte, 100)
+		n, _, _, err := receiver.readMsg(buf, true)
+		return string(buf[:n]), err
+	}
+	// The first packet is dropped, reads do not wait for the key of the
+	// sender.
+	_, err = sender.writeMsg(src, dst, path, []byte("hello"))
+	require.NoError(t, err)
+	_, err = read()
+	assert.ErrorIs(t, err, pandrkey.ErrKeyPending)
+	require.Eventually(t, func() bool {
+		_, err := cachedHostHostKey(receiver.auth.keys, receiver.auth.protocol, src, dst, time.Now())
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	_, err = sender.writeMsg(src, dst, path, []byte("hello"))
+	require.NoError(t, err)
+	msg, err := read()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", msg)
+
+	// Packets authenticated with another key are dropped.
+	sender.auth = testPacketAuthenticator("other secret")
+	_, err = sender.writeMsg(src, dst, path, []byte("forged"))
+	require.NoError(t, err)
+	_, err = read()
+	var authErr PacketAuthError
+	require.True(t, errors.As(err, &authErr), "got %v", err)
+	assert.Equal(t, src, authErr.Remote)
+
+	// Unauthenticated packets are dropped.
+	sender.auth = nil
+	_, err = sender.writeMsg(src, dst, path, []byte("plain"))
+	require.NoError(t, err)
+	_, err = read()
+	assert.True(t, errors.As(err, &authErr), "got %v", err)
+}
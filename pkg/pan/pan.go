@@ -21,7 +21,8 @@ The main entry points for applications are:
   - DialUDP / ListenUDP
   - DialQUIC / ListenQUIC
 
-Both forms of the Dial call allow to specify a Policy and a Selector.
+Both forms of the Dial call allow to specify a Policy and a Selector, with the
+options WithPolicy and WithSelector.
 
 # Policy
 
@@ -54,20 +55,30 @@ The default reply path selector records a fixed number of paths used by a client
 It normally uses the path last used by the client for replies, but does use other
 recorded paths to try routing around temporarily broken paths.
 
-# Dispatcher and SCION daemon connections
+# SCION daemon connection and endhost ports
 
-During the hidden initialisation of this package, the dispatcher and sciond
-connections are opened. The sciond connection determines the local IA.
-The dispatcher and sciond sockets are assumed to be at default locations, but this can
-be overridden using environment variables:
+During the hidden initialisation of this package, the sciond connection is
+opened. The sciond connection determines the local IA and the endhost port
+range of the local AS.
+The sciond address is assumed to be at the default location, but this can be
+overridden using an environment variable:
 
-	SCION_DISPATCHER_SOCKET: /run/shm/dispatcher/default.sock
 	SCION_DAEMON_ADDRESS: 127.0.0.1:30255
 
+There is no dispatcher; each connection binds its own UDP socket on the
+underlay, on the same port as its SCION/UDP port. Automatically chosen ports
+are taken from the endhost port range, in which the border routers and other
+hosts of the local AS deliver packets directly to the socket. Sockets bound to
+a port outside of the range only receive packets if a shim dispatcher is
+running on the host, which forwards them from the port 30041.
+
 This is convenient for the normal use case of running the endhost stack for a
 single SCION AS. When running multiple local ASes, e.g. during development, the
 address of the sciond corresponding to the desired AS needs to be specified in
 the SCION_DAEMON_ADDRESS environment variable.
+For hosts running redundant sciond instances, SCION_DAEMON_ADDRESS can be a
+comma separated list of addresses; requests fail over to the next sciond if the
+current one is unavailable.
 
 # Wildcard IP Addresses
 
@@ -78,6 +89,24 @@ Binding to one specific local IP address, means that the application will not be
 the other IP addresses of the host. Traffic sent will always appear to originate from this specific
 IP address, even if that's not the correct route to a destination in the local AS.
 
+For dialed connections, the default local IP is the source address of the
+route to the underlay next hop of the first path to the destination, so
+that on dual-stack hosts the address family matches the one of the border
+router. Otherwise, it is the source address of the route to the control
+service of the local AS. The address family can be forced with
+SetLocalAddrFamily.
+
+# Packet capture
+
+The SCION packets of all UDP connections of the process can be mirrored to a
+unix socket, by setting the SCION_PAN_TAP environment variable to its path, see
+TapSocketEnv. scion-capture writes the mirrored packets to a pcapng file.
+
+# Metrics
+
+Prometheus metrics for the path pool and the connections can be enabled with
+EnableMetrics. Metrics are disabled by default.
+
 Notes
 
   - pan only performs path lookups for destinations requested by the application.
@@ -106,10 +135,20 @@ import (
 // If the address is in the form of a hostname, the the following sources will
 // be used to resolve a name, in the given order of precedence.
 //
+//   - the hosts added with AddHost
 //   - /etc/hosts
+//   - the user's SCION hosts file, $XDG_CONFIG_HOME/scion/hosts or the equivalent on the OS (see os.UserConfigDir)
 //   - /etc/scion/hosts
-//   - RAINS, if a server is configured in /etc/scion/rains.cfg. Disabled if built with !norains.
-//   - DNS TXT records using the local DNS resolver (depending on OS config, see "Name Resolution" in net package docs)
+//   - RAINS, if a server is configured in the environment variable SCION_RAINS_SERVER (see RainsServerEnv) or
+//     in /etc/scion/rains.cfg. Disabled if built with norains.
+//   - the resolvers added with RegisterResolver, in the order of registration
+//   - DNS TXT records of the form "scion=<address>", or NAPTR records with the service "x-scion" and the address as
+//     substitution, e.g. "!^.*$!1-ff00:0:110,[192.0.2.1]!". The records are queried from the name servers in
+//     /etc/resolv.conf; if these are not available, only TXT records are looked up using the local DNS resolver
+//     (depending on OS config, see "Name Resolution" in net package docs).
+//     The results are cached for the TTL of the records, at most one hour.
+//
+// Changes to the hosts files are picked up without restarting the application.
 //
 // Returns HostNotFoundError if none of the sources did resolve the hostname.
 func ResolveUDPAddr(ctx context.Context, address string) (UDPAddr, error) {
@@ -0,0 +1,55 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pandebug serves debugging endpoints for applications using pan.
+//
+// It is separate from pan because it imports net/http/pprof, which registers
+// the profiling endpoints on http.DefaultServeMux; only the applications that
+// want the debug endpoints should import it.
+package pandebug
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+// Handler returns an http.Handler serving debugging information about the
+// running application:
+//
+//   - /debug/pprof/: the Go runtime profiles, as net/http/pprof
+//   - /debug/pan/: the state of pan, see pan.DebugInfoHandler
+//
+// The handler exposes internals of the application and should only be served
+// on trusted addresses. To serve it over SCION, use shttp, e.g.
+//
+//	shttp.ListenAndServe(":8899", pandebug.Handler())
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/pan/", pan.DebugInfoHandler())
+	return mux
+}
+
+// ListenAndServe serves the Handler on the TCP address addr, e.g.
+// "localhost:6060". It blocks until the server fails; it is typically started
+// in a goroutine.
+func ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, Handler())
+}
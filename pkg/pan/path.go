@@ -44,6 +44,20 @@ func (p *Path) String() string {
 	}
 }
 
+// MTU returns the maximum size of packets on the path, including the SCION
+// headers. This is the MTU from the path metadata, lowered to the MTU reported
+// by SCMP packet too big messages for the path, if any. Returns 0 if unknown.
+func (p *Path) MTU() uint16 {
+	var mtu uint16
+	if p.Metadata != nil {
+		mtu = p.Metadata.MTU
+	}
+	if discovered := stats.PathMTU(p.Fingerprint); discovered != 0 && (mtu == 0 || discovered < mtu) {
+		mtu = discovered
+	}
+	return mtu
+}
+
 // ForwardingPath represents a data plane forwarding path.
 type ForwardingPath struct {
 	dataplanePath snet.DataplanePath
@@ -75,6 +75,15 @@ type PathMetadata struct {
 	// Notes contains the notes added by ASes on the path, in the order of occurrence.
 	// Entry i is the note of AS i on the path.
 	Notes []string
+
+	// Hidden indicates that the path was only returned when including hidden
+	// path segments in the lookup, see QueryHiddenPaths.
+	Hidden bool
+
+	// Synthetic indicates that the path was not returned by the SCION daemon,
+	// but was created locally from the segments of other paths, see
+	// EnableSyntheticPaths. Only Interfaces and MTU are set for synthetic paths.
+	Synthetic bool
 }
 
 type GeoCoordinates = snet.GeoCoordinates
@@ -93,6 +102,8 @@ func (pm *PathMetadata) Copy() *PathMetadata {
 		LinkType:     append(pm.LinkType[:0:0], pm.LinkType...),
 		InternalHops: append(pm.InternalHops[:0:0], pm.InternalHops...),
 		Notes:        append(pm.Notes[:0:0], pm.Notes...),
+		Hidden:       pm.Hidden,
+		Synthetic:    pm.Synthetic,
 	}
 }
 
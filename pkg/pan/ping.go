@@ -0,0 +1,319 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers/path/empty"
+	"github.com/scionproto/scion/pkg/snet"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan/internal/ping"
+)
+
+// ErrPingTimeout is the error of a ping for which no reply was received.
+var ErrPingTimeout = errors.New("ping timeout")
+
+// PathDownError is the error of a ping that was answered with an SCMP path
+// down notification, i.e. an external interface down or internal
+// connectivity down message, for the interface.
+type PathDownError struct {
+	Interface PathInterface
+}
+
+func (e PathDownError) Error() string {
+	return fmt.Sprintf("path down at %s#%d", e.Interface.IA, e.Interface.IfID)
+}
+
+// PingResult is the result of one SCMP echo request.
+type PingResult struct {
+	// Path is the path on which the request was sent; nil in the local AS.
+	Path     *Path
+	Sequence uint16
+	// RTT is the round trip time, if a reply was received.
+	RTT time.Duration
+	// Size is the size of the reply packet.
+	Size int
+	// Err is nil if a reply was received. Otherwise, it is ErrPingTimeout, a
+	// PathDownError, or the error sending the request.
+	Err error
+}
+
+// Pinger sends SCMP echo requests to remote hosts, over specific paths or
+// over all paths allowed by a policy, and SCMP traceroute requests to the
+// routers on a path, see Traceroute. Replies are matched to requests, so that
+// a Pinger can be used concurrently.
+// Path down notifications received in response to a request are also
+// recorded, as for connections, so that selectors avoid the path.
+type Pinger struct {
+	pinger *ping.Pinger
+	cancel context.CancelFunc
+
+	mutex    sync.Mutex
+	sequence uint16
+	pending  map[uint16]*pendingPing
+	closed   bool
+}
+
+type pendingPing struct {
+	remote scionAddr
+	path   *Path
+	result chan PingResult
+	// hop is set instead of result for traceroute requests.
+	hop *pendingHop
+}
+
+// NewPinger opens a socket for sending SCMP echo requests. If the local
+// address, or either its IP or port, are left unspecified, they will be
+// automatically chosen.
+func NewPinger(ctx context.Context, local netip.AddrPort) (*Pinger, error) {
+	local, err := defaultLocalAddr(local)
+	if err != nil {
+		return nil, err
+	}
+	pingerCtx, cancel := context.WithCancel(context.Background())
+	pinger, err := ping.NewPinger(ctx, host().sciond, &snet.UDPAddr{
+		IA:   addr.IA(host().ia),
+		Host: net.UDPAddrFromAddrPort(local),
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	p := &Pinger{
+		pinger:  pinger,
+		cancel:  cancel,
+		pending: make(map[uint16]*pendingPing),
+	}
+	goroutines.goroutine(goroutinePinger, func() { pinger.Drain(pingerCtx) })
+	goroutines.goroutine(goroutinePinger, func() { p.run(pingerCtx) })
+	return p, nil
+}
+
+// Ping sends an echo request with a payload of size bytes to the remote host
+// over the path and waits for the reply until the context is done. The port
+// of remote is ignored. The path may be nil if remote is in the local AS.
+// If the context has no deadline, a timeout of one second is used.
+func (p *Pinger) Ping(ctx context.Context, remote UDPAddr, path *Path, size int) PingResult {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second)
+		defer cancel()
+	}
+	pp := &pendingPing{
+		remote: remote.scionAddr(),
+		path:   path,
+		result: make(chan PingResult, 1),
+	}
+	seq, err := p.register(pp)
+	if err != nil {
+		return PingResult{Path: path, Err: err}
+	}
+	defer p.unregister(seq)
+
+	dst := pp.remote.snetUDPAddr()
+	if path != nil {
+		dst.Path = path.ForwardingPath.dataplanePath
+		dst.NextHop = net.UDPAddrFromAddrPort(path.ForwardingPath.underlay)
+	}
+	if err := p.pinger.Send(ctx, dst, seq, size); err != nil {
+		return PingResult{Path: path, Sequence: seq, Err: p.closedErr(err)}
+	}
+	select {
+	case r := <-pp.result:
+		return r
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return PingResult{Path: path, Sequence: seq, Err: ErrPingTimeout}
+		}
+		return PingResult{Path: path, Sequence: seq, Err: ctx.Err()}
+	}
+}
+
+// PingPaths pings the remote host concurrently over all paths allowed by the
+// policy, and returns the results in the order of the paths. If the policy is
+// nil, all paths are used.
+func (p *Pinger) PingPaths(ctx context.Context, remote UDPAddr, policy Policy,
+	size int) ([]PingResult, error) {
+
+	paths, err := QueryPaths(ctx, remote.IA, WithPolicy(policy))
+	if err != nil {
+		return nil, err
+	}
+	results := make([]PingResult, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path *Path) {
+			defer wg.Done()
+			results[i] = p.Ping(ctx, remote, path, size)
+		}(i, path)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// register adds the pending request and returns its sequence number.
+// Returns net.ErrClosed if the Pinger is closed.
+func (p *Pinger) register(pp *pendingPing) (uint16, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return 0, net.ErrClosed
+	}
+	p.sequence++
+	p.pending[p.sequence] = pp
+	return p.sequence, nil
+}
+
+// closedErr returns net.ErrClosed if the Pinger is closed, and err otherwise.
+func (p *Pinger) closedErr(err error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return net.ErrClosed
+	}
+	return err
+}
+
+func (p *Pinger) unregister(seq uint16) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.pending, seq)
+}
+
+// Close closes the Pinger. Pending requests fail with net.ErrClosed, as do
+// requests sent after Close. Closing a closed Pinger returns net.ErrClosed.
+func (p *Pinger) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return net.ErrClosed
+	}
+	p.closed = true
+	for seq, pp := range p.pending {
+		if pp.hop != nil {
+			p.deliverHop(pp, TracerouteHop{Index: pp.hop.index, Err: net.ErrClosed})
+		} else {
+			p.deliver(pp, PingResult{Path: pp.path, Sequence: seq, Err: net.ErrClosed})
+		}
+	}
+	p.mutex.Unlock()
+	p.cancel()
+	return p.pinger.Close()
+}
+
+// run dispatches the replies to the pending requests.
+func (p *Pinger) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-p.pinger.Replies:
+			p.handleReply(r)
+		}
+	}
+}
+
+func (p *Pinger) handleReply(reply ping.Reply) {
+	if reply.Traceroute != nil {
+		if reply.Error == nil {
+			p.handleTracerouteReply(reply)
+		}
+		return
+	}
+	var pf PathFingerprint // empty for replies from the local AS
+	if reply.Path.PathType != empty.PathType {
+		var err error
+		if pf, err = reversePathFingerprint(reply.Path); err != nil {
+			return
+		}
+	}
+	if reply.Error != nil {
+		pi, ok := pathDownInterface(reply.Error)
+		if !ok || pf == "" {
+			return
+		}
+		stats.NotifyPathDown(pf, pi)
+		// The request is not identified by the notification; it applies to
+		// all pending requests on the path.
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		for seq, pp := range p.pending {
+			if pp.path == nil || pp.path.Fingerprint != pf {
+				continue
+			}
+			if pp.hop != nil {
+				p.deliverHop(pp, TracerouteHop{Index: pp.hop.index, Err: PathDownError{pi}})
+			} else {
+				p.deliver(pp, PingResult{Path: pp.path, Sequence: seq, Err: PathDownError{pi}})
+			}
+		}
+		return
+	}
+
+	if reply.Source.Host.Type() != addr.HostTypeIP {
+		return
+	}
+	src := scionAddr{IA: IA(reply.Source.IA), IP: reply.Source.Host.IP()}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pp, ok := p.pending[reply.Reply.SeqNumber]
+	if !ok || pp.hop != nil || pp.remote != src {
+		return
+	}
+	if pp.path != nil && pp.path.Fingerprint != pf {
+		return
+	}
+	rtt := reply.RTT()
+	if pp.path != nil {
+		stats.RecordLatency(src, pf, rtt)
+		stats.RecordAlive(src.IA, pf)
+	}
+	p.deliver(pp, PingResult{
+		Path:     pp.path,
+		Sequence: reply.Reply.SeqNumber,
+		RTT:      rtt,
+		Size:     reply.Size,
+	})
+}
+
+// deliver sends the result, unless a result was already delivered. Must be
+// called with the mutex held.
+func (p *Pinger) deliver(pp *pendingPing, r PingResult) {
+	select {
+	case pp.result <- r:
+	default:
+	}
+}
+
+// pathDownInterface returns the interface of a path down notification
+// received by the internal pinger.
+func pathDownInterface(err error) (PathInterface, bool) {
+	switch e := err.(type) { //nolint:errorlint
+	case ping.InternalConnectivityDownError:
+		return PathInterface{IA: IA(e.IA), IfID: IfID(e.Egress)}, true
+	case ping.ExternalInterfaceDownError:
+		return PathInterface{IA: IA(e.IA), IfID: IfID(e.Interface)}, true
+	}
+	return PathInterface{}, false
+}
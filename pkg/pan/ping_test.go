@@ -0,0 +1,79 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers/path/empty"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan/internal/ping"
+)
+
+func TestPingerHandleReply(t *testing.T) {
+	remote := MustParseUDPAddr("1-ff00:0:111,127.0.0.2:0")
+	p := &Pinger{pending: make(map[uint16]*pendingPing)}
+	pp := &pendingPing{remote: remote.scionAddr(), result: make(chan PingResult, 1)}
+	p.pending[7] = pp
+
+	reply := func(src UDPAddr, seq uint16, sent time.Time) ping.Reply {
+		payload := make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, uint64(sent.UnixNano()))
+		return ping.Reply{
+			Received: sent.Add(3 * time.Millisecond),
+			Source:   snet.SCIONAddress{IA: addr.IA(src.IA), Host: addr.HostIP(src.IP)},
+			Path:     snet.RawPath{PathType: empty.PathType},
+			Size:     64,
+			Reply:    snet.SCMPEchoReply{SeqNumber: seq, Payload: payload},
+		}
+	}
+	now := time.Now()
+	// Unexpected sequence number and unexpected source are ignored.
+	p.handleReply(reply(remote, 8, now))
+	p.handleReply(reply(MustParseUDPAddr("1-ff00:0:111,127.0.0.3:0"), 7, now))
+	assert.Empty(t, pp.result)
+
+	p.handleReply(reply(remote, 7, now))
+	select {
+	case r := <-pp.result:
+		assert.NoError(t, r.Err)
+		assert.Equal(t, uint16(7), r.Sequence)
+		assert.Equal(t, 3*time.Millisecond, r.RTT)
+		assert.Equal(t, 64, r.Size)
+	default:
+		t.Fatal("no result delivered")
+	}
+}
+
+func TestPathDownInterface(t *testing.T) {
+	ia := MustParseIA("1-ff00:0:110")
+	pi, ok := pathDownInterface(ping.ExternalInterfaceDownError{
+		SCMPExternalInterfaceDown: snet.SCMPExternalInterfaceDown{IA: addr.IA(ia), Interface: 3},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, PathInterface{IA: ia, IfID: 3}, pi)
+	pi, ok = pathDownInterface(ping.InternalConnectivityDownError{
+		SCMPInternalConnectivityDown: snet.SCMPInternalConnectivityDown{IA: addr.IA(ia), Ingress: 1, Egress: 2},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, PathInterface{IA: ia, IfID: 2}, pi)
+	_, ok = pathDownInterface(ErrPingTimeout)
+	assert.False(t, ok)
+}
@@ -231,6 +231,32 @@ func (p LeastHops) Filter(paths []*Path) []*Path {
 	return paths
 }
 
+// RequireHiddenPaths is a policy keeping only hidden paths, see QueryHiddenPaths.
+type RequireHiddenPaths struct{}
+
+func (p RequireHiddenPaths) Filter(paths []*Path) []*Path {
+	return filterHidden(paths, true)
+}
+
+// AvoidHiddenPaths is a policy keeping only paths that are not hidden paths,
+// see QueryHiddenPaths.
+type AvoidHiddenPaths struct{}
+
+func (p AvoidHiddenPaths) Filter(paths []*Path) []*Path {
+	return filterHidden(paths, false)
+}
+
+func filterHidden(paths []*Path, hidden bool) []*Path {
+	filtered := make([]*Path, 0, len(paths))
+	for _, p := range paths {
+		isHidden := p.Metadata != nil && p.Metadata.Hidden
+		if isHidden == hidden {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
 type HighestMTU struct{}
 
 func (p HighestMTU) Filter(paths []*Path) []*Path {
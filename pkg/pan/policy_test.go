@@ -268,6 +268,19 @@ func TestPreferredPolicy(t *testing.T) {
 	}
 }
 
+func TestHiddenPathsPolicy(t *testing.T) {
+	paths := []*Path{
+		{Fingerprint: "a", Metadata: &PathMetadata{Hidden: true}},
+		{Fingerprint: "b", Metadata: &PathMetadata{}},
+		{Fingerprint: "c"}, // no metadata, not hidden
+		{Fingerprint: "d", Metadata: &PathMetadata{Hidden: true}},
+	}
+	hidden := fingerprintsFromTestdataPaths(RequireHiddenPaths{}.Filter(paths))
+	assert.Equal(t, []PathFingerprint{"a", "d"}, hidden)
+	notHidden := fingerprintsFromTestdataPaths(AvoidHiddenPaths{}.Filter(paths))
+	assert.Equal(t, []PathFingerprint{"b", "c"}, notHidden)
+}
+
 // testdataPathsFromFingerprints creates a path slice only Fingerprints set.
 func testdataPathsFromFingerprints(strs []PathFingerprint) []*Path {
 	if strs == nil {
@@ -0,0 +1,289 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+// pollerMaxEvents is the maximum number of ready connections returned by a
+// single Poller.Wait.
+const pollerMaxEvents = 128
+
+var (
+	errPollerUnsupported     = errors.New("poller not supported on this platform")
+	errPollerConnUnsupported = errors.New("connection not supported by poller")
+)
+
+// PollConn is a connection that can be added to a Poller, i.e. a Conn,
+// MultiPathConn or ListenConn opened with this package.
+type PollConn interface {
+	LocalAddr() net.Addr
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// pollable is implemented by the connections that can be added to a Poller.
+type pollable interface {
+	// pollConn returns the connection whose underlay socket is polled.
+	pollConn() *baseUDPConn
+}
+
+// Poller waits until one of multiple connections has a packet to read,
+// without a goroutine per connection. This allows applications like proxies
+// to serve many connections from a single goroutine.
+// A connection is reported as ready as long as a packet is pending on its
+// underlay socket. That packet may not yield data for the application, e.g.
+// for SCMP messages, duplicates or incomplete fragmented messages, so that a
+// subsequent read may still block until the next packet arrives; set a read
+// deadline if this is not acceptable.
+// Dialed connections remain polled when they migrate to a new local address,
+// see EnableAddressMigration.
+// Polling is only supported on Linux, where it is backed by epoll.
+type Poller struct {
+	mutex  sync.Mutex
+	conns  map[uint32]PollConn
+	ids    map[PollConn]uint32
+	nextID uint32
+	closed bool
+	impl   pollerImpl
+
+	// waitMutex serializes the calls to Wait, which share the events buffer.
+	waitMutex sync.Mutex
+	events    []uint32
+}
+
+// NewPoller creates a Poller without connections. It must be closed after
+// use.
+func NewPoller() (*Poller, error) {
+	impl, err := newPollerImpl()
+	if err != nil {
+		return nil, err
+	}
+	return &Poller{
+		conns:  make(map[uint32]PollConn),
+		ids:    make(map[PollConn]uint32),
+		impl:   impl,
+		events: make([]uint32, 0, pollerMaxEvents),
+	}, nil
+}
+
+// Add adds the connection to the poller. Adding a connection twice has no
+// effect. Closed connections are not reported by Wait; they should be
+// removed with Remove.
+func (p *Poller) Add(conn PollConn) error {
+	pc, ok := conn.(pollable)
+	if !ok {
+		return errPollerConnUnsupported
+	}
+	c := pc.pollConn()
+	if c == nil {
+		return errPollerConnUnsupported
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return net.ErrClosed
+	}
+	if _, ok := p.ids[conn]; ok {
+		return nil
+	}
+	id := p.nextID
+	p.nextID++
+	if err := c.addPoller(p, id); err != nil {
+		return err
+	}
+	p.conns[id] = conn
+	p.ids[conn] = id
+	return nil
+}
+
+// Remove removes the connection from the poller. Removing a connection that
+// was not added has no effect.
+func (p *Poller) Remove(conn PollConn) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	id, ok := p.ids[conn]
+	if !ok {
+		return nil
+	}
+	delete(p.ids, conn)
+	delete(p.conns, id)
+	return conn.(pollable).pollConn().removePoller(p, id)
+}
+
+// Wait blocks until at least one of the connections has a packet to read,
+// and appends the ready connections to ready. Returns the context's error if
+// it is done first, and net.ErrClosed if the poller is closed.
+// Connections may be added and removed while Wait is blocked; concurrent
+// calls to Wait are serialized.
+func (p *Poller) Wait(ctx context.Context, ready []PollConn) ([]PollConn, error) {
+	p.waitMutex.Lock()
+	defer p.waitMutex.Unlock()
+	stop := context.AfterFunc(ctx, p.impl.wake)
+	defer stop()
+	for {
+		if err := ctx.Err(); err != nil {
+			return ready, err
+		}
+		var err error
+		p.events, err = p.impl.wait(p.events[:0])
+		if err != nil {
+			return ready, p.closedErr(err)
+		}
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return ready, net.ErrClosed
+		}
+		n := len(ready)
+		for _, id := range p.events {
+			// The connection may have been removed in the meantime.
+			if conn, ok := p.conns[id]; ok {
+				ready = append(ready, conn)
+			}
+		}
+		p.mutex.Unlock()
+		if len(ready) > n {
+			return ready, nil
+		}
+	}
+}
+
+// Close closes the poller, Wait returns net.ErrClosed. The connections are
+// not closed.
+func (p *Poller) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return net.ErrClosed
+	}
+	p.closed = true
+	for id, conn := range p.conns {
+		_ = conn.(pollable).pollConn().removePoller(p, id)
+	}
+	p.conns = nil
+	p.ids = nil
+	p.mutex.Unlock()
+
+	p.impl.wake()
+	// Wait for a blocked Wait to return before releasing the poller.
+	p.waitMutex.Lock()
+	defer p.waitMutex.Unlock()
+	return p.impl.close()
+}
+
+func (p *Poller) closedErr(err error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return net.ErrClosed
+	}
+	return err
+}
+
+func (c *baseUDPConn) pollConn() *baseUDPConn {
+	return c
+}
+
+func (c *fragmentedConn) pollConn() *baseUDPConn {
+	if pc, ok := c.Conn.(pollable); ok {
+		return pc.pollConn()
+	}
+	return nil
+}
+
+func (c *fragmentedListenConn) pollConn() *baseUDPConn {
+	if pc, ok := c.ListenConn.(pollable); ok {
+		return pc.pollConn()
+	}
+	return nil
+}
+
+// addPoller registers the underlay socket with the poller p under id, and
+// again whenever the raw connection is replaced.
+func (c *baseUDPConn) addPoller(p *Poller, id uint32) error {
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+	rc, err := rawSyscallConn(c.raw)
+	if err != nil {
+		return err
+	}
+	if err := p.impl.add(rc, id); err != nil {
+		return err
+	}
+	c.pollMutex.Lock()
+	defer c.pollMutex.Unlock()
+	if c.pollers == nil {
+		c.pollers = make(map[*Poller]uint32)
+	}
+	c.pollers[p] = id
+	return nil
+}
+
+// removePoller unregisters the underlay socket from the poller p.
+func (c *baseUDPConn) removePoller(p *Poller, id uint32) error {
+	c.pollMutex.Lock()
+	delete(c.pollers, p)
+	c.pollMutex.Unlock()
+
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	if c.closed.Load() {
+		// The socket is closed and thus no longer polled.
+		return nil
+	}
+	rc, err := rawSyscallConn(c.raw)
+	if err != nil {
+		return err
+	}
+	return p.impl.remove(rc, id)
+}
+
+// repoll registers the new raw connection with the pollers of the connection.
+// Must be called with the underlayMutex held.
+func (c *baseUDPConn) repoll(raw snet.PacketConn) {
+	c.pollMutex.Lock()
+	defer c.pollMutex.Unlock()
+	if len(c.pollers) == 0 {
+		return
+	}
+	rc, err := rawSyscallConn(raw)
+	if err != nil {
+		return
+	}
+	for p, id := range c.pollers {
+		_ = p.impl.add(rc, id)
+	}
+}
+
+// rawSyscallConn returns the syscall.RawConn of the UDP socket underlying raw.
+func rawSyscallConn(raw snet.PacketConn) (syscall.RawConn, error) {
+	scionConn, ok := raw.(*snet.SCIONPacketConn)
+	if !ok || scionConn.Conn == nil {
+		return nil, errPollerConnUnsupported
+	}
+	return scionConn.Conn.SyscallConn()
+}
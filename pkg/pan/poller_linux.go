@@ -0,0 +1,133 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package pan
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// pollerWakeID is the event data of the eventfd used to interrupt a blocked
+// wait. Connection ids are assigned from 0 upwards and never reach it in
+// practice.
+const pollerWakeID int32 = -1
+
+// pollerImpl is the epoll instance of a Poller. The event data holds the id
+// of the connection.
+type pollerImpl struct {
+	epfd   int
+	wakefd int
+	events []unix.EpollEvent
+}
+
+func newPollerImpl() (pollerImpl, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return pollerImpl{}, err
+	}
+	wakefd, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		_ = unix.Close(epfd)
+		return pollerImpl{}, err
+	}
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: pollerWakeID}
+	if err := unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, wakefd, &ev); err != nil {
+		_ = unix.Close(wakefd)
+		_ = unix.Close(epfd)
+		return pollerImpl{}, err
+	}
+	return pollerImpl{
+		epfd:   epfd,
+		wakefd: wakefd,
+		events: make([]unix.EpollEvent, pollerMaxEvents),
+	}, nil
+}
+
+// add registers the socket under id. A socket that is already registered
+// is updated to the new id.
+func (p *pollerImpl) add(rc syscall.RawConn, id uint32) error {
+	var cerr error
+	err := rc.Control(func(fd uintptr) {
+		ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(id)}
+		cerr = unix.EpollCtl(p.epfd, unix.EPOLL_CTL_ADD, int(fd), &ev)
+		if errors.Is(cerr, unix.EEXIST) {
+			cerr = unix.EpollCtl(p.epfd, unix.EPOLL_CTL_MOD, int(fd), &ev)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+func (p *pollerImpl) remove(rc syscall.RawConn, id uint32) error {
+	var cerr error
+	err := rc.Control(func(fd uintptr) {
+		cerr = unix.EpollCtl(p.epfd, unix.EPOLL_CTL_DEL, int(fd), nil)
+		if errors.Is(cerr, unix.ENOENT) {
+			cerr = nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// wait blocks until a registered socket is readable or wake is called, and
+// appends the ids of the readable sockets to ids.
+func (p *pollerImpl) wait(ids []uint32) ([]uint32, error) {
+	var n int
+	var err error
+	for {
+		n, err = unix.EpollWait(p.epfd, p.events, -1)
+		if !errors.Is(err, unix.EINTR) {
+			break
+		}
+	}
+	if err != nil {
+		return ids, err
+	}
+	for _, ev := range p.events[:n] {
+		if ev.Fd == pollerWakeID {
+			var buf [8]byte
+			_, _ = unix.Read(p.wakefd, buf[:])
+			continue
+		}
+		ids = append(ids, uint32(ev.Fd))
+	}
+	return ids, nil
+}
+
+// wake interrupts a blocked wait.
+func (p *pollerImpl) wake() {
+	var buf [8]byte
+	binary.NativeEndian.PutUint64(buf[:], 1)
+	_, _ = unix.Write(p.wakefd, buf[:])
+}
+
+func (p *pollerImpl) close() error {
+	err := unix.Close(p.epfd)
+	if werr := unix.Close(p.wakefd); err == nil {
+		err = werr
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package pan
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoller(t *testing.T) {
+	p, err := NewPoller()
+	require.NoError(t, err)
+	defer p.Close()
+
+	conns := make([]*listenConn, 2)
+	addrs := make([]UDPAddr, 2)
+	for i := range conns {
+		base, addr := testLoopbackConn(t, "127.0.0.1")
+		conns[i] = &listenConn{baseUDPConn: baseUDPConn{raw: base.raw}, local: addr}
+		addrs[i] = addr
+		require.NoError(t, p.Add(conns[i]))
+	}
+	require.NoError(t, p.Add(conns[0])) // no effect
+	remote, remoteAddr := testLoopbackConn(t, "127.0.0.2")
+	send := func(dst UDPAddr) {
+		path := testLoopbackPath(t, &remoteAddr, &dst)
+		_, err := remote.writeMsg(remoteAddr, dst, path, []byte("hello"))
+		require.NoError(t, err)
+	}
+	wait := func(timeout time.Duration) ([]PollConn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return p.Wait(ctx, nil)
+	}
+
+	_, err = wait(10 * time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	send(addrs[1])
+	ready, err := wait(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []PollConn{conns[1]}, ready)
+	// Reported until the packet is read.
+	ready, err = wait(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []PollConn{conns[1]}, ready)
+	buf := make([]byte, 100)
+	n, _, _, err := conns[1].readMsg(buf, false)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	_, err = wait(10 * time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, p.Remove(conns[0]))
+	send(addrs[0])
+	_, err = wait(10 * time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The replacement socket of a migrated connection is polled.
+	next, nextAddr := testLoopbackConn(t, "127.0.0.1")
+	require.NoError(t, conns[1].replaceRaw(next.raw, func() {}))
+	send(nextAddr)
+	ready, err = wait(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []PollConn{conns[1]}, ready)
+}
+
+func TestPollerClose(t *testing.T) {
+	p, err := NewPoller()
+	require.NoError(t, err)
+	base, addr := testLoopbackConn(t, "127.0.0.1")
+	require.NoError(t, p.Add(&listenConn{baseUDPConn: baseUDPConn{raw: base.raw}, local: addr}))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Wait(context.Background(), nil)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond) // let Wait block
+	require.NoError(t, p.Close())
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "Wait not interrupted by Close")
+	}
+	assert.ErrorIs(t, p.Close(), net.ErrClosed)
+}
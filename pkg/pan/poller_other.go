@@ -0,0 +1,45 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package pan
+
+import "syscall"
+
+// pollerImpl is not available, NewPoller fails on this platform.
+type pollerImpl struct{}
+
+func newPollerImpl() (pollerImpl, error) {
+	return pollerImpl{}, errPollerUnsupported
+}
+
+func (p *pollerImpl) add(rc syscall.RawConn, id uint32) error {
+	return errPollerUnsupported
+}
+
+func (p *pollerImpl) remove(rc syscall.RawConn, id uint32) error {
+	return errPollerUnsupported
+}
+
+func (p *pollerImpl) wait(ids []uint32) ([]uint32, error) {
+	return ids, errPollerUnsupported
+}
+
+func (p *pollerImpl) wake() {}
+
+func (p *pollerImpl) close() error {
+	return nil
+}
@@ -16,7 +16,9 @@ package pan
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,13 +31,108 @@ func init() {
 	pool.refresher = makeRefresher(&pool)
 	pool.entries = make(map[IA]pathPoolDst)
 	// note: start refresher, but won't do anything until paths are added to the pool
-	go pool.refresher.run()
+	goroutines.goroutine(goroutineRefresher, pool.refresher.run)
 }
 
 type pathPool struct {
 	refresher    refresher
 	entriesMutex sync.RWMutex
 	entries      map[IA]pathPoolDst
+	hidden       atomic.Bool
+	synthetic    atomic.Bool
+	options      atomic.Pointer[PoolOptions]
+
+	// synthesisMutex protects synthesisPending, the down notifications
+	// waiting to be processed by addSyntheticPaths, per destination. A
+	// destination is present while a goroutine is processing its
+	// notifications.
+	synthesisMutex   sync.Mutex
+	synthesisPending map[IA][]pathDownNotification
+}
+
+// PoolOptions are the tuning parameters for the path lookups and refreshes of
+// the global path pool. Zero values for the refresh intervals are replaced by
+// the defaults, see DefaultPoolOptions.
+type PoolOptions struct {
+	// RefreshInterval is the interval in which paths are refreshed, in case no
+	// paths are expiring, i.e. the interval in which new paths are discovered.
+	RefreshInterval time.Duration
+	// RefreshMinInterval is the minimum time between two refreshes. This is
+	// also the time, relative to its expiry, when a path that is no longer
+	// returned by a lookup is dropped.
+	RefreshMinInterval time.Duration
+	// RefreshLeadTime specifies when a refresh is triggered for a path,
+	// relative to its expiry.
+	RefreshLeadTime time.Duration
+	// QueryTimeout is the timeout for path lookups from the SCION daemon.
+	// 0 for no timeout, other than from the context passed in by the
+	// application. This is the default.
+	QueryTimeout time.Duration
+}
+
+// DefaultPoolOptions returns the default PoolOptions.
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		RefreshInterval:    pathRefreshInterval,
+		RefreshMinInterval: pathRefreshMinInterval,
+		RefreshLeadTime:    pathRefreshLeadTime,
+	}
+}
+
+// SetPoolOptions sets the tuning parameters of the global path pool. This
+// affects all connections, including existing ones.
+// Long-lived servers may want to refresh less often, short-lived tools may
+// want to use a short query timeout.
+func SetPoolOptions(opts PoolOptions) error {
+	defaults := DefaultPoolOptions()
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = defaults.RefreshInterval
+	}
+	if opts.RefreshMinInterval == 0 {
+		opts.RefreshMinInterval = defaults.RefreshMinInterval
+	}
+	if opts.RefreshLeadTime == 0 {
+		opts.RefreshLeadTime = defaults.RefreshLeadTime
+	}
+	if opts.RefreshInterval < 0 || opts.RefreshMinInterval < 0 || opts.RefreshLeadTime < 0 ||
+		opts.QueryTimeout < 0 {
+		return fmt.Errorf("invalid pool options, negative durations: %+v", opts)
+	}
+	if opts.RefreshMinInterval > opts.RefreshInterval {
+		return fmt.Errorf("invalid pool options, RefreshMinInterval (%s) exceeds RefreshInterval (%s)",
+			opts.RefreshMinInterval, opts.RefreshInterval)
+	}
+	pool.options.Store(&opts)
+	pool.refresher.reschedule()
+	return nil
+}
+
+// opts returns the current PoolOptions.
+func (p *pathPool) opts() PoolOptions {
+	if o := p.options.Load(); o != nil {
+		return *o
+	}
+	return DefaultPoolOptions()
+}
+
+// QueryHiddenPaths enables or disables including paths constructed from
+// hidden path segments in the path lookups. The hidden path groups are
+// configured in the SCION daemon. Disabled by default.
+// Hidden paths are marked in the PathMetadata, so that they can be required or
+// avoided with the RequireHiddenPaths and AvoidHiddenPaths policies.
+// Only affects subsequent path lookups.
+func QueryHiddenPaths(enabled bool) {
+	pool.hidden.Store(enabled)
+}
+
+// EnableSyntheticPaths enables or disables the creation of synthetic paths.
+// When a path is notified down, additional paths to the same destination are
+// created locally by recombining the segments of the paths already in the
+// pool, without waiting for the SCION daemon. This allows fast failover even
+// if the daemon is slow or unreachable. Disabled by default.
+// Synthetic paths are marked in the PathMetadata.
+func EnableSyntheticPaths(enabled bool) {
+	pool.synthetic.Store(enabled)
 }
 
 // pathPoolDst is path pool entry for one destination IA
@@ -51,9 +148,9 @@ type pathPoolSubscriber interface {
 }
 
 func (p *pathPool) subscribe(ctx context.Context, dstIA IA,
-	s pathPoolSubscriber) ([]*Path, error) {
+	s pathPoolSubscriber, lookup pathLookupOptions) ([]*Path, error) {
 
-	paths, err := p.refresher.subscribe(ctx, dstIA, s)
+	paths, err := p.refresher.subscribe(ctx, dstIA, s, lookup)
 	if err != nil {
 		return nil, err
 	}
@@ -66,33 +163,154 @@ func (p *pathPool) unsubscribe(dstIA IA, s pathPoolSubscriber) {
 	stats.unsubscribe(s)
 }
 
-// paths returns paths to dstIA. This _may_ query paths, unless they have recently been queried.
+// paths returns paths to dstIA. This _may_ query paths, unless they have
+// been queried within RefreshMinInterval. Expired paths are not returned; if
+// all cached paths expired, paths are queried.
 func (p *pathPool) paths(ctx context.Context, dstIA IA) ([]*Path, error) {
 	p.entriesMutex.RLock()
-	if entry, ok := p.entries[dstIA]; ok {
-		if time.Since(entry.lastQuery) > pathRefreshMinInterval {
-			defer p.entriesMutex.RUnlock()
-			return append([]*Path{}, entry.paths...), nil
+	if entry, ok := p.entries[dstIA]; ok && time.Since(entry.lastQuery) < p.opts().RefreshMinInterval {
+		now := time.Now()
+		var paths []*Path
+		for _, path := range entry.paths {
+			if path.Expiry.After(now) {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) > 0 {
+			p.entriesMutex.RUnlock()
+			return paths, nil
 		}
 	}
 	p.entriesMutex.RUnlock()
 	return p.queryPaths(ctx, dstIA)
 }
 
+// pathLookupOptions control the initial path lookup of a dialed connection,
+// see WithPathLookupTimeout and WithStalePathFallback.
+type pathLookupOptions struct {
+	timeout       time.Duration
+	staleFallback bool
+}
+
+// lookupPaths returns paths to dstIA, like paths, with the options for the
+// initial path lookup of a dialed connection.
+func (p *pathPool) lookupPaths(ctx context.Context, dstIA IA, o pathLookupOptions) ([]*Path, error) {
+	lookupCtx := ctx
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		lookupCtx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	paths, err := p.paths(lookupCtx, dstIA)
+	if err == nil || !o.staleFallback || ctx.Err() != nil {
+		return paths, err
+	}
+	stale := p.unexpiredCachedPaths(dstIA)
+	if len(stale) == 0 {
+		return nil, err
+	}
+	events.emit(Event{Type: EventStalePaths, Destination: dstIA, Err: err})
+	return stale, nil
+}
+
 // queryPaths returns paths to dstIA. Unconditionally requests paths from sciond.
 func (p *pathPool) queryPaths(ctx context.Context, dstIA IA) ([]*Path, error) {
-	paths, err := host().queryPaths(ctx, dstIA)
+	if timeout := p.opts().QueryTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	paths, err := host().queryPaths(ctx, dstIA, p.hidden.Load())
+	currentMetrics().recordPathQuery(dstIA, len(paths), err)
 	if err != nil {
 		return nil, err
 	}
 	p.entriesMutex.Lock()
 	defer p.entriesMutex.Unlock()
 	entry := p.entries[dstIA]
-	entry.update(paths)
+	dropped := entry.update(paths, p.opts().RefreshMinInterval)
 	p.entries[dstIA] = entry
+	currentMetrics().removePaths(dstIA, dropped)
 	return append([]*Path{}, paths...), nil
 }
 
+// addSyntheticPathsAsync runs addSyntheticPaths in the background. The down
+// notifications for a destination are processed by a single goroutine, so
+// that a notification affecting many connections to the same destination
+// does not start a goroutine per connection. Duplicate pending notifications
+// are dropped.
+func (p *pathPool) addSyntheticPathsAsync(dstIA IA, pf PathFingerprint, pi PathInterface) {
+	p.synthesisMutex.Lock()
+	defer p.synthesisMutex.Unlock()
+
+	n := pathDownNotification{Fingerprint: pf, Interface: pi}
+	if pending, running := p.synthesisPending[dstIA]; running {
+		for _, v := range pending {
+			if v == n {
+				return
+			}
+		}
+		p.synthesisPending[dstIA] = append(pending, n)
+		return
+	}
+	if p.synthesisPending == nil {
+		p.synthesisPending = make(map[IA][]pathDownNotification)
+	}
+	p.synthesisPending[dstIA] = []pathDownNotification{n}
+	goroutines.goroutine(goroutineSyntheticPaths, func() { p.processSynthesis(dstIA) })
+}
+
+// processSynthesis processes the pending down notifications for dstIA, until
+// there are no more.
+func (p *pathPool) processSynthesis(dstIA IA) {
+	for {
+		p.synthesisMutex.Lock()
+		pending := p.synthesisPending[dstIA]
+		if len(pending) == 0 {
+			delete(p.synthesisPending, dstIA)
+			p.synthesisMutex.Unlock()
+			return
+		}
+		p.synthesisPending[dstIA] = []pathDownNotification{}
+		p.synthesisMutex.Unlock()
+
+		for _, n := range pending {
+			p.addSyntheticPaths(dstIA, n.Fingerprint, n.Interface)
+		}
+	}
+}
+
+// addSyntheticPaths adds synthetic paths to dstIA to the pool, if any cached
+// path to dstIA is affected by the down notification. Subscribers are
+// informed if any new paths were added.
+func (p *pathPool) addSyntheticPaths(dstIA IA, pf PathFingerprint, pi PathInterface) {
+	p.entriesMutex.Lock()
+	entry, ok := p.entries[dstIA]
+	affected := false
+	for _, path := range entry.paths {
+		if path.Fingerprint == pf || isInterfaceOnPath(path, pi) {
+			affected = true
+			break
+		}
+	}
+	if !ok || !affected || len(entry.paths) == 0 {
+		p.entriesMutex.Unlock()
+		return
+	}
+	synthetic := synthesizePaths(entry.paths[0].Source, dstIA, entry.paths)
+	if len(synthetic) == 0 {
+		p.entriesMutex.Unlock()
+		return
+	}
+	entry.paths = append(entry.paths, synthetic...)
+	entry.earliestExpiry = earliestPathExpiry(entry.paths)
+	p.entries[dstIA] = entry
+	paths := append([]*Path{}, entry.paths...)
+	p.entriesMutex.Unlock()
+
+	p.refresher.notify(dstIA, paths)
+}
+
 // cachedPaths returns paths to dstIA. Always returns the cached paths, never queries paths.
 func (p *pathPool) cachedPaths(dst IA) []*Path {
 	p.entriesMutex.RLock()
@@ -100,6 +318,18 @@ func (p *pathPool) cachedPaths(dst IA) []*Path {
 	return append([]*Path{}, p.entries[dst].paths...)
 }
 
+// unexpiredCachedPaths returns the cached paths to dst that have not expired.
+func (p *pathPool) unexpiredCachedPaths(dst IA) []*Path {
+	now := time.Now()
+	var paths []*Path
+	for _, path := range p.cachedPaths(dst) {
+		if path.Expiry.After(now) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
 func (p *pathPool) entry(dstIA IA) (pathPoolDst, bool) {
 	p.entriesMutex.RLock()
 	defer p.entriesMutex.RUnlock()
@@ -107,9 +337,12 @@ func (p *pathPool) entry(dstIA IA) (pathPoolDst, bool) {
 	return e, ok
 }
 
-func (e *pathPoolDst) update(paths []*Path) {
+// update sets the paths. Old paths not included in the new paths are kept
+// until pruneLeadTime before their expiry. Returns the fingerprints of the old
+// paths that were dropped.
+func (e *pathPoolDst) update(paths []*Path, pruneLeadTime time.Duration) []PathFingerprint {
 	now := time.Now()
-	expiryDropTime := now.Add(-pathPruneLeadTime)
+	expiryDropTime := now.Add(-pruneLeadTime)
 
 	// the updated entry includes all new paths.
 	// Any non-expired old path not included in the new paths is appended to the
@@ -118,15 +351,22 @@ func (e *pathPoolDst) update(paths []*Path) {
 	for _, p := range paths {
 		newPathSet[p.Fingerprint] = struct{}{}
 	}
+	var dropped []PathFingerprint
 	for _, old := range e.paths {
-		if _, ok := newPathSet[old.Fingerprint]; !ok && old.Expiry.After(expiryDropTime) {
+		if _, ok := newPathSet[old.Fingerprint]; ok {
+			continue
+		}
+		if old.Expiry.After(expiryDropTime) {
 			paths = append(paths, old)
+		} else {
+			dropped = append(dropped, old.Fingerprint)
 		}
 	}
 
 	e.lastQuery = now
 	e.earliestExpiry = earliestPathExpiry(paths)
 	e.paths = paths
+	return dropped
 }
 
 func (p *pathPool) earliestPathExpiry() time.Time {
@@ -0,0 +1,196 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetPoolOptions(t *testing.T) {
+	defer pool.options.Store(nil)
+
+	assert.Equal(t, DefaultPoolOptions(), pool.opts())
+
+	require.NoError(t, SetPoolOptions(PoolOptions{
+		RefreshLeadTime: 30 * time.Second,
+		QueryTimeout:    time.Second,
+	}))
+	expected := DefaultPoolOptions()
+	expected.RefreshLeadTime = 30 * time.Second
+	expected.QueryTimeout = time.Second
+	assert.Equal(t, expected, pool.opts())
+
+	assert.Error(t, SetPoolOptions(PoolOptions{QueryTimeout: -1}))
+	assert.Error(t, SetPoolOptions(PoolOptions{RefreshInterval: time.Second}))
+	assert.Equal(t, expected, pool.opts(), "unchanged after invalid options")
+
+	// refresh timing follows the options
+	now := time.Now()
+	expiry := now.Add(time.Minute)
+	assert.True(t, pool.refresher.shouldRefresh(now, now.Add(20*time.Second), time.Time{}))
+	assert.False(t, pool.refresher.shouldRefresh(now, expiry, time.Time{}))
+}
+
+func TestAddSyntheticPathsAsync(t *testing.T) {
+	var p pathPool
+	dst := MustParseIA("1-ff00:0:112")
+	pi := PathInterface{IA: dst, IfID: 1}
+
+	// block the synthesis, so that the notifications queue up
+	p.entriesMutex.Lock()
+	for i := 0; i < 100; i++ {
+		p.addSyntheticPathsAsync(dst, "synthesis-a", pi)
+		p.addSyntheticPathsAsync(dst, "synthesis-b", pi)
+	}
+	p.synthesisMutex.Lock()
+	assert.LessOrEqual(t, len(p.synthesisPending[dst]), 2)
+	p.synthesisMutex.Unlock()
+	assert.Equal(t, 1, Goroutines()[goroutineSyntheticPaths])
+	p.entriesMutex.Unlock()
+
+	assert.Eventually(t, func() bool {
+		p.synthesisMutex.Lock()
+		defer p.synthesisMutex.Unlock()
+		return len(p.synthesisPending) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return Goroutines()[goroutineSyntheticPaths] == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestLookupPathsStaleFallback(t *testing.T) {
+	// A daemon that does not answer path lookups.
+	initOnce.Do(func() {})
+	previous := singletonHostContext
+	defer func() { singletonHostContext = previous }()
+	singletonHostContext.sciond = slowDaemon{}
+
+	dst := MustParseIA("1-ff00:0:115")
+	valid := &Path{Destination: dst, Fingerprint: "stale-p0", Expiry: time.Now().Add(time.Hour)}
+	expired := &Path{Destination: dst, Fingerprint: "stale-p1", Expiry: time.Now().Add(-time.Minute)}
+	pool.entriesMutex.Lock()
+	// not queried recently, so that the daemon is queried again
+	pool.entries[dst] = pathPoolDst{lastQuery: time.Now().Add(-time.Hour), paths: []*Path{valid, expired}}
+	pool.entriesMutex.Unlock()
+	defer func() {
+		pool.entriesMutex.Lock()
+		delete(pool.entries, dst)
+		pool.entriesMutex.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	evs, err := SubscribeEvents(ctx)
+	require.NoError(t, err)
+
+	timeout := pathLookupOptions{timeout: 20 * time.Millisecond}
+	_, err = pool.lookupPaths(context.Background(), dst, timeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	fallback := pathLookupOptions{timeout: 20 * time.Millisecond, staleFallback: true}
+	paths, err := pool.lookupPaths(context.Background(), dst, fallback)
+	require.NoError(t, err)
+	assert.Equal(t, []*Path{valid}, paths)
+	select {
+	case e := <-evs:
+		assert.Equal(t, EventStalePaths, e.Type)
+		assert.Equal(t, dst, e.Destination)
+		assert.ErrorIs(t, e.Err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no stale paths event")
+	}
+
+	// no fallback if the dial context is done
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer dialCancel()
+	_, err = pool.lookupPaths(dialCtx, dst, pathLookupOptions{staleFallback: true})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// no fallback without unexpired cached paths
+	_, err = pool.lookupPaths(context.Background(), MustParseIA("1-ff00:0:116"), fallback)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPoolPathsCached(t *testing.T) {
+	// A daemon that does not answer path lookups.
+	initOnce.Do(func() {})
+	previous := singletonHostContext
+	defer func() { singletonHostContext = previous }()
+	singletonHostContext.sciond = slowDaemon{}
+
+	dst := MustParseIA("1-ff00:0:117")
+	valid := &Path{Destination: dst, Fingerprint: "cached-p0", Expiry: time.Now().Add(time.Hour)}
+	expired := &Path{Destination: dst, Fingerprint: "cached-p1", Expiry: time.Now().Add(-time.Minute)}
+	setEntry := func(e pathPoolDst) {
+		pool.entriesMutex.Lock()
+		defer pool.entriesMutex.Unlock()
+		pool.entries[dst] = e
+	}
+	defer func() {
+		pool.entriesMutex.Lock()
+		delete(pool.entries, dst)
+		pool.entriesMutex.Unlock()
+	}()
+	query := func() ([]*Path, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		return pool.paths(ctx, dst)
+	}
+
+	// recently queried: the unexpired cached paths are returned
+	setEntry(pathPoolDst{lastQuery: time.Now(), paths: []*Path{valid, expired}})
+	paths, err := query()
+	require.NoError(t, err)
+	assert.Equal(t, []*Path{valid}, paths)
+
+	// all cached paths expired, or not queried recently: the daemon is queried
+	setEntry(pathPoolDst{lastQuery: time.Now(), paths: []*Path{expired}})
+	_, err = query()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	setEntry(pathPoolDst{lastQuery: time.Now().Add(-time.Hour), paths: []*Path{valid}})
+	_, err = query()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPathPoolDstUpdate(t *testing.T) {
+	now := time.Now()
+	kept := &Path{Fingerprint: "update-p0", Expiry: now.Add(time.Hour)}
+	expired := &Path{Fingerprint: "update-p1", Expiry: now.Add(-time.Hour)}
+	renewed := &Path{Fingerprint: "update-p2", Expiry: now.Add(time.Second)}
+	fresh := &Path{Fingerprint: "update-p2", Expiry: now.Add(time.Hour)}
+
+	e := pathPoolDst{paths: []*Path{kept, expired, renewed}}
+	dropped := e.update([]*Path{fresh}, time.Minute)
+	assert.Equal(t, []*Path{fresh, kept}, e.paths)
+	assert.Equal(t, []PathFingerprint{"update-p1"}, dropped)
+}
+
+// slowDaemon blocks path lookups until the context is done.
+type slowDaemon struct {
+	daemon.Connector
+}
+
+func (slowDaemon) Paths(ctx context.Context, dst, src addr.IA, f daemon.PathReqFlags) ([]snet.Path, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
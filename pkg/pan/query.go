@@ -0,0 +1,84 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+)
+
+// QueryOption is an option for QueryPaths.
+type QueryOption interface {
+	applyQuery(*queryOptions)
+}
+
+type queryOption func(*queryOptions)
+
+func (o queryOption) applyQuery(q *queryOptions) {
+	o(q)
+}
+
+type queryOptions struct {
+	policy  Policy
+	refresh bool
+}
+
+// WithRefresh makes QueryPaths always request the paths from the SCION
+// daemon, instead of returning recently cached paths.
+func WithRefresh() QueryOption {
+	return queryOption(func(o *queryOptions) {
+		o.refresh = true
+	})
+}
+
+// QueryPaths returns the paths to dst, with the full metadata (latency,
+// bandwidth, geo, MTU, ...) and expiry, without opening a connection.
+// The paths are looked up through the same path pool as used by the
+// connections. The returned paths are copies and can be modified by the
+// caller.
+// Returns an empty result if dst is the local AS, and an error wrapping
+// ErrNoPath if there are no paths to dst (before applying the policy).
+func QueryPaths(ctx context.Context, dst IA, opts ...QueryOption) ([]*Path, error) {
+	var o queryOptions
+	for _, opt := range opts {
+		opt.applyQuery(&o)
+	}
+	if dst == host().ia {
+		return []*Path{}, nil
+	}
+	return queryPaths(ctx, dst, o)
+}
+
+func queryPaths(ctx context.Context, dst IA, o queryOptions) ([]*Path, error) {
+	var paths []*Path
+	var err error
+	if o.refresh {
+		paths, err = pool.queryPaths(ctx, dst)
+	} else {
+		paths, err = pool.paths(ctx, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errNoPathTo(dst)
+	}
+	copies := make([]*Path, len(paths))
+	for i, p := range paths {
+		c := *p
+		c.Metadata = p.Metadata.Copy()
+		copies[i] = &c
+	}
+	return filtered(o.policy, copies), nil
+}
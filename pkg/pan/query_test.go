@@ -0,0 +1,61 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryPaths(t *testing.T) {
+	dst := MustParseIA("1-ff00:0:114")
+	expiry := time.Now().Add(time.Hour)
+	newPath := func(pf PathFingerprint, mtu uint16) *Path {
+		return &Path{
+			Destination: dst,
+			Fingerprint: pf,
+			Expiry:      expiry,
+			Metadata:    &PathMetadata{MTU: mtu},
+		}
+	}
+	cached := []*Path{newPath("query-p0", 1280), newPath("query-p1", 1400)}
+	// cached entry, recent enough to be returned without querying the daemon
+	pool.entriesMutex.Lock()
+	pool.entries[dst] = pathPoolDst{lastQuery: time.Now(), paths: cached}
+	pool.entriesMutex.Unlock()
+	defer func() {
+		pool.entriesMutex.Lock()
+		delete(pool.entries, dst)
+		pool.entriesMutex.Unlock()
+	}()
+
+	var o queryOptions
+	WithPolicy(HighestMTU{}).applyQuery(&o)
+	paths, err := queryPaths(context.Background(), dst, o)
+	require.NoError(t, err)
+	require.Len(t, paths, 2)
+	assert.Equal(t, PathFingerprint("query-p1"), paths[0].Fingerprint)
+	assert.Equal(t, uint16(1400), paths[0].Metadata.MTU)
+	assert.Equal(t, expiry, paths[0].Expiry)
+
+	// copies: modifications do not affect the pool
+	paths[0].Metadata.MTU = 0
+	assert.Equal(t, uint16(1400), cached[1].Metadata.MTU)
+	assert.Equal(t, []PathFingerprint{"query-p0", "query-p1"}, pathFingerprints(pool.cachedPaths(dst)))
+}
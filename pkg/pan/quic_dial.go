@@ -18,7 +18,6 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
-	"net/netip"
 
 	"github.com/quic-go/quic-go"
 )
@@ -49,17 +48,19 @@ func (s *QUICEarlySession) CloseWithError(code quic.ApplicationErrorCode, desc s
 }
 
 // DialQUIC establishes a new QUIC connection to a server at the remote address.
+// The options are those of DialUDP.
 //
 // The host parameter is used for SNI.
 // The tls.Config must define an application protocol (using NextProtos).
-func DialQUIC(ctx context.Context,
-	local netip.AddrPort, remote UDPAddr, policy Policy, selector Selector,
-	host string, tlsConf *tls.Config, quicConf *quic.Config) (*QUICSession, error) {
+func DialQUIC(ctx context.Context, remote UDPAddr,
+	host string, tlsConf *tls.Config, quicConf *quic.Config, opts ...DialOption) (*QUICSession, error) {
 
-	conn, err := DialUDP(ctx, local, remote, policy, selector)
+	o := applyDialOptions(opts)
+	conn, err := dialFragmented(ctx, remote, o)
 	if err != nil {
 		return nil, err
 	}
+	quicConf = o.adjustQUICConfig(conn, quicConf)
 	pconn := connectedPacketConn{conn}
 	// HACK: we silence the log here to shut up quic-go's warning about trying to
 	// set receive buffer size (it's not a UDPConn, we know).
@@ -85,14 +86,15 @@ func DialQUIC(ctx context.Context,
 }
 
 // DialQUICEarly establishes a new 0-RTT QUIC connection to a server. Analogous to DialQUIC.
-func DialQUICEarly(ctx context.Context,
-	local netip.AddrPort, remote UDPAddr, policy Policy, selector Selector,
-	host string, tlsConf *tls.Config, quicConf *quic.Config) (*QUICEarlySession, error) {
+func DialQUICEarly(ctx context.Context, remote UDPAddr,
+	host string, tlsConf *tls.Config, quicConf *quic.Config, opts ...DialOption) (*QUICEarlySession, error) {
 
-	conn, err := DialUDP(ctx, local, remote, policy, selector)
+	o := applyDialOptions(opts)
+	conn, err := dialFragmented(ctx, remote, o)
 	if err != nil {
 		return nil, err
 	}
+	quicConf = o.adjustQUICConfig(conn, quicConf)
 	pconn := connectedPacketConn{conn}
 	// HACK: we silence the log here to shut up quic-go's warning about trying to
 	// set receive buffer size (it's not a UDPConn, we know).
@@ -105,6 +107,18 @@ func DialQUICEarly(ctx context.Context,
 	return &QUICEarlySession{session, conn}, nil
 }
 
+// adjustQUICConfig returns the QUIC config for the dialed connection conn,
+// adjusted as set with WithQUICConfig.
+func (o dialOptions) adjustQUICConfig(conn Conn, conf *quic.Config) *quic.Config {
+	if o.quicConfig == nil {
+		return conf
+	}
+	if conf != nil {
+		conf = conf.Clone()
+	}
+	return o.quicConfig(conn, conf)
+}
+
 // connectedPacketConn wraps a Conn into a PacketConn interface.
 // net makes a weird mess of stream/datagram sockets and connected/unconnected
 // sockets. meh.
@@ -17,20 +17,20 @@ package pan
 import (
 	"context"
 	"crypto/tls"
-	"net/netip"
 
 	"github.com/quic-go/quic-go"
 )
 
-// ListenQUIC listens for QUIC connections on a SCION/UDP port.
+// ListenQUIC listens for QUIC connections on a SCION/UDP port. The options are
+// those of ListenUDP.
 //
 // See note on wildcard addresses in the package documentation.
 //
 // BUG This "leaks" the UDP connection, which is never closed.
-func ListenQUIC(ctx context.Context, local netip.AddrPort, selector ReplySelector,
-	tlsConf *tls.Config, quicConfig *quic.Config) (*quic.Listener, error) {
+func ListenQUIC(ctx context.Context, tlsConf *tls.Config, quicConfig *quic.Config,
+	opts ...ListenOption) (*quic.Listener, error) {
 
-	conn, err := ListenUDP(ctx, local, selector)
+	conn, err := ListenUDP(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +42,7 @@ type rainsResolver struct{}
 var _ resolver = &rainsResolver{}
 
 func (r *rainsResolver) Resolve(ctx context.Context, name string) (scionAddr, error) {
-	server, err := readRainsConfig()
+	server, err := rainsServer()
 	if err != nil {
 		return scionAddr{}, err
 	}
@@ -53,16 +53,36 @@ func (r *rainsResolver) Resolve(ctx context.Context, name string) (scionAddr, er
 	return rainsQuery(ctx, server, name)
 }
 
-func readRainsConfig() (UDPAddr, error) {
-	bs, err := os.ReadFile(rainsConfigPath)
+// rainsServer returns the address of the RAINS server, from RainsServerEnv
+// if set, or from the config file otherwise. Returns the zero address if no
+// server is configured.
+func rainsServer() (UDPAddr, error) {
+	if env, ok := os.LookupEnv(RainsServerEnv); ok {
+		return parseRainsServer(env, RainsServerEnv)
+	}
+	return readRainsConfig(rainsConfigPath)
+}
+
+func readRainsConfig(path string) (UDPAddr, error) {
+	bs, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
 		return UDPAddr{}, nil
 	} else if err != nil {
-		return UDPAddr{}, fmt.Errorf("error loading %s: %w", rainsConfigPath, err)
+		return UDPAddr{}, fmt.Errorf("error loading %s: %w", path, err)
+	}
+	return parseRainsServer(string(bs), path)
+}
+
+// parseRainsServer parses the server address s read from source. An empty
+// address or "off" disables RAINS.
+func parseRainsServer(s, source string) (UDPAddr, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return UDPAddr{}, nil
 	}
-	address, err := ParseUDPAddr(strings.TrimSpace(string(bs)))
+	address, err := ParseUDPAddr(s)
 	if err != nil {
-		return UDPAddr{}, fmt.Errorf("error parsing %s, expected SCION UDP address: %w", rainsConfigPath, err)
+		return UDPAddr{}, fmt.Errorf("error parsing %s, expected SCION UDP address: %w", source, err)
 	}
 	return address, nil
 }
@@ -0,0 +1,61 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !norains
+// +build !norains
+
+package pan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRainsServer(t *testing.T) {
+	t.Setenv(RainsServerEnv, "17-ffaa:0:1,[192.0.2.1]:55553")
+	server, err := rainsServer()
+	require.NoError(t, err)
+	assert.Equal(t, MustParseUDPAddr("17-ffaa:0:1,[192.0.2.1]:55553"), server)
+
+	for _, off := range []string{"", "off", " off\n"} {
+		t.Setenv(RainsServerEnv, off)
+		server, err = rainsServer()
+		require.NoError(t, err)
+		assert.Equal(t, UDPAddr{}, server)
+	}
+	// Disabled RAINS does not resolve any name.
+	_, err = (&rainsResolver{}).Resolve(context.Background(), "host")
+	assertErrHostNotFound(t, err)
+
+	t.Setenv(RainsServerEnv, "192.0.2.1:55553")
+	_, err = rainsServer()
+	assert.ErrorContains(t, err, RainsServerEnv)
+}
+
+func TestReadRainsConfig(t *testing.T) {
+	server, err := readRainsConfig(filepath.Join(t.TempDir(), "missing.cfg"))
+	require.NoError(t, err)
+	assert.Equal(t, UDPAddr{}, server)
+
+	path := filepath.Join(t.TempDir(), "rains.cfg")
+	require.NoError(t, os.WriteFile(path, []byte("17-ffaa:0:1,[192.0.2.1]:55553\n"), 0600))
+	server, err = readRainsConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, MustParseUDPAddr("17-ffaa:0:1,[192.0.2.1]:55553"), server)
+}
@@ -15,18 +15,18 @@
 package pan
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/scionproto/scion/pkg/addr"
 	"github.com/scionproto/scion/pkg/private/common"
 	"github.com/scionproto/scion/pkg/slayers"
 	"github.com/scionproto/scion/pkg/snet"
-	snetpath "github.com/scionproto/scion/pkg/snet/path"
-	"github.com/scionproto/scion/private/topology/underlay"
 )
 
 // baseUDPConn contains the common message read/write logic for different the
@@ -34,54 +34,166 @@ import (
 // Currently this wraps snet.PacketConn/snet.SCIONPacketConn, but this logic
 // could easily be moved here too.
 type baseUDPConn struct {
-	raw         snet.PacketConn
+	// underlayMutex guards raw and the state derived from it (udp, batch
+	// conn and underlayTrafficClass), which are replaced when a dialed
+	// connection migrates to a new local address, see EnableAddressMigration.
+	// It is held for reading by all reads and writes on the raw connection.
+	underlayMutex sync.RWMutex
+	raw           snet.PacketConn
+	// interrupted is set while reads are interrupted to replace the raw
+	// connection; reads failing in the meantime are retried.
+	interrupted atomic.Bool
+	closed      atomic.Bool
+	// deadlineMutex guards the deadlines, which are applied again when the
+	// raw connection is replaced.
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	metrics     *connMetrics
+	udpOnce     sync.Once
+	udp         *net.UDPConn
 	readMutex   sync.Mutex
 	readBuffer  []byte
+	parser      packetParser
 	writeMutex  sync.Mutex
 	writeBuffer []byte
+	serializer  packetSerializer
+	// trafficClass is the traffic class set with SetTrafficClass,
+	// underlayTrafficClass the one currently set on the underlay socket.
+	trafficClass         TrafficClass
+	underlayTrafficClass TrafficClass
+	batchOnce            sync.Once
+	batchState           batchState
+	// scmp handles the SCMP messages received on this connection.
+	scmp scmpHandler
+	// lastWrite is the time of the last write, in unix nanoseconds.
+	lastWrite atomic.Int64
+	// auth authenticates the packets, nil unless enabled with
+	// WithPacketAuthentication.
+	auth *packetAuthenticator
+	// pollers maps the pollers to which the connection was added to its id
+	// there, see Poller.
+	pollMutex sync.Mutex
+	pollers   map[*Poller]uint32
+}
+
+// udpConn returns the underlying UDP socket, or nil if the raw connection is
+// not a snet.SCIONPacketConn. Packets are read and written directly on the
+// UDP socket, bypassing snet, to avoid per-packet allocations.
+// Must be called with the underlayMutex held for reading.
+func (c *baseUDPConn) udpConn() *net.UDPConn {
+	c.udpOnce.Do(func() {
+		if raw, ok := c.raw.(*snet.SCIONPacketConn); ok {
+			c.udp = raw.Conn
+		}
+	})
+	return c.udp
 }
 
 func (c *baseUDPConn) SetDeadline(t time.Time) error {
-	return c.raw.SetDeadline(t)
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
 }
 
 func (c *baseUDPConn) SetReadDeadline(t time.Time) error {
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	c.deadlineMutex.Lock()
+	defer c.deadlineMutex.Unlock()
+	c.readDeadline = t
+	if c.interrupted.Load() {
+		return nil // applied to the new raw connection
+	}
 	return c.raw.SetReadDeadline(t)
 }
 
 func (c *baseUDPConn) SetWriteDeadline(t time.Time) error {
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	c.deadlineMutex.Lock()
+	defer c.deadlineMutex.Unlock()
+	c.writeDeadline = t
 	return c.raw.SetWriteDeadline(t)
 }
 
 func (c *baseUDPConn) writeMsg(src, dst UDPAddr, path *Path, b []byte) (int, error) {
-	// assert:
-	if src.IA != dst.IA && path == nil {
-		panic("writeMsg: need path when src.IA != dst.IA")
-	}
-	if path != nil && src.IA != path.Source {
-		panic("writeMsg: src.IA != path.Source")
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeMsgLocked(src, dst, path, c.trafficClass, b)
+}
+
+// writeMsgTrafficClass is writeMsg with the traffic class tc instead of the
+// one set for the connection.
+func (c *baseUDPConn) writeMsgTrafficClass(src, dst UDPAddr, path *Path, tc TrafficClass, b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeMsgLocked(src, dst, path, tc, b)
+}
+
+// writeMsgLocked writes a message with traffic class tc. The traffic class is
+// only applied if the raw connection is a UDP socket.
+// Must be called with the writeMutex held.
+func (c *baseUDPConn) writeMsgLocked(src, dst UDPAddr, path *Path, tc TrafficClass, b []byte) (int, error) {
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	if c.closed.Load() {
+		return 0, net.ErrClosed
 	}
-	if path != nil && dst.IA != path.Destination {
-		panic("writeMsg: dst.IA != path.Destination")
+	if conn := c.udpConn(); conn != nil {
+		if err := c.setUnderlayTrafficClass(tc); err != nil {
+			return 0, err
+		}
+		pkt, nextHop, err := c.serializer.serialize(src, dst, path, tc, b, c.auth)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := conn.WriteToUDPAddrPort(pkt, nextHop); err != nil {
+			return 0, c.closedErr(err)
+		}
+		c.mirrorToTap(true, nextHop, pkt)
+		c.recordWrite(len(b))
+		return len(b), nil
 	}
 
-	var dataplanePath snet.DataplanePath = snetpath.Empty{}
-	var nextHop netip.AddrPort
-	if src.IA == dst.IA {
-		nextHop = netip.AddrPortFrom(dst.IP, underlay.EndhostPort)
-	} else {
-		nextHop = path.ForwardingPath.underlay
-		dataplanePath = path.ForwardingPath.dataplanePath
+	if c.auth != nil {
+		return 0, errPacketAuthUnsupported
 	}
-
-	c.writeMutex.Lock()
-	defer c.writeMutex.Unlock()
 	if c.writeBuffer == nil {
 		c.writeBuffer = make([]byte, common.SupportedMTU)
 	}
+	pkt, nextHop, err := newPacket(c.writeBuffer, src, dst, path, b)
+	if err != nil {
+		return 0, err
+	}
+	err = c.raw.WriteTo(pkt, net.UDPAddrFromAddrPort(nextHop))
+	if err != nil {
+		return 0, c.closedErr(err)
+	}
+	c.mirrorToTap(true, nextHop, pkt.Bytes)
+	c.recordWrite(len(b))
+	return len(b), nil
+}
+
+// recordWrite records a written message with payload size n.
+func (c *baseUDPConn) recordWrite(n int) {
+	c.lastWrite.Store(time.Now().UnixNano())
+	c.metrics.recordSent(n)
+}
+
+// newPacket creates a snet.Packet with payload b, using buf as the buffer
+// for serialization, and returns it together with the next hop on the
+// underlay. Only used if the raw connection is not a snet.SCIONPacketConn.
+func newPacket(buf []byte, src, dst UDPAddr, path *Path, b []byte) (*snet.Packet, netip.AddrPort, error) {
+	dataplanePath, nextHop := route(src, dst, path)
+	if max, ok := maxPayloadSize(src, dst, path); ok && len(b) > max {
+		return nil, netip.AddrPort{}, ErrMsgTooLarge{MaxSize: max}
+	}
 
 	pkt := &snet.Packet{
-		Bytes: c.writeBuffer,
+		Bytes: buf,
 		PacketInfo: snet.PacketInfo{
 			Source: snet.SCIONAddress{
 				IA:   addr.IA(src.IA),
@@ -99,64 +211,293 @@ func (c *baseUDPConn) writeMsg(src, dst UDPAddr, path *Path, b []byte) (int, err
 			},
 		},
 	}
+	return pkt, nextHop, nil
+}
 
-	err := c.raw.WriteTo(pkt, net.UDPAddrFromAddrPort(nextHop))
-	if err != nil {
-		return 0, err
+// udpHdrLen is the length of the SCION/UDP header.
+const udpHdrLen = 8
+
+// ErrMsgTooLarge is returned when writing a message that exceeds the maximum
+// payload size for the path, i.e. the path MTU minus the SCION and UDP
+// headers. MaxSize is the maximum payload size allowed on the path.
+type ErrMsgTooLarge struct {
+	MaxSize int
+}
+
+func (e ErrMsgTooLarge) Error() string {
+	return fmt.Sprintf("message too large for path, max payload size is %d bytes", e.MaxSize)
+}
+
+// maxPayloadSize returns the maximum UDP payload size for packets from src to
+// dst via path. Returns false if the path MTU is not known, e.g. for paths
+// without metadata or within the local AS.
+func maxPayloadSize(src, dst UDPAddr, path *Path) (int, bool) {
+	if path == nil {
+		return 0, false
 	}
-	return len(b), nil
+	return maxPayloadSizeMTU(src, dst, path.ForwardingPath.dataplanePath, path.MTU())
+}
+
+// maxPayloadSizeMTU returns the maximum UDP payload size for packets from src
+// to dst with the dataplane path, for the given MTU. Returns false if the MTU
+// is 0 (unknown).
+func maxPayloadSizeMTU(src, dst UDPAddr, dataplanePath snet.DataplanePath, mtu uint16) (int, bool) {
+	if mtu == 0 {
+		return 0, false
+	}
+	var scn slayers.SCION
+	if err := scn.SetSrcAddr(addr.HostIP(src.IP)); err != nil {
+		return 0, false
+	}
+	if err := scn.SetDstAddr(addr.HostIP(dst.IP)); err != nil {
+		return 0, false
+	}
+	if err := dataplanePath.SetPath(&scn); err != nil {
+		return 0, false
+	}
+	return maxPayload(mtu, &scn), true
 }
 
 // readMsg is a helper for reading a single packet.
 // Internally invokes the configured SCMP handler.
 // Ignores non-UDP packets.
-func (c *baseUDPConn) readMsg(b []byte) (int, UDPAddr, ForwardingPath, error) {
+// The forwarding path is only extracted if withPath is set.
+func (c *baseUDPConn) readMsg(b []byte, withPath bool) (int, UDPAddr, ForwardingPath, error) {
 	c.readMutex.Lock()
 	defer c.readMutex.Unlock()
 	if c.readBuffer == nil {
 		c.readBuffer = make([]byte, common.SupportedMTU)
 	}
+	for {
+		n, remote, fw, err := c.readMsgUnderlay(b, withPath)
+		if errors.Is(err, errReadInterrupted) {
+			continue // the raw connection was replaced, read from the new one
+		}
+		return n, remote, fw, err
+	}
+}
+
+// errReadInterrupted is returned by reads on the raw connection that were
+// interrupted to replace it.
+var errReadInterrupted = errors.New("read interrupted")
+
+// readErr returns errReadInterrupted if the read on the raw connection that
+// failed with err was interrupted to replace the raw connection, and
+// closedErr(err) otherwise. Must be called with the underlayMutex held for
+// reading.
+func (c *baseUDPConn) readErr(err error) error {
+	if c.interrupted.Load() && !c.closed.Load() {
+		return errReadInterrupted
+	}
+	return c.closedErr(err)
+}
+
+// closedErr returns net.ErrClosed if the connection is closed, and err
+// otherwise. Reads and writes failing due to a concurrent Close thus
+// consistently return net.ErrClosed, not the error of the raw connection.
+func (c *baseUDPConn) closedErr(err error) error {
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+	return err
+}
 
+// readMsgUnderlay reads a single packet from the current raw connection.
+// Must be called with the readMutex held.
+func (c *baseUDPConn) readMsgUnderlay(b []byte, withPath bool) (int, UDPAddr, ForwardingPath, error) {
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	if c.closed.Load() {
+		return 0, UDPAddr{}, ForwardingPath{}, net.ErrClosed
+	}
+
+	conn := c.udpConn()
 	for {
-		pkt := snet.Packet{
-			Bytes: c.readBuffer,
+		var pkt udpPacket
+		if conn != nil {
+			n, from, err := conn.ReadFromUDPAddrPort(c.readBuffer)
+			if err != nil {
+				return 0, UDPAddr{}, ForwardingPath{}, c.readErr(err)
+			}
+			var ok bool
+			pkt, ok, err = c.decodePacket(c.readBuffer[:n], from, withPath)
+			if err != nil {
+				return 0, UDPAddr{}, ForwardingPath{}, err
+			}
+			if !ok {
+				continue
+			}
+		} else {
+			snetPkt := snet.Packet{
+				Bytes: c.readBuffer,
+			}
+			var lastHop net.UDPAddr
+			err := c.raw.ReadFrom(&snetPkt, &lastHop)
+			if err != nil {
+				return 0, UDPAddr{}, ForwardingPath{}, c.readErr(err)
+			}
+			c.mirrorToTap(false, lastHop.AddrPort(), snetPkt.Bytes)
+			if c.auth != nil {
+				return 0, UDPAddr{}, ForwardingPath{}, errPacketAuthUnsupported
+			}
+			var ok bool
+			pkt.payload, pkt.remote, pkt.fw, ok = udpFromPacket(&snetPkt, lastHop.AddrPort())
+			if !ok {
+				continue
+			}
 		}
-		var lastHop net.UDPAddr
-		err := c.raw.ReadFrom(&pkt, &lastHop)
-		if err != nil {
-			return 0, UDPAddr{}, ForwardingPath{}, err
+		n := copy(b, pkt.payload)
+		c.metrics.recordReceived(n)
+		return n, pkt.remote, pkt.fw, nil
+	}
+}
+
+// udpPacket is a received SCION/UDP packet.
+type udpPacket struct {
+	payload []byte
+	remote  UDPAddr
+	fw      ForwardingPath
+}
+
+// decodePacket decodes a packet received on the underlay from the address
+// from. SCMP packets are passed to the SCMP handler and its error is returned.
+// Returns false for packets that are to be ignored.
+// The payload references data. The forwarding path is only extracted if
+// withPath is set. Must be called with the readMutex held, and the
+// underlayMutex held for reading.
+func (c *baseUDPConn) decodePacket(data []byte, from netip.AddrPort, withPath bool) (udpPacket, bool, error) {
+	c.mirrorToTap(false, from, data)
+	l4, err := c.parser.parse(data)
+	if err != nil {
+		return udpPacket{}, false, err
+	}
+	switch l4 {
+	case slayers.LayerTypeSCIONUDP:
+	case slayers.LayerTypeSCMP:
+		// SCMP messages are rare, decode them fully for the SCMP handler.
+		pkt := snet.Packet{Bytes: data}
+		if err := pkt.Decode(); err != nil {
+			return udpPacket{}, false, err
 		}
-		udp, ok := pkt.Payload.(snet.UDPPayload)
-		if !ok {
-			continue // ignore non-UDP packet
+		return udpPacket{}, false, c.scmp.Handle(&pkt)
+	default:
+		return udpPacket{}, false, nil // ignore non-UDP packet
+	}
+	src, err := c.parser.scion.SrcAddr()
+	if err != nil || src.Type() != addr.HostTypeIP {
+		return udpPacket{}, false, nil //nolint:nilerr // ignore non-IP source
+	}
+	pkt := udpPacket{
+		payload: c.parser.udp.Payload,
+		remote: UDPAddr{
+			IA:   IA(c.parser.scion.SrcIA),
+			IP:   src.IP(),
+			Port: c.parser.udp.SrcPort,
+		},
+	}
+	if c.auth != nil {
+		if err := c.auth.verify(&c.parser, pkt.remote); err != nil {
+			return udpPacket{}, false, PacketAuthError{Remote: pkt.remote, Err: err}
 		}
-		if pkt.Source.Host.Type() != addr.HostTypeIP {
-			continue // ignore non-IP destination
+	}
+	if withPath {
+		rp, err := c.parser.rawPath()
+		if err != nil {
+			return udpPacket{}, false, err
 		}
-		remote := UDPAddr{
-			IA:   IA(pkt.Source.IA),
-			IP:   pkt.Source.Host.IP(),
-			Port: udp.SrcPort,
+		lastHop, err := c.lastHop(from, &c.parser.scion, c.parser.udp.SrcPort)
+		if err != nil {
+			return udpPacket{}, false, err
 		}
-		underlay := lastHop.AddrPort()
-		fw := ForwardingPath{
-			dataplanePath: pkt.Path,
-			underlay:      underlay,
+		pkt.fw = ForwardingPath{
+			dataplanePath: rp,
+			underlay:      lastHop,
 		}
-		n := copy(b, udp.Payload)
-		return n, remote, fw, nil
 	}
+	return pkt, true, nil
+}
+
+// udpFromPacket extracts the UDP payload, the remote address and the
+// forwarding path from a received packet. Returns false for non-UDP packets
+// and packets from non-IP sources, which are to be ignored.
+func udpFromPacket(pkt *snet.Packet, lastHop netip.AddrPort) ([]byte, UDPAddr, ForwardingPath, bool) {
+	udp, ok := pkt.Payload.(snet.UDPPayload)
+	if !ok {
+		return nil, UDPAddr{}, ForwardingPath{}, false // ignore non-UDP packet
+	}
+	if pkt.Source.Host.Type() != addr.HostTypeIP {
+		return nil, UDPAddr{}, ForwardingPath{}, false // ignore non-IP destination
+	}
+	remote := UDPAddr{
+		IA:   IA(pkt.Source.IA),
+		IP:   pkt.Source.Host.IP(),
+		Port: udp.SrcPort,
+	}
+	fw := ForwardingPath{
+		dataplanePath: pkt.Path,
+		underlay:      lastHop,
+	}
+	return udp.Payload, remote, fw, true
 }
 
+// Close closes the connection. Reads and writes, including those blocked or
+// in progress, return net.ErrClosed. Closing a closed connection returns
+// net.ErrClosed.
 func (c *baseUDPConn) Close() error {
+	if !c.markClosed() {
+		return net.ErrClosed
+	}
+	c.metrics.close()
+	return c.closeRaw()
+}
+
+// markClosed marks the connection as closed, so that no further reads and
+// writes are started. Returns false if it was already closed. This is the
+// first step of closing a connection; only the caller for which it returns
+// true completes it.
+func (c *baseUDPConn) markClosed() bool {
+	return c.closed.CompareAndSwap(false, true)
+}
+
+// closeRaw closes the current raw connection, interrupting the reads and
+// writes in progress.
+func (c *baseUDPConn) closeRaw() error {
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
 	return c.raw.Close()
 }
 
-type scmpHandler struct{}
+type scmpHandler struct {
+	// echoReply, if set, is called for SCMP echo replies, which are then not
+	// reported as errors.
+	echoReply func(snet.SCMPEchoReply)
+	// next are the handlers installed with WithSCMPHandler, called after the
+	// built-in handling.
+	next []snet.SCMPHandler
+}
 
 func (h scmpHandler) Handle(pkt *snet.Packet) error {
+	err := h.handle(pkt)
+	for _, next := range h.next {
+		if nextErr := next.Handle(pkt); err == nil {
+			err = nextErr
+		}
+	}
+	return err
+}
+
+// handle is the built-in handling of SCMP messages.
+func (h scmpHandler) handle(pkt *snet.Packet) error {
 	scmp := pkt.Payload.(snet.SCMPPayload)
 	switch scmp.Type() {
+	case slayers.SCMPTypePacketTooBig:
+		// Record the path MTU, so that later writes exceeding it fail with
+		// ErrMsgTooLarge, and also report the error to the reader.
+		msg := pkt.Payload.(snet.SCMPPacketTooBig)
+		if pf, err := reversePathFingerprint(pkt.Path.(snet.RawPath)); err == nil {
+			stats.RecordPathMTU(pf, msg.MTU)
+		}
+		return newSCMPError(pkt, scmp)
 	case slayers.SCMPTypeExternalInterfaceDown:
 		msg := pkt.Payload.(snet.SCMPExternalInterfaceDown)
 		pi := PathInterface{
@@ -182,16 +523,31 @@ func (h scmpHandler) Handle(pkt *snet.Packet) error {
 		}
 		stats.NotifyPathDown(pf, pi)
 		return nil
-	default:
-		ip := netip.Addr{}
-		if pkt.Source.Host.Type() == addr.HostTypeIP {
-			ip = pkt.Source.Host.IP()
-		}
-		return SCMPError{
-			typeCode: slayers.CreateSCMPTypeCode(scmp.Type(), scmp.Code()),
-			ErrorIA:  IA(pkt.Source.IA),
-			ErrorIP:  ip,
+	case slayers.SCMPTypeEchoReply:
+		if h.echoReply == nil {
+			return newSCMPError(pkt, scmp)
 		}
+		h.echoReply(pkt.Payload.(snet.SCMPEchoReply))
+		return nil
+	default:
+		return newSCMPError(pkt, scmp)
+	}
+}
+
+func newSCMPError(pkt *snet.Packet, scmp snet.SCMPPayload) SCMPError {
+	ip := netip.Addr{}
+	if pkt.Source.Host.Type() == addr.HostTypeIP {
+		ip = pkt.Source.Host.IP()
+	}
+	var pf PathFingerprint
+	if rp, ok := pkt.Path.(snet.RawPath); ok {
+		pf, _ = reversePathFingerprint(rp)
+	}
+	return SCMPError{
+		typeCode: slayers.CreateSCMPTypeCode(scmp.Type(), scmp.Code()),
+		ErrorIA:  IA(pkt.Source.IA),
+		ErrorIP:  ip,
+		path:     pf,
 	}
 }
 
@@ -201,6 +557,9 @@ type SCMPError struct {
 	ErrorIA IA
 	// ErrorIP is the source IP of the SCMP error message
 	ErrorIP netip.Addr
+	// path is the fingerprint of the path of the packet that caused the
+	// error, if known.
+	path PathFingerprint
 	// TODO: include quote information (pkt destinition, path, ...)
 }
 
@@ -0,0 +1,230 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/snet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxPayloadSize(t *testing.T) {
+	src := MustParseUDPAddr("1-ff00:0:111,127.0.0.1:1234")
+	dst := MustParseUDPAddr("1-ff00:0:112,127.0.0.2:1234")
+	core := MustParseIA("1-ff00:0:110")
+	p := testPathFromSegments(t, src.IA, dst.IA, []testSegment{
+		{consDir: false, interfaces: []PathInterface{{src.IA, 1}, {core, 2}}},
+		{consDir: true, interfaces: []PathInterface{{core, 3}, {dst.IA, 4}}},
+	})
+
+	// common header 12, address header 16+4+4, path 4+2*8+4*12, UDP 8
+	max, ok := maxPayloadSize(src, dst, p)
+	assert.True(t, ok)
+	assert.Equal(t, 1400-112, max)
+
+	_, ok = maxPayloadSize(src, dst, nil)
+	assert.False(t, ok)
+
+	c := &baseUDPConn{}
+	_, err := c.writeMsg(src, dst, p, make([]byte, max+1))
+	var errTooLarge ErrMsgTooLarge
+	assert.True(t, errors.As(err, &errTooLarge))
+	assert.Equal(t, max, errTooLarge.MaxSize)
+}
+
+func BenchmarkWriteMsg(b *testing.B) {
+	sender, src := testLoopbackConn(b, "127.0.0.1")
+	_, dst := testLoopbackConn(b, "127.0.0.2")
+	path := testLoopbackPath(b, &src, &dst)
+	payload := make([]byte, 100)
+
+	run := func(b *testing.B, c *baseUDPConn) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(payload)))
+		for i := 0; i < b.N; i++ {
+			if _, err := c.writeMsg(src, dst, path, payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("snet", func(b *testing.B) {
+		run(b, &baseUDPConn{raw: opaquePacketConn{sender.raw}})
+	})
+	b.Run("direct", func(b *testing.B) {
+		run(b, sender)
+	})
+}
+
+func BenchmarkReadMsg(b *testing.B) {
+	sender, src := testLoopbackConn(b, "127.0.0.1")
+	receiver, dst := testLoopbackConn(b, "127.0.0.2")
+	path := testLoopbackPath(b, &src, &dst)
+	pkt, nextHop, err := sender.serializer.serialize(src, dst, path, 0, make([]byte, 100), nil)
+	require.NoError(b, err)
+	udpConn := sender.udpConn()
+	buf := make([]byte, 1500)
+
+	// Note: write and read in lockstep, so that no packets are dropped.
+	run := func(b *testing.B, c *baseUDPConn, withPath bool) {
+		b.ReportAllocs()
+		b.SetBytes(100)
+		for i := 0; i < b.N; i++ {
+			if _, err := udpConn.WriteToUDPAddrPort(pkt, nextHop); err != nil {
+				b.Fatal(err)
+			}
+			if _, _, _, err := c.readMsg(buf, withPath); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("snet", func(b *testing.B) {
+		run(b, &baseUDPConn{raw: opaquePacketConn{receiver.raw}}, true)
+	})
+	b.Run("direct", func(b *testing.B) {
+		run(b, receiver, false)
+	})
+	b.Run("direct-with-path", func(b *testing.B) {
+		run(b, receiver, true)
+	})
+}
+
+// opaquePacketConn hides the type of the wrapped snet.PacketConn, so that
+// baseUDPConn falls back to reading and writing via snet.
+type opaquePacketConn struct {
+	snet.PacketConn
+}
+
+// testLoopbackConn opens a baseUDPConn on the loopback address ip.
+// Note: use different loopback addresses for sender and receiver, so that the
+// receiver does not mistake the sender for the shim dispatcher.
+func testLoopbackConn(tb testing.TB, ip string) (*baseUDPConn, UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip)})
+	require.NoError(tb, err)
+	tb.Cleanup(func() { _ = conn.Close() })
+	require.NoError(tb, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	a := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	return &baseUDPConn{raw: &snet.SCIONPacketConn{Conn: conn}}, UDPAddr{IP: a.Addr(), Port: a.Port()}
+}
+
+// testLoopbackPath sets the IAs of src and dst and returns a path between
+// them, with the underlay next hop set to dst.
+func testLoopbackPath(tb testing.TB, src, dst *UDPAddr) *Path {
+	src.IA = MustParseIA("1-ff00:0:111")
+	dst.IA = MustParseIA("1-ff00:0:112")
+	core := MustParseIA("1-ff00:0:110")
+	path := testPathFromSegments(tb, src.IA, dst.IA, []testSegment{
+		{consDir: false, interfaces: []PathInterface{{src.IA, 1}, {core, 2}}},
+		{consDir: true, interfaces: []PathInterface{{core, 3}, {dst.IA, 4}}},
+	})
+	path.ForwardingPath.underlay = netip.AddrPortFrom(dst.IP, dst.Port)
+	return path
+}
+
+func TestPathMTU(t *testing.T) {
+	src := MustParseUDPAddr("1-ff00:0:111,127.0.0.1:1234")
+	dst := MustParseUDPAddr("1-ff00:0:112,127.0.0.2:1234")
+	core := MustParseIA("1-ff00:0:110")
+	p := testPathFromSegments(t, src.IA, dst.IA, []testSegment{
+		{consDir: false, interfaces: []PathInterface{{src.IA, 11}, {core, 12}}},
+		{consDir: true, interfaces: []PathInterface{{core, 13}, {dst.IA, 14}}},
+	})
+	assert.Equal(t, uint16(1400), p.MTU())
+
+	// only lower MTUs are taken into account
+	stats.RecordPathMTU(p.Fingerprint, 1472)
+	assert.Equal(t, uint16(1400), p.MTU())
+	stats.RecordPathMTU(p.Fingerprint, 1280)
+	assert.Equal(t, uint16(1280), p.MTU())
+	stats.RecordPathMTU(p.Fingerprint, 1300)
+	assert.Equal(t, uint16(1280), p.MTU())
+	// MTUs below the SCION minimum are ignored
+	stats.RecordPathMTU(p.Fingerprint, 60)
+	assert.Equal(t, uint16(1280), p.MTU())
+
+	max, ok := maxPayloadSize(src, dst, p)
+	assert.True(t, ok)
+	assert.Equal(t, 1280-112, max)
+
+	// expired
+	stats.mutex.Lock()
+	ps := stats.paths[p.Fingerprint]
+	ps.DiscoveredMTUTime = time.Now().Add(-pathMTUTimeout - time.Second)
+	stats.paths[p.Fingerprint] = ps
+	stats.mutex.Unlock()
+	assert.Equal(t, uint16(1400), p.MTU())
+}
+
+func TestEndhostUnderlayPort(t *testing.T) {
+	defer localEndhostPorts.Store(localEndhostPorts.Load())
+	src := MustParseUDPAddr("1-ff00:0:111,127.0.0.1:31000")
+	dst := MustParseUDPAddr("1-ff00:0:111,127.0.0.2:31001")
+
+	localEndhostPorts.Store(nil)
+	_, nextHop := route(src, dst, nil)
+	assert.Equal(t, netip.MustParseAddrPort("127.0.0.2:30041"), nextHop)
+
+	localEndhostPorts.Store(&portRange{start: 31000, end: 32767})
+	_, nextHop = route(src, dst, nil)
+	assert.Equal(t, netip.MustParseAddrPort("127.0.0.2:31001"), nextHop)
+	_, nextHop = route(src, dst.WithPort(8080), nil)
+	assert.Equal(t, netip.MustParseAddrPort("127.0.0.2:30041"), nextHop)
+}
+
+// scmpHandlerFunc adapts a function to snet.SCMPHandler.
+type scmpHandlerFunc func(pkt *snet.Packet) error
+
+func (f scmpHandlerFunc) Handle(pkt *snet.Packet) error {
+	return f(pkt)
+}
+
+func TestSCMPHandlerChain(t *testing.T) {
+	var calls []string
+	record := func(name string, err error) snet.SCMPHandler {
+		return scmpHandlerFunc(func(pkt *snet.Packet) error {
+			calls = append(calls, name)
+			return err
+		})
+	}
+	errFirst := errors.New("first")
+	o := applyDialOptions([]DialOption{
+		WithSCMPHandler(record("a", nil)),
+		WithSCMPHandler(record("b", errFirst)),
+		WithSCMPHandler(record("c", errors.New("second"))),
+	})
+
+	var replies int
+	h := scmpHandler{echoReply: func(snet.SCMPEchoReply) { replies++ }, next: o.scmpHandlers}
+	echoReply := &snet.Packet{PacketInfo: snet.PacketInfo{Payload: snet.SCMPEchoReply{}}}
+	assert.Equal(t, errFirst, h.Handle(echoReply))
+	assert.Equal(t, []string{"a", "b", "c"}, calls)
+	assert.Equal(t, 1, replies)
+
+	// The error of the built-in handling takes precedence.
+	calls = nil
+	unreachable := &snet.Packet{PacketInfo: snet.PacketInfo{
+		Source:  snet.SCIONAddress{Host: addr.HostIP(netip.MustParseAddr("127.0.0.1"))},
+		Payload: snet.SCMPDestinationUnreachable{},
+	}}
+	var scmpErr SCMPError
+	assert.ErrorAs(t, h.Handle(unreachable), &scmpErr)
+	assert.Equal(t, []string{"a", "b", "c"}, calls)
+}
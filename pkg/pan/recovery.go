@@ -0,0 +1,240 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan/internal/ping"
+)
+
+var recoveryProbeInterval atomic.Int64
+
+// EnableRecoveryProbing enables active probing of paths that were notified
+// down. Each dialed connection periodically sends SCMP echo requests to its
+// remote host over its paths that are down, in the given interval. Once a reply
+// is received, the down notifications for the path are cleared and the
+// selector is informed, so that e.g. the DefaultSelector can fail back to the
+// preferred path.
+// An interval of 0 disables probing, which is the default.
+// Only affects connections dialed after this call.
+func EnableRecoveryProbing(interval time.Duration) {
+	recoveryProbeInterval.Store(int64(interval))
+}
+
+// recoveryProber probes the down paths of one dialed connection.
+// A nil *recoveryProber is valid and does nothing.
+type recoveryProber struct {
+	interval time.Duration
+	local    scionAddr
+	remote   scionAddr
+
+	mutex  sync.Mutex
+	paths  []*Path
+	down   map[PathFingerprint]*Path
+	ctx    context.Context
+	cancel context.CancelFunc
+	// pinger and stop are set while probing, i.e. while any paths are down.
+	pinger *ping.Pinger
+	stop   context.CancelFunc
+}
+
+// newRecoveryProber returns a prober for a connection from local to remote,
+// or nil if recovery probing is disabled.
+func newRecoveryProber(local, remote UDPAddr) *recoveryProber {
+	interval := time.Duration(recoveryProbeInterval.Load())
+	if interval <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &recoveryProber{
+		interval: interval,
+		local:    local.scionAddr(),
+		remote:   remote.scionAddr(),
+		down:     make(map[PathFingerprint]*Path),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// setPaths sets the paths of the connection. Down paths no longer in the set
+// are no longer probed.
+func (p *recoveryProber) setPaths(paths []*Path) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.paths = paths
+	current := make(map[PathFingerprint]struct{}, len(paths))
+	for _, path := range paths {
+		current[path.Fingerprint] = struct{}{}
+	}
+	for pf := range p.down {
+		if _, ok := current[pf]; !ok {
+			delete(p.down, pf)
+		}
+	}
+	if len(p.down) == 0 {
+		p.stopRunning()
+	}
+}
+
+// setLocal sets the new local address of the connection. The probes are sent
+// from the new address from now on.
+func (p *recoveryProber) setLocal(local UDPAddr) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.local = local.scionAddr()
+	if p.pinger != nil {
+		p.stopRunning()
+		p.ensureRunning()
+	}
+}
+
+// pathDown starts probing the paths of the connection affected by the down
+// notification.
+func (p *recoveryProber) pathDown(pf PathFingerprint, pi PathInterface) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, path := range p.paths {
+		if path.Fingerprint == pf || isInterfaceOnPath(path, pi) {
+			p.down[path.Fingerprint] = path
+		}
+	}
+	if len(p.down) > 0 {
+		p.ensureRunning()
+	}
+}
+
+// ensureRunning starts the pinger and the probing goroutines. Must be called
+// with the mutex held.
+func (p *recoveryProber) ensureRunning() {
+	if p.pinger != nil || p.ctx.Err() != nil {
+		return
+	}
+	ctx, stop := context.WithCancel(p.ctx)
+	pinger, err := ping.NewPinger(ctx, host().sciond, p.local.snetUDPAddr())
+	if err != nil {
+		stop()
+		return
+	}
+	p.pinger, p.stop = pinger, stop
+	goroutines.goroutine(goroutineRecoveryProber, func() { pinger.Drain(ctx) })
+	goroutines.goroutine(goroutineRecoveryProber, func() { p.run(ctx, pinger) })
+}
+
+// stopRunning stops the probing goroutines and closes the pinger, once no
+// paths are down, so that idle connections do not keep goroutines and sockets.
+// Must be called with the mutex held.
+func (p *recoveryProber) stopRunning() {
+	if p.pinger == nil {
+		return
+	}
+	p.stop()
+	_ = p.pinger.Close()
+	p.pinger, p.stop = nil, nil
+}
+
+func (p *recoveryProber) run(ctx context.Context, pinger *ping.Pinger) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var sequenceNo uint16
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sequenceNo++
+			p.sendProbes(ctx, sequenceNo)
+		case r := <-pinger.Replies:
+			if path := p.handleReply(r); path != nil {
+				stats.NotifyPathRecovered(path)
+			}
+		}
+	}
+}
+
+func (p *recoveryProber) sendProbes(ctx context.Context, sequenceNo uint16) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.pinger == nil {
+		return
+	}
+	for _, path := range p.down {
+		remote := p.remote.snetUDPAddr()
+		remote.Path = path.ForwardingPath.dataplanePath
+		remote.NextHop = net.UDPAddrFromAddrPort(path.ForwardingPath.underlay)
+		// Errors are ignored, the path is simply probed again in the next interval.
+		_ = p.pinger.Send(ctx, remote, sequenceNo, 16)
+	}
+}
+
+// handleReply returns the path that is confirmed to work by the reply, or nil.
+func (p *recoveryProber) handleReply(reply ping.Reply) *Path {
+	if reply.Error != nil || reply.Source.Host.Type() != addr.HostTypeIP {
+		return nil
+	}
+	src := scionAddr{
+		IA: IA(reply.Source.IA),
+		IP: reply.Source.Host.IP(),
+	}
+	if src != p.remote {
+		return nil
+	}
+	pf, err := reversePathFingerprint(reply.Path)
+	if err != nil {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	path, ok := p.down[pf]
+	if !ok {
+		return nil
+	}
+	delete(p.down, pf)
+	if len(p.down) == 0 {
+		p.stopRunning()
+	}
+	return path
+}
+
+func (p *recoveryProber) close() {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cancel()
+	p.stopRunning()
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyPathRecovered(t *testing.T) {
+	stats := newPathStatsDB()
+
+	pi := PathInterface{IA: MustParseIA("1-ff00:0:110"), IfID: 7}
+	p := &Path{
+		Destination: MustParseIA("1-ff00:0:111"),
+		Fingerprint: "recovered",
+		Metadata:    &PathMetadata{Interfaces: []PathInterface{pi}},
+	}
+	subscriber := &dummyPathRecoveredSubscriber{recovered: make(chan PathFingerprint, 1)}
+	stats.subscribe(subscriber)
+	defer stats.unsubscribe(subscriber)
+
+	stats.recordPathDown(p.Fingerprint, pi)
+	assert.False(t, stats.newestDownNotification(p).IsZero())
+
+	stats.NotifyPathRecovered(p)
+	assert.True(t, stats.newestDownNotification(p).IsZero())
+	assert.False(t, stats.paths[p.Fingerprint].IsRecovered.IsZero())
+
+	select {
+	case pf := <-subscriber.recovered:
+		assert.Equal(t, p.Fingerprint, pf)
+	case <-time.After(time.Second):
+		assert.FailNow(t, "subscriber not notified before timeout")
+	}
+}
+
+type dummyPathRecoveredSubscriber struct {
+	recovered chan PathFingerprint
+}
+
+func (s *dummyPathRecoveredSubscriber) PathDown(PathFingerprint, PathInterface) {}
+
+func (s *dummyPathRecoveredSubscriber) PathRecovered(pf PathFingerprint) {
+	s.recovered <- pf
+}
+
+func TestDefaultSelectorFailback(t *testing.T) {
+	dst := MustParseIA("1-ff00:0:112")
+	newPath := func(pf PathFingerprint, ifID IfID) *Path {
+		return &Path{
+			Destination: dst,
+			Fingerprint: pf,
+			Metadata: &PathMetadata{
+				Interfaces: []PathInterface{{IA: dst, IfID: ifID}},
+			},
+		}
+	}
+	p0 := newPath("failback-p0", 1)
+	p1 := newPath("failback-p1", 2)
+
+	s := NewDefaultSelector()
+	s.Initialize(UDPAddr{}, UDPAddr{}, []*Path{p0, p1})
+
+	// Note: only modify the global stats directly, without notifications, to
+	// avoid racing with other tests replacing the global stats.
+	stats.recordPathDown(p0.Fingerprint, p0.Metadata.Interfaces[0])
+	s.PathDown(p0.Fingerprint, p0.Metadata.Interfaces[0])
+	assert.Equal(t, p1, s.Path())
+
+	// unrelated or not yet recovered: no change
+	s.PathRecovered("unrelated")
+	s.PathRecovered(p0.Fingerprint)
+	assert.Equal(t, p1, s.Path())
+
+	stats.recordPathRecovered(p0)
+	s.PathRecovered(p0.Fingerprint)
+	assert.Equal(t, p0, s.Path())
+}
@@ -41,9 +41,11 @@ func makeRefresher(pool *pathPool) refresher {
 }
 
 // subscribe for paths to dst.
-func (r *refresher) subscribe(ctx context.Context, dst IA, s refreshee) ([]*Path, error) {
+func (r *refresher) subscribe(ctx context.Context, dst IA, s refreshee,
+	lookup pathLookupOptions) ([]*Path, error) {
+
 	// BUG: oops, this will not inform subscribers of updated paths. Need to explicily check here
-	paths, err := r.pool.paths(ctx, dst)
+	paths, err := r.pool.lookupPaths(ctx, dst, lookup)
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +82,12 @@ func (r *refresher) unsubscribe(ia IA, s refreshee) {
 	}
 }
 
+// reschedule recomputes the time of the next refresh, e.g. after the
+// PoolOptions have changed.
+func (r *refresher) reschedule() {
+	r.newSubscription <- false
+}
+
 func (r *refresher) run() {
 	refreshTimer := time.NewTimer(0)
 	<-refreshTimer.C
@@ -119,6 +127,7 @@ func (r *refresher) refresh() {
 		if r.shouldRefresh(now, poolEntry.earliestExpiry, poolEntry.lastQuery) {
 			paths, err := r.pool.queryPaths(context.Background(), dstIA)
 			if err != nil {
+				events.emit(Event{Type: EventRefreshFailed, Destination: dstIA, Err: err})
 				// ignore errors here. The idea is that there is probably a lot of time
 				// until this manifests as an actual problem to the application (i.e.
 				// when the paths actually expire).
@@ -126,18 +135,24 @@ func (r *refresher) refresh() {
 				// to sciond or something like that.
 				continue
 			}
-			r.subscribersMutex.Lock()
-			for _, subscriber := range r.subscribers[dstIA] {
-				subscriber.refresh(dstIA, paths)
-			}
-			r.subscribersMutex.Unlock()
+			r.notify(dstIA, paths)
 		}
 	}
 }
 
+// notify informs the subscribers for dstIA of the updated paths.
+func (r *refresher) notify(dstIA IA, paths []*Path) {
+	r.subscribersMutex.Lock()
+	defer r.subscribersMutex.Unlock()
+	for _, subscriber := range r.subscribers[dstIA] {
+		subscriber.refresh(dstIA, paths)
+	}
+}
+
 func (r *refresher) shouldRefresh(now, expiry, lastQuery time.Time) bool {
-	earliestAllowedRefresh := lastQuery.Add(pathRefreshMinInterval)
-	timeForRefresh := expiry.Add(-pathRefreshLeadTime)
+	opts := r.pool.opts()
+	earliestAllowedRefresh := lastQuery.Add(opts.RefreshMinInterval)
+	timeForRefresh := expiry.Add(-opts.RefreshLeadTime)
 	return now.After(earliestAllowedRefresh) && now.After(timeForRefresh)
 }
 
@@ -149,11 +164,12 @@ func (r *refresher) nextRefresh(prevRefresh time.Time) time.Time {
 	if len(r.subscribers) == 0 {
 		return maxTime
 	}
-	nextRefresh := prevRefresh.Add(pathRefreshInterval)
+	opts := r.pool.opts()
+	nextRefresh := prevRefresh.Add(opts.RefreshInterval)
 
 	expiry := r.pool.earliestPathExpiry()
 	randOffset := time.Duration(rand.Intn(10)) * time.Second // avoid everbody refreshing simultaneously
-	expiryRefresh := expiry.Add(-pathRefreshLeadTime + randOffset)
+	expiryRefresh := expiry.Add(-opts.RefreshLeadTime + randOffset)
 
 	if expiryRefresh.Before(nextRefresh) {
 		nextRefresh = expiryRefresh
@@ -162,7 +178,7 @@ func (r *refresher) nextRefresh(prevRefresh time.Time) time.Time {
 	// if there are still paths that expire very soon (or have already expired),
 	// we still wait a little bit until the next refresh. Otherwise, failing
 	// refresh of an expired path would make us refresh continuously.
-	earliestAllowed := prevRefresh.Add(pathRefreshMinInterval)
+	earliestAllowed := prevRefresh.Add(opts.RefreshMinInterval)
 	if nextRefresh.Before(earliestAllowed) {
 		return earliestAllowed
 	}
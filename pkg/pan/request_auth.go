@@ -0,0 +1,180 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/drkey"
+	"github.com/scionproto/scion/pkg/scrypto"
+
+	pandrkey "github.com/netsec-ethz/scion-apps/pkg/pan/drkey"
+)
+
+// RequestAuthOverhead is the number of bytes RequestAuthenticator.Seal adds
+// to a payload: an 8 byte timestamp, an 8 byte nonce and a 16 byte AES-CMAC.
+const RequestAuthOverhead = 32
+
+// requestAuthHeaderLen is the length of the timestamp and nonce preceding the
+// payload.
+const requestAuthHeaderLen = 16
+
+// RequestAuthenticator authenticates individual datagrams with DRKey
+// host-host keys, for stateless services where each request is answered
+// without any handshake. The sender seals the payload, adding a timestamp, a
+// random nonce and an AES-CMAC over the addresses of both ends, the timestamp,
+// the nonce and the payload. The receiver opens it, rejecting messages with an
+// invalid MAC, with a timestamp differing more than 10 seconds from the local
+// time, or already opened before, i.e. replayed within this window.
+// Unlike WithPacketAuthentication, the authenticator is part of the payload
+// and only covers the addresses, not the path, so it can be used on any
+// connection, including a ListenConn serving unauthenticated clients too.
+// Both ends must use the same DRKey protocol, and the daemons of both hosts
+// must serve DRKeys; keys are cached as for WithPacketAuthentication.
+// Safe for concurrent use.
+type RequestAuthenticator struct {
+	protocol drkey.Protocol
+	// keys is the DRKey manager; the shared one, see drkeys, if nil.
+	keys *pandrkey.Manager
+	now  func() time.Time
+
+	mutex sync.Mutex
+	// seen holds the expiry of the messages opened within the last maximum
+	// skew, to detect replays.
+	seen      map[requestAuthID]time.Time
+	lastPrune time.Time
+}
+
+// requestAuthID identifies an opened message.
+type requestAuthID struct {
+	remote UDPAddr
+	nonce  uint64
+}
+
+// NewRequestAuthenticator returns an authenticator using DRKey host-host keys
+// of the given protocol, which must not be 0.
+func NewRequestAuthenticator(protocol uint16) *RequestAuthenticator {
+	return &RequestAuthenticator{
+		protocol: drkey.Protocol(protocol),
+		now:      time.Now,
+		seen:     make(map[requestAuthID]time.Time),
+	}
+}
+
+// Seal appends the payload, authenticated for a message from local to remote,
+// to b and returns the extended buffer. local must be the address the remote
+// end receives the message from, e.g. the LocalAddr of the connection; the
+// local IP must not be unspecified.
+func (a *RequestAuthenticator) Seal(b []byte, local, remote UDPAddr, payload []byte) ([]byte, error) {
+	now := a.now()
+	key, err := hostHostKey(a.keys, a.protocol, local, remote, now)
+	if err != nil {
+		return nil, err
+	}
+	start := len(b)
+	b = binary.BigEndian.AppendUint64(b, uint64(now.UnixNano()))
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	b = append(b, nonce[:]...)
+	b = append(b, payload...)
+	mac, err := requestAuthMAC(key, local, remote, b[start:])
+	if err != nil {
+		return nil, err
+	}
+	return mac.Sum(b), nil
+}
+
+// Open verifies the message received by local from remote and returns its
+// payload, a subslice of msg.
+// Open does not wait for the key of the remote: if it is not cached, it is
+// fetched in the background and drkey.ErrKeyPending is returned, so that
+// messages with spoofed sources do not block the caller. The message is to
+// be dropped, the remote retrying it as if it was lost.
+func (a *RequestAuthenticator) Open(local, remote UDPAddr, msg []byte) ([]byte, error) {
+	if len(msg) < RequestAuthOverhead {
+		return nil, errors.New("message too short")
+	}
+	now := a.now()
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(msg)))
+	if skew := now.Sub(sent); skew > packetAuthMaxSkew || skew < -packetAuthMaxSkew {
+		return nil, errors.New("timestamp out of range")
+	}
+	// The key valid when the message was sent; it may have changed since.
+	key, err := cachedHostHostKey(a.keys, a.protocol, remote, local, sent)
+	if err != nil {
+		return nil, err
+	}
+	signed := msg[:len(msg)-packetAuthMACLen]
+	mac, err := requestAuthMAC(key, remote, local, signed)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(mac.Sum(nil), msg[len(signed):]) != 1 {
+		return nil, errors.New("invalid authenticator")
+	}
+	id := requestAuthID{remote: remote, nonce: binary.BigEndian.Uint64(msg[8:])}
+	if !a.markSeen(id, sent.Add(packetAuthMaxSkew), now) {
+		return nil, errors.New("replayed message")
+	}
+	return signed[requestAuthHeaderLen:], nil
+}
+
+// markSeen records the message id, which can not be replayed after expiry,
+// and returns false if it was recorded before.
+func (a *RequestAuthenticator) markSeen(id requestAuthID, expiry, now time.Time) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if now.Sub(a.lastPrune) > packetAuthMaxSkew {
+		for k, e := range a.seen {
+			if now.After(e) {
+				delete(a.seen, k)
+			}
+		}
+		a.lastPrune = now
+	}
+	if _, ok := a.seen[id]; ok {
+		return false
+	}
+	a.seen[id] = expiry
+	return true
+}
+
+// requestAuthMAC returns the AES-CMAC over the addresses of the message from
+// src to dst and the signed part of the message.
+func requestAuthMAC(key drkey.HostHostKey, src, dst UDPAddr, signed []byte) (hash.Hash, error) {
+	mac, err := scrypto.InitMac(key.Key[:])
+	if err != nil {
+		return nil, err
+	}
+	var addrs [2 * (8 + 16 + 2)]byte
+	b := addrs[:0]
+	for _, a := range []UDPAddr{src, dst} {
+		b = binary.BigEndian.AppendUint64(b, uint64(a.IA))
+		ip := a.IP.As16()
+		b = append(b, ip[:]...)
+		b = binary.BigEndian.AppendUint16(b, a.Port)
+	}
+	mac.Write(b)
+	mac.Write(signed)
+	return mac, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pandrkey "github.com/netsec-ethz/scion-apps/pkg/pan/drkey"
+)
+
+// testRequestAuthenticator returns an authenticator whose keys are derived
+// from the secret, see testKeyFetcher.
+func testRequestAuthenticator(secret string) *RequestAuthenticator {
+	a := NewRequestAuthenticator(42)
+	a.keys = pandrkey.NewManager(testKeyFetcher(secret))
+	return a
+}
+
+func TestRequestAuthenticator(t *testing.T) {
+	client := UDPAddr{IA: MustParseIA("1-ff00:0:111"), IP: netip.MustParseAddr("10.0.0.1"), Port: 31000}
+	server := UDPAddr{IA: MustParseIA("1-ff00:0:112"), IP: netip.MustParseAddr("10.0.0.2"), Port: 40002}
+	sender := testRequestAuthenticator("secret")
+	receiver := testRequestAuthenticator("secret")
+
+	prefix := []byte("prefix")
+	msg, err := sender.Seal(prefix, client, server, []byte("request"))
+	require.NoError(t, err)
+	assert.Equal(t, len(prefix)+len("request")+RequestAuthOverhead, len(msg))
+	msg = msg[len(prefix):]
+	// The key of the client is fetched in the background.
+	_, err = receiver.Open(server, client, msg)
+	assert.ErrorIs(t, err, pandrkey.ErrKeyPending)
+	require.Eventually(t, func() bool {
+		_, err := cachedHostHostKey(receiver.keys, receiver.protocol, client, server, time.Now())
+		return err == nil
+	}, time.Second, time.Millisecond)
+	payload, err := receiver.Open(server, client, msg)
+	require.NoError(t, err)
+	assert.Equal(t, "request", string(payload))
+
+	// Replays are rejected.
+	_, err = receiver.Open(server, client, msg)
+	assert.EqualError(t, err, "replayed message")
+
+	// Messages from another address, modified, or with another key are
+	// rejected.
+	msg, err = sender.Seal(nil, client, server, []byte("request"))
+	require.NoError(t, err)
+	other := client
+	other.Port++
+	_, err = receiver.Open(server, other, msg)
+	assert.EqualError(t, err, "invalid authenticator")
+	msg[RequestAuthOverhead/2] ^= 1
+	_, err = receiver.Open(server, client, msg)
+	assert.EqualError(t, err, "invalid authenticator")
+	msg, err = testRequestAuthenticator("other secret").Seal(nil, client, server, []byte("forged"))
+	require.NoError(t, err)
+	_, err = receiver.Open(server, client, msg)
+	assert.EqualError(t, err, "invalid authenticator")
+	_, err = receiver.Open(server, client, msg[:RequestAuthOverhead-1])
+	assert.Error(t, err)
+
+	// Messages outside of the time window are rejected; the nonces of
+	// expired messages are forgotten.
+	now := time.Now()
+	receiver.now = func() time.Time { return now.Add(time.Minute) }
+	msg, err = sender.Seal(nil, client, server, []byte("late"))
+	require.NoError(t, err)
+	_, err = receiver.Open(server, client, msg)
+	assert.EqualError(t, err, "timestamp out of range")
+	sender.now = receiver.now
+	msg, err = sender.Seal(nil, client, server, []byte("request"))
+	require.NoError(t, err)
+	_, err = receiver.Open(server, client, msg)
+	require.NoError(t, err)
+	assert.Len(t, receiver.seen, 1)
+}
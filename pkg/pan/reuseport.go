@@ -0,0 +1,82 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// listenUDPReusePort opens n UDP sockets bound to the same local address with
+// SO_REUSEPORT. If the local port is 0, the sockets are bound to the highest
+// free port in the range start-end, as snet does for single sockets.
+func listenUDPReusePort(local netip.AddrPort, n int, start, end uint16) ([]*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	listen := func(port uint16) (*net.UDPConn, error) {
+		addr := netip.AddrPortFrom(local.Addr(), port)
+		c, err := lc.ListenPacket(context.Background(), "udp", addr.String())
+		if err != nil {
+			return nil, err
+		}
+		return c.(*net.UDPConn), nil
+	}
+
+	var first *net.UDPConn
+	var err error
+	if local.Port() != 0 {
+		first, err = listen(local.Port())
+	} else {
+		// Like snet, skip the well-known ports and prefer the higher ports.
+		// Note that a port already bound with SO_REUSEPORT by another socket of
+		// the same user is not considered to be in use.
+		if start < 1024 {
+			start = 1024
+		}
+		err = fmt.Errorf("binding to port range %d-%d: %w", start, end, syscall.EADDRINUSE)
+		for port := int(end); port >= int(start); port-- {
+			var perr error
+			first, perr = listen(uint16(port))
+			if perr == nil {
+				err = nil
+				break
+			}
+			if !errors.Is(perr, syscall.EADDRINUSE) {
+				err = perr
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conns := []*net.UDPConn{first}
+	port := first.LocalAddr().(*net.UDPAddr).AddrPort().Port()
+	for len(conns) < n {
+		c, err := listen(port)
+		if err != nil {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, c)
+	}
+	return conns, nil
+}
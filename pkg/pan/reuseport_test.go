@@ -0,0 +1,101 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUDPReusePort(t *testing.T) {
+	const n = 4
+	// Not the address of the senders, which would be taken for the shim
+	// dispatcher.
+	local := netip.MustParseAddrPort("127.0.0.2:0")
+	udpConns, err := listenUDPReusePort(local, n, 31000, 32767)
+	require.NoError(t, err)
+	require.Len(t, udpConns, n)
+	port := udpConns[0].LocalAddr().(*net.UDPAddr).AddrPort().Port()
+	assert.GreaterOrEqual(t, port, uint16(31000))
+	assert.LessOrEqual(t, port, uint16(32767))
+	for _, c := range udpConns {
+		assert.Equal(t, port, c.LocalAddr().(*net.UDPAddr).AddrPort().Port())
+		require.NoError(t, c.SetDeadline(time.Now().Add(5*time.Second)))
+	}
+
+	dst := UDPAddr{IP: local.Addr(), Port: port}
+	selector := &countingReplySelector{DefaultReplySelector: NewDefaultReplySelector()}
+	const senders = 16
+	for i := 0; i < senders; i++ {
+		c, src := testLoopbackConn(t, "127.0.0.1")
+		path := testLoopbackPath(t, &src, &dst)
+		_, err := c.writeMsg(src, dst, path, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+
+	// All packets are received, on any of the sockets.
+	conns := newListenConnsShared(udpConns, dst, selector, connOptions{})
+	var wg sync.WaitGroup
+	received := make(chan byte, senders)
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c ListenConn) {
+			defer wg.Done()
+			_ = c.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			buf := make([]byte, 16)
+			for {
+				n, _, path, err := c.ReadFromVia(buf)
+				if err != nil {
+					return
+				}
+				assert.NotNil(t, path)
+				if n == 1 {
+					received <- buf[0]
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(received)
+	seen := make(map[byte]bool)
+	for b := range received {
+		seen[b] = true
+	}
+	assert.Len(t, seen, senders)
+
+	// The shared selector is closed with the last connection.
+	for _, c := range conns[:n-1] {
+		require.NoError(t, c.Close())
+	}
+	assert.Equal(t, 0, selector.closed)
+	require.NoError(t, conns[n-1].Close())
+	assert.Equal(t, 1, selector.closed)
+}
+
+type countingReplySelector struct {
+	*DefaultReplySelector
+	closed int
+}
+
+func (s *countingReplySelector) Close() error {
+	s.closed++
+	return nil
+}
@@ -20,13 +20,17 @@ import (
 	"net"
 	"net/netip"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/scionproto/scion/pkg/addr"
 	"github.com/scionproto/scion/pkg/daemon"
 	"github.com/scionproto/scion/pkg/snet"
 	"github.com/scionproto/scion/pkg/snet/addrutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // hostContext contains the information needed to connect to the host's local SCION stack,
@@ -35,6 +39,12 @@ type hostContext struct {
 	ia            IA
 	sciond        daemon.Connector
 	hostInLocalAS net.IP
+	// mtu is the MTU of the local AS, 0 if unknown.
+	mtu uint16
+	// endhostPorts is the port range of the local AS in which end hosts
+	// receive SCION packets directly, see endhostUnderlayPort. Nil if
+	// unknown.
+	endhostPorts *portRange
 }
 
 const (
@@ -57,12 +67,14 @@ func mustInitHostContext() {
 		os.Exit(1)
 	}
 	singletonHostContext = hostCtx
+	localEndhostPorts.Store(hostCtx.endhostPorts)
 }
 
 func initHostContext() (hostContext, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+	addresses := daemonAddresses()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(addresses))*initTimeout)
 	defer cancel()
-	sciondConn, err := findSciond(ctx)
+	sciondConn, err := findSciond(ctx, addresses)
 	if err != nil {
 		return hostContext{}, err
 	}
@@ -74,21 +86,52 @@ func initHostContext() (hostContext, error) {
 	if err != nil {
 		return hostContext{}, err
 	}
+	// The MTU is optional; ignore errors.
+	var mtu uint16
+	if asInfo, err := sciondConn.ASInfo(ctx, localIA); err == nil {
+		mtu = asInfo.MTU
+	}
+	// The port range is optional too; without it, all packets within the
+	// local AS are sent via the shim dispatcher.
+	var endhostPorts *portRange
+	if start, end, err := sciondConn.PortRange(ctx); err == nil {
+		endhostPorts = &portRange{start: start, end: end}
+	}
 	return hostContext{
 		ia:            IA(localIA),
 		sciond:        sciondConn,
 		hostInLocalAS: hostInLocalAS,
+		mtu:           mtu,
+		endhostPorts:  endhostPorts,
 	}, nil
 }
 
-func findSciond(ctx context.Context) (daemon.Connector, error) {
-	address, ok := os.LookupEnv("SCION_DAEMON_ADDRESS")
-	if !ok {
-		address = daemon.DefaultAPIAddress
+// daemonAddresses returns the addresses of the SCION daemons, from the
+// comma separated list in SCION_DAEMON_ADDRESS or the default address.
+func daemonAddresses() []string {
+	addresses := parseDaemonAddresses(os.Getenv("SCION_DAEMON_ADDRESS"))
+	if len(addresses) == 0 {
+		addresses = []string{daemon.DefaultAPIAddress}
 	}
-	sciondConn, err := daemon.NewService(address).Connect(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("unable to connect to SCIOND at %s (override with SCION_DAEMON_ADDRESS): %w", address, err)
+	return addresses
+}
+
+// findSciond connects to the SCION daemon. If multiple addresses are given,
+// the returned connector fails over between the daemons, see
+// failoverConnector.
+func findSciond(ctx context.Context, addresses []string) (daemon.Connector, error) {
+	if len(addresses) == 1 {
+		sciondConn, err := daemon.NewService(addresses[0]).Connect(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to SCIOND at %s (override with SCION_DAEMON_ADDRESS): %w",
+				addresses[0], err)
+		}
+		return sciondConn, nil
+	}
+	sciondConn := newFailoverConnector(addresses)
+	if _, err := sciondConn.LocalIA(ctx); err != nil {
+		return nil, fmt.Errorf("unable to connect to any SCIOND at %s (override with SCION_DAEMON_ADDRESS): %w",
+			strings.Join(addresses, ","), err)
 	}
 	return sciondConn, nil
 }
@@ -108,12 +151,7 @@ func findAnyHostInLocalAS(ctx context.Context, sciondConn daemon.Connector) (net
 // wildcard addresses in snet.
 // See note on wildcard addresses in the package documentation.
 func defaultLocalIP() (netip.Addr, error) {
-	stdIP, err := addrutil.ResolveLocal(host().hostInLocalAS)
-	ip, ok := netip.AddrFromSlice(stdIP)
-	if err != nil || !ok {
-		return netip.Addr{}, fmt.Errorf("unable to resolve default local address %w", err)
-	}
-	return ip.Unmap(), nil
+	return localIPToward(host().hostInLocalAS)
 }
 
 // defaultLocalAddr fills in a missing or unspecified IP field with defaultLocalIP.
@@ -128,9 +166,178 @@ func defaultLocalAddr(local netip.AddrPort) (netip.AddrPort, error) {
 	return local, nil
 }
 
-func (h *hostContext) queryPaths(ctx context.Context, dst IA) ([]*Path, error) {
-	flags := daemon.PathReqFlags{Refresh: false, Hidden: false}
-	snetPaths, err := h.sciond.Paths(ctx, addr.IA(dst), 0, flags)
+// AddrFamily is the IP address family of automatically chosen local
+// addresses, see SetLocalAddrFamily.
+type AddrFamily int32
+
+const (
+	// AddrFamilyAny chooses the family of the underlay route to the peer.
+	AddrFamilyAny AddrFamily = iota
+	AddrFamilyIPv4
+	AddrFamilyIPv6
+)
+
+func (f AddrFamily) String() string {
+	switch f {
+	case AddrFamilyAny:
+		return "any"
+	case AddrFamilyIPv4:
+		return "ipv4"
+	case AddrFamilyIPv6:
+		return "ipv6"
+	default:
+		return fmt.Sprintf("AddrFamily(%d)", int32(f))
+	}
+}
+
+func (f AddrFamily) matches(ip netip.Addr) bool {
+	switch f {
+	case AddrFamilyIPv4:
+		return ip.Is4()
+	case AddrFamilyIPv6:
+		return ip.Is6()
+	default:
+		return true
+	}
+}
+
+var localAddrFamily atomic.Int32
+
+// SetLocalAddrFamily forces the IP address family of automatically chosen
+// local addresses, e.g. on dual-stack hosts where only one family is routed
+// in the SCION AS. By default (AddrFamilyAny), the local address is chosen
+// to match the underlay address of the first hop: the border router for
+// DialUDP, or the control service of the local AS otherwise.
+func SetLocalAddrFamily(f AddrFamily) {
+	localAddrFamily.Store(int32(f))
+}
+
+// localIPToward returns the local IP from which the underlay address target
+// is reached. If the family of the target does not match the family set with
+// SetLocalAddrFamily, the IP of any interface with that family is returned.
+func localIPToward(target net.IP) (netip.Addr, error) {
+	family := AddrFamily(localAddrFamily.Load())
+	if t, ok := netip.AddrFromSlice(target); ok && family.matches(t.Unmap()) {
+		stdIP, err := addrutil.ResolveLocal(target)
+		ip, ok := netip.AddrFromSlice(stdIP)
+		if err != nil || !ok {
+			return netip.Addr{}, fmt.Errorf("unable to resolve default local address %w", err)
+		}
+		return ip.Unmap(), nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("unable to resolve default local address %w", err)
+	}
+	ip, ok := interfaceIP(addrs, family)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("unable to resolve default local address: no %s address", family)
+	}
+	return ip, nil
+}
+
+// interfaceIP returns the first global unicast IP of the family among the
+// interface addresses.
+func interfaceIP(addrs []net.Addr, family AddrFamily) (netip.Addr, bool) {
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+		if ip.IsGlobalUnicast() && family.matches(ip) {
+			return ip, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// dialLocalAddr is defaultLocalAddr for a connection to remote with the path
+// policy. The IP is chosen with localIPFor, for the first path to remote
+// allowed by the policy, so that the address family of the socket matches the
+// one of the underlay next hop.
+func dialLocalAddr(ctx context.Context, local netip.AddrPort, remote UDPAddr,
+	policy Policy) (netip.AddrPort, error) {
+
+	if local.Addr().IsValid() && !local.Addr().IsUnspecified() {
+		return local, nil
+	}
+	var path *Path
+	if remote.IA != host().ia {
+		if paths, err := pool.paths(ctx, remote.IA); err == nil {
+			if policy != nil {
+				paths = policy.Filter(paths)
+			}
+			if len(paths) > 0 {
+				path = paths[0]
+			}
+		}
+	}
+	localIP, err := localIPFor(remote, path)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(localIP, local.Port()), nil
+}
+
+// localIPFor returns the local IP from which the underlay next hop toward
+// remote on path is reached, i.e. the border router of the path, or remote
+// itself if it is in the local AS. Falls back to defaultLocalIP if there is
+// no path or the next hop is not reachable.
+func localIPFor(remote UDPAddr, path *Path) (netip.Addr, error) {
+	var target net.IP
+	if remote.IA == host().ia {
+		target = remote.IP.AsSlice()
+	} else if path != nil && path.ForwardingPath.underlay.IsValid() {
+		target = path.ForwardingPath.underlay.Addr().AsSlice()
+	}
+	if target == nil {
+		return defaultLocalIP()
+	}
+	localIP, err := localIPToward(target)
+	if err != nil {
+		return defaultLocalIP()
+	}
+	return localIP, nil
+}
+
+// queryPaths requests paths to dst from sciond.
+// If hidden is set, the paths constructed from hidden path segments are included
+// and marked in the path metadata.
+func (h *hostContext) queryPaths(ctx context.Context, dst IA, hidden bool) ([]*Path, error) {
+	paths, err := h.queryPathsWithFlags(ctx, dst, daemon.PathReqFlags{Refresh: false, Hidden: false})
+	if err != nil || !hidden {
+		return paths, err
+	}
+	allPaths, err := h.queryPathsWithFlags(ctx, dst, daemon.PathReqFlags{Refresh: false, Hidden: true})
+	if err != nil {
+		return nil, err
+	}
+	public := make(map[PathFingerprint]struct{}, len(paths))
+	for _, p := range paths {
+		public[p.Fingerprint] = struct{}{}
+	}
+	for _, p := range allPaths {
+		if _, ok := public[p.Fingerprint]; !ok {
+			p.Metadata.Hidden = true
+		}
+	}
+	return allPaths, nil
+}
+
+func (h *hostContext) queryPathsWithFlags(ctx context.Context, dst IA,
+	flags daemon.PathReqFlags) ([]*Path, error) {
+
+	var snetPaths []snet.Path
+	err := withDaemonRetry(ctx, func() error {
+		var err error
+		snetPaths, err = h.sciond.Paths(ctx, addr.IA(dst), 0, flags)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -173,3 +380,34 @@ func convertPathInterfaceSlice(spis []snet.PathInterface) []PathInterface {
 	}
 	return pis
 }
+
+// withDaemonRetry calls f and retries it with exponential backoff for as long
+// as the SCION daemon is unavailable, e.g. while it is restarting. Gives up
+// after daemonRetryTimeout or when ctx is done, returning the last error.
+// The connection to the daemon is re-established automatically.
+func withDaemonRetry(ctx context.Context, f func() error) error {
+	deadline := time.Now().Add(daemonRetryTimeout)
+	backoff := daemonRetryBackoffMin
+	for {
+		err := f()
+		if err == nil || !isDaemonUnavailable(err) || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > daemonRetryBackoffMax {
+			backoff = daemonRetryBackoffMax
+		}
+	}
+}
+
+// isDaemonUnavailable checks whether err indicates that the SCION daemon is
+// (temporarily) unreachable.
+func isDaemonUnavailable(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Unavailable
+}
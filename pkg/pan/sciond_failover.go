@@ -0,0 +1,218 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/scionproto/scion/pkg/drkey"
+	"github.com/scionproto/scion/pkg/private/ctrl/path_mgmt"
+	"github.com/scionproto/scion/pkg/snet"
+)
+
+// parseDaemonAddresses parses a comma separated list of SCION daemon
+// addresses.
+func parseDaemonAddresses(s string) []string {
+	var addresses []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses
+}
+
+// failoverConnector is a daemon.Connector for multiple, redundant SCION
+// daemons of the same AS. Requests are sent to the current daemon. If it is
+// unavailable, the request is retried with the next daemon, which then
+// becomes the current one.
+// The daemons are connected lazily, when they are first needed.
+type failoverConnector struct {
+	addresses []string
+	connect   func(ctx context.Context, address string) (daemon.Connector, error)
+
+	mutex      sync.Mutex
+	connectors []daemon.Connector
+	current    int
+}
+
+func newFailoverConnector(addresses []string) *failoverConnector {
+	return &failoverConnector{
+		addresses:  addresses,
+		connect:    connectDaemon,
+		connectors: make([]daemon.Connector, len(addresses)),
+	}
+}
+
+func connectDaemon(ctx context.Context, address string) (daemon.Connector, error) {
+	return daemon.NewService(address).Connect(ctx)
+}
+
+// connector returns the connector for the i-th daemon, connecting to it if
+// necessary. The lock is not held while connecting, so that an unreachable
+// daemon does not block the requests to the other daemons.
+func (c *failoverConnector) connector(ctx context.Context, i int) (daemon.Connector, error) {
+	c.mutex.Lock()
+	conn := c.connectors[i]
+	c.mutex.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	// limit the time for each daemon, so an unreachable daemon does not
+	// use up all the time of the request.
+	ctx, cancel := context.WithTimeout(ctx, initTimeout)
+	defer cancel()
+	conn, err := c.connect(ctx, c.addresses[i])
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.connectors[i] != nil {
+		// connected concurrently by another request
+		_ = conn.Close()
+		return c.connectors[i], nil
+	}
+	c.connectors[i] = conn
+	return conn, nil
+}
+
+// failover calls f with the connector of the current daemon. If the daemon is
+// unavailable, f is retried with the other daemons in turn.
+func failover[T any](ctx context.Context, c *failoverConnector,
+	f func(daemon.Connector) (T, error)) (T, error) {
+
+	c.mutex.Lock()
+	start := c.current
+	c.mutex.Unlock()
+
+	var ret T
+	var err error
+	for k := 0; k < len(c.addresses); k++ {
+		i := (start + k) % len(c.addresses)
+		var conn daemon.Connector
+		conn, err = c.connector(ctx, i)
+		if err == nil {
+			ret, err = f(conn)
+			if err == nil || !isDaemonUnavailable(err) {
+				if k > 0 {
+					c.mutex.Lock()
+					c.current = i
+					c.mutex.Unlock()
+				}
+				return ret, err
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return ret, err
+}
+
+func (c *failoverConnector) LocalIA(ctx context.Context) (addr.IA, error) {
+	return failover(ctx, c, func(conn daemon.Connector) (addr.IA, error) {
+		return conn.LocalIA(ctx)
+	})
+}
+
+func (c *failoverConnector) PortRange(ctx context.Context) (uint16, uint16, error) {
+	type portRange struct{ min, max uint16 }
+	r, err := failover(ctx, c, func(conn daemon.Connector) (portRange, error) {
+		min, max, err := conn.PortRange(ctx)
+		return portRange{min, max}, err
+	})
+	return r.min, r.max, err
+}
+
+func (c *failoverConnector) Interfaces(ctx context.Context) (map[uint16]netip.AddrPort, error) {
+	return failover(ctx, c, func(conn daemon.Connector) (map[uint16]netip.AddrPort, error) {
+		return conn.Interfaces(ctx)
+	})
+}
+
+func (c *failoverConnector) Paths(ctx context.Context, dst, src addr.IA,
+	f daemon.PathReqFlags) ([]snet.Path, error) {
+
+	return failover(ctx, c, func(conn daemon.Connector) ([]snet.Path, error) {
+		return conn.Paths(ctx, dst, src, f)
+	})
+}
+
+func (c *failoverConnector) ASInfo(ctx context.Context, ia addr.IA) (daemon.ASInfo, error) {
+	return failover(ctx, c, func(conn daemon.Connector) (daemon.ASInfo, error) {
+		return conn.ASInfo(ctx, ia)
+	})
+}
+
+func (c *failoverConnector) SVCInfo(ctx context.Context,
+	svcTypes []addr.SVC) (map[addr.SVC][]string, error) {
+
+	return failover(ctx, c, func(conn daemon.Connector) (map[addr.SVC][]string, error) {
+		return conn.SVCInfo(ctx, svcTypes)
+	})
+}
+
+func (c *failoverConnector) RevNotification(ctx context.Context,
+	revInfo *path_mgmt.RevInfo) error {
+
+	_, err := failover(ctx, c, func(conn daemon.Connector) (struct{}, error) {
+		return struct{}{}, conn.RevNotification(ctx, revInfo)
+	})
+	return err
+}
+
+func (c *failoverConnector) DRKeyGetASHostKey(ctx context.Context,
+	meta drkey.ASHostMeta) (drkey.ASHostKey, error) {
+
+	return failover(ctx, c, func(conn daemon.Connector) (drkey.ASHostKey, error) {
+		return conn.DRKeyGetASHostKey(ctx, meta)
+	})
+}
+
+func (c *failoverConnector) DRKeyGetHostASKey(ctx context.Context,
+	meta drkey.HostASMeta) (drkey.HostASKey, error) {
+
+	return failover(ctx, c, func(conn daemon.Connector) (drkey.HostASKey, error) {
+		return conn.DRKeyGetHostASKey(ctx, meta)
+	})
+}
+
+func (c *failoverConnector) DRKeyGetHostHostKey(ctx context.Context,
+	meta drkey.HostHostMeta) (drkey.HostHostKey, error) {
+
+	return failover(ctx, c, func(conn daemon.Connector) (drkey.HostHostKey, error) {
+		return conn.DRKeyGetHostHostKey(ctx, meta)
+	})
+}
+
+func (c *failoverConnector) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var errs []error
+	for i, conn := range c.connectors {
+		if conn != nil {
+			errs = append(errs, conn.Close())
+			c.connectors[i] = nil
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,205 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/daemon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithDaemonRetry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	calls := 0
+	err := withDaemonRetry(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return unavailable
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	other := errors.New("no such AS")
+	err = withDaemonRetry(context.Background(), func() error {
+		calls++
+		return other
+	})
+	assert.ErrorIs(t, err, other)
+	assert.Equal(t, 1, calls, "other errors are not retried")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	err = withDaemonRetry(ctx, func() error {
+		calls++
+		return unavailable
+	})
+	assert.Equal(t, unavailable, err)
+	assert.Equal(t, 1, calls, "no retry after context is done")
+}
+
+func TestParseDaemonAddresses(t *testing.T) {
+	assert.Empty(t, parseDaemonAddresses(""))
+	assert.Equal(t, []string{"127.0.0.1:30255"}, parseDaemonAddresses("127.0.0.1:30255"))
+	assert.Equal(t, []string{"127.0.0.1:30255", "[::1]:30255"},
+		parseDaemonAddresses(" 127.0.0.1:30255, [::1]:30255,"))
+}
+
+func TestFailoverConnector(t *testing.T) {
+	ia := addr.MustIAFrom(1, 0xff0000000110)
+	daemons := map[string]*fakeDaemon{
+		"a": {ia: ia},
+		"b": {ia: ia},
+	}
+	c := newFailoverConnector([]string{"unreachable", "a", "b"})
+	c.connect = func(ctx context.Context, address string) (daemon.Connector, error) {
+		if d, ok := daemons[address]; ok {
+			return d, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	// skips unreachable daemon
+	actual, err := c.LocalIA(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, ia, actual)
+	assert.Equal(t, 1, daemons["a"].calls)
+
+	// fails over if current daemon is unavailable, and sticks with the new one
+	daemons["a"].unavailable = true
+	_, err = c.LocalIA(context.Background())
+	require.NoError(t, err)
+	_, err = c.LocalIA(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, daemons["a"].calls)
+	assert.Equal(t, 2, daemons["b"].calls)
+
+	// other errors are returned without failover
+	daemons["b"].err = errors.New("no such AS")
+	_, err = c.LocalIA(context.Background())
+	assert.ErrorIs(t, err, daemons["b"].err)
+	assert.Equal(t, 2, daemons["a"].calls)
+}
+
+func TestFailoverConnectorConcurrentConnect(t *testing.T) {
+	a := &fakeDaemon{}
+	c := newFailoverConnector([]string{"slow", "a"})
+	connecting := make(chan struct{})
+	release := make(chan struct{})
+	c.connect = func(ctx context.Context, address string) (daemon.Connector, error) {
+		if address == "slow" {
+			close(connecting)
+			<-release
+			return nil, errors.New("connection refused")
+		}
+		return a, nil
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := c.connector(context.Background(), 0)
+		done <- err
+	}()
+	<-connecting
+	// the slow daemon does not block the connection to the other daemon
+	conn, err := c.connector(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, a, conn)
+	close(release)
+	assert.Error(t, <-done)
+
+	// a connector stored concurrently is kept, the new one is closed
+	b := &fakeDaemon{}
+	c.connect = func(ctx context.Context, address string) (daemon.Connector, error) {
+		c.mutex.Lock()
+		c.connectors[0] = a
+		c.mutex.Unlock()
+		return b, nil
+	}
+	conn, err = c.connector(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, a, conn)
+	assert.True(t, b.closed)
+}
+
+type fakeDaemon struct {
+	daemon.Connector
+	ia          addr.IA
+	unavailable bool
+	err         error
+	calls       int
+	closed      bool
+}
+
+func (d *fakeDaemon) Close() error {
+	d.closed = true
+	return nil
+}
+
+func (d *fakeDaemon) LocalIA(ctx context.Context) (addr.IA, error) {
+	d.calls++
+	if d.unavailable {
+		return 0, status.Error(codes.Unavailable, "connection refused")
+	}
+	return d.ia, d.err
+}
+
+func TestInterfaceIP(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+		&net.IPNet{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("192.0.2.1").To16(), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)},
+	}
+	ip, ok := interfaceIP(addrs, AddrFamilyIPv4)
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParseAddr("192.0.2.1"), ip)
+	ip, ok = interfaceIP(addrs, AddrFamilyIPv6)
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParseAddr("2001:db8::1"), ip)
+	_, ok = interfaceIP(addrs[:3], AddrFamilyIPv6)
+	assert.False(t, ok)
+}
+
+func TestLocalIPToward(t *testing.T) {
+	ip, err := localIPToward(net.ParseIP("127.0.0.1"))
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("127.0.0.1"), ip)
+
+	defer SetLocalAddrFamily(AddrFamilyAny)
+	SetLocalAddrFamily(AddrFamilyIPv4)
+	ip, err = localIPToward(net.ParseIP("127.0.0.1"))
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("127.0.0.1"), ip)
+	// For a target of the other family, any interface address of the family
+	// is used, if there is one.
+	if ip, err := localIPToward(net.ParseIP("::1")); err == nil {
+		assert.True(t, ip.Is4())
+	}
+}
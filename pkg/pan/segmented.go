@@ -0,0 +1,232 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/scionproto/scion/pkg/private/common"
+)
+
+const (
+	// segmentHeaderLen is the length of the header prepended to each segment:
+	// the message ID (4 bytes), the index of the segment (2 bytes) and the
+	// number of segments of the message (2 bytes).
+	segmentHeaderLen = 8
+	// segmentFallbackSize is the size of the segments if the MTU of the path is
+	// unknown. It fits the minimum SCION MTU of 1280 bytes with the headers of
+	// typical paths.
+	segmentFallbackSize = 1000
+	// segmentReassemblyTimeout is the time after which an incomplete message is
+	// dropped.
+	segmentReassemblyTimeout = 10 * time.Second
+	// segmentMaxPending is the maximum number of incomplete messages kept by a
+	// SegmentReceiver; the oldest is dropped if exceeded.
+	segmentMaxPending = 64
+)
+
+// segmentMsgID is the ID of the last message sent with SendSegmented. It starts
+// at a random value, so that the messages of a restarted sender are not mixed
+// up with the ones it sent before.
+var segmentMsgID = func() *atomic.Uint32 {
+	id := &atomic.Uint32{}
+	id.Store(rand.Uint32())
+	return id
+}()
+
+// SendSegmented writes msg to the remote address of conn, split into as many
+// datagrams as needed to fit the MTU of the current path. Each datagram
+// carries a small header to reassemble the message with a SegmentReceiver.
+// This is meant for message-oriented applications that occasionally exceed the
+// path MTU; there is no retransmission, a message is lost if any of its
+// datagrams is lost.
+// All datagrams of a message are sent on the same path, using as few system
+// calls as possible. Returns ErrMsgTooLarge if msg exceeds 65535 segments.
+func SendSegmented(conn Conn, msg []byte) error {
+	return sendSegmented(conn.WriteBatch, UDPAddr{}, conn.GetPath(), conn.MTU(), msg)
+}
+
+// sendSegmented writes msg with writeBatch, split into segments that fit mtu,
+// or segmentFallbackSize if mtu is 0. All segments are sent to dst via path.
+func sendSegmented(writeBatch func([]Message) (int, error), dst UDPAddr, path *Path,
+	mtu int, msg []byte) error {
+
+	size := segmentFallbackSize
+	if mtu > segmentHeaderLen {
+		size = mtu - segmentHeaderLen
+	}
+	count := (len(msg) + size - 1) / size
+	if count == 0 {
+		count = 1 // an empty message is sent as a single empty segment
+	}
+	if count > math.MaxUint16 {
+		return ErrMsgTooLarge{MaxSize: math.MaxUint16 * size}
+	}
+
+	id := segmentMsgID.Add(1)
+	buf := make([]byte, len(msg)+count*segmentHeaderLen)
+	msgs := make([]Message, count)
+	for i := range msgs {
+		payload := msg[i*size : min((i+1)*size, len(msg))]
+		segment := buf[:segmentHeaderLen+len(payload)]
+		buf = buf[len(segment):]
+		binary.BigEndian.PutUint32(segment[0:4], id)
+		binary.BigEndian.PutUint16(segment[4:6], uint16(i))
+		binary.BigEndian.PutUint16(segment[6:8], uint16(count))
+		copy(segment[segmentHeaderLen:], payload)
+		msgs[i] = Message{Buffer: segment, Addr: dst, Path: path}
+	}
+	for n := 0; n < len(msgs); {
+		written, err := writeBatch(msgs[n:])
+		if err != nil {
+			return err
+		}
+		n += written
+	}
+	return nil
+}
+
+// SegmentReceiver reassembles the messages sent with SendSegmented from the
+// datagrams read from a connection, e.g. a ListenConn. Messages from different
+// remotes and the segments of concurrently sent messages may be interleaved.
+// Incomplete messages are dropped after a timeout. A SegmentReceiver must not
+// be used concurrently.
+type SegmentReceiver struct {
+	read    func([]byte) (int, net.Addr, error)
+	maxSize int
+	buf     []byte
+	pending map[segmentKey]*segmentedMessage
+}
+
+// segmentKey identifies a message being reassembled.
+type segmentKey struct {
+	remote string
+	id     uint32
+}
+
+// segmentedMessage is a message being reassembled.
+type segmentedMessage struct {
+	segments [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// NewSegmentReceiver returns a SegmentReceiver reading from conn. Messages
+// larger than maxSize bytes are dropped; a maxSize of 0 means no limit.
+func NewSegmentReceiver(conn net.PacketConn, maxSize int) *SegmentReceiver {
+	return newSegmentReceiver(func(b []byte) (int, net.Addr, error) {
+		return conn.ReadFrom(b)
+	}, maxSize)
+}
+
+// newSegmentReceiver returns a SegmentReceiver reading the datagrams with read.
+func newSegmentReceiver(read func([]byte) (int, net.Addr, error), maxSize int) *SegmentReceiver {
+	return &SegmentReceiver{
+		read:    read,
+		maxSize: maxSize,
+		buf:     make([]byte, common.SupportedMTU),
+		pending: make(map[segmentKey]*segmentedMessage),
+	}
+}
+
+// ReceiveSegmented reads from the connection until a message is complete and
+// returns it together with the address of its sender. Datagrams without a
+// valid segment header are skipped.
+func (r *SegmentReceiver) ReceiveSegmented() ([]byte, net.Addr, error) {
+	for {
+		n, remote, err := r.read(r.buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if msg, ok := r.add(remote, r.buf[:n], time.Now()); ok {
+			return msg, remote, nil
+		}
+	}
+}
+
+// add adds the segment b received from remote. Returns the message if it is
+// complete.
+func (r *SegmentReceiver) add(remote net.Addr, b []byte, now time.Time) ([]byte, bool) {
+	if len(b) < segmentHeaderLen {
+		return nil, false
+	}
+	id := binary.BigEndian.Uint32(b[0:4])
+	index := int(binary.BigEndian.Uint16(b[4:6]))
+	count := int(binary.BigEndian.Uint16(b[6:8]))
+	payload := b[segmentHeaderLen:]
+	if index >= count {
+		return nil, false
+	}
+	if count == 1 {
+		return bytes.Clone(payload), true
+	}
+
+	r.expire(now)
+	key := segmentKey{remote: remote.String(), id: id}
+	m, ok := r.pending[key]
+	if !ok {
+		if len(r.pending) >= segmentMaxPending {
+			r.dropOldest()
+		}
+		m = &segmentedMessage{segments: make([][]byte, count), started: now}
+		r.pending[key] = m
+	}
+	if len(m.segments) != count || m.segments[index] != nil {
+		return nil, false // inconsistent or duplicate segment
+	}
+	m.segments[index] = bytes.Clone(payload)
+	m.received++
+	m.size += len(payload)
+	if r.maxSize > 0 && m.size > r.maxSize {
+		delete(r.pending, key)
+		return nil, false
+	}
+	if m.received < count {
+		return nil, false
+	}
+	delete(r.pending, key)
+	msg := make([]byte, 0, m.size)
+	for _, s := range m.segments {
+		msg = append(msg, s...)
+	}
+	return msg, true
+}
+
+// expire drops the incomplete messages that timed out.
+func (r *SegmentReceiver) expire(now time.Time) {
+	for key, m := range r.pending {
+		if now.Sub(m.started) > segmentReassemblyTimeout {
+			delete(r.pending, key)
+		}
+	}
+}
+
+func (r *SegmentReceiver) dropOldest() {
+	var oldest segmentKey
+	var started time.Time
+	for key, m := range r.pending {
+		if started.IsZero() || m.started.Before(started) {
+			oldest, started = key, m.started
+		}
+	}
+	delete(r.pending, oldest)
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendSegmented(t *testing.T) {
+	c, remote, _ := testKeepaliveConn(t)
+	receiver := NewSegmentReceiver(&listenConn{
+		baseUDPConn: baseUDPConn{raw: remote.raw},
+		local:       c.remote,
+		selector:    NewDefaultReplySelector(),
+	}, 0)
+
+	mtu := c.MTU()
+	require.Greater(t, mtu, segmentHeaderLen)
+	for _, size := range []int{0, 100, 3*mtu + 17} {
+		msg := bytes.Repeat([]byte{byte(size)}, size)
+		require.NoError(t, SendSegmented(c, msg))
+		received, from, err := receiver.ReceiveSegmented()
+		require.NoError(t, err)
+		assert.Equal(t, msg, received, "size %d", size)
+		assert.Equal(t, c.local, from)
+	}
+}
+
+func testSegmentDatagram(id uint32, index, count int, payload string) []byte {
+	b := make([]byte, segmentHeaderLen, segmentHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(b[0:4], id)
+	binary.BigEndian.PutUint16(b[4:6], uint16(index))
+	binary.BigEndian.PutUint16(b[6:8], uint16(count))
+	return append(b, payload...)
+}
+
+func TestSegmentReceiverReassembly(t *testing.T) {
+	r := NewSegmentReceiver(nil, 10)
+	a := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	b := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1}
+	now := time.Now()
+
+	// Interleaved messages with the same ID from different remotes, out of
+	// order and with a duplicate.
+	_, ok := r.add(a, testSegmentDatagram(1, 1, 2, "lo"), now)
+	assert.False(t, ok)
+	_, ok = r.add(b, testSegmentDatagram(1, 0, 2, "wor"), now)
+	assert.False(t, ok)
+	_, ok = r.add(a, testSegmentDatagram(1, 1, 2, "lo"), now)
+	assert.False(t, ok)
+	msg, ok := r.add(a, testSegmentDatagram(1, 0, 2, "hel"), now)
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(msg))
+	msg, ok = r.add(b, testSegmentDatagram(1, 1, 2, "ld"), now)
+	assert.True(t, ok)
+	assert.Equal(t, "world", string(msg))
+	assert.Empty(t, r.pending)
+
+	// Malformed segments are skipped.
+	_, ok = r.add(a, []byte{1, 2, 3}, now)
+	assert.False(t, ok)
+	_, ok = r.add(a, testSegmentDatagram(2, 2, 2, "x"), now)
+	assert.False(t, ok)
+
+	// Messages exceeding the maximum size are dropped.
+	_, ok = r.add(a, testSegmentDatagram(3, 0, 2, "0123456"), now)
+	assert.False(t, ok)
+	_, ok = r.add(a, testSegmentDatagram(3, 1, 2, "7890"), now)
+	assert.False(t, ok)
+	assert.Empty(t, r.pending)
+
+	// Incomplete messages time out.
+	_, ok = r.add(a, testSegmentDatagram(4, 0, 2, "a"), now)
+	assert.False(t, ok)
+	_, ok = r.add(a, testSegmentDatagram(5, 0, 2, "b"), now.Add(segmentReassemblyTimeout+time.Second))
+	assert.False(t, ok)
+	assert.Len(t, r.pending, 1)
+	_, ok = r.add(a, testSegmentDatagram(4, 1, 2, "a"), now.Add(segmentReassemblyTimeout+time.Second))
+	assert.False(t, ok)
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/scionproto/scion/pkg/slayers/path"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+)
+
+// pathSegment is one of the (up to three) segments of a SCION path, as
+// extracted from a path returned by the SCION daemon. The hop fields and
+// interfaces are in the order of traversal.
+type pathSegment struct {
+	info       path.InfoField
+	hops       []path.HopField
+	interfaces []PathInterface
+	// from the original path, only valid if this was the first segment:
+	underlay netip.AddrPort
+	mtu      uint16
+}
+
+func (s pathSegment) start() IA {
+	return s.interfaces[0].IA
+}
+
+func (s pathSegment) end() IA {
+	return s.interfaces[len(s.interfaces)-1].IA
+}
+
+// splitSegments splits the path into its segments. Only works for paths with
+// a SCION dataplane path and metadata, as returned by the SCION daemon.
+// Peering paths are not supported.
+func splitSegments(p *Path) ([]pathSegment, error) {
+	if p.Metadata == nil {
+		return nil, errors.New("no path metadata")
+	}
+	sp, ok := p.ForwardingPath.dataplanePath.(snetpath.SCION)
+	if !ok {
+		return nil, fmt.Errorf("unsupported path type %T", p.ForwardingPath.dataplanePath)
+	}
+	var decoded scion.Decoded
+	if err := decoded.DecodeFromBytes(sp.Raw); err != nil {
+		return nil, err
+	}
+	segments := make([]pathSegment, len(decoded.InfoFields))
+	hop, iface := 0, 0
+	for i, info := range decoded.InfoFields {
+		if info.Peer {
+			return nil, errors.New("peering paths are not supported")
+		}
+		seglen := int(decoded.PathMeta.SegLen[i])
+		numInterfaces := 2 * (seglen - 1)
+		if seglen < 2 || iface+numInterfaces > len(p.Metadata.Interfaces) {
+			return nil, errors.New("path metadata does not match forwarding path")
+		}
+		segments[i] = pathSegment{
+			info:       info,
+			hops:       decoded.HopFields[hop : hop+seglen],
+			interfaces: p.Metadata.Interfaces[iface : iface+numInterfaces],
+			underlay:   p.ForwardingPath.underlay,
+			mtu:        p.Metadata.MTU,
+		}
+		hop += seglen
+		iface += numInterfaces
+	}
+	if iface != len(p.Metadata.Interfaces) {
+		return nil, errors.New("path metadata does not match forwarding path")
+	}
+	return segments, nil
+}
+
+// synthesizePaths creates new paths by recombining the segments of the given
+// paths, all from src to dst. A synthetic path consists of a prefix of the
+// segments of one path and a suffix of the segments of another path, joined in
+// an AS where the two segments meet. Only loop free paths that are not already
+// in paths are returned. The returned paths are marked as Synthetic in the
+// metadata.
+func synthesizePaths(src, dst IA, paths []*Path) []*Path {
+	known := make(map[PathFingerprint]struct{}, len(paths))
+	var split [][]pathSegment
+	for _, p := range paths {
+		known[p.Fingerprint] = struct{}{}
+		if segments, err := splitSegments(p); err == nil {
+			split = append(split, segments)
+		}
+	}
+
+	var synthetic []*Path
+	for _, a := range split {
+		for _, b := range split {
+			for i := 0; i < len(a)-1; i++ {
+				for j := 1; j < len(b); j++ {
+					if i+1+len(b)-j > 3 || a[i].end() != b[j].start() {
+						continue
+					}
+					segments := append(append([]pathSegment{}, a[:i+1]...), b[j:]...)
+					p, err := combineSegments(src, dst, segments)
+					if err != nil {
+						continue
+					}
+					if _, ok := known[p.Fingerprint]; ok {
+						continue
+					}
+					known[p.Fingerprint] = struct{}{}
+					synthetic = append(synthetic, p)
+				}
+			}
+		}
+	}
+	return synthetic
+}
+
+// combineSegments creates a path from the given segments.
+func combineSegments(src, dst IA, segments []pathSegment) (*Path, error) {
+	var interfaces []PathInterface
+	var decoded scion.Decoded
+	mtu := segments[0].mtu
+	for i, s := range segments {
+		decoded.PathMeta.SegLen[i] = uint8(len(s.hops))
+		decoded.InfoFields = append(decoded.InfoFields, s.info)
+		decoded.HopFields = append(decoded.HopFields, s.hops...)
+		interfaces = append(interfaces, s.interfaces...)
+		if s.mtu < mtu {
+			mtu = s.mtu
+		}
+	}
+	if interfaces[0].IA != src || interfaces[len(interfaces)-1].IA != dst {
+		return nil, errors.New("segments do not connect source and destination")
+	}
+	if hasASLoop(interfaces) {
+		return nil, errors.New("path contains a loop")
+	}
+	decoded.NumINF = len(decoded.InfoFields)
+	decoded.NumHops = len(decoded.HopFields)
+	raw := make([]byte, decoded.Len())
+	if err := decoded.SerializeTo(raw); err != nil {
+		return nil, err
+	}
+	return &Path{
+		Source:      src,
+		Destination: dst,
+		Metadata: &PathMetadata{
+			Interfaces: interfaces,
+			MTU:        mtu,
+			Synthetic:  true,
+		},
+		Fingerprint: pathSequenceFromInterfaces(interfaces).Fingerprint(),
+		Expiry:      expiryFromDecoded(decoded),
+		ForwardingPath: ForwardingPath{
+			dataplanePath: snetpath.SCION{Raw: raw},
+			underlay:      segments[0].underlay,
+		},
+	}, nil
+}
+
+// hasASLoop checks whether any AS is visited more than once on the path
+// described by the list of interfaces.
+func hasASLoop(interfaces []PathInterface) bool {
+	visited := make(map[IA]struct{}, len(interfaces)/2+1)
+	var prev IA
+	for i, pi := range interfaces {
+		if i > 0 && pi.IA == prev {
+			continue
+		}
+		if _, ok := visited[pi.IA]; ok {
+			return true
+		}
+		visited[pi.IA] = struct{}{}
+		prev = pi.IA
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/slayers/path"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSynthesizePaths(t *testing.T) {
+	src := MustParseIA("1-ff00:0:111")
+	dst := MustParseIA("1-ff00:0:112")
+	core1 := MustParseIA("1-ff00:0:110")
+	core2 := MustParseIA("1-ff00:0:120")
+
+	// a: up src->core1, down core1->dst
+	a := testPathFromSegments(t, src, dst, []testSegment{
+		{consDir: false, interfaces: []PathInterface{{src, 1}, {core1, 2}}},
+		{consDir: true, interfaces: []PathInterface{{core1, 3}, {dst, 4}}},
+	})
+	// b: up src->core2, core core2->core1, down core1->dst (different links)
+	b := testPathFromSegments(t, src, dst, []testSegment{
+		{consDir: false, interfaces: []PathInterface{{src, 5}, {core2, 6}}},
+		{consDir: true, interfaces: []PathInterface{{core2, 7}, {core1, 8}}},
+		{consDir: true, interfaces: []PathInterface{{core1, 9}, {dst, 10}}},
+	})
+
+	synthetic := synthesizePaths(src, dst, []*Path{a, b})
+	fingerprints := pathFingerprints(synthetic)
+	assert.ElementsMatch(t, []PathFingerprint{
+		"1 2 9 10",    // up of a, down of b
+		"5 6 7 8 3 4", // up and core of b, down of a
+	}, fingerprints)
+
+	for _, p := range synthetic {
+		assert.True(t, p.Metadata.Synthetic)
+		assert.Equal(t, src, p.Source)
+		assert.Equal(t, dst, p.Destination)
+		assert.False(t, p.Expiry.IsZero())
+		// the forwarding path must be consistent with the fingerprint
+		fpi, err := p.ForwardingPath.forwardingPathInfo()
+		require.NoError(t, err)
+		assert.Equal(t, p.Fingerprint, pathSequence{InterfaceIDs: fpi.interfaceIDs}.Fingerprint())
+	}
+
+	// nothing new to synthesize from the complete set
+	assert.Empty(t, synthesizePaths(src, dst, append([]*Path{a, b}, synthetic...)))
+}
+
+func TestHasASLoop(t *testing.T) {
+	a := MustParseIA("1-ff00:0:1")
+	b := MustParseIA("1-ff00:0:2")
+	c := MustParseIA("1-ff00:0:3")
+	assert.False(t, hasASLoop([]PathInterface{{a, 1}, {b, 2}, {b, 3}, {c, 4}}))
+	assert.True(t, hasASLoop([]PathInterface{{a, 1}, {b, 2}, {b, 3}, {a, 4}}))
+}
+
+type testSegment struct {
+	consDir    bool
+	interfaces []PathInterface // in order of traversal
+}
+
+// testPathFromSegments creates a path with a SCION dataplane path and metadata
+// from the given segments. The hop fields have no valid MACs.
+func testPathFromSegments(t testing.TB, src, dst IA, segments []testSegment) *Path {
+	var decoded scion.Decoded
+	var interfaces []PathInterface
+	for i, s := range segments {
+		numHops := len(s.interfaces)/2 + 1
+		decoded.PathMeta.SegLen[i] = uint8(numHops)
+		decoded.InfoFields = append(decoded.InfoFields, path.InfoField{
+			ConsDir:   s.consDir,
+			Timestamp: uint32(time.Now().Unix()),
+		})
+		for h := 0; h < numHops; h++ {
+			var ingress, egress uint16 // in order of traversal
+			if h > 0 {
+				ingress = uint16(s.interfaces[2*h-1].IfID)
+			}
+			if h < numHops-1 {
+				egress = uint16(s.interfaces[2*h].IfID)
+			}
+			hf := path.HopField{ExpTime: 63, ConsIngress: ingress, ConsEgress: egress}
+			if !s.consDir {
+				hf.ConsIngress, hf.ConsEgress = egress, ingress
+			}
+			decoded.HopFields = append(decoded.HopFields, hf)
+		}
+		interfaces = append(interfaces, s.interfaces...)
+	}
+	decoded.NumINF = len(decoded.InfoFields)
+	decoded.NumHops = len(decoded.HopFields)
+	raw := make([]byte, decoded.Len())
+	require.NoError(t, decoded.SerializeTo(raw))
+	return &Path{
+		Source:         src,
+		Destination:    dst,
+		Metadata:       &PathMetadata{Interfaces: interfaces, MTU: 1400},
+		Fingerprint:    pathSequenceFromInterfaces(interfaces).Fingerprint(),
+		ForwardingPath: ForwardingPath{dataplanePath: snetpath.SCION{Raw: raw}},
+	}
+}
@@ -34,7 +34,8 @@ type Selector interface {
 	Path() *Path
 	// Initialize the selector for a connection with the initial list of paths,
 	// filtered/ordered by the Policy.
-	// Invoked once during the creation of a Conn.
+	// Invoked once during the creation of a Conn, and again whenever the Conn
+	// migrates to a new local address, see EnableAddressMigration.
 	Initialize(local, remote UDPAddr, paths []*Path)
 	// Refresh updates the paths. This is called whenever the Policy is changed or
 	// when paths were about to expire and are refreshed from the SCION daemon.
@@ -47,6 +48,14 @@ type Selector interface {
 	Close() error
 }
 
+// A Selector may optionally implement
+//
+//	PathRecovered(PathFingerprint)
+//
+// to be informed when a path that was notified down has been observed to work
+// again, see EnableRecoveryProbing. Like PathDown, this may be called for
+// unrelated paths.
+
 // DefaultSelector is a Selector for a single dialed socket.
 // This will keep using the current path, starting with the first path chosen
 // by the policy, as long possible.
@@ -54,6 +63,9 @@ type Selector interface {
 // a down notification affects the current path, the DefaultSelector will
 // switch to the first path (in the order defined by the policy) that is not
 // affected by down notifications.
+// If recovery probing is enabled (see EnableRecoveryProbing), the
+// DefaultSelector fails back to a path earlier in the policy order once it has
+// recovered.
 type DefaultSelector struct {
 	mutex   sync.Mutex
 	paths   []*Path
@@ -111,7 +123,28 @@ func (s *DefaultSelector) PathDown(pf PathFingerprint, pi PathInterface) {
 			// Try next path. Note that this will keep cycling if we get down notifications
 			s.current = better
 			fmt.Println("failover:", s.current, len(s.paths))
+			emitPathSwitched(current, s.paths[s.current])
+		}
+	}
+}
+
+func (s *DefaultSelector) PathRecovered(pf PathFingerprint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.paths) == 0 {
+		return
+	}
+	current := s.paths[s.current]
+	for i := 0; i < s.current; i++ {
+		if s.paths[i].Fingerprint != pf {
+			continue
+		}
+		if !stats.IsMoreAlive(current, s.paths[i]) {
+			s.current = i
+			emitPathSwitched(current, s.paths[s.current])
 		}
+		return
 	}
 }
 
@@ -119,6 +152,20 @@ func (s *DefaultSelector) Close() error {
 	return nil
 }
 
+// emitPathSwitched emits an EventPathSwitched for a selector switching from
+// path prev to path next.
+func emitPathSwitched(prev, next *Path) {
+	if prev.Fingerprint == next.Fingerprint {
+		return
+	}
+	events.emit(Event{
+		Type:                EventPathSwitched,
+		Destination:         next.Destination,
+		Fingerprint:         next.Fingerprint,
+		PreviousFingerprint: prev.Fingerprint,
+	})
+}
+
 type PingingSelector struct {
 	// Interval for pinging. Must be positive.
 	Interval time.Duration
@@ -135,6 +182,8 @@ type PingingSelector struct {
 	pingerCtx    context.Context
 	pingerCancel context.CancelFunc
 	pinger       *ping.Pinger
+	// closed is set by Close; the pinger is then not started again.
+	closed bool
 }
 
 // SetActive enables active pinging on at most numActive paths.
@@ -155,12 +204,20 @@ func (s *PingingSelector) Path() *Path {
 
 func (s *PingingSelector) Initialize(local, remote UDPAddr, paths []*Path) {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
+	restart := s.pinger != nil && local.scionAddr() != s.local
+	if restart {
+		// The local address changed, the pinger is restarted on the new one.
+		s.stopPinger()
+	}
 	s.local = local.scionAddr()
 	s.remote = remote.scionAddr()
 	s.paths = paths
 	s.current = stats.LowestLatency(s.remote, s.paths)
+	s.mutex.Unlock()
+
+	if restart {
+		s.ensureRunning()
+	}
 }
 
 func (s *PingingSelector) Refresh(paths []*Path) {
@@ -175,11 +232,22 @@ func (s *PingingSelector) PathDown(pf PathFingerprint, pi PathInterface) {
 	s.reselectPath()
 }
 
+func (s *PingingSelector) PathRecovered(pf PathFingerprint) {
+	s.reselectPath()
+}
+
 func (s *PingingSelector) reselectPath() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	prev := -1
+	if s.current >= 0 && s.current < len(s.paths) {
+		prev = s.current
+	}
 	s.current = stats.LowestLatency(s.remote, s.paths)
+	if prev >= 0 && s.current >= 0 && s.current != prev {
+		emitPathSwitched(s.paths[prev], s.paths[s.current])
+	}
 }
 
 func (s *PingingSelector) ensureRunning() {
@@ -189,7 +257,7 @@ func (s *PingingSelector) ensureRunning() {
 	if s.local.IA == s.remote.IA {
 		return
 	}
-	if s.pinger != nil {
+	if s.pinger != nil || s.closed {
 		return
 	}
 	s.pingerCtx, s.pingerCancel = context.WithCancel(context.Background())
@@ -199,11 +267,20 @@ func (s *PingingSelector) ensureRunning() {
 		return
 	}
 	s.pinger = pinger
-	go s.pinger.Drain(s.pingerCtx)
-	go s.run()
+	ctx := s.pingerCtx
+	goroutines.goroutine(goroutineSelectorPinger, func() { pinger.Drain(ctx) })
+	goroutines.goroutine(goroutineSelectorPinger, func() { s.run(ctx, pinger) })
+}
+
+// stopPinger stops the pinger and the goroutines started by ensureRunning.
+// Must be called with the mutex held.
+func (s *PingingSelector) stopPinger() {
+	s.pingerCancel()
+	_ = s.pinger.Close()
+	s.pinger = nil
 }
 
-func (s *PingingSelector) run() {
+func (s *PingingSelector) run(ctx context.Context, pinger *ping.Pinger) {
 	pingTicker := time.NewTicker(s.Interval)
 	pingTimeout := time.NewTimer(0)
 	if !pingTimeout.Stop() {
@@ -215,7 +292,7 @@ func (s *PingingSelector) run() {
 
 	for {
 		select {
-		case <-s.pingerCtx.Done():
+		case <-ctx.Done():
 			return
 		case <-pingTicker.C:
 			numActive := int(atomic.LoadInt64(&s.numActive))
@@ -231,9 +308,9 @@ func (s *PingingSelector) run() {
 				replyPending[p.Fingerprint] = struct{}{}
 			}
 			sequenceNo++
-			s.sendPings(activePaths, sequenceNo)
+			s.sendPings(ctx, pinger, activePaths, sequenceNo)
 			resetTimer(pingTimeout, s.Timeout)
-		case r := <-s.pinger.Replies:
+		case r := <-pinger.Replies:
 			s.handlePingReply(r, replyPending, sequenceNo)
 			if len(replyPending) == 0 {
 				pingTimeout.Stop()
@@ -252,12 +329,12 @@ func (s *PingingSelector) run() {
 	}
 }
 
-func (s *PingingSelector) sendPings(paths []*Path, sequenceNo uint16) {
+func (s *PingingSelector) sendPings(ctx context.Context, pinger *ping.Pinger, paths []*Path, sequenceNo uint16) {
 	for _, p := range paths {
 		remote := s.remote.snetUDPAddr()
 		remote.Path = p.ForwardingPath.dataplanePath
 		remote.NextHop = net.UDPAddrFromAddrPort(p.ForwardingPath.underlay)
-		err := s.pinger.Send(s.pingerCtx, remote, sequenceNo, 16)
+		err := pinger.Send(ctx, remote, sequenceNo, 16)
 		if err != nil {
 			panic(err)
 		}
@@ -275,18 +352,7 @@ func (s *PingingSelector) handlePingReply(reply ping.Reply,
 		if err != nil {
 			return
 		}
-		switch e := reply.Error.(type) { //nolint:errorlint
-		case ping.InternalConnectivityDownError:
-			pi := PathInterface{
-				IA:   IA(e.IA),
-				IfID: IfID(e.Egress),
-			}
-			stats.NotifyPathDown(pf, pi)
-		case ping.ExternalInterfaceDownError:
-			pi := PathInterface{
-				IA:   IA(e.IA),
-				IfID: IfID(e.Interface),
-			}
+		if pi, ok := pathDownInterface(reply.Error); ok {
 			stats.NotifyPathDown(pf, pi)
 		}
 		return
@@ -310,15 +376,19 @@ func (s *PingingSelector) handlePingReply(reply ping.Reply,
 		return
 	}
 	stats.RecordLatency(s.remote, pf, reply.RTT())
+	stats.RecordAlive(s.remote.IA, pf)
 	delete(expectedReplies, pf)
 }
 
 func (s *PingingSelector) Close() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	s.closed = true
 	if s.pinger == nil {
 		return nil
 	}
 	s.pingerCancel()
-	return s.pinger.Close()
+	err := s.pinger.Close()
+	s.pinger = nil
+	return err
 }
@@ -0,0 +1,271 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupSRV looks up DNS SRV records, replaced in tests.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// srvRecords caches the SRV records looked up by ResolveService.
+var srvRecords = &srvCache{}
+
+// pathLatency returns the latency of the path with the lowest latency to
+// dst, and whether there is any path. Replaced in tests.
+var pathLatency = lowestPathLatency
+
+// ServiceOption is an option for ResolveService.
+type ServiceOption interface {
+	applyService(*serviceOptions)
+}
+
+type serviceOption func(*serviceOptions)
+
+func (o serviceOption) applyService(s *serviceOptions) {
+	o(s)
+}
+
+type serviceOptions struct {
+	bestPath bool
+}
+
+// WithBestPath makes ResolveService order the replicas of the same
+// priority by the latency of their best path, instead of randomly by weight.
+// Replicas without any path are ordered last.
+func WithBestPath() ServiceOption {
+	return serviceOption(func(o *serviceOptions) {
+		o.bestPath = true
+	})
+}
+
+// ResolveService returns the addresses of the replicas of a service, from the
+// DNS SRV records of name, e.g. "_ssh._udp.example.org". The target of each
+// record is resolved to a SCION host address with the sources of
+// ResolveUDPAddr, and the port is taken from the record. Targets that can not
+// be resolved are skipped.
+// The addresses are ordered by priority; replicas with the same priority are
+// ordered randomly, proportional to their weight, as described in RFC 2782,
+// or by their path, see WithBestPath. Clients should try them in order.
+//
+// The SRV records are cached for dnsDefaultTTL, as their TTL is not known, and
+// names without records for dnsNegativeTTL.
+//
+// Returns HostNotFoundError if there are no SRV records for name, or if none
+// of the targets could be resolved.
+func ResolveService(ctx context.Context, name string, opts ...ServiceOption) ([]UDPAddr, error) {
+	var o serviceOptions
+	for _, opt := range opts {
+		opt.applyService(&o)
+	}
+	return resolveService(ctx, name, defaultResolver(), o)
+}
+
+// ResolveServiceUDPAddr resolves the address of a service, of the form
+// "host:port" or "host", to the addresses of its replicas, which clients
+// should try in order.
+//
+// A SCION address, or a host name with an explicit port, is resolved with
+// ResolveUDPAddr only. For a host name without port, the hosts added with
+// AddHost and the hosts files are checked first; if the name is found there,
+// the address with defaultPort is returned. Otherwise, the replicas of the
+// service on host are looked up with ResolveService, using the name
+// "_<service>._udp.<host>". If there are none, the name is resolved with the
+// other sources of ResolveUDPAddr, again with defaultPort.
+// The SRV records are cached, see ResolveService.
+func ResolveServiceUDPAddr(ctx context.Context, service, address string, defaultPort uint16,
+	opts ...ServiceOption) ([]UDPAddr, error) {
+
+	var o serviceOptions
+	for _, opt := range opts {
+		opt.applyService(&o)
+	}
+	return resolveServiceUDPAddr(ctx, service, address, defaultPort, localResolver(), defaultResolver(), o)
+}
+
+func resolveServiceUDPAddr(ctx context.Context, service, address string, defaultPort uint16,
+	local, resolver resolver, o serviceOptions) ([]UDPAddr, error) {
+
+	if a, err := ParseUDPAddr(address); err == nil {
+		return []UDPAddr{a}, nil
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		a, err := resolveUDPAddrAt(ctx, address, resolver)
+		if err != nil {
+			return nil, err
+		}
+		return []UDPAddr{a}, nil
+	}
+	if host, err := local.Resolve(ctx, address); err == nil {
+		return []UDPAddr{host.WithPort(defaultPort)}, nil
+	}
+	replicas, err := resolveService(ctx, "_"+service+"._udp."+address, resolver, o)
+	if err == nil {
+		return replicas, nil
+	}
+	host, err := resolver.Resolve(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return []UDPAddr{host.WithPort(defaultPort)}, nil
+}
+
+// replica is a resolved SRV record.
+type replica struct {
+	addr     UDPAddr
+	priority uint16
+	latency  time.Duration
+	hasPath  bool
+}
+
+func resolveService(ctx context.Context, name string, resolver resolver,
+	o serviceOptions) ([]UDPAddr, error) {
+
+	records, err := srvRecords.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var replicas []replica
+	var rerr error
+	for _, r := range records {
+		target := strings.TrimSuffix(r.Target, ".")
+		if target == "" {
+			continue // the service is decidedly not available
+		}
+		host, err := resolver.Resolve(ctx, target)
+		if err != nil {
+			rerr = err
+			continue
+		}
+		replicas = append(replicas, replica{addr: host.WithPort(r.Port), priority: r.Priority})
+	}
+	if len(replicas) == 0 {
+		if rerr != nil && !errors.As(rerr, &HostNotFoundError{}) {
+			return nil, rerr
+		}
+		return nil, HostNotFoundError{name}
+	}
+	if o.bestPath {
+		for i := range replicas {
+			replicas[i].latency, replicas[i].hasPath = pathLatency(ctx, replicas[i].addr.IA)
+		}
+		// The records are ordered by priority, and randomly by weight within
+		// the same priority, which is kept for replicas with equal paths.
+		sort.SliceStable(replicas, func(i, j int) bool {
+			a, b := replicas[i], replicas[j]
+			if a.priority != b.priority {
+				return a.priority < b.priority
+			}
+			if a.hasPath != b.hasPath {
+				return a.hasPath
+			}
+			return a.latency < b.latency
+		})
+	}
+	addrs := make([]UDPAddr, len(replicas))
+	for i, r := range replicas {
+		addrs[i] = r.addr
+	}
+	return addrs, nil
+}
+
+// lowestPathLatency returns the latency of the path with the lowest latency
+// to dst, according to the path metadata, and whether there is any path. The
+// latency in the local AS is 0.
+func lowestPathLatency(ctx context.Context, dst IA) (time.Duration, bool) {
+	if dst == host().ia {
+		return 0, true
+	}
+	paths, err := QueryPaths(ctx, dst, WithPolicy(LowestLatency{}))
+	if err != nil || len(paths) == 0 {
+		return 0, false
+	}
+	if paths[0].Metadata == nil {
+		return 0, true
+	}
+	latency, _ := paths[0].Metadata.latencySum()
+	return latency, true
+}
+
+// srvCache caches the results of SRV lookups, like the dnsResolver caches the
+// TXT and NAPTR records.
+type srvCache struct {
+	mutex sync.Mutex
+	cache map[string]srvCacheEntry
+}
+
+// srvCacheEntry is a cached result of an SRV lookup, either the records or a
+// HostNotFoundError.
+type srvCacheEntry struct {
+	records []*net.SRV
+	err     error
+	expires time.Time
+}
+
+// lookup returns the SRV records for name, from the cache if possible.
+// Returns HostNotFoundError if there are no records for name.
+func (c *srvCache) lookup(ctx context.Context, name string) ([]*net.SRV, error) {
+	if e, ok := c.cached(name, time.Now()); ok {
+		return e.records, e.err
+	}
+	_, records, err := lookupSRV(ctx, "", "", name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		err = HostNotFoundError{name}
+		c.store(name, srvCacheEntry{err: err, expires: time.Now().Add(dnsNegativeTTL)})
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	c.store(name, srvCacheEntry{records: records, expires: time.Now().Add(dnsDefaultTTL)})
+	return records, nil
+}
+
+func (c *srvCache) cached(name string, now time.Time) (srvCacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.cache[name]
+	if !ok || now.After(e.expires) {
+		return srvCacheEntry{}, false
+	}
+	return e, true
+}
+
+func (c *srvCache) store(name string, e srvCacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cache == nil {
+		c.cache = make(map[string]srvCacheEntry)
+	}
+	if len(c.cache) >= dnsMaxCacheEntries {
+		now := time.Now()
+		for n, e := range c.cache {
+			if now.After(e.expires) {
+				delete(c.cache, n)
+			}
+		}
+		if len(c.cache) >= dnsMaxCacheEntries {
+			clear(c.cache)
+		}
+	}
+	c.cache[name] = e
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveService(t *testing.T) {
+	defer func(orig func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = orig
+	}(lookupSRV)
+	defer func(orig func(context.Context, IA) (time.Duration, bool)) { pathLatency = orig }(pathLatency)
+	defer func(orig *srvCache) { srvRecords = orig }(srvRecords)
+	srvRecords = &srvCache{}
+
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		switch name {
+		case "_ssh._udp.example.org":
+			// ordered by priority, and by weight within the same priority
+			return "", []*net.SRV{
+				{Target: "far.example.org.", Port: 22, Priority: 10},
+				{Target: "unknown.example.org.", Port: 22, Priority: 10},
+				{Target: "near.example.org.", Port: 2222, Priority: 10},
+				{Target: "nopath.example.org.", Port: 22, Priority: 10},
+				{Target: "backup.example.org.", Port: 22, Priority: 20},
+			}, nil
+		case "_ssh._udp.unresolvable.example.org":
+			return "", []*net.SRV{{Target: "unknown.example.org.", Port: 22}}, nil
+		case "_ssh._udp.broken.example.org":
+			return "", nil, errors.New("server failure")
+		}
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	latencies := map[IA]time.Duration{
+		MustParseIA("1-ff00:0:1"): 100 * time.Millisecond,
+		MustParseIA("1-ff00:0:2"): 10 * time.Millisecond,
+		MustParseIA("1-ff00:0:4"): 200 * time.Millisecond,
+	}
+	pathLatency = func(ctx context.Context, dst IA) (time.Duration, bool) {
+		latency, ok := latencies[dst]
+		return latency, ok
+	}
+	resolver := dummyResolver{map[string]scionAddr{
+		"far.example.org":    mustParse("1-ff00:0:1,[192.0.2.1]"),
+		"near.example.org":   mustParse("1-ff00:0:2,[192.0.2.2]"),
+		"nopath.example.org": mustParse("1-ff00:0:3,[192.0.2.3]"),
+		"backup.example.org": mustParse("1-ff00:0:4,[192.0.2.4]"),
+	}}
+	ctx := context.Background()
+
+	addrs, err := resolveService(ctx, "_ssh._udp.example.org", resolver, serviceOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []UDPAddr{
+		MustParseUDPAddr("1-ff00:0:1,[192.0.2.1]:22"),
+		MustParseUDPAddr("1-ff00:0:2,[192.0.2.2]:2222"),
+		MustParseUDPAddr("1-ff00:0:3,[192.0.2.3]:22"),
+		MustParseUDPAddr("1-ff00:0:4,[192.0.2.4]:22"),
+	}, addrs)
+
+	addrs, err = resolveService(ctx, "_ssh._udp.example.org", resolver, serviceOptions{bestPath: true})
+	require.NoError(t, err)
+	assert.Equal(t, []UDPAddr{
+		MustParseUDPAddr("1-ff00:0:2,[192.0.2.2]:2222"),
+		MustParseUDPAddr("1-ff00:0:1,[192.0.2.1]:22"),
+		MustParseUDPAddr("1-ff00:0:3,[192.0.2.3]:22"),
+		MustParseUDPAddr("1-ff00:0:4,[192.0.2.4]:22"), // lower priority
+	}, addrs)
+
+	_, err = resolveService(ctx, "_ssh._udp.unresolvable.example.org", resolver, serviceOptions{})
+	assertErrHostNotFound(t, err)
+	_, err = resolveService(ctx, "_ssh._udp.other.example.org", resolver, serviceOptions{})
+	assertErrHostNotFound(t, err)
+	_, err = resolveService(ctx, "_ssh._udp.broken.example.org", resolver, serviceOptions{})
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &HostNotFoundError{}))
+}
+
+func TestResolveServiceUDPAddr(t *testing.T) {
+	defer func(orig func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = orig
+	}(lookupSRV)
+	defer func(orig *srvCache) { srvRecords = orig }(srvRecords)
+	srvRecords = &srvCache{}
+
+	lookups := map[string]int{}
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		lookups[name]++
+		if name == "_ssh._udp.example.org" {
+			return "", []*net.SRV{
+				{Target: "a.example.org.", Port: 2222, Priority: 10},
+				{Target: "b.example.org.", Port: 22, Priority: 20},
+			}, nil
+		}
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	local := dummyResolver{map[string]scionAddr{
+		"local.example.org": mustParse("1-ff00:0:1,[192.0.2.1]"),
+	}}
+	resolver := resolverList{local, dummyResolver{map[string]scionAddr{
+		"example.org":       mustParse("1-ff00:0:2,[192.0.2.2]"),
+		"a.example.org":     mustParse("1-ff00:0:3,[192.0.2.3]"),
+		"b.example.org":     mustParse("1-ff00:0:4,[192.0.2.4]"),
+		"other.example.org": mustParse("1-ff00:0:5,[192.0.2.5]"),
+	}}}
+	ctx := context.Background()
+
+	cases := []struct {
+		address  string
+		expected []UDPAddr
+		lookups  int
+	}{
+		{"1-ff00:0:9,[192.0.2.9]:80", []UDPAddr{MustParseUDPAddr("1-ff00:0:9,[192.0.2.9]:80")}, 0},
+		// an explicit port is not replaced by the port of the SRV records
+		{"example.org:8022", []UDPAddr{MustParseUDPAddr("1-ff00:0:2,[192.0.2.2]:8022")}, 0},
+		// the hosts files take precedence over the SRV records
+		{"local.example.org", []UDPAddr{MustParseUDPAddr("1-ff00:0:1,[192.0.2.1]:22")}, 0},
+		{"example.org", []UDPAddr{
+			MustParseUDPAddr("1-ff00:0:3,[192.0.2.3]:2222"),
+			MustParseUDPAddr("1-ff00:0:4,[192.0.2.4]:22"),
+		}, 1},
+		{"other.example.org", []UDPAddr{MustParseUDPAddr("1-ff00:0:5,[192.0.2.5]:22")}, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.address, func(t *testing.T) {
+			clear(lookups)
+			for i := 0; i < 2; i++ {
+				addrs, err := resolveServiceUDPAddr(ctx, "ssh", c.address, 22, local, resolver, serviceOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, c.expected, addrs)
+			}
+			total := 0
+			for _, n := range lookups {
+				total += n
+			}
+			assert.Equal(t, c.lookups, total, "SRV lookups are cached")
+		})
+	}
+
+	_, err := resolveServiceUDPAddr(ctx, "ssh", "unknown.example.org", 22, local, resolver, serviceOptions{})
+	assertErrHostNotFound(t, err)
+}
@@ -28,6 +28,13 @@ func init() {
 type PathStats struct {
 	// Was notified down at the recorded time (0 for never notified down)
 	IsNotifiedDown time.Time
+	// Was observed alive after the last down notification at the recorded
+	// time (0 for never observed alive after a down notification)
+	IsRecovered time.Time
+	// MTU learned from SCMP packet too big messages (0 if none received),
+	// and the time at which it was last lowered.
+	DiscoveredMTU     uint16
+	DiscoveredMTUTime time.Time
 }
 
 type PathInterfaceStats struct {
@@ -50,6 +57,12 @@ type pathDownNotifyee interface {
 	PathDown(PathFingerprint, PathInterface)
 }
 
+// pathRecoveredNotifyee is optionally implemented by a pathDownNotifyee, to
+// be informed when a path that was previously notified down has recovered.
+type pathRecoveredNotifyee interface {
+	PathRecovered(PathFingerprint)
+}
+
 type pathStatsDB struct {
 	mutex sync.RWMutex
 	// TODO: this needs a fixed/max capacity and least-recently-used spill over
@@ -82,6 +95,19 @@ func (s *pathStatsDB) RecordLatency(dst scionAddr, p PathFingerprint, latency ti
 	}
 	dstStats.Latency[p] = dstStats.Latency[p].insert(latency)
 	s.destinations[dst] = dstStats
+	currentMetrics().recordLatency(dst.IA, p, latency)
+}
+
+// lastLatency returns the most recent latency sample for the path to dst.
+func (s *pathStatsDB) lastLatency(dst scionAddr, p PathFingerprint) (StatsLatencySample, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	samples := s.destinations[dst].Latency[p]
+	if len(samples) == 0 {
+		return StatsLatencySample{}, false
+	}
+	return samples[0], true
 }
 
 // LowestLatency returns the index of the path with lowest recorded latency.
@@ -150,6 +176,19 @@ func (s *pathStatsDB) IsMoreAlive(a, b *Path) bool {
 	return newestA.Before(oldestB.Add(-pathDownNotificationTimeout)) // XXX: what is this value, what does it mean?
 }
 
+// IsNotifiedDown checks whether there are any down notifications for the path
+// or any of its interfaces that have not been cleared by a recovery. Like in
+// IsMoreAlive, notifications older than pathDownNotificationTimeout are
+// ignored, so that a path is eventually used again even if no recovery is
+// observed, e.g. as recovery probing is disabled.
+func (s *pathStatsDB) IsNotifiedDown(p *Path) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	newest := s.newestDownNotification(p)
+	return !newest.IsZero() && time.Since(newest) < pathDownNotificationTimeout
+}
+
 // newestDownNotification returns the time of the newest relevant down
 // notification for path p.
 func (s *pathStatsDB) newestDownNotification(p *Path) time.Time {
@@ -193,8 +232,82 @@ func (s *pathStatsDB) unsubscribe(subscriber pathDownNotifyee) {
 }
 
 func (s *pathStatsDB) NotifyPathDown(pf PathFingerprint, pi PathInterface) {
+	currentMetrics().recordPathDown()
 	s.recordPathDown(pf, pi)
 	s.notifier.notifyAsync(pf, pi)
+	events.emit(Event{Type: EventPathDown, Fingerprint: pf, Interface: pi})
+}
+
+// RecordAlive records that the path was observed to work, e.g. by a ping
+// reply. Emits an EventPathRecovered, if this is the first such observation
+// after a down notification for the path.
+func (s *pathStatsDB) RecordAlive(dst IA, pf PathFingerprint) {
+	s.mutex.Lock()
+	ps, ok := s.paths[pf]
+	recovered := ok && !ps.IsNotifiedDown.IsZero() && !ps.IsRecovered.After(ps.IsNotifiedDown)
+	if recovered {
+		ps.IsRecovered = time.Now()
+		s.paths[pf] = ps
+	}
+	s.mutex.Unlock()
+
+	if recovered {
+		events.emit(Event{Type: EventPathRecovered, Destination: dst, Fingerprint: pf})
+	}
+}
+
+// NotifyPathRecovered clears the down notifications for the path p and all
+// of its interfaces, after p was observed to work again (e.g. by the
+// recoveryProber). Subscribers implementing PathRecovered are notified so that
+// they can fail back to p.
+func (s *pathStatsDB) NotifyPathRecovered(p *Path) {
+	s.recordPathRecovered(p)
+	s.notifier.notifyRecoveredAsync(p.Fingerprint)
+	events.emit(Event{Type: EventPathRecovered, Destination: p.Destination, Fingerprint: p.Fingerprint})
+}
+
+func (s *pathStatsDB) recordPathRecovered(p *Path) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ps := s.paths[p.Fingerprint]
+	ps.IsNotifiedDown = time.Time{}
+	ps.IsRecovered = time.Now()
+	s.paths[p.Fingerprint] = ps
+	if p.Metadata != nil {
+		for _, pi := range p.Metadata.Interfaces {
+			delete(s.interfaces, pi)
+		}
+	}
+}
+
+// RecordPathMTU records the path MTU reported by an SCMP packet too big
+// message for the path. The lowest MTU reported is kept until it expires
+// after pathMTUTimeout. MTUs below minPathMTU are ignored.
+func (s *pathStatsDB) RecordPathMTU(pf PathFingerprint, mtu uint16) {
+	if mtu < minPathMTU {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	ps := s.paths[pf]
+	if ps.DiscoveredMTU == 0 || mtu < ps.DiscoveredMTU || now.Sub(ps.DiscoveredMTUTime) > pathMTUTimeout {
+		ps.DiscoveredMTU = mtu
+		ps.DiscoveredMTUTime = now
+		s.paths[pf] = ps
+	}
+}
+
+// PathMTU returns the path MTU recorded with RecordPathMTU, or 0 if none was
+// recorded or it has expired.
+func (s *pathStatsDB) PathMTU(pf PathFingerprint) uint16 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ps := s.paths[pf]
+	if time.Since(ps.DiscoveredMTUTime) > pathMTUTimeout {
+		return 0
+	}
+	return ps.DiscoveredMTU
 }
 
 func (s *pathStatsDB) recordPathDown(pf PathFingerprint, pi PathInterface) {
@@ -257,11 +370,15 @@ func (n *pathDownNotifier) run() {
 	notifications := make(chan pathDownNotification, pathDownNotificationChannelCapacity)
 	n.notifications = notifications
 
-	go func() {
+	goroutines.goroutine(goroutineNotifier, func() {
 		for notification := range notifications {
-			n.notify(notification.Fingerprint, notification.Interface)
+			if notification.Recovered {
+				n.notifyRecovered(notification.Fingerprint)
+			} else {
+				n.notify(notification.Fingerprint, notification.Interface)
+			}
 		}
-	}()
+	})
 }
 
 func (n *pathDownNotifier) notifyAsync(pf PathFingerprint, pi PathInterface) {
@@ -269,6 +386,11 @@ func (n *pathDownNotifier) notifyAsync(pf PathFingerprint, pi PathInterface) {
 	n.notifications <- pathDownNotification{Fingerprint: pf, Interface: pi}
 }
 
+func (n *pathDownNotifier) notifyRecoveredAsync(pf PathFingerprint) {
+	n.runOnce.Do(n.run)
+	n.notifications <- pathDownNotification{Fingerprint: pf, Recovered: true}
+}
+
 func (n *pathDownNotifier) notify(pf PathFingerprint, pi PathInterface) {
 	n.mutex.Lock()
 	defer n.mutex.Unlock()
@@ -278,7 +400,21 @@ func (n *pathDownNotifier) notify(pf PathFingerprint, pi PathInterface) {
 	}
 }
 
+func (n *pathDownNotifier) notifyRecovered(pf PathFingerprint) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, s := range n.subscribers {
+		if r, ok := s.(pathRecoveredNotifyee); ok {
+			r.PathRecovered(pf)
+		}
+	}
+}
+
 type pathDownNotification struct {
 	Fingerprint PathFingerprint
 	Interface   PathInterface
+	// Recovered is set for notifications of recovered paths, see
+	// NotifyPathRecovered. Interface is unset in this case.
+	Recovered bool
 }
@@ -55,6 +55,23 @@ func (s *dummyPathDownSubscriber) PathDown(_ PathFingerprint, _ PathInterface) {
 	s.notified <- struct{}{}
 }
 
+func TestIsNotifiedDown(t *testing.T) {
+	stats := newPathStatsDB()
+	pi := PathInterface{IA: MustParseIA("1-ff00:0:110"), IfID: 5}
+	p := &Path{Fingerprint: "p", Metadata: &PathMetadata{Interfaces: []PathInterface{pi}}}
+	assert.False(t, stats.IsNotifiedDown(p))
+
+	stats.NotifyPathDown(p.Fingerprint, PathInterface{})
+	assert.True(t, stats.IsNotifiedDown(p))
+
+	// notifications expire, even without recovery
+	stats.paths[p.Fingerprint] = PathStats{IsNotifiedDown: time.Now().Add(-pathDownNotificationTimeout)}
+	assert.False(t, stats.IsNotifiedDown(p))
+
+	stats.interfaces[pi] = PathInterfaceStats{IsNotifiedDown: time.Now()}
+	assert.True(t, stats.IsNotifiedDown(p))
+}
+
 func TestRecordLatency(t *testing.T) {
 	stats = newPathStatsDB()
 
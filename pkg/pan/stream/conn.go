@@ -0,0 +1,482 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stream provides reliable, ordered delivery of a byte stream over pan
+// UDP connections, for applications that need reliability but not the
+// overhead of QUIC and TLS, e.g. sensor data collection.
+//
+// The stream is split into segments that fit the path MTU. Segments are
+// acknowledged cumulatively and retransmitted after a timeout derived from the
+// measured round trip time, or after three duplicate acknowledgments. Up to
+// windowSize segments are in flight; there is no further congestion control.
+// The receiver buffers at most receiveBufferSize bytes that were not read yet
+// and announces the free space in each acknowledgment; the sender does not
+// send more data than fits, except for a single segment that probes whether
+// the space opened up.
+// After repeated timeouts, a dialed stream reports the current path as down
+// to its selector, so that it fails over to another path.
+// The data is neither authenticated nor encrypted.
+package stream
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// headerLen is the length of the header of each packet: the packet type
+	// (1 byte) and the sequence or acknowledgment number (4 bytes).
+	headerLen = 5
+
+	// ackLen is the length of an acknowledgment: the header and the free
+	// space in the receive buffer (4 bytes).
+	ackLen = headerLen + 4
+
+	typeData = 1
+	typeAck  = 2
+	typeFin  = 3
+
+	// windowSize is the maximum number of unacknowledged segments.
+	windowSize = 64
+	// receiveBufferSize is the maximum number of bytes buffered by the
+	// receiver, either not read yet or received out of order.
+	receiveBufferSize = 256 * 1024
+	// fallbackSegmentSize is the size of the segments if the path MTU is
+	// unknown.
+	fallbackSegmentSize = 1000
+
+	initialRTO = 200 * time.Millisecond
+	minRTO     = 50 * time.Millisecond
+	maxRTO     = 5 * time.Second
+	// failoverTimeouts is the number of consecutive timeouts after which the
+	// path is reported as down.
+	failoverTimeouts = 2
+	// maxTimeouts is the number of consecutive timeouts after which the
+	// stream fails with ErrTimeout.
+	maxTimeouts = 8
+	// dupAcksRetransmit is the number of duplicate acknowledgments after which
+	// the first unacknowledged segment is retransmitted.
+	dupAcksRetransmit = 3
+	// closeTimeout is the maximum time Close waits for the end of the stream
+	// to be acknowledged.
+	closeTimeout = 5 * time.Second
+)
+
+// ErrTimeout is returned when the remote did not acknowledge any data for
+// maxTimeouts consecutive retransmission timeouts.
+var ErrTimeout = errors.New("stream: remote not responding")
+
+// transport sends the packets of a stream.
+type transport struct {
+	write func(b []byte) error
+	// mtu returns the maximum payload size of a packet, or 0 if unknown.
+	mtu func() int
+	// failover is called after failoverTimeouts consecutive timeouts.
+	failover func()
+	// close is called when the stream is closed.
+	close func() error
+
+	local, remote net.Addr
+}
+
+// segment is an unacknowledged segment.
+type segment struct {
+	packet        []byte
+	sent          time.Time
+	retransmitted bool
+}
+
+// received is a segment received out of order.
+type received struct {
+	payload []byte
+	fin     bool
+}
+
+// Conn is a reliable, ordered byte stream, see Dial and Listener.Accept.
+type Conn struct {
+	transport transport
+
+	mutex sync.Mutex
+	cond  *sync.Cond
+	err   error // set if the stream failed or is closed
+	// sender state
+	sendBase uint32     // sequence number of unacked[0]
+	unacked  []*segment // in order of the sequence numbers
+	finSent  bool
+	dupAcks  int
+	timer    *time.Timer
+	timeouts int
+	rto      time.Duration
+	srtt     time.Duration
+	rttvar   time.Duration
+	// sendWindow is the free space in the receive buffer of the remote, from
+	// the last acknowledgment.
+	sendWindow int
+	// receiver state
+	recvNext        uint32 // next expected sequence number
+	outOfOrder      map[uint32]received
+	outOfOrderBytes int
+	finReceived     bool // set once the fin was received in order
+	readBuf         []byte
+	advertised      int // receive window of the last acknowledgment
+}
+
+func newConn(t transport) *Conn {
+	c := &Conn{
+		transport:  t,
+		rto:        initialRTO,
+		sendWindow: receiveBufferSize,
+		outOfOrder: make(map[uint32]received),
+		advertised: receiveBufferSize,
+	}
+	c.cond = sync.NewCond(&c.mutex)
+	c.timer = time.AfterFunc(time.Hour, c.onTimeout)
+	c.timer.Stop()
+	return c
+}
+
+// Read reads data from the stream. Returns io.EOF once the remote closed the
+// stream and all data was read.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	for len(c.readBuf) == 0 && !c.finReceived && c.err == nil {
+		c.cond.Wait()
+	}
+	var n int
+	var err error
+	var update []byte
+	switch {
+	case len(c.readBuf) > 0:
+		n = copy(b, c.readBuf)
+		c.readBuf = c.readBuf[n:]
+		update = c.windowUpdate()
+	case c.finReceived:
+		err = io.EOF
+	default:
+		err = c.err
+	}
+	c.mutex.Unlock()
+	if update != nil {
+		_ = c.transport.write(update)
+	}
+	return n, err
+}
+
+// Write writes data to the stream. Blocks while windowSize segments are
+// unacknowledged, or while the receive buffer of the remote is full.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.finSent && c.err == nil {
+		return 0, net.ErrClosed
+	}
+	n := 0
+	for n < len(b) {
+		size := min(len(b)-n, c.segmentSize())
+		for !c.canSend(size) && c.err == nil {
+			c.cond.Wait()
+		}
+		if c.err != nil {
+			return n, c.err
+		}
+		if err := c.send(typeData, b[n:n+size]); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// Close sends the end of the stream and waits until the remote acknowledged
+// all data, at most a few seconds, then closes the stream in both directions.
+// Data received after Close is dropped.
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	if c.err == nil && !c.finSent {
+		_ = c.send(typeFin, nil)
+		c.finSent = true
+	}
+	// If the remote already closed its end, it may not acknowledge the fin.
+	if !c.finReceived {
+		deadline := time.AfterFunc(closeTimeout, func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			c.fail(ErrTimeout)
+		})
+		for len(c.unacked) > 0 && c.err == nil {
+			c.cond.Wait()
+		}
+		deadline.Stop()
+	}
+	c.fail(net.ErrClosed)
+	c.mutex.Unlock()
+	return c.transport.close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.transport.local
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.transport.remote
+}
+
+// segmentSize returns the maximum payload size of a segment.
+func (c *Conn) segmentSize() int {
+	if mtu := c.transport.mtu(); mtu > headerLen {
+		return mtu - headerLen
+	}
+	return fallbackSegmentSize
+}
+
+// canSend returns whether a new segment with size bytes of payload can be
+// sent. If no segment is in flight, one is always sent, to probe whether the
+// receive buffer of the remote has space again. Must be called with the mutex
+// held.
+func (c *Conn) canSend(size int) bool {
+	if len(c.unacked) == 0 {
+		return true
+	}
+	return len(c.unacked) < windowSize && c.inFlight()+size <= c.sendWindow
+}
+
+// inFlight returns the number of unacknowledged payload bytes. Must be called
+// with the mutex held.
+func (c *Conn) inFlight() int {
+	n := 0
+	for _, s := range c.unacked {
+		n += len(s.packet) - headerLen
+	}
+	return n
+}
+
+// send sends a new segment and adds it to the unacknowledged segments. Must be
+// called with the mutex held.
+func (c *Conn) send(typ byte, payload []byte) error {
+	seq := c.sendBase + uint32(len(c.unacked))
+	packet := make([]byte, headerLen+len(payload))
+	packet[0] = typ
+	binary.BigEndian.PutUint32(packet[1:], seq)
+	copy(packet[headerLen:], payload)
+	c.unacked = append(c.unacked, &segment{packet: packet, sent: time.Now()})
+	if len(c.unacked) == 1 {
+		c.timer.Reset(c.rto)
+	}
+	return c.transport.write(packet)
+}
+
+// fail terminates the stream with err, if it is not terminated yet. Must be
+// called with the mutex held.
+func (c *Conn) fail(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.timer.Stop()
+	c.cond.Broadcast()
+}
+
+// isFirstSegment returns whether packet is the first data or fin segment of a
+// stream.
+func isFirstSegment(packet []byte) bool {
+	typ := packet[0]
+	return (typ == typeData || typ == typeFin) && binary.BigEndian.Uint32(packet[1:headerLen]) == 0
+}
+
+// handle processes a packet received from the remote.
+func (c *Conn) handle(b []byte) {
+	if len(b) < headerLen {
+		return
+	}
+	typ, num := b[0], binary.BigEndian.Uint32(b[1:headerLen])
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return
+	}
+	var ack []byte
+	switch typ {
+	case typeData, typeFin:
+		ack = c.receive(num, typ == typeFin, b[headerLen:])
+	case typeAck:
+		if len(b) >= ackLen {
+			c.acknowledge(num, int(binary.BigEndian.Uint32(b[headerLen:ackLen])))
+		}
+	}
+	c.mutex.Unlock()
+	// The acknowledgment is written without holding the mutex, so that a
+	// blocking write does not stall the reader and the sender.
+	if ack != nil {
+		_ = c.transport.write(ack)
+	}
+}
+
+// receive processes a data or fin segment and returns its acknowledgment.
+// Segments that do not fit into the receive buffer are dropped. Must be called
+// with the mutex held.
+func (c *Conn) receive(seq uint32, fin bool, payload []byte) []byte {
+	offset := int32(seq - c.recvNext)
+	if offset >= 0 && offset < windowSize && !c.finReceived &&
+		len(c.readBuf)+c.outOfOrderBytes+len(payload) <= receiveBufferSize {
+		if _, ok := c.outOfOrder[seq]; !ok {
+			c.outOfOrder[seq] = received{payload: append([]byte{}, payload...), fin: fin}
+			c.outOfOrderBytes += len(payload)
+		}
+		for !c.finReceived {
+			r, ok := c.outOfOrder[c.recvNext]
+			if !ok {
+				break
+			}
+			delete(c.outOfOrder, c.recvNext)
+			c.outOfOrderBytes -= len(r.payload)
+			c.readBuf = append(c.readBuf, r.payload...)
+			c.finReceived = r.fin
+			c.recvNext++
+		}
+		c.cond.Broadcast()
+	}
+	// Segments before recvNext are acknowledged again, as the previous
+	// acknowledgment may have been lost.
+	return c.ack()
+}
+
+// ack returns an acknowledgment of all segments before recvNext, with the
+// free space in the receive buffer. Data received out of order is not
+// subtracted, as the sender counts it as in flight. Must be called with the
+// mutex held.
+func (c *Conn) ack() []byte {
+	c.advertised = receiveBufferSize - len(c.readBuf)
+	ack := make([]byte, ackLen)
+	ack[0] = typeAck
+	binary.BigEndian.PutUint32(ack[1:], c.recvNext)
+	binary.BigEndian.PutUint32(ack[headerLen:], uint32(c.advertised))
+	return ack
+}
+
+// windowUpdate returns an acknowledgment if the receive buffer was announced
+// as more than half full and has since been read to less than half, so that
+// the sender does not need to wait for its next probe. Returns nil otherwise.
+// Must be called with the mutex held.
+func (c *Conn) windowUpdate() []byte {
+	if c.advertised >= receiveBufferSize/2 || len(c.readBuf) > receiveBufferSize/2 || c.finReceived {
+		return nil
+	}
+	return c.ack()
+}
+
+// acknowledge processes a cumulative acknowledgment of all segments before
+// next, with the free space in the receive buffer of the remote. Must be
+// called with the mutex held.
+func (c *Conn) acknowledge(next uint32, window int) {
+	acked := int32(next - c.sendBase)
+	if acked < 0 || int(acked) > len(c.unacked) {
+		return
+	}
+	prevWindow := c.sendWindow
+	if window != prevWindow {
+		c.sendWindow = window
+		c.cond.Broadcast()
+	}
+	if acked == 0 {
+		switch inFlight := c.inFlight(); {
+		case len(c.unacked) == 0:
+		case inFlight > window:
+			// The remote is responding, but drops the segments as its
+			// receive buffer is full. They are retransmitted on timeout,
+			// without failing over or giving up.
+			c.timeouts = 0
+		case inFlight > prevWindow:
+			// The receive buffer was read, the dropped segments are sent
+			// again right away.
+			for _, s := range c.unacked {
+				c.retransmit(s)
+			}
+		default:
+			c.dupAcks++
+			if c.dupAcks == dupAcksRetransmit {
+				c.retransmit(c.unacked[0])
+			}
+		}
+		return
+	}
+	now := time.Now()
+	for _, s := range c.unacked[:acked] {
+		// Karn's algorithm: the RTT of retransmitted segments is ambiguous.
+		if !s.retransmitted {
+			c.updateRTT(now.Sub(s.sent))
+		}
+	}
+	c.unacked = c.unacked[acked:]
+	c.sendBase = next
+	c.dupAcks = 0
+	c.timeouts = 0
+	if len(c.unacked) > 0 {
+		c.timer.Reset(c.rto)
+	} else {
+		c.timer.Stop()
+	}
+	c.cond.Broadcast()
+}
+
+// updateRTT updates the retransmission timeout with a new RTT sample, as
+// described in RFC 6298.
+func (c *Conn) updateRTT(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		diff := c.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		c.rttvar = (3*c.rttvar + diff) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = min(max(c.srtt+4*c.rttvar, minRTO), maxRTO)
+}
+
+// retransmit sends s again. Must be called with the mutex held.
+func (c *Conn) retransmit(s *segment) {
+	s.sent = time.Now()
+	s.retransmitted = true
+	_ = c.transport.write(s.packet)
+}
+
+// onTimeout retransmits all unacknowledged segments and backs off the
+// retransmission timeout.
+func (c *Conn) onTimeout() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil || len(c.unacked) == 0 {
+		return
+	}
+	c.timeouts++
+	if c.timeouts > maxTimeouts {
+		c.fail(ErrTimeout)
+		return
+	}
+	if c.timeouts%failoverTimeouts == 0 && c.transport.failover != nil {
+		c.transport.failover()
+	}
+	c.rto = min(2*c.rto, maxRTO)
+	c.dupAcks = 0
+	for _, s := range c.unacked {
+		c.retransmit(s)
+	}
+	c.timer.Reset(c.rto)
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stream
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLink delivers the packets written by one stream to the other, dropping
+// and delaying packets at random.
+type testLink struct {
+	mutex    sync.Mutex
+	rand     *rand.Rand
+	loss     float64
+	drop     atomic.Bool // drop all packets
+	peer     func() *Conn
+	failover atomic.Int32
+}
+
+func (l *testLink) write(b []byte) error {
+	l.mutex.Lock()
+	lost := l.rand.Float64() < l.loss
+	delay := time.Duration(l.rand.Intn(2000)) * time.Microsecond
+	l.mutex.Unlock()
+	if lost || l.drop.Load() {
+		return nil
+	}
+	packet := bytes.Clone(b)
+	// The random delay reorders the packets.
+	time.AfterFunc(delay, func() { l.peer().handle(packet) })
+	return nil
+}
+
+// testStreamPair returns two streams connected by lossy links.
+func testStreamPair(loss float64) (*Conn, *Conn, *testLink) {
+	var a, b *Conn
+	ab := &testLink{rand: rand.New(rand.NewSource(1)), loss: loss, peer: func() *Conn { return b }}
+	ba := &testLink{rand: rand.New(rand.NewSource(2)), loss: loss, peer: func() *Conn { return a }}
+	newTestConn := func(l *testLink) *Conn {
+		return newConn(transport{
+			write:    l.write,
+			mtu:      func() int { return 100 + headerLen },
+			failover: func() { l.failover.Add(1) },
+			close:    func() error { return nil },
+		})
+	}
+	a, b = newTestConn(ab), newTestConn(ba)
+	return a, b, ab
+}
+
+func TestStreamTransfer(t *testing.T) {
+	a, b, _ := testStreamPair(0.1)
+	data := make([]byte, 20000) // 200 segments
+	rand.New(rand.NewSource(3)).Read(data)
+
+	go func() {
+		_, err := a.Write(data)
+		assert.NoError(t, err)
+		assert.NoError(t, a.Close())
+	}()
+	received := make(chan []byte)
+	go func() {
+		r, err := io.ReadAll(b)
+		assert.NoError(t, err)
+		received <- r
+	}()
+	select {
+	case r := <-received:
+		assert.Equal(t, data, r)
+	case <-time.After(30 * time.Second):
+		require.FailNow(t, "transfer did not complete")
+	}
+	assert.NoError(t, b.Close())
+}
+
+func TestStreamFailover(t *testing.T) {
+	a, _, ab := testStreamPair(0)
+	ab.drop.Store(true)
+	_, err := a.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return ab.failover.Load() > 0 },
+		5*time.Second, 10*time.Millisecond)
+
+	// The segment is delivered once the link is up again.
+	ab.drop.Store(false)
+	assert.Eventually(t, func() bool {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		return len(a.unacked) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	a, b, ab := testStreamPair(0)
+	data := make([]byte, 2*receiveBufferSize)
+	rand.New(rand.NewSource(4)).Read(data)
+
+	written := make(chan struct{})
+	go func() {
+		_, err := a.Write(data)
+		assert.NoError(t, err)
+		close(written)
+	}()
+	// Without reading, the receiver buffers at most receiveBufferSize bytes
+	// and the sender blocks, without failing.
+	assert.Eventually(t, func() bool {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		return len(b.readBuf) > receiveBufferSize-a.segmentSize()
+	}, 10*time.Second, 10*time.Millisecond)
+	time.Sleep(2 * initialRTO)
+	b.mutex.Lock()
+	assert.LessOrEqual(t, len(b.readBuf)+b.outOfOrderBytes, receiveBufferSize)
+	b.mutex.Unlock()
+	select {
+	case <-written:
+		require.FailNow(t, "write did not block")
+	default:
+	}
+
+	received := make([]byte, len(data))
+	_, err := io.ReadFull(b, received)
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+	<-written
+	assert.Zero(t, ab.failover.Load())
+}
+
+func TestUpdateRTT(t *testing.T) {
+	c := newConn(transport{})
+	c.updateRTT(100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, c.srtt)
+	assert.Equal(t, 300*time.Millisecond, c.rto)
+	c.updateRTT(time.Millisecond)
+	assert.Less(t, c.srtt, 100*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		c.updateRTT(time.Millisecond)
+	}
+	assert.Equal(t, minRTO, c.rto)
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stream
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+// Dial opens a stream to the remote address, over a pan.Conn dialed with
+// opts. The paths are chosen by selector; if it is nil, a
+// pan.DefaultSelector is used. When the remote does not acknowledge data
+// for a few retransmission timeouts, the current path is reported to the
+// selector with PathDown, so that the stream fails over to another path.
+// Any selector set in opts is replaced by selector.
+//
+// No packets are exchanged until the first Write; the remote accepts the
+// stream when the first data arrives.
+func Dial(ctx context.Context, remote pan.UDPAddr, selector pan.Selector,
+	opts ...pan.DialOption) (*Conn, error) {
+
+	if selector == nil {
+		selector = pan.NewDefaultSelector()
+	}
+	opts = append(opts, pan.WithSelector(selector))
+	conn, err := pan.DialUDP(ctx, remote, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(transport{
+		write: func(b []byte) error {
+			_, err := conn.Write(b)
+			return err
+		},
+		mtu: conn.MTU,
+		failover: func() {
+			if p := conn.GetPath(); p != nil {
+				// The interface is unknown, the selector matches the
+				// fingerprint.
+				selector.PathDown(p.Fingerprint, pan.PathInterface{})
+			}
+		},
+		close:  conn.Close,
+		local:  conn.LocalAddr(),
+		remote: conn.RemoteAddr(),
+	})
+	go c.readLoop(conn)
+	return c, nil
+}
+
+// readLoop passes the packets read from conn to c until conn is closed.
+func (c *Conn) readLoop(conn net.Conn) {
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if errors.Is(err, net.ErrClosed) {
+			c.mutex.Lock()
+			c.fail(net.ErrClosed)
+			c.mutex.Unlock()
+			return
+		} else if err != nil {
+			continue
+		}
+		c.handle(buf[:n])
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+// acceptQueueLen is the number of streams that can wait in Accept.
+const acceptQueueLen = 16
+
+// Listener accepts streams from remotes, over a single pan.ListenConn.
+// The streams are demultiplexed by the remote address.
+type Listener struct {
+	conn     pan.ListenConn
+	accepted chan *Conn
+
+	mutex   sync.Mutex
+	streams map[pan.UDPAddr]*Conn
+	closed  bool
+	err     error
+}
+
+// Listen opens a pan.ListenConn with opts and accepts streams on it.
+func Listen(ctx context.Context, opts ...pan.ListenOption) (*Listener, error) {
+	conn, err := pan.ListenUDP(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		conn:     conn,
+		accepted: make(chan *Conn, acceptQueueLen),
+		streams:  make(map[pan.UDPAddr]*Conn),
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+// Accept waits for and returns the next stream.
+func (l *Listener) Accept() (*Conn, error) {
+	c, ok := <-l.accepted
+	if !ok {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		return nil, l.err
+	}
+	return c, nil
+}
+
+// Close closes the listener and the underlying pan.ListenConn. Streams
+// accepted before are closed without waiting for the remote.
+func (l *Listener) Close() error {
+	l.mutex.Lock()
+	l.closed = true
+	streams := make([]*Conn, 0, len(l.streams))
+	for _, c := range l.streams {
+		streams = append(streams, c)
+	}
+	l.mutex.Unlock()
+	for _, c := range streams {
+		c.mutex.Lock()
+		c.fail(net.ErrClosed)
+		c.mutex.Unlock()
+	}
+	return l.conn.Close()
+}
+
+// Addr returns the local address of the listener.
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := l.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			l.mutex.Lock()
+			l.err = net.ErrClosed
+			l.mutex.Unlock()
+			close(l.accepted)
+			return
+		} else if err != nil {
+			continue
+		}
+		remote, ok := from.(pan.UDPAddr)
+		if !ok || n < headerLen {
+			continue
+		}
+		if c := l.stream(remote, buf[:n]); c != nil {
+			c.handle(buf[:n])
+		}
+	}
+}
+
+// stream returns the stream for remote. A new stream is created and queued
+// for Accept when the first segment of a stream is received from a new
+// remote; other packets from unknown remotes are dropped.
+func (l *Listener) stream(remote pan.UDPAddr, packet []byte) *Conn {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if c, ok := l.streams[remote]; ok {
+		return c
+	}
+	if l.closed || !isFirstSegment(packet) {
+		return nil
+	}
+	c := newConn(transport{
+		write: func(b []byte) error {
+			_, err := l.conn.WriteTo(b, remote)
+			return err
+		},
+		mtu: func() int { return 0 },
+		close: func() error {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			delete(l.streams, remote)
+			return nil
+		},
+		local:  l.conn.LocalAddr(),
+		remote: remote,
+	})
+	select {
+	case l.accepted <- c:
+	default:
+		return nil // accept queue full, the remote retransmits
+	}
+	l.streams[remote] = c
+	return c
+}
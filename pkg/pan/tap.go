@@ -0,0 +1,187 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// TapSocketEnv is the environment variable with the path of a unix datagram
+// socket to which all SCION packets sent and received by the UDP connections
+// of this process are mirrored, as TapRecords. This allows to capture the
+// traffic of an application without access to the underlay, e.g. with
+// scion-capture.
+// Mirroring is best effort: packets are dropped if the receiver does not keep
+// up or if there is no socket at the path.
+const TapSocketEnv = "SCION_PAN_TAP"
+
+const (
+	tapRecordVersion   = 1
+	tapRecordHeaderLen = 2 + 8 + 2*18
+	tapFlagOutgoing    = 0x1
+	// tapQueueLen is the number of records buffered for the tap socket.
+	tapQueueLen = 1024
+	// tapRedialInterval is the minimum time between attempts to connect to
+	// the tap socket.
+	tapRedialInterval = time.Second
+)
+
+// TapRecord is a SCION packet mirrored to the tap socket, see TapSocketEnv.
+type TapRecord struct {
+	Time     time.Time
+	Outgoing bool
+	// Local is the local address of the underlay socket.
+	Local netip.AddrPort
+	// Remote is the underlay address the packet was sent to or received from,
+	// i.e. usually a border router.
+	Remote netip.AddrPort
+	// Packet is the SCION packet, starting with the SCION common header.
+	Packet []byte
+}
+
+// Marshal encodes the record as a datagram for the tap socket.
+func (r TapRecord) Marshal() []byte {
+	b := make([]byte, tapRecordHeaderLen+len(r.Packet))
+	b[0] = tapRecordVersion
+	if r.Outgoing {
+		b[1] |= tapFlagOutgoing
+	}
+	binary.BigEndian.PutUint64(b[2:10], uint64(r.Time.UnixNano()))
+	putTapAddr(b[10:28], r.Local)
+	putTapAddr(b[28:46], r.Remote)
+	copy(b[tapRecordHeaderLen:], r.Packet)
+	return b
+}
+
+// ParseTapRecord decodes a datagram received on the tap socket. The Packet of
+// the record references b.
+func ParseTapRecord(b []byte) (TapRecord, error) {
+	if len(b) < tapRecordHeaderLen {
+		return TapRecord{}, errors.New("tap record too short")
+	}
+	if b[0] != tapRecordVersion {
+		return TapRecord{}, errors.New("unsupported tap record version")
+	}
+	return TapRecord{
+		Time:     time.Unix(0, int64(binary.BigEndian.Uint64(b[2:10]))),
+		Outgoing: b[1]&tapFlagOutgoing != 0,
+		Local:    tapAddr(b[10:28]),
+		Remote:   tapAddr(b[28:46]),
+		Packet:   b[tapRecordHeaderLen:],
+	}, nil
+}
+
+func putTapAddr(b []byte, a netip.AddrPort) {
+	ip := a.Addr().As16()
+	copy(b[:16], ip[:])
+	binary.BigEndian.PutUint16(b[16:18], a.Port())
+}
+
+func tapAddr(b []byte) netip.AddrPort {
+	ip := netip.AddrFrom16([16]byte(b[:16])).Unmap()
+	return netip.AddrPortFrom(ip, binary.BigEndian.Uint16(b[16:18]))
+}
+
+var (
+	tapOnce sync.Once
+	tap     *packetTap
+)
+
+// currentTap returns the tap, or nil if TapSocketEnv is not set.
+func currentTap() *packetTap {
+	tapOnce.Do(func() {
+		if path := os.Getenv(TapSocketEnv); path != "" {
+			tap = newPacketTap(path)
+		}
+	})
+	return tap
+}
+
+// packetTap mirrors packets to the tap socket. A nil *packetTap is valid and
+// does nothing.
+type packetTap struct {
+	addr    *net.UnixAddr
+	records chan []byte
+}
+
+func newPacketTap(path string) *packetTap {
+	t := &packetTap{
+		addr:    &net.UnixAddr{Name: path, Net: "unixgram"},
+		records: make(chan []byte, tapQueueLen),
+	}
+	goroutines.goroutine(goroutineTap, t.run)
+	return t
+}
+
+// mirror queues a copy of the packet, unless the queue is full.
+func (t *packetTap) mirror(outgoing bool, local, remote netip.AddrPort, pkt []byte) {
+	if t == nil {
+		return
+	}
+	r := TapRecord{
+		Time:     time.Now(),
+		Outgoing: outgoing,
+		Local:    local,
+		Remote:   remote,
+		Packet:   pkt,
+	}
+	select {
+	case t.records <- r.Marshal():
+	default:
+	}
+}
+
+// run writes the queued records to the tap socket. Records are dropped while
+// the socket is unavailable.
+func (t *packetTap) run() {
+	var conn *net.UnixConn
+	var lastDial time.Time
+	for r := range t.records {
+		if conn == nil {
+			if time.Since(lastDial) < tapRedialInterval {
+				continue
+			}
+			lastDial = time.Now()
+			var err error
+			if conn, err = net.DialUnix("unixgram", nil, t.addr); err != nil {
+				continue
+			}
+		}
+		if _, err := conn.Write(r); err != nil {
+			_ = conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// mirrorToTap mirrors a packet sent or received on this connection to the
+// tap, if enabled. Must be called with the underlayMutex held for reading.
+func (c *baseUDPConn) mirrorToTap(outgoing bool, remote netip.AddrPort, pkt []byte) {
+	t := currentTap()
+	if t == nil {
+		return
+	}
+	var local netip.AddrPort
+	if a, ok := c.raw.LocalAddr().(*net.UDPAddr); ok {
+		local = a.AddrPort()
+	}
+	t.mirror(outgoing, local, remote, pkt)
+}
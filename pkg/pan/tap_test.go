@@ -0,0 +1,74 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTapRecord(t *testing.T) {
+	r := TapRecord{
+		Time:     time.Unix(1700000000, 123456789),
+		Outgoing: true,
+		Local:    netip.MustParseAddrPort("127.0.0.1:31000"),
+		Remote:   netip.MustParseAddrPort("[fd00::1]:30042"),
+		Packet:   []byte("scion packet"),
+	}
+	parsed, err := ParseTapRecord(r.Marshal())
+	require.NoError(t, err)
+	assert.True(t, r.Time.Equal(parsed.Time))
+	parsed.Time = r.Time
+	assert.Equal(t, r, parsed)
+
+	_, err = ParseTapRecord([]byte{tapRecordVersion})
+	assert.Error(t, err)
+	b := r.Marshal()
+	b[0] = tapRecordVersion + 1
+	_, err = ParseTapRecord(b)
+	assert.Error(t, err)
+}
+
+func TestPacketTap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tap.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	tap := newPacketTap(path)
+	defer close(tap.records)
+	local := netip.MustParseAddrPort("127.0.0.1:31000")
+	remote := netip.MustParseAddrPort("127.0.0.2:30042")
+	pkt := []byte("scion packet")
+	tap.mirror(false, local, remote, pkt)
+	pkt[0] = 'X' // the packet is copied
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 1000)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	r, err := ParseTapRecord(buf[:n])
+	require.NoError(t, err)
+	assert.False(t, r.Outgoing)
+	assert.Equal(t, local, r.Local)
+	assert.Equal(t, remote, r.Remote)
+	assert.Equal(t, []byte("scion packet"), r.Packet)
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan/internal/ping"
+)
+
+// TracerouteHop is the result of probing one interface on a path.
+type TracerouteHop struct {
+	// Index is the position of the probed interface on the path, starting at 0.
+	Index int
+	// Interface is the probed interface, as reported by the router. Unset if
+	// no reply was received.
+	Interface PathInterface
+	// RTT is the round trip time to the router, if a reply was received.
+	RTT time.Duration
+	// Err is nil if a reply was received. Otherwise, it is ErrPingTimeout, a
+	// PathDownError, or the error sending the request.
+	Err error
+}
+
+type pendingHop struct {
+	index  int
+	sent   time.Time
+	result chan TracerouteHop
+}
+
+// Traceroute sends SCMP traceroute requests for each interface on the path,
+// using a temporary Pinger, see Pinger.Traceroute. The requests are addressed
+// to the local IP in the destination AS of the path; they are answered by the
+// routers on the path, so the remote host is irrelevant.
+// Use a Pinger to trace several paths, or the same path repeatedly.
+func Traceroute(ctx context.Context, path *Path) ([]TracerouteHop, error) {
+	if path == nil {
+		return nil, errors.New("traceroute requires a path")
+	}
+	local, err := defaultLocalAddr(netip.AddrPort{})
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewPinger(ctx, local)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	remote := UDPAddr{IA: path.Destination, IP: local.Addr()}
+	return p.Traceroute(ctx, remote, path)
+}
+
+// Traceroute sends SCMP traceroute requests for each interface on the path to
+// the remote host, and returns the results in the order of the interfaces on
+// the path. The interfaces are probed concurrently and the replies are waited
+// for until the context is done. If the context has no deadline, a timeout of
+// one second is used.
+// The path must not be nil, i.e. remote must not be in the local AS.
+func (p *Pinger) Traceroute(ctx context.Context, remote UDPAddr, path *Path) ([]TracerouteHop, error) {
+	if path == nil {
+		return nil, errors.New("traceroute requires a path")
+	}
+	probes, err := tracerouteProbes(path.ForwardingPath.dataplanePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second)
+		defer cancel()
+	}
+	hops := make([]TracerouteHop, len(probes))
+	var wg sync.WaitGroup
+	for i, probe := range probes {
+		wg.Add(1)
+		go func(i int, probe snet.DataplanePath) {
+			defer wg.Done()
+			hops[i] = p.probeHop(ctx, remote, path, i, probe)
+		}(i, probe)
+	}
+	wg.Wait()
+	return hops, nil
+}
+
+func (p *Pinger) probeHop(ctx context.Context, remote UDPAddr, path *Path, index int,
+	probe snet.DataplanePath) TracerouteHop {
+
+	pp := &pendingPing{
+		remote: remote.scionAddr(),
+		path:   path,
+		hop: &pendingHop{
+			index:  index,
+			sent:   time.Now(),
+			result: make(chan TracerouteHop, 1),
+		},
+	}
+	seq, err := p.register(pp)
+	if err != nil {
+		return TracerouteHop{Index: index, Err: err}
+	}
+	defer p.unregister(seq)
+
+	dst := pp.remote.snetUDPAddr()
+	dst.Path = probe
+	dst.NextHop = net.UDPAddrFromAddrPort(path.ForwardingPath.underlay)
+	if err := p.pinger.SendTraceroute(ctx, dst, seq); err != nil {
+		return TracerouteHop{Index: index, Err: p.closedErr(err)}
+	}
+	select {
+	case h := <-pp.hop.result:
+		return h
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return TracerouteHop{Index: index, Err: ErrPingTimeout}
+		}
+		return TracerouteHop{Index: index, Err: ctx.Err()}
+	}
+}
+
+// handleTracerouteReply dispatches a traceroute reply. The reply is sent by a
+// router on the path, so unlike for echo replies, the source and path are not
+// checked.
+func (p *Pinger) handleTracerouteReply(reply ping.Reply) {
+	tr := reply.Traceroute
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pp, ok := p.pending[tr.Sequence]
+	if !ok || pp.hop == nil {
+		return
+	}
+	p.deliverHop(pp, TracerouteHop{
+		Index:     pp.hop.index,
+		Interface: PathInterface{IA: IA(tr.IA), IfID: IfID(tr.Interface)},
+		RTT:       reply.Received.Sub(pp.hop.sent).Round(time.Microsecond),
+	})
+}
+
+// deliverHop sends the result, unless a result was already delivered. Must be
+// called with the mutex held.
+func (p *Pinger) deliverHop(pp *pendingPing, h TracerouteHop) {
+	select {
+	case pp.hop.result <- h:
+	default:
+	}
+}
+
+// tracerouteProbes returns a copy of the dataplane path for each interface on
+// the path, in order, with the router alert flag set for that interface.
+// As in the scion traceroute tool, the interfaces at segment crossovers are
+// only probed once, i.e. the ingress interface of the crossover hop.
+func tracerouteProbes(dp snet.DataplanePath) ([]snet.DataplanePath, error) {
+	sp, ok := dp.(snetpath.SCION)
+	if !ok {
+		return nil, fmt.Errorf("traceroute requires a SCION path, not %T", dp)
+	}
+	var decoded scion.Decoded
+	if err := decoded.DecodeFromBytes(sp.Raw); err != nil {
+		return nil, fmt.Errorf("decoding path: %w", err)
+	}
+	alert := func(hf uint8, egress bool) (snet.DataplanePath, error) {
+		var d scion.Decoded
+		if err := d.DecodeFromBytes(sp.Raw); err != nil {
+			return nil, err
+		}
+		if egress {
+			d.HopFields[hf].EgressRouterAlert = true
+		} else {
+			d.HopFields[hf].IngressRouterAlert = true
+		}
+		return snetpath.NewSCIONFromDecoded(d)
+	}
+
+	var probes []snet.DataplanePath
+	numHops := len(decoded.HopFields)
+	prevXover := false
+	for i := 0; i < numHops; i++ {
+		hf := decoded.PathMeta.CurrHF
+		info := decoded.InfoFields[decoded.PathMeta.CurrINF]
+		// The first hop has no ingress interface, and after a crossover the
+		// ingress interface was already probed in the previous hop.
+		if i != 0 && !prevXover {
+			probe, err := alert(hf, !info.ConsDir)
+			if err != nil {
+				return nil, err
+			}
+			probes = append(probes, probe)
+		}
+		// Peering links are not crossovers in this sense; both interfaces are
+		// probed.
+		xover := decoded.IsXover() && !info.Peer
+		if i < numHops-1 && !xover {
+			probe, err := alert(hf, info.ConsDir)
+			if err != nil {
+				return nil, err
+			}
+			probes = append(probes, probe)
+		}
+		if i < numHops-1 {
+			if err := decoded.IncPath(); err != nil {
+				return nil, fmt.Errorf("incrementing path: %w", err)
+			}
+		}
+		prevXover = xover
+	}
+	return probes, nil
+}
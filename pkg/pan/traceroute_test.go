@@ -0,0 +1,104 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan/internal/ping"
+)
+
+func TestTracerouteProbes(t *testing.T) {
+	a := MustParseIA("1-ff00:0:1")
+	b := MustParseIA("1-ff00:0:2")
+	c := MustParseIA("1-ff00:0:3")
+	d := MustParseIA("1-ff00:0:4")
+	path := testPathFromSegments(t, a, d, []testSegment{
+		{consDir: false, interfaces: []PathInterface{{a, 1}, {b, 2}, {b, 3}, {c, 4}}},
+		{consDir: true, interfaces: []PathInterface{{c, 5}, {d, 6}}},
+	})
+
+	probes, err := tracerouteProbes(path.ForwardingPath.dataplanePath)
+	require.NoError(t, err)
+	// Each interface is probed once, in order, with the alert flag set on
+	// exactly one hop field.
+	var alerted []IfID
+	for _, probe := range probes {
+		var decoded scion.Decoded
+		require.NoError(t, decoded.DecodeFromBytes(probe.(snetpath.SCION).Raw))
+		n := 0
+		for _, hf := range decoded.HopFields {
+			if hf.EgressRouterAlert {
+				alerted = append(alerted, IfID(hf.ConsEgress))
+				n++
+			}
+			if hf.IngressRouterAlert {
+				alerted = append(alerted, IfID(hf.ConsIngress))
+				n++
+			}
+		}
+		assert.Equal(t, 1, n)
+	}
+	assert.Equal(t, []IfID{1, 2, 3, 4, 5, 6}, alerted)
+
+	_, err = tracerouteProbes(snetpath.Empty{})
+	assert.Error(t, err)
+}
+
+func TestPingerHandleTracerouteReply(t *testing.T) {
+	ia := MustParseIA("1-ff00:0:2")
+	p := &Pinger{pending: make(map[uint16]*pendingPing)}
+	sent := time.Now()
+	pp := &pendingPing{hop: &pendingHop{index: 2, sent: sent, result: make(chan TracerouteHop, 1)}}
+	p.pending[5] = pp
+	echo := &pendingPing{result: make(chan PingResult, 1)}
+	p.pending[6] = echo
+
+	reply := func(seq uint16) ping.Reply {
+		return ping.Reply{
+			Received: sent.Add(2 * time.Millisecond),
+			Source:   snet.SCIONAddress{IA: addr.IA(ia)},
+			Traceroute: &snet.SCMPTracerouteReply{
+				Sequence:  seq,
+				IA:        addr.IA(ia),
+				Interface: 3,
+			},
+		}
+	}
+	// Replies for unknown sequence numbers and echo requests are ignored.
+	p.handleReply(reply(7))
+	p.handleReply(reply(6))
+	assert.Empty(t, pp.hop.result)
+	assert.Empty(t, echo.result)
+
+	p.handleReply(reply(5))
+	select {
+	case h := <-pp.hop.result:
+		assert.NoError(t, h.Err)
+		assert.Equal(t, 2, h.Index)
+		assert.Equal(t, PathInterface{IA: ia, IfID: 3}, h.Interface)
+		assert.Equal(t, 2*time.Millisecond, h.RTT)
+	default:
+		t.Fatal("no result delivered")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// TrafficClass is the traffic class of a packet. It is written to the traffic
+// class field of the SCION header and to the DSCP/ECN field of the underlay IP
+// header (IPv4 type of service, IPv6 traffic class). The upper six bits are
+// the DSCP, the lower two bits the ECN codepoint.
+type TrafficClass uint8
+
+// Common DSCP values, see RFC 4594.
+const (
+	DSCPDefault             = 0
+	DSCPExpeditedForwarding = 46
+	DSCPLowerEffort         = 1
+)
+
+// TrafficClassFromDSCP returns the TrafficClass with the given DSCP (0-63)
+// and no ECN codepoint.
+func TrafficClassFromDSCP(dscp uint8) TrafficClass {
+	return TrafficClass(dscp << 2)
+}
+
+// DSCP returns the DSCP of the traffic class.
+func (tc TrafficClass) DSCP() uint8 {
+	return uint8(tc) >> 2
+}
+
+func (tc TrafficClass) String() string {
+	return fmt.Sprintf("dscp=%d,ecn=%d", tc.DSCP(), uint8(tc)&0x3)
+}
+
+// SetTrafficClass sets the traffic class for packets written on this
+// connection.
+func (c *baseUDPConn) SetTrafficClass(tc TrafficClass) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.underlayMutex.RLock()
+	defer c.underlayMutex.RUnlock()
+	if err := c.setUnderlayTrafficClass(tc); err != nil {
+		return err
+	}
+	c.trafficClass = tc
+	return nil
+}
+
+// setUnderlayTrafficClass sets the traffic class of the underlay socket, if
+// it differs from the current value. This is a no-op if the raw connection is
+// not a UDP socket. Must be called with the writeMutex held, and the
+// underlayMutex held for reading.
+func (c *baseUDPConn) setUnderlayTrafficClass(tc TrafficClass) error {
+	conn := c.udpConn()
+	if conn == nil || tc == c.underlayTrafficClass {
+		return nil
+	}
+	var err error
+	if isIPv4Socket(conn) {
+		err = ipv4.NewConn(conn).SetTOS(int(tc))
+	} else {
+		err = ipv6.NewConn(conn).SetTrafficClass(int(tc))
+	}
+	if err != nil {
+		return fmt.Errorf("setting underlay traffic class: %w", err)
+	}
+	c.underlayTrafficClass = tc
+	return nil
+}
+
+// classPaths is the path selection for the writes with one traffic class, see
+// SetClassPolicy.
+type classPaths struct {
+	selector   Selector
+	subscriber *pathRefreshSubscriber
+}
+
+func (p *classPaths) close() {
+	_ = p.subscriber.Close()
+	_ = p.selector.Close()
+}
+
+func (c *dialedConn) SetClassPolicy(ctx context.Context, tc TrafficClass, policy Policy,
+	selector Selector) error {
+
+	local := c.localAddr()
+	if local.IA == c.remote.IA {
+		return nil // no paths in the local AS
+	}
+	if selector == nil {
+		selector = NewDefaultSelector()
+	}
+	subscriber, err := openPathRefreshSubscriber(ctx, local, c.remote, policy, selector, pathLookupOptions{})
+	if err != nil {
+		return err
+	}
+	p := &classPaths{selector: selector, subscriber: subscriber}
+
+	c.classMutex.Lock()
+	if c.closed.Load() {
+		c.classMutex.Unlock()
+		p.close()
+		return net.ErrClosed
+	}
+	if c.classes == nil {
+		c.classes = make(map[TrafficClass]*classPaths)
+	}
+	old := c.classes[tc]
+	c.classes[tc] = p
+	c.classMutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+func (c *dialedConn) WriteWithTrafficClass(tc TrafficClass, b []byte) (int, error) {
+	var path *Path
+	if c.localAddr().IA != c.remote.IA {
+		path = c.classSelector(tc).Path()
+		if path == nil {
+			return 0, errNoPathTo(c.remote.IA)
+		}
+	}
+	return c.WriteViaWithTrafficClass(path, tc, b)
+}
+
+// classSelector returns the selector for the writes with the traffic class
+// tc, i.e. the one set with SetClassPolicy or the connection's selector.
+func (c *dialedConn) classSelector(tc TrafficClass) Selector {
+	c.classMutex.Lock()
+	defer c.classMutex.Unlock()
+	if p, ok := c.classes[tc]; ok {
+		return p.selector
+	}
+	return c.selector
+}
+
+// classSubscribers returns the path refresh subscribers of the traffic
+// classes set with SetClassPolicy.
+func (c *dialedConn) classSubscribers() []*pathRefreshSubscriber {
+	c.classMutex.Lock()
+	defer c.classMutex.Unlock()
+	subscribers := make([]*pathRefreshSubscriber, 0, len(c.classes))
+	for _, p := range c.classes {
+		subscribers = append(subscribers, p.subscriber)
+	}
+	return subscribers
+}
+
+// closeClasses closes the path selection of all traffic classes.
+func (c *dialedConn) closeClasses() {
+	c.classMutex.Lock()
+	classes := c.classes
+	c.classes = nil
+	c.classMutex.Unlock()
+	for _, p := range classes {
+		p.close()
+	}
+}
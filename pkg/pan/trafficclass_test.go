@@ -0,0 +1,106 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+)
+
+func TestTrafficClass(t *testing.T) {
+	ef := TrafficClassFromDSCP(DSCPExpeditedForwarding)
+	assert.Equal(t, TrafficClass(0xb8), ef)
+	assert.Equal(t, uint8(DSCPExpeditedForwarding), ef.DSCP())
+
+	sender, src := testLoopbackConn(t, "127.0.0.1")
+	receiver, dst := testLoopbackConn(t, "127.0.0.2")
+	path := testLoopbackPath(t, &src, &dst)
+
+	// receive returns the traffic class of the SCION header of the next packet
+	receive := func() TrafficClass {
+		buf := make([]byte, 1500)
+		n, _, err := receiver.udpConn().ReadFromUDPAddrPort(buf)
+		require.NoError(t, err)
+		_, err = receiver.parser.parse(buf[:n])
+		require.NoError(t, err)
+		return TrafficClass(receiver.parser.scion.TrafficClass)
+	}
+	underlay := func() TrafficClass {
+		tos, err := ipv4.NewConn(sender.udpConn()).TOS()
+		require.NoError(t, err)
+		return TrafficClass(tos)
+	}
+
+	require.NoError(t, sender.SetTrafficClass(ef))
+	assert.Equal(t, ef, underlay())
+	_, err := sender.writeMsg(src, dst, path, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, ef, receive())
+
+	le := TrafficClassFromDSCP(DSCPLowerEffort)
+	_, err = sender.writeMsgTrafficClass(src, dst, path, le, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, le, receive())
+	assert.Equal(t, le, underlay())
+
+	// back to the connection's traffic class
+	_, err = sender.writeMsg(src, dst, path, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, ef, receive())
+	assert.Equal(t, ef, underlay())
+}
+
+func TestWriteWithTrafficClass(t *testing.T) {
+	c, remote, path := testKeepaliveConn(t)
+	core := MustParseIA("1-ff00:0:110")
+	bulkPath := testPathFromSegments(t, c.local.IA, c.remote.IA, []testSegment{
+		{consDir: false, interfaces: []PathInterface{{c.local.IA, 5}, {core, 6}}},
+		{consDir: true, interfaces: []PathInterface{{core, 7}, {c.remote.IA, 8}}},
+	})
+	bulkPath.ForwardingPath.underlay = path.ForwardingPath.underlay
+	bulk := TrafficClassFromDSCP(DSCPLowerEffort)
+	c.classes = map[TrafficClass]*classPaths{
+		bulk: {selector: &initRecordingSelector{path: bulkPath}},
+	}
+
+	// receive returns the traffic class of the next packet
+	receive := func() TrafficClass {
+		_, _, _, err := remote.readMsg(make([]byte, 1500), false)
+		require.NoError(t, err)
+		return TrafficClass(remote.parser.scion.TrafficClass)
+	}
+	sent := func() map[PathFingerprint]uint64 {
+		m := make(map[PathFingerprint]uint64)
+		for _, s := range c.Stats() {
+			m[s.Path.Fingerprint] = s.SentPackets
+		}
+		return m
+	}
+
+	_, err := c.WriteWithTrafficClass(bulk, []byte("bulk"))
+	require.NoError(t, err)
+	assert.Equal(t, bulk, receive())
+	assert.Equal(t, map[PathFingerprint]uint64{bulkPath.Fingerprint: 1}, sent())
+
+	// Classes without a policy use the connection's selector.
+	ef := TrafficClassFromDSCP(DSCPExpeditedForwarding)
+	_, err = c.WriteWithTrafficClass(ef, []byte("control"))
+	require.NoError(t, err)
+	assert.Equal(t, ef, receive())
+	assert.Equal(t, map[PathFingerprint]uint64{bulkPath.Fingerprint: 1, path.Fingerprint: 1}, sent())
+}
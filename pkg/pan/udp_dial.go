@@ -18,8 +18,12 @@ import (
 	"context"
 	"net"
 	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
 )
 
 // Conn represents a _dialed_ connection.
@@ -34,69 +38,169 @@ type Conn interface {
 	// ReadVia reads a message and returns the (return-)path via which the
 	// message was received.
 	ReadVia(b []byte) (int, *Path, error)
+	// ReadBatch reads up to len(msgs) messages, using a single system call
+	// where supported. Blocks until at least one message is read. Returns the
+	// number of messages read.
+	ReadBatch(msgs []Message) (int, error)
+	// WriteBatch writes the messages to the remote address, using as few
+	// system calls as possible. Messages with a nil Path are sent on the path
+	// chosen by the selector. Returns the number of messages written.
+	WriteBatch(msgs []Message) (int, error)
+	// MTU returns the maximum size of a message written with Write on the
+	// current path, i.e. the path MTU minus the SCION and UDP headers. The
+	// path MTU is lowered when SCMP packet too big messages are received.
+	// Returns 0 if unknown.
+	MTU() int
+	// SetTrafficClass sets the traffic class for the packets written on this
+	// connection, in the SCION header and in the underlay IP header. The
+	// default is 0.
+	SetTrafficClass(tc TrafficClass) error
+	// WriteViaWithTrafficClass is WriteVia with the traffic class tc instead
+	// of the one set with SetTrafficClass, e.g. to mark individual
+	// latency-critical messages.
+	WriteViaWithTrafficClass(path *Path, tc TrafficClass, b []byte) (int, error)
+	// SetClassPolicy sets a separate path policy and selector for the writes
+	// with the traffic class tc, see WriteWithTrafficClass. This allows to
+	// tag the messages of an application, e.g. sending "control" messages on
+	// low latency paths and "bulk" data on high bandwidth paths, over the
+	// same connection. If the selector is nil, a DefaultSelector is used; if
+	// the policy is nil, all paths are allowed. Setting the policy again for
+	// tc replaces the previous policy and selector, which is closed.
+	SetClassPolicy(ctx context.Context, tc TrafficClass, policy Policy, selector Selector) error
+	// WriteWithTrafficClass writes a message with the traffic class tc, on
+	// the path chosen by the selector set for tc with SetClassPolicy, or by
+	// the connection's selector if none is set.
+	WriteWithTrafficClass(tc TrafficClass, b []byte) (int, error)
+	// Stats returns the statistics for each path used by this connection, in
+	// the order in which the paths were first used. Empty if the remote is in
+	// the local AS.
+	Stats() []ConnPathStats
+	// SetKeepalive enables sending keepalives to the remote address whenever
+	// nothing was written for the interval, so that NATs and stateful
+	// firewalls on the underlay do not expire the mapping of an idle
+	// connection. An interval of 0 disables keepalives, which is the default.
+	SetKeepalive(interval time.Duration, mode KeepaliveMode)
+	// KeepaliveStatus returns the status of the keepalives.
+	KeepaliveStatus() KeepaliveStatus
 
 	GetPath() *Path
 }
 
 // DialUDP opens a SCION/UDP socket, connected to the remote address.
-// If the local address, or either its IP or port, are left unspecified, they
-// will be automatically chosen.
+// The local address is set with WithLocalAddr. If it is not set, or either
+// its IP or port are left unspecified, they will be automatically chosen. An
+// automatically chosen IP is the one from which the underlay next hop is
+// reached: the border router of the first path allowed by the policy, or the
+// remote itself in the local AS. A connection with an automatically chosen IP
+// follows changes of this IP if EnableAddressMigration is enabled.
 //
-// DialUDP looks up SCION paths to the destination AS. The policy defines the
-// allowed paths and their preference order. The selector dynamically selects
-// a path among this set for each Write operation.
-// If the policy is nil, all paths are allowed.
-// If the selector is nil, a DefaultSelector is used.
-func DialUDP(ctx context.Context, local netip.AddrPort, remote UDPAddr,
-	policy Policy, selector Selector) (Conn, error) {
-
-	local, err := defaultLocalAddr(local)
+// DialUDP looks up SCION paths to the destination AS. The policy, set with
+// WithPolicy, defines the allowed paths and their preference order. The
+// selector, set with WithSelector, dynamically selects a path among this set
+// for each Write operation.
+// If no policy is set, all paths are allowed.
+// If no selector is set, a DefaultSelector is used.
+func DialUDP(ctx context.Context, remote UDPAddr, opts ...DialOption) (Conn, error) {
+	return dialFragmented(ctx, remote, applyDialOptions(opts))
+}
+
+// dialFragmented is dialUDP, with fragmentation if enabled in the options.
+func dialFragmented(ctx context.Context, remote UDPAddr, o dialOptions) (Conn, error) {
+	conn, err := dialUDP(ctx, remote, o)
 	if err != nil {
 		return nil, err
 	}
+	return fragmentConn(conn, o.connOptions), nil
+}
 
-	sn := snet.SCIONNetwork{
-		Topology:    host().sciond,
-		SCMPHandler: scmpHandler{},
-	}
-	conn, err := sn.OpenRaw(ctx, net.UDPAddrFromAddrPort(local))
+func dialUDP(ctx context.Context, remote UDPAddr, o dialOptions) (Conn, error) {
+	autoLocalIP := !o.local.Addr().IsValid() || o.local.Addr().IsUnspecified()
+	local, err := dialLocalAddr(ctx, o.local, remote, o.policy)
 	if err != nil {
 		return nil, err
 	}
-	ipport := conn.LocalAddr().(*net.UDPAddr).AddrPort()
-	localUDPAddr := UDPAddr{
-		IA:   host().ia,
-		IP:   ipport.Addr(),
-		Port: ipport.Port(),
+	selector := o.selector
+
+	ka := &keepalive{}
+	handler := scmpHandler{echoReply: ka.reply, next: o.scmpHandlers}
+	conn, localUDPAddr, err := openRaw(ctx, local, handler)
+	if err != nil {
+		return nil, err
 	}
 	var subscriber *pathRefreshSubscriber
 	if remote.IA != localUDPAddr.IA {
 		if selector == nil {
 			selector = NewDefaultSelector()
 		}
-		subscriber, err = openPathRefreshSubscriber(ctx, localUDPAddr, remote, policy, selector)
+		subscriber, err = openPathRefreshSubscriber(ctx, localUDPAddr, remote, o.policy, selector, o.lookup)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &dialedConn{
+	c := &dialedConn{
 		baseUDPConn: baseUDPConn{
-			raw: conn,
+			raw:     conn,
+			metrics: newConnMetrics(localUDPAddr, remote),
+			scmp:    handler,
+			auth:    newPacketAuthenticator(o.connOptions),
 		},
-		local:      localUDPAddr,
-		remote:     remote,
-		subscriber: subscriber,
-		selector:   selector,
+		local:       localUDPAddr,
+		autoLocalIP: autoLocalIP,
+		remote:      remote,
+		subscriber:  subscriber,
+		selector:    selector,
+		keepalive:   ka,
+	}
+	openConns.add(c)
+	return c, nil
+}
+
+// openRaw opens the raw connection for a dialed connection, bound to local,
+// and returns it with its local address.
+func openRaw(ctx context.Context, local netip.AddrPort, handler scmpHandler) (snet.PacketConn, UDPAddr, error) {
+	sn := snet.SCIONNetwork{
+		Topology:    host().sciond,
+		SCMPHandler: handler,
+	}
+	conn, err := sn.OpenRaw(ctx, net.UDPAddrFromAddrPort(local))
+	if err != nil {
+		return nil, UDPAddr{}, err
+	}
+	ipport := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	return conn, UDPAddr{
+		IA:   host().ia,
+		IP:   ipport.Addr(),
+		Port: ipport.Port(),
 	}, nil
 }
 
 type dialedConn struct {
 	baseUDPConn
 
+	// localMutex guards local, which changes when the connection migrates to
+	// a new local address, see EnableAddressMigration.
+	localMutex sync.Mutex
 	local      UDPAddr
-	remote     UDPAddr
-	subscriber *pathRefreshSubscriber
-	selector   Selector
+	// autoLocalIP is set if the local IP was chosen automatically, i.e. if
+	// the connection may migrate.
+	autoLocalIP bool
+	remote      UDPAddr
+	subscriber  *pathRefreshSubscriber
+	selector    Selector
+	pathStats   connStats
+	keepalive   *keepalive
+
+	// classMutex guards classes, the path selection for the traffic classes
+	// set with SetClassPolicy.
+	classMutex sync.Mutex
+	classes    map[TrafficClass]*classPaths
+}
+
+// localAddr returns the current local address.
+func (c *dialedConn) localAddr() UDPAddr {
+	c.localMutex.Lock()
+	defer c.localMutex.Unlock()
+	return c.local
 }
 
 func (c *dialedConn) SetPolicy(policy Policy) {
@@ -106,7 +210,7 @@ func (c *dialedConn) SetPolicy(policy Policy) {
 }
 
 func (c *dialedConn) LocalAddr() net.Addr {
-	return c.local
+	return c.localAddr()
 }
 
 func (c *dialedConn) GetPath() *Path {
@@ -116,29 +220,51 @@ func (c *dialedConn) GetPath() *Path {
 	return c.selector.Path()
 }
 
+func (c *dialedConn) MTU() int {
+	var max int
+	if c.localAddr().IA == c.remote.IA {
+		max, _ = maxPayloadSizeMTU(c.localAddr(), c.remote, snetpath.Empty{}, host().mtu)
+	} else {
+		max, _ = maxPayloadSize(c.localAddr(), c.remote, c.GetPath())
+	}
+	if c.auth != nil && max > 0 {
+		max -= packetAuthExtLen
+	}
+	return max
+}
+
 func (c *dialedConn) RemoteAddr() net.Addr {
 	return c.remote
 }
 
 func (c *dialedConn) Write(b []byte) (int, error) {
 	var path *Path
-	if c.local.IA != c.remote.IA {
+	if c.localAddr().IA != c.remote.IA {
 		path = c.selector.Path()
 		if path == nil {
 			return 0, errNoPathTo(c.remote.IA)
 		}
 	}
-	return c.baseUDPConn.writeMsg(c.local, c.remote, path, b)
+	return c.WriteVia(path, b)
 }
 
 func (c *dialedConn) WriteVia(path *Path, b []byte) (int, error) {
-	return c.baseUDPConn.writeMsg(c.local, c.remote, path, b)
+	n, err := c.baseUDPConn.writeMsg(c.localAddr(), c.remote, path, b)
+	c.pathStats.recordSent(path, n, err)
+	return n, err
+}
+
+func (c *dialedConn) WriteViaWithTrafficClass(path *Path, tc TrafficClass, b []byte) (int, error) {
+	n, err := c.baseUDPConn.writeMsgTrafficClass(c.localAddr(), c.remote, path, tc, b)
+	c.pathStats.recordSent(path, n, err)
+	return n, err
 }
 
 func (c *dialedConn) Read(b []byte) (int, error) {
 	for {
-		n, remote, _, err := c.baseUDPConn.readMsg(b)
+		n, remote, _, err := c.baseUDPConn.readMsg(b, false)
 		if err != nil {
+			c.pathStats.recordError(err)
 			return n, err
 		}
 		if remote != c.remote {
@@ -150,29 +276,79 @@ func (c *dialedConn) Read(b []byte) (int, error) {
 
 func (c *dialedConn) ReadVia(b []byte) (int, *Path, error) {
 	for {
-		n, remote, fwPath, err := c.baseUDPConn.readMsg(b)
+		n, remote, fwPath, err := c.baseUDPConn.readMsg(b, true)
 		if err != nil {
+			c.pathStats.recordError(err)
 			return n, nil, err
 		}
 		if remote != c.remote {
 			continue // connected! Ignore spurious packets from wrong source
 		}
-		path, err := reversePathFromForwardingPath(c.remote.IA, c.local.IA, fwPath)
+		path, err := reversePathFromForwardingPath(c.remote.IA, c.localAddr().IA, fwPath)
 		if err != nil {
 			continue // just drop the packet if there is something wrong with the path
 		}
+		c.pathStats.recordReceived(path, n)
 		return n, path, nil
 	}
 }
 
+func (c *dialedConn) ReadBatch(msgs []Message) (int, error) {
+	n, err := c.baseUDPConn.readBatch(msgs, false, func(m *Message, remote UDPAddr, _ ForwardingPath) bool {
+		m.Addr = remote
+		return remote == c.remote // connected! Ignore spurious packets from wrong source
+	})
+	c.pathStats.recordError(err)
+	return n, err
+}
+
+func (c *dialedConn) WriteBatch(msgs []Message) (int, error) {
+	routes := make([]batchRoute, len(msgs))
+	for i, m := range msgs {
+		path := m.Path
+		if path == nil && c.localAddr().IA != c.remote.IA {
+			path = c.selector.Path()
+			if path == nil {
+				return 0, errNoPathTo(c.remote.IA)
+			}
+		}
+		routes[i] = batchRoute{dst: c.remote, path: path}
+	}
+	n, err := c.baseUDPConn.writeBatch(c.localAddr(), msgs, routes)
+	for i, r := range routes {
+		if i < n {
+			c.pathStats.recordSent(r.path, len(msgs[i].Buffer), nil)
+		} else if i == n && err != nil {
+			c.pathStats.recordSent(r.path, 0, err)
+		}
+	}
+	return n, err
+}
+
+func (c *dialedConn) Stats() []ConnPathStats {
+	return c.pathStats.snapshot(c.remote.scionAddr())
+}
+
+// Close closes the connection, its selector and the path selection of the
+// traffic classes. It is safe to call concurrently with reads, writes and the
+// selector callbacks; these return net.ErrClosed once the connection is
+// closed, or are ignored. Closing a closed connection returns net.ErrClosed.
 func (c *dialedConn) Close() error {
+	if !c.markClosed() {
+		return net.ErrClosed
+	}
+	openConns.remove(c)
+	c.keepalive.close()
 	if c.subscriber != nil {
 		_ = c.subscriber.Close()
 	}
 	if c.selector != nil {
 		_ = c.selector.Close()
 	}
-	return c.baseUDPConn.Close()
+	c.metrics.close()
+	err := c.closeRaw()
+	c.closeClasses()
+	return err
 }
 
 // pathRefreshSubscriber is the glue between a connection and the global path
@@ -182,41 +358,89 @@ type pathRefreshSubscriber struct {
 	remoteIA IA
 	policy   Policy
 	target   Selector
+	prober   *recoveryProber
+	// closed is set when the subscriber is closed. Notifications delivered
+	// concurrently with Close are then no longer passed to the target, which
+	// may be closed already.
+	closed atomic.Bool
 }
 
 func openPathRefreshSubscriber(ctx context.Context, local, remote UDPAddr, policy Policy,
-	target Selector) (*pathRefreshSubscriber, error) {
+	target Selector, lookup pathLookupOptions) (*pathRefreshSubscriber, error) {
 
 	s := &pathRefreshSubscriber{
 		remoteIA: remote.IA,
 		policy:   policy,
 		target:   target,
+		prober:   newRecoveryProber(local, remote),
 	}
-	paths, err := pool.subscribe(ctx, remote.IA, s)
+	paths, err := pool.subscribe(ctx, remote.IA, s, lookup)
 	if err != nil {
+		s.prober.close()
 		return nil, err
 	}
-	s.target.Initialize(local, remote, filtered(s.policy, paths))
+	paths = filtered(s.policy, paths)
+	s.prober.setPaths(paths)
+	s.target.Initialize(local, remote, paths)
 	return s, nil
 }
 
 func (s *pathRefreshSubscriber) Close() error {
+	s.closed.Store(true)
 	pool.unsubscribe(s.remoteIA, s)
+	s.prober.close()
 	return nil
 }
 
 func (s *pathRefreshSubscriber) setPolicy(policy Policy) {
+	if s.closed.Load() {
+		return
+	}
 	s.policy = policy
-	paths := pool.cachedPaths(s.remoteIA)
-	s.target.Refresh(filtered(s.policy, paths))
+	paths := filtered(s.policy, pool.cachedPaths(s.remoteIA))
+	s.prober.setPaths(paths)
+	s.target.Refresh(paths)
+}
+
+// setLocal reinitializes the target selector and the recovery prober for the
+// new local address of the connection.
+func (s *pathRefreshSubscriber) setLocal(local, remote UDPAddr) {
+	if s.closed.Load() {
+		return
+	}
+	paths := filtered(s.policy, pool.cachedPaths(s.remoteIA))
+	s.prober.setLocal(local)
+	s.prober.setPaths(paths)
+	s.target.Initialize(local, remote, paths)
 }
 
 func (s *pathRefreshSubscriber) refresh(dst IA, paths []*Path) {
-	s.target.Refresh(filtered(s.policy, paths))
+	if s.closed.Load() {
+		return
+	}
+	paths = filtered(s.policy, paths)
+	s.prober.setPaths(paths)
+	s.target.Refresh(paths)
 }
 
 func (s *pathRefreshSubscriber) PathDown(pf PathFingerprint, pi PathInterface) {
+	if s.closed.Load() {
+		return
+	}
+	s.prober.pathDown(pf, pi)
 	s.target.PathDown(pf, pi)
+	if pool.synthetic.Load() {
+		pool.addSyntheticPathsAsync(s.remoteIA, pf, pi)
+	}
+}
+
+func (s *pathRefreshSubscriber) PathRecovered(pf PathFingerprint) {
+	if s.closed.Load() {
+		return
+	}
+	if r, ok := s.target.(pathRecoveredNotifyee); ok {
+		r.PathRecovered(pf)
+	}
 }
 
 func filtered(policy Policy, paths []*Path) []*Path {
@@ -0,0 +1,157 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan/internal/ping"
+)
+
+// ErrNoWorkingPath is returned by DialUDPProbed if no reply was received on
+// any of the probed paths.
+var ErrNoWorkingPath = errors.New("no working path")
+
+// DialUDPProbed is like DialUDP, but only returns once the remote host is
+// confirmed to be reachable, so that the first Write does not silently go into
+// a black-holed path.
+// The first few paths allowed by the policy are probed with SCMP echo
+// requests, in the order of preference, each started a short time after the
+// previous one as in "happy eyeballs" (RFC 8305). The path on which the
+// first reply is received is preferred over the other paths allowed by the
+// policy; the selector may still switch paths later on, e.g. after a down
+// notification.
+// Returns ErrNoWorkingPath if no reply is received within a few seconds. The
+// remote host must respond to SCMP echo requests. The options are those of
+// DialUDP; the probes are sent from the same local IP, but not on the
+// returned connection.
+func DialUDPProbed(ctx context.Context, remote UDPAddr, opts ...DialOption) (Conn, error) {
+	o := applyDialOptions(opts)
+	if remote.IA == host().ia {
+		return dialFragmented(ctx, remote, o)
+	}
+	policy := o.policy
+	paths, err := pool.paths(ctx, remote.IA)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		paths = policy.Filter(paths)
+	}
+	if len(paths) == 0 {
+		return nil, errNoPathTo(remote.IA)
+	}
+	if len(paths) > dialProbeCandidates {
+		paths = paths[:dialProbeCandidates]
+	}
+	// The local address is resolved again by DialUDP, so that an
+	// automatically chosen IP remains marked as such.
+	probeLocal, err := dialLocalAddr(ctx, o.local, remote, policy)
+	if err != nil {
+		return nil, err
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, dialProbeTimeout)
+	defer cancel()
+	working, err := probePaths(probeCtx, scionAddr{IA: host().ia, IP: probeLocal.Addr()},
+		remote.scionAddr(), paths)
+	if err != nil {
+		return nil, err
+	}
+	prefer := Preferred{Pinned{working.Fingerprint}}
+	if policy != nil {
+		o.policy = PolicyChain{policy, prefer}
+	} else {
+		o.policy = prefer
+	}
+	return dialFragmented(ctx, remote, o)
+}
+
+// probePaths sends SCMP echo requests to remote over the paths, one after the
+// other in intervals of dialProbeStagger, and returns the first path on which
+// a reply is received.
+func probePaths(ctx context.Context, local, remote scionAddr, paths []*Path) (*Path, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pinger, err := ping.NewPinger(ctx, host().sciond, local.snetUDPAddr())
+	if err != nil {
+		return nil, err
+	}
+	defer pinger.Close()
+	goroutines.goroutine(goroutineDialProber, func() { pinger.Drain(ctx) })
+
+	send := func(p *Path, seq uint16) {
+		dst := remote.snetUDPAddr()
+		dst.Path = p.ForwardingPath.dataplanePath
+		dst.NextHop = net.UDPAddrFromAddrPort(p.ForwardingPath.underlay)
+		// Errors are ignored, the other paths are still probed.
+		_ = pinger.Send(ctx, dst, seq, 16)
+	}
+	return awaitProbeReply(ctx, remote, paths, send, pinger.Replies)
+}
+
+// awaitProbeReply calls send for each of the paths, one after the other in
+// intervals of dialProbeStagger, and returns the first path on which a reply
+// from remote is received, irrespective of the order of the probes.
+func awaitProbeReply(ctx context.Context, remote scionAddr, paths []*Path,
+	send func(p *Path, seq uint16), replies <-chan ping.Reply) (*Path, error) {
+
+	candidates := make(map[PathFingerprint]*Path, len(paths))
+	for _, p := range paths {
+		candidates[p.Fingerprint] = p
+	}
+	ticker := time.NewTicker(dialProbeStagger)
+	defer ticker.Stop()
+	next := 0
+	sendNext := func() {
+		p := paths[next]
+		next++
+		send(p, uint16(next))
+	}
+
+	sendNext()
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w to %s, probed %d paths", ErrNoWorkingPath, remote, len(paths))
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+			if next < len(paths) {
+				sendNext()
+			}
+		case r := <-replies:
+			if r.Error != nil || r.Source.Host.Type() != addr.HostTypeIP {
+				continue
+			}
+			if (scionAddr{IA: IA(r.Source.IA), IP: r.Source.Host.IP()}) != remote {
+				continue
+			}
+			pf, err := reversePathFingerprint(r.Path)
+			if err != nil {
+				continue
+			}
+			if p, ok := candidates[pf]; ok {
+				return p, nil
+			}
+		}
+	}
+}
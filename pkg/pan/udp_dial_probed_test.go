@@ -0,0 +1,101 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pan
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/slayers/path/scion"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan/internal/ping"
+)
+
+func TestAwaitProbeReply(t *testing.T) {
+	src, dst := MustParseIA("1-ff00:0:1"), MustParseIA("1-ff00:0:2")
+	remote := mustParse("1-ff00:0:2,[192.0.2.1]")
+	testPath := func(i IfID) *Path {
+		return testPathFromSegments(t, src, dst, []testSegment{
+			{consDir: true, interfaces: []PathInterface{{src, i}, {dst, 10 + i}}},
+		})
+	}
+	paths := []*Path{testPath(1), testPath(2), testPath(3)}
+
+	t.Run("first reply", func(t *testing.T) {
+		replies := make(chan ping.Reply, 4)
+		var sent []*Path
+		send := func(p *Path, seq uint16) {
+			sent = append(sent, p)
+			if p == paths[1] {
+				// Errors, and replies from other hosts or on other paths are
+				// ignored.
+				replies <- ping.Reply{Error: errors.New("unreachable")}
+				replies <- testProbeReply(t, mustParse("1-ff00:0:2,[192.0.2.2]"), p)
+				replies <- testProbeReply(t, remote, testPath(4))
+				replies <- testProbeReply(t, remote, p)
+			}
+		}
+		// The second path replies first, although the first was probed
+		// before.
+		p, err := awaitProbeReply(context.Background(), remote, paths, send, replies)
+		require.NoError(t, err)
+		assert.Equal(t, paths[1], p)
+		assert.Equal(t, paths[:2], sent)
+	})
+
+	t.Run("no reply", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*dialProbeStagger+100*time.Millisecond)
+		defer cancel()
+		var sent []*Path
+		send := func(p *Path, seq uint16) {
+			sent = append(sent, p)
+		}
+		_, err := awaitProbeReply(ctx, remote, paths, send, make(chan ping.Reply))
+		assert.ErrorIs(t, err, ErrNoWorkingPath)
+		assert.Equal(t, paths, sent, "all paths are probed, in order")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		send := func(p *Path, seq uint16) {
+			cancel()
+		}
+		_, err := awaitProbeReply(ctx, remote, paths, send, make(chan ping.Reply))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, ErrNoWorkingPath)
+	})
+}
+
+// testProbeReply returns the reply of src to a probe sent on p, received on
+// the reversed path.
+func testProbeReply(t *testing.T, src scionAddr, p *Path) ping.Reply {
+	var decoded scion.Decoded
+	require.NoError(t, decoded.DecodeFromBytes(p.ForwardingPath.dataplanePath.(snetpath.SCION).Raw))
+	reversed, err := decoded.Reverse()
+	require.NoError(t, err)
+	raw := make([]byte, reversed.Len())
+	require.NoError(t, reversed.SerializeTo(raw))
+	return ping.Reply{
+		Source: snet.SCIONAddress{IA: addr.IA(src.IA), Host: addr.HostIP(src.IP)},
+		Path:   snet.RawPath{PathType: scion.PathType, Raw: raw},
+	}
+}
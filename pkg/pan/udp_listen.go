@@ -19,9 +19,9 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"net/netip"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/scionproto/scion/pkg/snet"
@@ -55,24 +55,62 @@ type ListenConn interface {
 	ReadFromVia(b []byte) (int, UDPAddr, *Path, error)
 	// WriteToVia writes a message to the remote address via the given path.
 	// This bypasses selector used for WriteTo.
+	// If path is nil and dst is in a remote AS, a path is looked up from the
+	// path pool, as for WriteToIA.
 	WriteToVia(b []byte, dst UDPAddr, path *Path) (int, error)
+	// WriteToIA writes a message to the remote address via a path looked up
+	// from the path pool. This bypasses the selector used for WriteTo and
+	// allows to initiate contact with remotes that have never been heard from.
+	WriteToIA(b []byte, dst UDPAddr) (int, error)
+	// ReadBatch reads up to len(msgs) messages, using a single system call
+	// where supported. Blocks until at least one message is read. Sets the
+	// remote address and the (return-)path of each message read and returns
+	// the number of messages read.
+	ReadBatch(msgs []Message) (int, error)
+	// WriteBatch writes the messages to their respective Addr, using as few
+	// system calls as possible. Messages with a nil Path are sent on the path
+	// chosen as for WriteTo. Returns the number of messages written.
+	WriteBatch(msgs []Message) (int, error)
+	// SetTrafficClass sets the traffic class for the packets written on this
+	// connection, in the SCION header and in the underlay IP header. The
+	// default is 0.
+	SetTrafficClass(tc TrafficClass) error
+	// EnableDeduplication enables the deduplication of received packets, for
+	// remotes sending with a RedundantScheduler, see
+	// MultiPathConn.EnableDeduplication. The sequence numbers are tracked per
+	// remote. Deduplication must be enabled on all remotes. Must be called
+	// before any packet is sent or received.
+	EnableDeduplication()
+	// DedupStats returns the statistics of the deduplication of received
+	// packets, for all remotes. Zero if deduplication is not enabled.
+	DedupStats() DedupStats
 }
 
-func ListenUDP(ctx context.Context, local netip.AddrPort,
-	selector ReplySelector) (ListenConn, error) {
-
-	local, err := defaultLocalAddr(local)
+// ListenUDP opens a SCION/UDP socket, listening on the local address set with
+// WithLocalAddr.
+// If the IP is left unspecified, a default local IP is chosen, see the
+// package documentation. If the port is 0, it is chosen from the endhost port
+// range of the local AS. A fixed port outside of this range only receives
+// packets via a shim dispatcher.
+//
+// The selector, set with WithReplySelector, chooses the reply paths; if it is
+// not set, a DefaultReplySelector is used.
+func ListenUDP(ctx context.Context, opts ...ListenOption) (ListenConn, error) {
+	o := applyListenOptions(opts)
+	local, err := defaultLocalAddr(o.local)
 	if err != nil {
 		return nil, err
 	}
 
+	selector := o.selector
 	if selector == nil {
 		selector = NewDefaultReplySelector()
 	}
 	stats.subscribe(selector)
+	handler := scmpHandler{next: o.scmpHandlers}
 	sn := snet.SCIONNetwork{
 		Topology:    host().sciond,
-		SCMPHandler: scmpHandler{},
+		SCMPHandler: handler,
 	}
 	conn, err := sn.OpenRaw(ctx, net.UDPAddrFromAddrPort(local))
 	if err != nil {
@@ -90,13 +128,101 @@ func ListenUDP(ctx context.Context, local netip.AddrPort,
 		fmt.Printf("Listening addr=%s\n", localUDPAddr)
 	}
 
-	return &listenConn{
+	c := &listenConn{
 		baseUDPConn: baseUDPConn{
-			raw: conn,
+			raw:     conn,
+			metrics: newConnMetrics(localUDPAddr, UDPAddr{}),
+			scmp:    handler,
+			auth:    newPacketAuthenticator(o.connOptions),
 		},
 		local:    localUDPAddr,
 		selector: selector,
-	}, nil
+	}
+	openConns.addListen(c)
+	return fragmentListenConn(c, o.connOptions), nil
+}
+
+// ListenUDPMulti opens n sockets bound to the same local address with
+// SO_REUSEPORT and returns a ListenConn for each. The kernel distributes the
+// received packets among the sockets by a hash of the remote address, so that
+// the packets of a remote are always received on the same socket. Serving each
+// connection from its own goroutine allows servers to scale beyond a single
+// receive loop.
+//
+// All connections share the selector, which must be safe for concurrent use,
+// as is the DefaultReplySelector. The selector is closed when the last
+// connection is closed.
+// The options are those of ListenUDP.
+// Only supported on unix platforms.
+func ListenUDPMulti(ctx context.Context, n int, opts ...ListenOption) ([]ListenConn, error) {
+	if n < 1 {
+		return nil, errors.New("ListenUDPMulti: number of sockets must be positive")
+	}
+	o := applyListenOptions(opts)
+	local, err := defaultLocalAddr(o.local)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := host().sciond.PortRange(ctx)
+	if err != nil {
+		return nil, err
+	}
+	udpConns, err := listenUDPReusePort(local, n, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := o.selector
+	if selector == nil {
+		selector = NewDefaultReplySelector()
+	}
+	stats.subscribe(selector)
+	ipport := udpConns[0].LocalAddr().(*net.UDPAddr).AddrPort()
+	localUDPAddr := UDPAddr{
+		IA:   host().ia,
+		IP:   ipport.Addr(),
+		Port: ipport.Port(),
+	}
+	selector.Initialize(localUDPAddr)
+
+	if len(os.Getenv("SCION_GO_INTEGRATION")) > 0 {
+		fmt.Printf("Listening addr=%s\n", localUDPAddr)
+	}
+
+	return newListenConnsShared(udpConns, localUDPAddr, selector, o.connOptions), nil
+}
+
+// newListenConnsShared returns a listenConn for each of the sockets, sharing
+// the selector.
+func newListenConnsShared(udpConns []*net.UDPConn, local UDPAddr, selector ReplySelector,
+	o connOptions) []ListenConn {
+
+	handler := scmpHandler{next: o.scmpHandlers}
+	refs := &atomic.Int32{}
+	refs.Store(int32(len(udpConns)))
+	metrics := newConnMetrics(local, UDPAddr{})
+	// The authenticator is safe for concurrent use and shares the key cache.
+	auth := newPacketAuthenticator(o)
+	conns := make([]ListenConn, len(udpConns))
+	for i, conn := range udpConns {
+		c := &listenConn{
+			baseUDPConn: baseUDPConn{
+				raw: &snet.SCIONPacketConn{
+					Conn:        conn,
+					SCMPHandler: handler,
+				},
+				metrics: metrics,
+				scmp:    handler,
+				auth:    auth,
+			},
+			local:        local,
+			selector:     selector,
+			selectorRefs: refs,
+		}
+		openConns.addListen(c)
+		conns[i] = fragmentListenConn(c, o)
+	}
+	return conns
 }
 
 type listenConn struct {
@@ -104,6 +230,19 @@ type listenConn struct {
 
 	local    UDPAddr
 	selector ReplySelector
+	// selectorRefs counts the open connections sharing the selector and the
+	// metrics, see ListenUDPMulti. Nil if they are not shared.
+	selectorRefs *atomic.Int32
+
+	dedup atomic.Pointer[deduplicator] // nil unless deduplication is enabled
+}
+
+func (c *listenConn) EnableDeduplication() {
+	c.dedup.CompareAndSwap(nil, newDeduplicator(c.metrics))
+}
+
+func (c *listenConn) DedupStats() DedupStats {
+	return c.dedup.Load().Stats()
 }
 
 func (c *listenConn) LocalAddr() net.Addr {
@@ -115,14 +254,21 @@ func (c *listenConn) ReadFrom(b []byte) (int, net.Addr, error) {
 	return n, remote, err
 }
 
+// ReadFromVia reads the next packet; if deduplication is enabled, duplicates
+// are skipped.
 func (c *listenConn) ReadFromVia(b []byte) (int, UDPAddr, *Path, error) {
-	n, remote, fwPath, err := c.baseUDPConn.readMsg(b)
-	if err != nil {
-		return n, UDPAddr{}, nil, err
+	for {
+		n, remote, fwPath, err := c.baseUDPConn.readMsg(b, true)
+		if err != nil {
+			return n, UDPAddr{}, nil, err
+		}
+		path, err := reversePathFromForwardingPath(remote.IA, c.local.IA, fwPath)
+		c.selector.Record(remote, path)
+		n, ok := c.dedup.Load().receive(remote, b[:n])
+		if ok {
+			return n, remote, path, err
+		}
 	}
-	path, err := reversePathFromForwardingPath(remote.IA, c.local.IA, fwPath)
-	c.selector.Record(remote, path)
-	return n, remote, path, err
 }
 
 func (c *listenConn) WriteTo(b []byte, dst net.Addr) (int, error) {
@@ -132,23 +278,118 @@ func (c *listenConn) WriteTo(b []byte, dst net.Addr) (int, error) {
 	}
 	var path *Path
 	if c.local.IA != sdst.IA {
+		// if the selector has no path, e.g. for a remote never heard from
+		// before, WriteToVia looks up a path.
 		path = c.selector.Path(sdst)
-		if path == nil {
-			return 0, errNoPathTo(sdst.IA)
-		}
 	}
 	return c.WriteToVia(b, sdst, path)
 }
 
 func (c *listenConn) WriteToVia(b []byte, dst UDPAddr, path *Path) (int, error) {
+	return c.dedup.Load().write(dst, b, func(b []byte) (int, error) {
+		if path == nil && c.local.IA != dst.IA {
+			return c.writeToIA(b, dst)
+		}
+		return c.baseUDPConn.writeMsg(c.local, dst, path, b)
+	})
+}
+
+func (c *listenConn) WriteToIA(b []byte, dst UDPAddr) (int, error) {
+	return c.dedup.Load().write(dst, b, func(b []byte) (int, error) {
+		return c.writeToIA(b, dst)
+	})
+}
+
+// writeToIA implements WriteToIA, after prepending the sequence number if
+// deduplication is enabled.
+func (c *listenConn) writeToIA(b []byte, dst UDPAddr) (int, error) {
+	var path *Path
+	if c.local.IA != dst.IA {
+		ctx, cancel := context.WithTimeout(context.Background(), pathLookupTimeout)
+		defer cancel()
+		var err error
+		path, err = lookupPath(ctx, dst.IA)
+		if err != nil {
+			return 0, err
+		}
+	}
 	return c.baseUDPConn.writeMsg(c.local, dst, path, b)
 }
 
+func (c *listenConn) ReadBatch(msgs []Message) (int, error) {
+	return c.baseUDPConn.readBatch(msgs, true, func(m *Message, remote UDPAddr, fw ForwardingPath) bool {
+		path, err := reversePathFromForwardingPath(remote.IA, c.local.IA, fw)
+		if err != nil {
+			return false // drop the packet if there is something wrong with the path
+		}
+		c.selector.Record(remote, path)
+		m.Addr = remote
+		m.Path = path
+		var ok bool
+		m.N, ok = c.dedup.Load().receive(remote, m.Buffer[:m.N])
+		return ok
+	})
+}
+
+func (c *listenConn) WriteBatch(msgs []Message) (int, error) {
+	msgs = c.dedup.Load().frameBatch(msgs, func(m *Message) UDPAddr { return m.Addr })
+	routes := make([]batchRoute, len(msgs))
+	for i, m := range msgs {
+		path := m.Path
+		if path == nil && c.local.IA != m.Addr.IA {
+			path = c.selector.Path(m.Addr)
+		}
+		if path == nil && c.local.IA != m.Addr.IA {
+			ctx, cancel := context.WithTimeout(context.Background(), pathLookupTimeout)
+			var err error
+			path, err = lookupPath(ctx, m.Addr.IA)
+			cancel()
+			if err != nil {
+				return 0, err
+			}
+		}
+		routes[i] = batchRoute{dst: m.Addr, path: path}
+	}
+	return c.baseUDPConn.writeBatch(c.local, msgs, routes)
+}
+
+// lookupPath returns a path to dst from the path pool, for sending without
+// a selector. Returns the first path, in the order returned by the SCION
+// daemon, that is not affected by down notifications, if possible. The
+// daemon is queried again once the cached paths are older than
+// RefreshMinInterval or expired, see pathPool.paths.
+func lookupPath(ctx context.Context, dst IA) (*Path, error) {
+	paths, err := pool.paths(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errNoPathTo(dst)
+	}
+	if better := stats.FirstMoreAlive(paths[0], paths); better >= 0 {
+		return paths[better], nil
+	}
+	return paths[0], nil
+}
+
+// Close closes the connection, and its selector unless it is shared with
+// other open connections. As for a dialed connection, it is safe to call
+// concurrently with reads and writes. Closing a closed connection returns
+// net.ErrClosed.
 func (c *listenConn) Close() error {
+	if !c.markClosed() {
+		return net.ErrClosed
+	}
+	openConns.remove(c)
+	if c.selectorRefs != nil && c.selectorRefs.Add(-1) > 0 {
+		// The selector and the metrics are still used by other connections.
+		return c.closeRaw()
+	}
 	stats.unsubscribe(c.selector)
 	// FIXME: multierror!
 	_ = c.selector.Close()
-	return c.baseUDPConn.Close()
+	c.metrics.close()
+	return c.closeRaw()
 }
 
 type DefaultReplySelector struct {
@@ -15,9 +15,12 @@
 package pan
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPathsMRU(t *testing.T) {
@@ -87,3 +90,38 @@ func TestPathsMRU(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupPath(t *testing.T) {
+	dst := MustParseIA("1-ff00:0:113")
+	pi := PathInterface{IA: dst, IfID: 1}
+	p0 := &Path{
+		Destination: dst,
+		Fingerprint: "lookup-p0",
+		Expiry:      time.Now().Add(time.Hour),
+		Metadata:    &PathMetadata{Interfaces: []PathInterface{pi}},
+	}
+	p1 := &Path{
+		Destination: dst,
+		Fingerprint: "lookup-p1",
+		Expiry:      time.Now().Add(time.Hour),
+		Metadata:    &PathMetadata{Interfaces: []PathInterface{{IA: dst, IfID: 2}}},
+	}
+	// cached entry, recent enough to be returned without querying the daemon
+	pool.entriesMutex.Lock()
+	pool.entries[dst] = pathPoolDst{lastQuery: time.Now(), paths: []*Path{p0, p1}}
+	pool.entriesMutex.Unlock()
+	defer func() {
+		pool.entriesMutex.Lock()
+		delete(pool.entries, dst)
+		pool.entriesMutex.Unlock()
+	}()
+
+	p, err := lookupPath(context.Background(), dst)
+	require.NoError(t, err)
+	assert.Equal(t, p0, p)
+
+	stats.recordPathDown(p0.Fingerprint, pi)
+	p, err = lookupPath(context.Background(), dst)
+	require.NoError(t, err)
+	assert.Equal(t, p1, p)
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quicutil
+
+import (
+	"math"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+const (
+	// DefaultKeepAlivePeriod is the keep-alive period for paths with a low RTT
+	// and no loss.
+	DefaultKeepAlivePeriod = 15 * time.Second
+	// DefaultMaxIdleTimeout is the idle timeout for paths with a low RTT, the
+	// default of quic-go.
+	DefaultMaxIdleTimeout = 30 * time.Second
+	// MaxDerivedIdleTimeout is the highest idle timeout derived from the path.
+	MaxDerivedIdleTimeout = 5 * time.Minute
+
+	// idleTimeoutRTTs is the minimum idle timeout, in round trips.
+	idleTimeoutRTTs = 20
+	// keepAliveLossTarget is the targeted probability that all keep-alives
+	// sent within an idle timeout are lost.
+	keepAliveLossTarget = 1e-6
+	// minLoss and maxLoss bound the loss used to derive the keep-alive period.
+	minLoss = 0.001
+	maxLoss = 0.5
+)
+
+// PathTimeouts are the QUIC keep-alive period and idle timeout for a path.
+type PathTimeouts struct {
+	KeepAlivePeriod time.Duration
+	MaxIdleTimeout  time.Duration
+}
+
+// DerivePathTimeouts returns the timeouts for a path with the given round
+// trip time and loss rate (0 to 1). Zero values mean unknown.
+//
+// The idle timeout is DefaultMaxIdleTimeout, or idleTimeoutRTTs round trips
+// on paths with a very high RTT, at most MaxDerivedIdleTimeout. The
+// keep-alive period is chosen such that enough keep-alives are sent within
+// the idle timeout that it is unlikely that all of them are lost. With no
+// loss, this is DefaultKeepAlivePeriod.
+func DerivePathTimeouts(rtt time.Duration, loss float64) PathTimeouts {
+	idle := min(max(DefaultMaxIdleTimeout, idleTimeoutRTTs*rtt), MaxDerivedIdleTimeout)
+	loss = min(max(loss, minLoss), maxLoss)
+	keepAlives := max(2, math.Ceil(math.Log(keepAliveLossTarget)/math.Log(loss)))
+	keepAlive := min((idle-rtt)/time.Duration(keepAlives), DefaultKeepAlivePeriod)
+	return PathTimeouts{
+		KeepAlivePeriod: keepAlive.Truncate(time.Millisecond),
+		MaxIdleTimeout:  idle,
+	}
+}
+
+// ConnPathTimeouts returns the timeouts for the current path of conn, see
+// DerivePathTimeouts, when conn is dialed.
+// The RTT is twice the latency announced in the path metadata. As no packets
+// were exchanged at this time, the loss of the path is unknown and the
+// keep-alive period is derived for a path without loss; the connection
+// statistics are not used. Use DerivePathTimeouts to take a known loss rate
+// into account.
+func ConnPathTimeouts(conn pan.Conn) PathTimeouts {
+	var rtt time.Duration
+	if path := conn.GetPath(); path != nil && path.Metadata != nil {
+		for _, l := range path.Metadata.Latency {
+			if l > 0 {
+				rtt += 2 * l
+			}
+		}
+	}
+	return DerivePathTimeouts(rtt, 0)
+}
+
+// Apply returns a copy of conf with the timeouts. Timeouts already set in
+// conf are kept, i.e. these override the derived timeouts. The keep-alive
+// period is at most half of the idle timeout.
+func (t PathTimeouts) Apply(conf *quic.Config) *quic.Config {
+	if conf == nil {
+		conf = &quic.Config{}
+	} else {
+		conf = conf.Clone()
+	}
+	if conf.MaxIdleTimeout == 0 {
+		conf.MaxIdleTimeout = t.MaxIdleTimeout
+	}
+	if conf.KeepAlivePeriod == 0 {
+		conf.KeepAlivePeriod = min(t.KeepAlivePeriod, conf.MaxIdleTimeout/2)
+	}
+	return conf
+}
+
+// WithPathTimeouts is a dial option for pan.DialQUIC that sets the QUIC
+// keep-alive period and idle timeout derived from the path of the dialed
+// connection, see ConnPathTimeouts. Timeouts set explicitly in the QUIC
+// config passed to the dial take precedence.
+// Note that the effective idle timeout is the minimum of the idle timeouts of
+// both peers; servers expecting clients on paths with a very high RTT should
+// set a MaxIdleTimeout of up to MaxDerivedIdleTimeout.
+func WithPathTimeouts() pan.DialOption {
+	return pan.WithQUICConfig(func(conn pan.Conn, conf *quic.Config) *quic.Config {
+		return ConnPathTimeouts(conn).Apply(conf)
+	})
+}
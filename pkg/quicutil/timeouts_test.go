@@ -0,0 +1,102 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quicutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+func TestDerivePathTimeouts(t *testing.T) {
+	cases := []struct {
+		name     string
+		rtt      time.Duration
+		loss     float64
+		expected PathTimeouts
+	}{
+		{"unknown", 0, 0, PathTimeouts{15 * time.Second, 30 * time.Second}},
+		{"low RTT", 20 * time.Millisecond, 0, PathTimeouts{14990 * time.Millisecond, 30 * time.Second}},
+		{"lossy", 0, 0.01, PathTimeouts{10 * time.Second, 30 * time.Second}},
+		{"very lossy", 0, 0.1, PathTimeouts{5 * time.Second, 30 * time.Second}},
+		{"high RTT", 3 * time.Second, 0, PathTimeouts{15 * time.Second, time.Minute}},
+		{"high RTT, lossy", 3 * time.Second, 0.1, PathTimeouts{9500 * time.Millisecond, time.Minute}},
+		{"extreme RTT", time.Minute, 0, PathTimeouts{15 * time.Second, MaxDerivedIdleTimeout}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, DerivePathTimeouts(c.rtt, c.loss))
+		})
+	}
+}
+
+func TestPathTimeoutsApply(t *testing.T) {
+	timeouts := PathTimeouts{KeepAlivePeriod: 10 * time.Second, MaxIdleTimeout: time.Minute}
+	conf := timeouts.Apply(nil)
+	assert.Equal(t, 10*time.Second, conf.KeepAlivePeriod)
+	assert.Equal(t, time.Minute, conf.MaxIdleTimeout)
+
+	// explicitly set timeouts override the derived ones
+	orig := &quic.Config{MaxIdleTimeout: 8 * time.Second}
+	conf = timeouts.Apply(orig)
+	assert.Equal(t, 4*time.Second, conf.KeepAlivePeriod)
+	assert.Equal(t, 8*time.Second, conf.MaxIdleTimeout)
+	assert.Zero(t, orig.KeepAlivePeriod, "config is copied")
+
+	conf = timeouts.Apply(&quic.Config{KeepAlivePeriod: time.Second})
+	assert.Equal(t, time.Second, conf.KeepAlivePeriod)
+	assert.Equal(t, time.Minute, conf.MaxIdleTimeout)
+}
+
+func TestConnPathTimeouts(t *testing.T) {
+	path := &pan.Path{
+		Fingerprint: "p0",
+		Metadata:    &pan.PathMetadata{Latency: []time.Duration{500 * time.Millisecond, 0, time.Second}},
+	}
+	other := &pan.Path{Fingerprint: "p1"}
+
+	// RTT from the path metadata
+	conn := &statsConn{path: path}
+	assert.Equal(t, DerivePathTimeouts(3*time.Second, 0), ConnPathTimeouts(conn))
+
+	// The connection statistics are not used.
+	conn.stats = []pan.ConnPathStats{
+		{Path: other, SentPackets: 100, ReceivedPackets: 99, LastRTT: time.Second},
+		{Path: path, SentPackets: 100, ReceivedPackets: 81, LastRTT: 2 * time.Second},
+	}
+	assert.Equal(t, DerivePathTimeouts(3*time.Second, 0), ConnPathTimeouts(conn))
+
+	conn.path = other
+	assert.Equal(t, DerivePathTimeouts(0, 0), ConnPathTimeouts(conn))
+}
+
+// statsConn is a pan.Conn with a fixed path and statistics.
+type statsConn struct {
+	pan.Conn
+	path  *pan.Path
+	stats []pan.ConnPathStats
+}
+
+func (c *statsConn) GetPath() *pan.Path {
+	return c.path
+}
+
+func (c *statsConn) Stats() []pan.ConnPathStats {
+	return c.stats
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+const (
+	// failoverGrace is the time given to the selector of a connection to
+	// switch to another path after a path down notification for its path.
+	// The selectors are notified asynchronously.
+	failoverGrace = 200 * time.Millisecond
+	// maxFailoverRetries is the number of times a request is retried on a new
+	// connection after its connection failed because its path went down.
+	maxFailoverRetries = 2
+)
+
+// failoverConn is a connection dialed by a Dialer. It is closed when the path
+// of its QUIC session goes down and the session's selector has no other path
+// to switch to, so that the requests in progress fail immediately instead of
+// waiting for the QUIC idle timeout, and the connection is not reused.
+type failoverConn struct {
+	net.Conn
+	// path returns the path currently used by the session, nil in the local AS.
+	path func() *pan.Path
+	// closeSession closes the QUIC session.
+	closeSession func()
+	// failed is set when the connection was closed because its path went down.
+	failed atomic.Bool
+}
+
+func newFailoverConn(conn net.Conn, session *pan.QUICSession) *failoverConn {
+	c := &failoverConn{
+		Conn: conn,
+		path: session.Conn.GetPath,
+		closeSession: func() {
+			_ = session.CloseWithError(0, "path down")
+		},
+	}
+	pathWatch.add(c)
+	return c
+}
+
+// GetPath returns the path currently used by the QUIC session.
+func (c *failoverConn) GetPath() *pan.Path {
+	return c.path()
+}
+
+func (c *failoverConn) Close() error {
+	pathWatch.remove(c)
+	return c.Conn.Close()
+}
+
+// onPath returns whether the connection currently uses the path, or a path
+// with the interface, notified down by the event.
+func (c *failoverConn) onPath(e pan.Event) bool {
+	p := c.path()
+	if p == nil {
+		return false
+	}
+	if p.Fingerprint == e.Fingerprint {
+		return true
+	}
+	if p.Metadata != nil {
+		for _, pi := range p.Metadata.Interfaces {
+			if pi == e.Interface {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fail closes the connection after its path went down.
+func (c *failoverConn) fail() {
+	c.failed.Store(true)
+	c.closeSession()
+}
+
+// pathWatch closes the failoverConns whose path went down.
+var pathWatch pathWatcher
+
+// pathWatcher subscribes to the path events once the first connection is
+// added, and closes the connections on which a path down notification was
+// not followed by a switch to another path.
+type pathWatcher struct {
+	once  sync.Once
+	mutex sync.Mutex
+	conns map[*failoverConn]struct{}
+}
+
+func (w *pathWatcher) add(c *failoverConn) {
+	w.once.Do(func() {
+		events, err := pan.SubscribeEvents(context.Background())
+		if err != nil {
+			return // only fails for a done context
+		}
+		go func() {
+			for e := range events {
+				if e.Type == pan.EventPathDown {
+					w.pathDown(e)
+				}
+			}
+		}()
+	})
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conns == nil {
+		w.conns = make(map[*failoverConn]struct{})
+	}
+	w.conns[c] = struct{}{}
+}
+
+func (w *pathWatcher) remove(c *failoverConn) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.conns, c)
+}
+
+// pathDown closes the connections on the path notified down by the event that
+// are still on it after failoverGrace.
+func (w *pathWatcher) pathDown(e pan.Event) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for c := range w.conns {
+		if !c.onPath(e) {
+			continue
+		}
+		c := c
+		time.AfterFunc(failoverGrace, func() {
+			if c.onPath(e) {
+				c.fail()
+			}
+		})
+	}
+}
+
+// roundTripWithFailover performs the request with rt. If it fails because the
+// path of its connection went down, a replayable request is retried, on a new
+// connection.
+func roundTripWithFailover(rt http.RoundTripper, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var conn atomic.Pointer[failoverConn]
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				c := info.Conn
+				if tlsConn, ok := c.(*tls.Conn); ok {
+					c = tlsConn.NetConn()
+				}
+				if fc, ok := c.(*failoverConn); ok {
+					conn.Store(fc)
+				}
+			},
+		}
+		resp, err := rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		if err == nil || attempt >= maxFailoverRetries || req.Context().Err() != nil ||
+			conn.Load() == nil || !conn.Load().failed.Load() || !isReplayable(req) {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// isReplayable returns whether the request can be sent again, as for the
+// retries of net/http: its method is idempotent, or it has an idempotency key,
+// and its body can be recreated.
+func isReplayable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
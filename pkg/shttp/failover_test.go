@@ -0,0 +1,116 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+func TestFailoverConnOnPath(t *testing.T) {
+	pi := pan.PathInterface{IA: pan.MustParseIA("1-ff00:0:110"), IfID: 1}
+	path := &pan.Path{
+		Fingerprint: "a",
+		Metadata:    &pan.PathMetadata{Interfaces: []pan.PathInterface{pi}},
+	}
+	c := &failoverConn{path: func() *pan.Path { return path }}
+
+	assert.True(t, c.onPath(pan.Event{Type: pan.EventPathDown, Fingerprint: "a"}))
+	assert.True(t, c.onPath(pan.Event{Type: pan.EventPathDown, Fingerprint: "b", Interface: pi}))
+	assert.False(t, c.onPath(pan.Event{Type: pan.EventPathDown, Fingerprint: "b"}))
+
+	// local AS
+	c.path = func() *pan.Path { return nil }
+	assert.False(t, c.onPath(pan.Event{Type: pan.EventPathDown, Fingerprint: "a"}))
+}
+
+func TestRoundTripWithFailover(t *testing.T) {
+	var requests atomic.Int32
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if requests.Add(1) == 1 {
+			// the path of the first connection goes down during the request
+			started <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			c := &failoverConn{
+				Conn:         conn,
+				path:         func() *pan.Path { return &pan.Path{Fingerprint: "a"} },
+				closeSession: func() { _ = conn.Close() },
+			}
+			pathWatch.add(c)
+			return c, nil
+		},
+	}
+	defer transport.CloseIdleConnections()
+	pathDown := func() {
+		<-started
+		pathWatch.pathDown(pan.Event{Type: pan.EventPathDown, Fingerprint: "a"})
+	}
+
+	go pathDown()
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := roundTripWithFailover(transport, req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.EqualValues(t, 2, requests.Load())
+
+	// non-idempotent requests are not retried
+	requests.Store(0)
+	go pathDown()
+	req, err = http.NewRequest(http.MethodPost, server.URL, strings.NewReader("data"))
+	require.NoError(t, err)
+	_, err = roundTripWithFailover(transport, req)
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, requests.Load())
+}
+
+func TestIsReplayable(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "http://example.org", nil)
+	assert.True(t, isReplayable(get))
+	post, _ := http.NewRequest(http.MethodPost, "http://example.org", strings.NewReader("data"))
+	assert.False(t, isReplayable(post))
+	post.Header.Set("Idempotency-Key", "1")
+	assert.True(t, isReplayable(post))
+	post.GetBody = nil
+	assert.False(t, isReplayable(post))
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+type policyContextKey struct{}
+
+// WithPolicy returns a context that makes PooledTransport use the given path
+// policy for a request, e.g.
+//
+//	req = req.WithContext(shttp.WithPolicy(req.Context(), policy))
+func WithPolicy(ctx context.Context, policy pan.Policy) context.Context {
+	return context.WithValue(ctx, policyContextKey{}, policy)
+}
+
+// PolicyFromContext returns the path policy set with WithPolicy, if any.
+func PolicyFromContext(ctx context.Context) (pan.Policy, bool) {
+	policy, ok := ctx.Value(policyContextKey{}).(pan.Policy)
+	return policy, ok
+}
+
+// WithSequence is WithPolicy for a pan.Sequence policy, given as the textual
+// pattern of hop predicates, e.g. "0* 1-ff00:0:110 0*" to route through the AS
+// 1-ff00:0:110; see pan.NewSequence. Requests with the same pattern share a
+// connection pool of PooledTransport.
+func WithSequence(ctx context.Context, sequence string) (context.Context, error) {
+	policy, err := pan.NewSequence(sequence)
+	if err != nil {
+		return nil, err
+	}
+	return WithPolicy(ctx, policy), nil
+}
+
+// PooledTransport is a RoundTripper for HTTP over SCION/QUIC that keeps a
+// separate connection pool for each path policy. The policy of a request is
+// set with WithPolicy; requests without a policy use Policy.
+// Connections are only reused for requests to the same host with the same
+// policy, so that concurrent requests with different policies never share a
+// connection, while requests with the same policy do not dial a new QUIC
+// session each.
+//
+// If the path of a connection goes down and no other path is available to
+// its QUIC session, the connection is closed, see Dialer.DialContext. Requests
+// that failed on it are retried on a new connection, dialed over the paths
+// that are still available, if they can be replayed: their method is
+// idempotent (or they have an Idempotency-Key header) and their body can be
+// recreated with GetBody. Failures while reading the response body are
+// returned to the caller.
+//
+// The policies of the pan package are considered the same if they have the
+// same parameters, e.g. two pan.Sequence policies parsed from the same
+// pattern, also when combined with pan.PolicyChain or pan.Preferred. Other
+// policies are considered the same if they are equal, as for ==, or, for
+// types that cannot be compared, if their formatted values are equal.
+//
+// The zero value is ready to use. The fields must not be modified after the
+// first request.
+type PooledTransport struct {
+	Local      netip.AddrPort
+	QuicConfig *quic.Config
+	// Policy is the path policy for requests without a policy set with
+	// WithPolicy.
+	Policy pan.Policy
+	// IdleConnTimeout is the maximum time an idle connection is kept open.
+	// The connection pool of a policy is removed once it has not been used for
+	// this time. Zero means the IdleConnTimeout of DefaultTransport.
+	IdleConnTimeout time.Duration
+	// MaxConnsPerHost limits the number of connections per host and policy,
+	// see http.Transport.MaxConnsPerHost. Zero means no limit.
+	MaxConnsPerHost int
+	// MaxIdleConnsPerHost limits the number of idle connections kept per host
+	// and policy, see http.Transport.MaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+
+	mutex sync.Mutex
+	pools map[any]*policyPool
+}
+
+// policyPool is the connection pool for one policy.
+type policyPool struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+func (t *PooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy, ok := PolicyFromContext(req.Context())
+	if !ok {
+		policy = t.Policy
+	}
+	return roundTripWithFailover(t.transport(policy), req)
+}
+
+// CloseIdleConnections closes the idle connections of all policies.
+func (t *PooledTransport) CloseIdleConnections() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, p := range t.pools {
+		p.transport.CloseIdleConnections()
+	}
+}
+
+// transport returns the transport for the policy, creating it if necessary.
+// Removes the pools of policies that have not been used for longer than the
+// idle timeout.
+func (t *PooledTransport) transport(policy pan.Policy) *http.Transport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	for key, p := range t.pools {
+		if now.Sub(p.lastUsed) > t.idleConnTimeout() {
+			p.transport.CloseIdleConnections()
+			delete(t.pools, key)
+		}
+	}
+
+	key := policyKey(policy)
+	p, ok := t.pools[key]
+	if !ok {
+		dialer := &Dialer{
+			Local:      t.Local,
+			QuicConfig: t.QuicConfig,
+			Policy:     policy,
+		}
+		transport := DefaultTransport.Clone()
+		transport.DialContext = dialer.DialContext
+		transport.IdleConnTimeout = t.idleConnTimeout()
+		transport.MaxConnsPerHost = t.MaxConnsPerHost
+		transport.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+		p = &policyPool{transport: transport}
+		if t.pools == nil {
+			t.pools = make(map[any]*policyPool)
+		}
+		t.pools[key] = p
+	}
+	p.lastUsed = now
+	return p.transport
+}
+
+func (t *PooledTransport) idleConnTimeout() time.Duration {
+	if t.IdleConnTimeout > 0 {
+		return t.IdleConnTimeout
+	}
+	return DefaultTransport.IdleConnTimeout
+}
+
+// policyName is the canonical string form of a policy, used as map key.
+type policyName string
+
+// policyKey returns a map key identifying the policy. The policies of the pan
+// package are identified by their canonical string form, see policyString,
+// so that e.g. two Sequences parsed from the same pattern share a key. Other
+// comparable policies are their own key, for the others the formatted value
+// is used.
+func policyKey(policy pan.Policy) any {
+	if name, ok := policyString(policy); ok {
+		return policyName(name)
+	}
+	if policy == nil || reflect.ValueOf(policy).Comparable() {
+		return policy
+	}
+	return policyName(fmt.Sprintf("%T %v", policy, policy))
+}
+
+// policyString returns the canonical string form of a policy of the pan
+// package, including the policies it is composed of. Returns false if any of
+// these is of another type.
+func policyString(policy pan.Policy) (string, bool) {
+	switch p := policy.(type) {
+	case pan.Sequence:
+		return "Sequence(" + p.String() + ")", true
+	case *pan.ACL:
+		return "ACL(" + p.String() + ")", true
+	case pan.Pinned:
+		return fmt.Sprintf("Pinned%q", []pan.PathFingerprint(p)), true
+	case pan.Preferred:
+		s, ok := policyString(p.Preferred)
+		return "Preferred(" + s + ")", ok
+	case pan.PolicyChain:
+		parts := make([]string, len(p))
+		for i, q := range p {
+			var ok bool
+			if parts[i], ok = policyString(q); !ok {
+				return "", false
+			}
+		}
+		return "PolicyChain(" + strings.Join(parts, ", ") + ")", true
+	case pan.LowestLatency, pan.HighestBandwidth, pan.LeastHops, pan.HighestMTU,
+		pan.RequireHiddenPaths, pan.AvoidHiddenPaths:
+		return fmt.Sprintf("%T", p), true
+	}
+	return "", false
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+func TestPooledTransport(t *testing.T) {
+	pt := &PooledTransport{IdleConnTimeout: time.Minute, MaxConnsPerHost: 4}
+
+	defaultTransport := pt.transport(nil)
+	assert.Same(t, defaultTransport, pt.transport(nil))
+	assert.Equal(t, 4, defaultTransport.MaxConnsPerHost)
+	assert.Equal(t, time.Minute, defaultTransport.IdleConnTimeout)
+
+	// equal policies share a pool, also if not comparable
+	chain := func() pan.Policy {
+		return pan.PolicyChain{pan.LeastHops{}, pan.Pinned{"a", "b"}}
+	}
+	assert.Same(t, pt.transport(pan.LeastHops{}), pt.transport(pan.LeastHops{}))
+	assert.Same(t, pt.transport(chain()), pt.transport(chain()))
+	assert.NotSame(t, pt.transport(chain()), pt.transport(pan.Pinned{"a", "b"}))
+	assert.NotSame(t, defaultTransport, pt.transport(pan.LeastHops{}))
+	assert.Len(t, pt.pools, 4)
+
+	// policies of the pan package are compared by their parameters
+	sequence := func(s string) pan.Policy {
+		seq, err := pan.NewSequence(s)
+		require.NoError(t, err)
+		return pan.PolicyChain{pan.Preferred{Preferred: pan.Pinned{"a"}}, seq}
+	}
+	assert.Same(t, pt.transport(sequence("0* 1-ff00:0:110 0*")), pt.transport(sequence("0* 1-ff00:0:110 0*")))
+	assert.NotSame(t, pt.transport(sequence("0* 1-ff00:0:110 0*")), pt.transport(sequence("0* 1-ff00:0:111 0*")))
+	assert.Equal(t,
+		policyName(`PolicyChain(Preferred(Pinned["a"]), Sequence(0* 1-ff00:0:110 0*))`),
+		policyKey(sequence("0* 1-ff00:0:110 0*")))
+	assert.Len(t, pt.pools, 6)
+
+	// unused pools are removed
+	for _, p := range pt.pools {
+		p.lastUsed = time.Now().Add(-2 * time.Minute)
+	}
+	pt.transport(nil)
+	assert.Len(t, pt.pools, 1)
+
+	policy, ok := PolicyFromContext(WithPolicy(context.Background(), pan.LeastHops{}))
+	assert.True(t, ok)
+	assert.Equal(t, pan.LeastHops{}, policy)
+	_, ok = PolicyFromContext(context.Background())
+	assert.False(t, ok)
+
+	// requests with the same sequence share a pool, with separately parsed
+	// policies
+	ctx, err := WithSequence(context.Background(), "0* 1-ff00:0:110 0*")
+	require.NoError(t, err)
+	a, ok := PolicyFromContext(ctx)
+	require.True(t, ok)
+	assert.IsType(t, pan.Sequence{}, a)
+	ctx, err = WithSequence(context.Background(), "0* 1-ff00:0:110 0*")
+	require.NoError(t, err)
+	b, _ := PolicyFromContext(ctx)
+	assert.Same(t, pt.transport(a), pt.transport(b))
+	ctx, err = WithSequence(context.Background(), "0* 1-ff00:0:111 0*")
+	require.NoError(t, err)
+	c, _ := PolicyFromContext(ctx)
+	assert.NotSame(t, pt.transport(a), pt.transport(c))
+	_, err = WithSequence(context.Background(), "0* invalid")
+	assert.Error(t, err)
+}
@@ -0,0 +1,277 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package proxy provides a reverse proxy bridging HTTP over SCION and HTTP over
+// TCP/IP. It forwards the requests it serves, over either network, to the
+// backend of the first matching route, over SCION or TCP/IP depending on the
+// backend. This allows to make an existing web service available over SCION
+// without modifying it, or to make a SCION web service available to TCP/IP
+// clients.
+//
+// Example, serving a local TCP/IP web server over SCION:
+//
+//	p, err := proxy.New([]proxy.Route{{Backend: "http://127.0.0.1:8080"}})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(shttp.ListenAndServe(":80", p))
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsec-ethz/scion-apps/pkg/shttp"
+)
+
+// ForwardedForSCIONHeader is the header set to the SCION address of a client
+// connected over SCION, e.g. "1-ff00:0:110,192.0.2.1", as X-Forwarded-For
+// only carries the IP of the client, which is not unique without its ISD-AS.
+const ForwardedForSCIONHeader = "X-Forwarded-For-Scion"
+
+// Route maps requests to a backend.
+type Route struct {
+	// Host is the host of the matching requests, without port. Empty matches
+	// any host.
+	Host string
+	// Path is the path prefix of the matching requests, matching complete path
+	// segments; "/api" matches "/api" and "/api/v1", but not "/apis". Empty
+	// matches any path. The request path is appended to the path of the
+	// backend, without removing the prefix.
+	Path string
+	// Backend is the URL of the backend, e.g. "http://127.0.0.1:8080" or
+	// "http://1-ff00:0:110,[10.0.0.1]:80". The backend is reached over SCION
+	// if its host is a SCION address, or if SCION is set, and over TCP/IP
+	// otherwise.
+	Backend string
+	// SCION forces the backend to be reached over SCION, for backends whose
+	// host name resolves to a SCION address.
+	SCION bool
+	// Policy is the path policy for the requests to a backend reached over
+	// SCION. Nil means the default policy of the SCION transport.
+	Policy pan.Policy
+}
+
+// ParseRoute parses a route of the form
+//
+//	[HOST][/PATH]=BACKEND[;[SEQUENCE]]
+//
+// e.g. "www.example.org/api=http://127.0.0.1:8080". A semicolon after the
+// backend forces it to be reached over SCION; it can be followed by a path
+// sequence (see pan.NewSequence) used as the route's policy, e.g.
+// "=http://www.example.org:80;0* 1-ff00:0:110 0*".
+func ParseRoute(s string) (Route, error) {
+	match, backend, ok := strings.Cut(s, "=")
+	if !ok {
+		return Route{}, fmt.Errorf("invalid route %q, missing '='", s)
+	}
+	var r Route
+	if i := strings.Index(match, "/"); i >= 0 {
+		r.Host, r.Path = match[:i], match[i:]
+	} else {
+		r.Host = match
+	}
+	backend, sequence, scion := strings.Cut(backend, ";")
+	r.Backend = backend
+	r.SCION = scion
+	if sequence = strings.TrimSpace(sequence); sequence != "" {
+		policy, err := pan.NewSequence(sequence)
+		if err != nil {
+			return Route{}, fmt.Errorf("invalid route %q: %w", s, err)
+		}
+		r.Policy = policy
+	}
+	if _, _, err := parseBackend(r.Backend); err != nil {
+		return Route{}, fmt.Errorf("invalid route %q: %w", s, err)
+	}
+	return r, nil
+}
+
+// ReverseProxy is an http.Handler forwarding each request to the backend of
+// the first matching route. Requests not matching any route are answered
+// with 502 Bad Gateway.
+// The Host header of the request is kept, the X-Forwarded-For,
+// X-Forwarded-Host and X-Forwarded-Proto headers are set. For clients
+// connected over SCION, X-Forwarded-For is set to the IP of the client and
+// ForwardedForSCIONHeader to its SCION address; for the others,
+// ForwardedForSCIONHeader is removed.
+type ReverseProxy struct {
+	// Transport is used for the backends reached over TCP/IP.
+	// http.DefaultTransport if nil.
+	Transport http.RoundTripper
+	// SCIONTransport is used for the backends reached over SCION. The policy
+	// of the route is set on the request context with shttp.WithPolicy; the
+	// transport must therefore keep separate connections per policy, as does
+	// shttp.PooledTransport. A shttp.PooledTransport if nil.
+	SCIONTransport http.RoundTripper
+	// ModifyResponse, if not nil, modifies the responses of the backends,
+	// see httputil.ReverseProxy.
+	ModifyResponse func(*http.Response) error
+
+	routes          []proxyRoute
+	pooledTransport *shttp.PooledTransport
+}
+
+// proxyRoute is a route with its parsed backend.
+type proxyRoute struct {
+	Route
+	proxy *httputil.ReverseProxy
+}
+
+// New returns a ReverseProxy for the routes. Returns an error if a backend
+// is not a valid URL.
+func New(routes []Route) (*ReverseProxy, error) {
+	p := &ReverseProxy{
+		pooledTransport: &shttp.PooledTransport{},
+	}
+	for _, r := range routes {
+		backend, scion, err := parseBackend(r.Backend)
+		if err != nil {
+			return nil, err
+		}
+		r.SCION = r.SCION || scion
+		p.routes = append(p.routes, proxyRoute{
+			Route: r,
+			proxy: p.newRouteProxy(backend, r.SCION, r.Policy),
+		})
+	}
+	return p, nil
+}
+
+func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, r := range p.routes {
+		if r.matches(req) {
+			r.proxy.ServeHTTP(w, req)
+			return
+		}
+	}
+	http.Error(w, "502 bad gateway", http.StatusBadGateway)
+}
+
+// newRouteProxy returns the proxy forwarding to the backend, over SCION with
+// the policy if scion is set.
+func (p *ReverseProxy) newRouteProxy(backend *url.URL, scion bool,
+	policy pan.Policy) *httputil.ReverseProxy {
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(backend)
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+			setForwardedSCION(r)
+			if scion && policy != nil {
+				r.Out = r.Out.WithContext(shttp.WithPolicy(r.Out.Context(), policy))
+			}
+		},
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return p.transport(scion).RoundTrip(req)
+		}),
+		ModifyResponse: func(resp *http.Response) error {
+			if p.ModifyResponse != nil {
+				return p.ModifyResponse(resp)
+			}
+			return nil
+		},
+	}
+}
+
+// setForwardedSCION sets the X-Forwarded-For and ForwardedForSCIONHeader
+// headers for clients connected over SCION, whose remote address is not
+// understood by SetXForwarded.
+func setForwardedSCION(r *httputil.ProxyRequest) {
+	r.Out.Header.Del(ForwardedForSCIONHeader)
+	client, ok := shttp.ClientAddrFromContext(r.In.Context())
+	if !ok {
+		// e.g. for requests served by a shttp3.Server
+		var err error
+		if client, err = pan.ParseUDPAddr(r.In.RemoteAddr); err != nil {
+			return
+		}
+	}
+	r.Out.Header.Set("X-Forwarded-For", client.IP.String())
+	r.Out.Header.Set(ForwardedForSCIONHeader, fmt.Sprintf("%s,%s", client.IA, client.IP))
+}
+
+// transport returns the transport for backends reached over SCION or TCP/IP.
+func (p *ReverseProxy) transport(scion bool) http.RoundTripper {
+	switch {
+	case scion && p.SCIONTransport != nil:
+		return p.SCIONTransport
+	case scion:
+		return p.pooledTransport
+	case p.Transport != nil:
+		return p.Transport
+	default:
+		return http.DefaultTransport
+	}
+}
+
+// matches returns whether the request matches the host and path of the route.
+func (r *proxyRoute) matches(req *http.Request) bool {
+	if r.Host != "" && !strings.EqualFold(r.Host, requestHost(req)) {
+		return false
+	}
+	prefix := strings.TrimSuffix(r.Path, "/")
+	return prefix == "" || req.URL.Path == prefix || strings.HasPrefix(req.URL.Path, prefix+"/")
+}
+
+// requestHost returns the host of the request without port. A mangled SCION
+// address is returned without brackets.
+func requestHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// parseBackend parses the backend URL, whose host may be a SCION address, and
+// returns whether it is. The host of the returned URL is mangled, see
+// pan.MangleSCIONAddr.
+func parseBackend(backend string) (*url.URL, bool, error) {
+	scheme, rest, ok := strings.Cut(backend, "://")
+	if !ok {
+		return nil, false, fmt.Errorf("invalid backend %q, missing scheme", backend)
+	}
+	host, tail := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host, tail = rest[:i], rest[i:]
+	}
+	if host == "" {
+		return nil, false, fmt.Errorf("invalid backend %q, missing host", backend)
+	}
+	// The URL parser does not accept mangled SCION addresses as host; parse
+	// the URL with a placeholder host instead.
+	u, err := url.Parse(scheme + "://backend" + tail)
+	if err != nil {
+		return nil, false, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, false, fmt.Errorf("invalid backend %q, scheme must be http or https", backend)
+	}
+	mangled := pan.MangleSCIONAddr(host)
+	u.Host = mangled
+	return u, mangled != host, nil
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsec-ethz/scion-apps/pkg/shttp"
+)
+
+func TestParseRoute(t *testing.T) {
+	r, err := ParseRoute("www.example.org/api=http://127.0.0.1:8080/v1")
+	require.NoError(t, err)
+	assert.Equal(t, Route{Host: "www.example.org", Path: "/api", Backend: "http://127.0.0.1:8080/v1"}, r)
+
+	r, err = ParseRoute("=http://1-ff00:0:110,[10.0.0.1]:80")
+	require.NoError(t, err)
+	assert.Equal(t, Route{Backend: "http://1-ff00:0:110,[10.0.0.1]:80"}, r)
+
+	r, err = ParseRoute("/static=https://www.example.org;0* 1-ff00:0:110 0*")
+	require.NoError(t, err)
+	assert.Equal(t, "/static", r.Path)
+	assert.Equal(t, "https://www.example.org", r.Backend)
+	assert.True(t, r.SCION)
+	assert.IsType(t, pan.Sequence{}, r.Policy)
+
+	r, err = ParseRoute("=http://www.example.org;")
+	require.NoError(t, err)
+	assert.True(t, r.SCION)
+	assert.Nil(t, r.Policy)
+
+	for _, s := range []string{
+		"http://127.0.0.1:8080",
+		"=127.0.0.1:8080",
+		"=ftp://127.0.0.1",
+		"=http:///path",
+		"=http://127.0.0.1;0* invalid",
+	} {
+		_, err := ParseRoute(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestReverseProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host+" "+r.URL.Path+" "+r.Header.Get("X-Forwarded-Host"))
+	}))
+	defer backend.Close()
+
+	var scionReq *http.Request
+	scionTransport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		scionReq = req
+		return &http.Response{
+			StatusCode: http.StatusNoContent,
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	})
+
+	p, err := New([]Route{
+		{Host: "www.example.org", Path: "/api/", Backend: backend.URL + "/v1"},
+		{Path: "/scion", Backend: "http://1-ff00:0:110,[10.0.0.1]:8080", Policy: pan.LeastHops{}},
+		{Host: "www.example.org", Backend: "http://www.example.org", SCION: true},
+	})
+	require.NoError(t, err)
+	p.SCIONTransport = scionTransport
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	// TCP/IP backend, keeping the Host header
+	w := serve("http://www.example.org:80/api/users")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "www.example.org:80 /v1/api/users www.example.org:80", w.Body.String())
+	w = serve("http://www.example.org/api")
+	assert.Equal(t, "www.example.org /v1/api www.example.org", w.Body.String())
+
+	// SCION backend with the route's policy
+	w = serve("http://other.example.org/scion/x")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	require.NotNil(t, scionReq)
+	assert.Equal(t, "[1-ff00:0:110,10.0.0.1]:8080", scionReq.URL.Host)
+	assert.Equal(t, "/scion/x", scionReq.URL.Path)
+	policy, ok := shttp.PolicyFromContext(scionReq.Context())
+	assert.True(t, ok)
+	assert.Equal(t, pan.LeastHops{}, policy)
+
+	// forced SCION backend without policy
+	scionReq = nil
+	w = serve("http://www.example.org/apis")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	require.NotNil(t, scionReq)
+	assert.Equal(t, "www.example.org", scionReq.URL.Host)
+	_, ok = shttp.PolicyFromContext(scionReq.Context())
+	assert.False(t, ok)
+
+	// no matching route
+	w = serve("http://other.example.org/api")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	// client addresses, over TCP/IP and SCION; a header set by the client
+	// is not forwarded
+	for remote, expected := range map[string][2]string{
+		"192.0.2.1:1234":                  {"192.0.2.1", ""},
+		"1-ff00:0:111,[192.0.2.2]:1234":   {"192.0.2.2", "1-ff00:0:111,192.0.2.2"},
+		"1-ff00:0:111,[2001:db8::1]:1234": {"2001:db8::1", "1-ff00:0:111,2001:db8::1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://other.example.org/scion", nil)
+		req.RemoteAddr = remote
+		req.Header.Set(ForwardedForSCIONHeader, "1-ff00:0:1,10.0.0.1")
+		p.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, expected[0], scionReq.Header.Get("X-Forwarded-For"), remote)
+		assert.Equal(t, expected[1], scionReq.Header.Get(ForwardedForSCIONHeader), remote)
+	}
+}
@@ -29,6 +29,34 @@ type Server struct {
 	*http.Server
 }
 
+type clientContextKey struct{}
+
+// clientInfo identifies the client of a connection; the reply selector of
+// the listening connection knows the path to the client.
+type clientInfo struct {
+	remote   pan.UDPAddr
+	selector pan.ReplySelector
+}
+
+// ClientAddrFromContext returns the SCION address of the client, for the
+// context of a request served by a Server.
+func ClientAddrFromContext(ctx context.Context) (pan.UDPAddr, bool) {
+	info, ok := ctx.Value(clientContextKey{}).(clientInfo)
+	return info.remote, ok
+}
+
+// ClientPathFromContext returns the path to the client, i.e. the reverse of
+// the path on which its last packet was received, for the context of a
+// request served by a Server. The path has no metadata. Returns nil if the
+// client is in the local AS.
+func ClientPathFromContext(ctx context.Context) (*pan.Path, bool) {
+	info, ok := ctx.Value(clientContextKey{}).(clientInfo)
+	if !ok {
+		return nil, false
+	}
+	return info.selector.Path(info.remote), true
+}
+
 // ListenAndServe listens for HTTP connections on the SCION address addr and calls Serve
 // with handler to handle requests
 func ListenAndServe(addr string, handler http.Handler) error {
@@ -66,7 +94,7 @@ func (srv *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
 // ListenAndServe listens for QUIC connections on srv.Addr and
 // calls Serve to handle incoming requests
 func (srv *Server) ListenAndServe() error {
-	listener, err := listen(srv.Addr)
+	listener, err := srv.listen()
 	if err != nil {
 		return err
 	}
@@ -75,7 +103,7 @@ func (srv *Server) ListenAndServe() error {
 }
 
 func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
-	listener, err := listen(srv.Addr)
+	listener, err := srv.listen()
 	if err != nil {
 		return err
 	}
@@ -83,7 +111,34 @@ func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
 	return srv.Server.ServeTLS(listener, certFile, keyFile)
 }
 
-func listen(addr string) (net.Listener, error) {
+// listen opens the listener for srv.Addr and sets up the connection context
+// for ClientAddrFromContext and ClientPathFromContext.
+func (srv *Server) listen() (net.Listener, error) {
+	selector := pan.NewDefaultReplySelector()
+	listener, err := listen(srv.Addr, pan.WithReplySelector(selector))
+	if err != nil {
+		return nil, err
+	}
+	connContext := srv.Server.ConnContext
+	srv.Server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, c)
+		}
+		return withClient(ctx, c, selector)
+	}
+	return listener, nil
+}
+
+// withClient adds the client of the connection c to the context.
+func withClient(ctx context.Context, c net.Conn, selector pan.ReplySelector) context.Context {
+	remote, ok := c.RemoteAddr().(pan.UDPAddr)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, clientContextKey{}, clientInfo{remote: remote, selector: selector})
+}
+
+func listen(addr string, opts ...pan.ListenOption) (net.Listener, error) {
 	tlsCfg := &tls.Config{
 		NextProtos:   []string{quicutil.SingleStreamProto},
 		Certificates: quicutil.MustGenerateSelfSignedCert(),
@@ -92,7 +147,8 @@ func listen(addr string) (net.Listener, error) {
 	if err != nil {
 		return nil, err
 	}
-	quicListener, err := pan.ListenQUIC(context.Background(), laddr, nil, tlsCfg, nil)
+	opts = append(opts, pan.WithLocalAddr(laddr))
+	quicListener, err := pan.ListenQUIC(context.Background(), tlsCfg, nil, opts...)
 	if err != nil {
 		return nil, err
 	}
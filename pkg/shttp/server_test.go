@@ -0,0 +1,67 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteAddrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestClientFromContext(t *testing.T) {
+	remote := pan.UDPAddr{
+		IA:   pan.MustParseIA("1-ff00:0:112"),
+		IP:   netip.MustParseAddr("192.0.2.1"),
+		Port: 31000,
+	}
+	path := &pan.Path{Source: pan.MustParseIA("1-ff00:0:111"), Destination: remote.IA, Fingerprint: "1 2"}
+	selector := pan.NewDefaultReplySelector()
+	selector.Record(remote, path)
+
+	ctx := withClient(context.Background(), remoteAddrConn{remote: remote}, selector)
+	addr, ok := ClientAddrFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, remote, addr)
+	p, ok := ClientPathFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, path, p)
+
+	// The path is looked up at the time of the request.
+	next := &pan.Path{Source: path.Source, Destination: remote.IA, Fingerprint: "3 4"}
+	selector.Record(remote, next)
+	p, _ = ClientPathFromContext(ctx)
+	assert.Equal(t, next, p)
+
+	_, ok = ClientAddrFromContext(context.Background())
+	assert.False(t, ok)
+	ctx = withClient(context.Background(), remoteAddrConn{remote: &net.UDPAddr{}}, selector)
+	_, ok = ClientPathFromContext(ctx)
+	assert.False(t, ok)
+}
@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"net/netip"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -64,35 +65,97 @@ func NewTransport(quicCfg *quic.Config, policy pan.Policy) (*http.Transport, *Di
 
 // Dialer dials an insecure, single-stream QUIC connection over SCION (just pretend it's TCP).
 // This is the Dialer used for shttp.DefaultTransport.
+// Host names are resolved with pan.ResolveServiceUDPAddr, i.e. replicas of the
+// server can be announced in DNS SRV records "_https._udp.<host>", which are
+// used unless the URL has a port other than the default port of its scheme.
+// The replicas are tried in order until a connection is established.
 type Dialer struct {
 	Local      netip.AddrPort
 	QuicConfig *quic.Config
 	Policy     pan.Policy
-	sessions   []*pan.QUICSession
+	// PreferBestPath selects the replica of the server with the lowest
+	// latency path among the replicas of the same priority, see
+	// pan.WithBestPath.
+	PreferBestPath bool
+
+	mutex    sync.Mutex
+	sessions []*pan.QUICSession
 }
 
 // DialContext dials an insecure, single-stream QUIC connection over SCION. This can be used
 // as the DialContext function in net/http.Transport.
+// The connection is closed when a path down notification is received for its
+// path and the session does not switch to another path, e.g. as the policy
+// allows no other path. Requests in progress on the connection then fail
+// immediately and the connection is not reused; PooledTransport retries them.
 func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	tlsCfg := &tls.Config{
 		NextProtos:         []string{quicutil.SingleStreamProto},
 		InsecureSkipVerify: true,
 	}
 
-	remote, err := pan.ResolveUDPAddr(ctx, pan.UnmangleSCIONAddr(addr))
+	var opts []pan.ServiceOption
+	if d.PreferBestPath {
+		opts = append(opts, pan.WithBestPath())
+	}
+	address, defaultPort := serviceAddress(pan.UnmangleSCIONAddr(addr))
+	replicas, err := pan.ResolveServiceUDPAddr(ctx, "https", address, defaultPort, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	session, err := pan.DialQUIC(ctx, d.Local, remote, d.Policy, nil, addr, tlsCfg, d.QuicConfig)
+	d.mutex.Lock()
+	policy := d.Policy
+	d.mutex.Unlock()
+	var session *pan.QUICSession
+	for _, remote := range replicas {
+		session, err = pan.DialQUIC(ctx, remote, addr, tlsCfg, d.QuicConfig,
+			pan.WithLocalAddr(d.Local), pan.WithPolicy(policy))
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	d.sessions = append(d.sessions, session)
-	return quicutil.NewSingleStream(session)
+	d.mutex.Lock()
+	// drop closed sessions
+	open := d.sessions[:0]
+	for _, s := range d.sessions {
+		if s.Context().Err() == nil {
+			open = append(open, s)
+		}
+	}
+	d.sessions = append(open, session)
+	d.mutex.Unlock()
+	conn, err := quicutil.NewSingleStream(session)
+	if err != nil {
+		return nil, err
+	}
+	return newFailoverConn(conn, session), nil
+}
+
+// serviceAddress returns the address to resolve with pan.ResolveServiceUDPAddr
+// and the default port. The http.Transport always adds the default port of
+// the scheme to the address, so 80 and 443 are treated as not given
+// explicitly, which allows to use the SRV records of the host.
+func serviceAddress(addr string) (string, uint16) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 443
+	}
+	switch port {
+	case "80":
+		return host, 80
+	case "443":
+		return host, 443
+	}
+	return addr, 443
 }
 
 func (d *Dialer) SetPolicy(policy pan.Policy) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 	d.Policy = policy
 	for _, s := range d.sessions {
 		s.Conn.SetPolicy(policy)
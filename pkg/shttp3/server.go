@@ -23,50 +23,79 @@ import (
 	"github.com/quic-go/quic-go/http3"
 
 	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsec-ethz/scion-apps/pkg/quicutil"
 )
 
-// Server wraps a http3.Server making it work with SCION
+// Server is an HTTP/3 server serving over a SCION/UDP socket. The ALPN
+// protocol for HTTP/3 is added to the TLS config by http3.
 type Server struct {
 	*http3.Server
 }
 
-// ListenAndServe listens on the SCION/UDP address addr and calls the handler
-// for HTTP/3 requests on incoming connections. http.DefaultServeMux is used
-// when handler is nil.
+// ListenAndServe serves HTTP/3 requests on the SCION address addr, with the
+// certificate and key loaded from certFile and keyFile.
 func ListenAndServe(addr string, certFile, keyFile string, handler http.Handler) error {
-	var err error
-	certs := make([]tls.Certificate, 1)
-	certs[0], err = tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return err
-	}
 	s := &Server{
 		Server: &http3.Server{
 			Addr:    addr,
 			Handler: handler,
-			TLSConfig: &tls.Config{
-				Certificates: certs,
-			},
 		},
 	}
-	return s.ListenAndServe()
+	return s.ListenAndServeTLS(certFile, keyFile)
 }
 
-// ListenAndServe listens on the UDP address s.Addr and calls s.Handler to
-// handle HTTP/3 requests on incoming connections.
+// ListenAndServe serves HTTP/3 requests on the SCION address s.Addr, with the
+// TLS config s.TLSConfig. If no TLS config is set, a self-signed certificate
+// is generated; clients must then skip the verification of the server
+// certificate, as for the insecure transport of shttp.
 func (s *Server) ListenAndServe() error {
+	if s.TLSConfig == nil {
+		s.TLSConfig = &tls.Config{
+			Certificates: quicutil.MustGenerateSelfSignedCert(),
+		}
+	}
 	laddr, err := pan.ParseOptionalIPPort(s.Addr)
 	if err != nil {
 		return err
 	}
-	sconn, err := pan.ListenUDP(context.Background(), laddr, nil)
+	sconn, err := pan.ListenUDP(context.Background(), pan.WithLocalAddr(laddr))
 	if err != nil {
 		return err
 	}
+	defer sconn.Close()
 	return s.Server.Serve(sconn)
 }
 
+// ListenAndServeTLS serves HTTP/3 requests on the SCION address s.Addr, with
+// the certificate and key loaded from certFile and keyFile, in addition to
+// the certificates of s.TLSConfig, if any.
+// This replaces http3.Server.ListenAndServeTLS, which would serve over IP.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	tlsConfig, err := withCertificate(s.TLSConfig, certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	s.TLSConfig = tlsConfig
+	return s.ListenAndServe()
+}
+
 func (s *Server) Serve(conn net.PacketConn) error {
 	// Providing a custom packet conn defeats the purpose of this library.
 	panic("not implemented")
 }
+
+// withCertificate returns a copy of tlsConfig, or a new config if nil, with
+// the certificate loaded from certFile and keyFile added.
+func withCertificate(tlsConfig *tls.Config, certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	return tlsConfig, nil
+}
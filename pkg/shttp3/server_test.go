@@ -0,0 +1,59 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp3
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netsec-ethz/scion-apps/pkg/quicutil"
+)
+
+func TestWithCertificate(t *testing.T) {
+	cert, err := quicutil.GenerateSelfSignedCert()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+
+	c, err := withCertificate(nil, certFile, keyFile)
+	require.NoError(t, err)
+	require.Len(t, c.Certificates, 1)
+	assert.Equal(t, cert.Certificate, c.Certificates[0].Certificate)
+
+	// The config is copied, keeping its settings and certificates.
+	base := &tls.Config{MinVersion: tls.VersionTLS13, Certificates: []tls.Certificate{*cert}}
+	c, err = withCertificate(base, certFile, keyFile)
+	require.NoError(t, err)
+	assert.Len(t, c.Certificates, 2)
+	assert.Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	assert.Len(t, base.Certificates, 1)
+
+	_, err = withCertificate(nil, filepath.Join(dir, "missing.pem"), keyFile)
+	assert.Error(t, err)
+}
@@ -19,6 +19,7 @@ package shttp3
 import (
 	"context"
 	"crypto/tls"
+	"net"
 	"net/netip"
 
 	"github.com/quic-go/quic-go"
@@ -37,21 +38,46 @@ var DefaultTransport = &http3.RoundTripper{
 
 // Dialer dials a QUIC connection over SCION.
 // This is the Dialer used for shttp3.DefaultTransport.
+// Host names are resolved with pan.ResolveServiceUDPAddr, i.e. replicas of the
+// server can be announced in DNS SRV records "_https._udp.<host>", which are
+// used unless the URL has a port other than 443. The replicas are tried in
+// order until a connection is established.
 type Dialer struct {
-	Local    netip.AddrPort
-	Policy   pan.Policy
-	sessions []*pan.QUICEarlySession
+	Local  netip.AddrPort
+	Policy pan.Policy
+	// PreferBestPath selects the replica of the server with the lowest
+	// latency path among the replicas of the same priority, see
+	// pan.WithBestPath.
+	PreferBestPath bool
+	sessions       []*pan.QUICEarlySession
 }
 
 // Dial dials a QUIC connection over SCION.
 func (d *Dialer) Dial(ctx context.Context, addr string, tlsCfg *tls.Config,
 	cfg *quic.Config) (quic.EarlyConnection, error) {
 
-	remote, err := pan.ResolveUDPAddr(ctx, pan.UnmangleSCIONAddr(addr))
+	var opts []pan.ServiceOption
+	if d.PreferBestPath {
+		opts = append(opts, pan.WithBestPath())
+	}
+	// The RoundTripper adds the default port 443 to the address, which is
+	// therefore treated as not given explicitly, to use the SRV records.
+	address := pan.UnmangleSCIONAddr(addr)
+	if host, port, err := net.SplitHostPort(address); err == nil && port == "443" {
+		address = host
+	}
+	replicas, err := pan.ResolveServiceUDPAddr(ctx, "https", address, 443, opts...)
 	if err != nil {
 		return nil, err
 	}
-	session, err := pan.DialQUICEarly(ctx, d.Local, remote, d.Policy, nil, addr, tlsCfg, cfg)
+	var session *pan.QUICEarlySession
+	for _, remote := range replicas {
+		session, err = pan.DialQUICEarly(ctx, remote, addr, tlsCfg, cfg,
+			pan.WithLocalAddr(d.Local), pan.WithPolicy(d.Policy))
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
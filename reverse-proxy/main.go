@@ -0,0 +1,79 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/handlers"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/netsec-ethz/scion-apps/pkg/shttp"
+	"github.com/netsec-ethz/scion-apps/pkg/shttp/proxy"
+)
+
+func main() {
+	listenSCION := kingpin.Flag("listen-scion", "Serve HTTP over SCION on this address, e.g. :80").String()
+	listenTCP := kingpin.Flag("listen-tcp", "Serve HTTP over TCP/IP on this address, e.g. :8080").String()
+	strictSCION := kingpin.Flag("strict", "Add `Strict-SCION` header with provided value"+
+		" (similar to HSTS directives) if not already present").String()
+	routeFlags := kingpin.Flag("route", "Route of the form [HOST][/PATH]=BACKEND[;[SEQUENCE]]."+
+		" The first matching route is used. The backend is reached over SCION if it is a SCION address"+
+		" or followed by ';' and an optional path sequence, e.g."+
+		" 'www.example.org/=http://www.example.org;0* 1-ff00:0:110 0*'.").Required().Strings()
+	kingpin.Parse()
+
+	if *listenSCION == "" && *listenTCP == "" {
+		kingpin.Fatalf("at least one of --listen-scion and --listen-tcp is required")
+	}
+	var routes []proxy.Route
+	for _, s := range *routeFlags {
+		r, err := proxy.ParseRoute(s)
+		if err != nil {
+			kingpin.Fatalf("%s", err)
+		}
+		routes = append(routes, r)
+	}
+	p, err := proxy.New(routes)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if *strictSCION != "" {
+		p.ModifyResponse = func(resp *http.Response) error {
+			if resp.Header.Get("Strict-SCION") == "" {
+				resp.Header.Set("Strict-SCION", *strictSCION)
+			}
+			return nil
+		}
+	}
+	handler := handlers.LoggingHandler(os.Stdout, p)
+
+	errs := make(chan error, 2)
+	if *listenSCION != "" {
+		log.Printf("Listen on SCION %s", *listenSCION)
+		go func() {
+			errs <- shttp.ListenAndServe(*listenSCION, handler)
+		}()
+	}
+	if *listenTCP != "" {
+		log.Printf("Listen on TCP/IP %s", *listenTCP)
+		go func() {
+			errs <- http.ListenAndServe(*listenTCP, handler)
+		}()
+	}
+	log.Fatalf("%s", <-errs)
+}
@@ -22,7 +22,6 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/netip"
 	"os"
 	"strings"
 
@@ -54,7 +53,7 @@ func main() {
 	check(err)
 	serverAddr, err := pan.ResolveUDPAddr(context.TODO(), *serverAddrStr)
 	check(err)
-	conn, err := pan.DialUDP(context.Background(), netip.AddrPort{}, serverAddr, policy, nil)
+	conn, err := pan.DialUDP(context.Background(), serverAddr, pan.WithPolicy(policy))
 	check(err)
 
 	receivePacketBuffer := make([]byte, 2500)
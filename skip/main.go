@@ -43,7 +43,6 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/netsec-ethz/scion-apps/pkg/pan"
-	"github.com/netsec-ethz/scion-apps/pkg/quicutil"
 	"github.com/netsec-ethz/scion-apps/pkg/shttp"
 )
 
@@ -390,7 +389,7 @@ func (h *tunnelHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
 		defer cancel()
 		destConn, err = h.dialer.DialContext(ctx, "", req.Host)
-		if panConn, ok := destConn.(*quicutil.SingleStream); ok {
+		if panConn, ok := destConn.(interface{ GetPath() *pan.Path }); ok {
 			pathF = panConn.GetPath
 		}
 	}
@@ -48,14 +48,23 @@ var (
 	sequence      = kingpin.Flag("sequence", "Sequence of space separated hop predicates to specify path").Default("").String()
 	preference    = kingpin.Flag("preference", "Preference sorting order for paths. "+
 		"Comma-separated list of available sorting options: "+
-		strings.Join(pan.AvailablePreferencePolicies, "|")).Default("").String()
-	pathSelector = kingpin.Flag("selector", "Path selection mode").Default("default").Enum(ssh.AvailablePathSelectors...)
+		strings.Join(pan.AvailablePreferencePolicies, "|")+". "+
+		"Defaults to latency for interactive sessions and bandwidth for file transfers").Default("").String()
+	pathSelector   = kingpin.Flag("selector", "Path selection mode").Default("default").Enum(ssh.AvailablePathSelectors...)
+	bulkConnection = kingpin.Flag("bulk-connection", "Use a separate connection, preferring high "+
+		"bandwidth paths, for port forwarding").Bool()
+	printPaths = kingpin.Flag("paths", "Print the candidate paths to the server after applying "+
+		"the path policy and exit").Bool()
 
 	// TODO: additional file paths
 	knownHostsFile = kingpin.Flag("known-hosts", "File where known hosts are stored").ExistingFile()
 	identityFile   = kingpin.Flag("identity", "Identity (private key) file").Short('i').ExistingFile()
 
-	loginName = kingpin.Flag("login-name", "Username to login with").String()
+	loginName = kingpin.Flag("login-name", "Username to login with").Short('l').String()
+
+	// Accepted for compatibility with the ssh invocation of scp (-S), X11
+	// forwarding is not supported.
+	_ = kingpin.Flag("disable-x11", "Disable X11 forwarding").Short('x').Hidden().Bool()
 )
 
 // PromptPassword prompts the user for a password to authenticate with.
@@ -151,7 +160,20 @@ func main() {
 		golog.Panicf("Error creating ssh client: %v", err)
 	}
 
-	policy, err := pan.PolicyFromCommandline(*sequence, *preference, *interactive)
+	// TODO Don't just join those!
+	runCommand := strings.Join((*runCommand)[:], " ")
+
+	pref := *preference
+	if pref == "" {
+		pref = ssh.ClassifyCommand(runCommand).Preference()
+	}
+	if *printPaths {
+		if err := printCandidatePaths(conf.HostAddress, pref); err != nil {
+			golog.Fatal(err)
+		}
+		return
+	}
+	policy, err := pan.PolicyFromCommandline(*sequence, pref, *interactive)
 	if err != nil {
 		golog.Fatal(err)
 	}
@@ -166,6 +188,21 @@ func main() {
 	defer sshClient.CloseSession()
 
 	if conf.LocalForward != "" {
+		if *bulkConnection {
+			bulkPref := *preference
+			if bulkPref == "" {
+				bulkPref = ssh.BulkTraffic.Preference()
+			}
+			bulkPolicy, err := pan.PolicyFromCommandline(*sequence, bulkPref, *interactive)
+			if err != nil {
+				golog.Fatal(err)
+			}
+			err = sshClient.ConnectBulk(ctx, serverAddress, bulkPolicy, *pathSelector)
+			if err != nil {
+				golog.Panicf("Error connecting bulk connection: %v", err)
+			}
+		}
+
 		localForward := strings.SplitN(conf.LocalForward, ":", 2)
 
 		port, err := strconv.ParseUint(localForward[0], 10, 16)
@@ -180,9 +217,6 @@ func main() {
 		}
 	}
 
-	// TODO Don't just join those!
-	runCommand := strings.Join((*runCommand)[:], " ")
-
 	if runCommand == "" {
 		err = sshClient.Shell()
 		if err != nil {
@@ -207,3 +241,19 @@ func main() {
 		}
 	}
 }
+
+// printCandidatePaths prints the paths to the host accepted by the path policy
+// of the sequence and preference options.
+func printCandidatePaths(hostAddress, pref string) error {
+	policy, err := pan.PolicyFromCommandline(*sequence, pref, false)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	// The port is irrelevant for the paths.
+	remote, err := pan.ResolveUDPAddr(ctx, hostAddress+":0")
+	if err != nil {
+		return err
+	}
+	return pan.PrintPaths(ctx, os.Stdout, remote.IA, policy)
+}
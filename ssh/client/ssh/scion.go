@@ -17,10 +17,8 @@ package ssh
 import (
 	"context"
 	"crypto/tls"
-	"net/netip"
-	"time"
+	"net"
 
-	"github.com/quic-go/quic-go"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/netsec-ethz/scion-apps/pkg/pan"
@@ -34,11 +32,15 @@ func dialSCION(ctx context.Context,
 	selector string,
 	config *ssh.ClientConfig) (*ssh.Client, error) {
 
-	remote, err := pan.ResolveUDPAddr(ctx, addr)
-	if err != nil {
-		return nil, err
+	// Replicas of the server can be announced in SRV records, which are tried
+	// in order, starting with the one with the best path. The default port is
+	// always set in the address, it is therefore treated as not given
+	// explicitly.
+	address := addr
+	if host, port, err := net.SplitHostPort(addr); err == nil && port == "22" {
+		address = host
 	}
-	sel, err := selectorByName(selector)
+	replicas, err := pan.ResolveServiceUDPAddr(ctx, "ssh", address, 22, pan.WithBestPath())
 	if err != nil {
 		return nil, err
 	}
@@ -46,10 +48,20 @@ func dialSCION(ctx context.Context,
 		NextProtos:         []string{quicutil.SingleStreamProto},
 		InsecureSkipVerify: true,
 	}
-	quicConf := &quic.Config{
-		KeepAlivePeriod: 15 * time.Second,
+	var sess *pan.QUICSession
+	for _, remote := range replicas {
+		// The selector keeps state of the connection, each dial needs its own.
+		var sel pan.Selector
+		sel, err = selectorByName(selector)
+		if err != nil {
+			return nil, err
+		}
+		sess, err = pan.DialQUIC(ctx, remote, "", tlsConf, nil,
+			pan.WithPolicy(policy), pan.WithSelector(sel), quicutil.WithPathTimeouts())
+		if err == nil || ctx.Err() != nil {
+			break
+		}
 	}
-	sess, err := pan.DialQUIC(ctx, netip.AddrPort{}, remote, policy, sel, "", tlsConf, quicConf)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +53,8 @@ type Client struct {
 
 	client  *ssh.Client
 	session *ssh.Session
+	// bulkClient is an optional, separate connection used for port forwarding.
+	bulkClient *ssh.Client
 }
 
 // Create creates a new unconnected Client.
@@ -130,6 +132,19 @@ func (client *Client) Connect(ctx context.Context, addr string, policy pan.Polic
 	return nil
 }
 
+// ConnectBulk opens an additional connection to the given address, which is
+// used for port forwarding instead of the main connection. This allows
+// forwarded bulk traffic to use different paths (e.g. preferring high
+// bandwidth) than the interactive session.
+func (client *Client) ConnectBulk(ctx context.Context, addr string, policy pan.Policy, selector string) error {
+	goClient, err := dialSCION(ctx, addr, policy, selector, client.config)
+	if err != nil {
+		return err
+	}
+	client.bulkClient = goClient
+	return nil
+}
+
 // RunSession runs a terminal session, waiting for it to end.
 func (client *Client) RunSession(cmd string) error {
 	return client.session.Run(cmd)
@@ -201,7 +216,7 @@ func (client *Client) StartTunnel(local netip.AddrPort, addr string) error {
 		tlsConf := &tls.Config{
 			NextProtos: []string{quicutil.SingleStreamProto},
 		}
-		ql, err := pan.ListenQUIC(context.Background(), local, nil, tlsConf, nil)
+		ql, err := pan.ListenQUIC(context.Background(), tlsConf, nil, pan.WithLocalAddr(local))
 		if err != nil {
 			return err
 		}
@@ -239,15 +254,22 @@ func (client *Client) StartTunnel(local netip.AddrPort, addr string) error {
 // Dial dials the given address over a tunnel to the server. If the given
 // address is a SCION address, QUIC is used; else TCP.
 func (client *Client) Dial(addr string) (io.ReadWriteCloser, error) {
+	c := client.client
+	if client.bulkClient != nil {
+		c = client.bulkClient
+	}
 	if strings.Contains(addr, ",") {
-		return tunnelDialSCION(client.client, addr)
+		return tunnelDialSCION(c, addr)
 	}
-	return client.client.Dial("tcp", addr)
+	return c.Dial("tcp", addr)
 }
 
 // CloseSession closes the current session
 func (client *Client) CloseSession() {
 	client.session.Close()
+	if client.bulkClient != nil {
+		client.bulkClient.Close()
+	}
 }
 
 func loadPrivateKey(filePath string) (ssh.AuthMethod, error) {
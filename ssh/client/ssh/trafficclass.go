@@ -0,0 +1,62 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"path"
+	"strings"
+)
+
+// TrafficClass describes the kind of traffic carried over an SSH connection.
+// It determines the default path preference for the connection.
+type TrafficClass int
+
+const (
+	// InteractiveTraffic is used for shells and regular commands. Prefers low
+	// latency paths.
+	InteractiveTraffic TrafficClass = iota
+	// BulkTraffic is used for file transfers (scp, sftp, rsync) and port
+	// forwarding. Prefers high bandwidth paths.
+	BulkTraffic
+)
+
+// bulkCommands are the remote programs invoked by file transfer tools running
+// over ssh.
+var bulkCommands = []string{"scp", "sftp-server", "internal-sftp", "rsync"}
+
+// ClassifyCommand returns the traffic class for the remote command.
+// An empty command, i.e. an interactive shell, is InteractiveTraffic.
+func ClassifyCommand(cmd string) TrafficClass {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return InteractiveTraffic
+	}
+	name := path.Base(fields[0])
+	for _, b := range bulkCommands {
+		if name == b {
+			return BulkTraffic
+		}
+	}
+	return InteractiveTraffic
+}
+
+// Preference returns the name of the pan preference policy for this traffic
+// class, as accepted by pan.PolicyFromCommandline.
+func (c TrafficClass) Preference() string {
+	if c == BulkTraffic {
+		return "bandwidth"
+	}
+	return "latency"
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClassifyCommand(t *testing.T) {
+	Convey("Given remote commands", t, func() {
+		cases := []struct {
+			cmd        string
+			class      TrafficClass
+			preference string
+		}{
+			{"", InteractiveTraffic, "latency"},
+			{"   ", InteractiveTraffic, "latency"},
+			{"ls -l", InteractiveTraffic, "latency"},
+			{"scp -t /tmp", BulkTraffic, "bandwidth"},
+			{"/usr/lib/openssh/sftp-server", BulkTraffic, "bandwidth"},
+			{"internal-sftp", BulkTraffic, "bandwidth"},
+			{"rsync --server -vlogDtpre.iLsfxC . /tmp", BulkTraffic, "bandwidth"},
+			{"echo scp", InteractiveTraffic, "latency"},
+			{"scpx", InteractiveTraffic, "latency"},
+		}
+
+		Convey("They should be classified by the name of the program", func() {
+			for _, c := range cases {
+				class := ClassifyCommand(c.cmd)
+				So(class, ShouldEqual, c.class)
+				So(class.Preference(), ShouldEqual, c.preference)
+			}
+		})
+	})
+}
@@ -0,0 +1,505 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/pkg/snet"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/netsec-ethz/scion-apps/pkg/integration"
+	"github.com/netsec-ethz/scion-apps/pkg/integration/sintegration"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsec-ethz/scion-apps/pkg/quicutil"
+)
+
+const (
+	sshBin  = "scion-ssh"
+	sshdBin = "scion-sshd"
+)
+
+func TestMain(m *testing.M) {
+	integration.TestMain(m)
+}
+
+// sshTestKeys contains the keys and configuration files for a client and
+// server pair, generated in a temporary directory.
+type sshTestKeys struct {
+	dir            string
+	emptyConfig    string
+	hostKey        string
+	identity       string
+	authorizedKeys string
+}
+
+func newSSHTestKeys(t *testing.T) sshTestKeys {
+	dir := t.TempDir()
+	k := sshTestKeys{
+		dir:            dir,
+		emptyConfig:    path.Join(dir, "empty_config"),
+		hostKey:        path.Join(dir, "host_key"),
+		identity:       path.Join(dir, "id_ed25519"),
+		authorizedKeys: path.Join(dir, "authorized_keys"),
+	}
+	writeFile(t, k.emptyConfig, nil)
+	writePrivateKey(t, k.hostKey)
+	pub := writePrivateKey(t, k.identity)
+	writeFile(t, k.authorizedKeys, ssh.MarshalAuthorizedKey(pub))
+	return k
+}
+
+// serverArgs returns the arguments for the server, accepting only the
+// client's key.
+func (k sshTestKeys) serverArgs() []string {
+	return []string{
+		"-f", k.emptyConfig,
+		"-oPort=" + integration.ServerPortReplace,
+		"-oHostKey=" + k.hostKey,
+		"-oAuthorizedKeysFile=" + k.authorizedKeys,
+		"-oPasswordAuthentication=no",
+	}
+}
+
+// clientArgs returns the arguments for the client, running command on the
+// server as the current user.
+func (k sshTestKeys) clientArgs(command ...string) []string {
+	args := []string{
+		"-c", k.emptyConfig,
+		"-p", integration.ServerPortReplace,
+		"-i", k.identity,
+		"-oStrictHostKeyChecking=no",
+		integration.DstIAReplace + ",[" + integration.DstHostReplace + "]",
+	}
+	return append(args, command...)
+}
+
+func writePrivateKey(t *testing.T, file string) ssh.PublicKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, file, pem.EncodeToMemory(block))
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sshPub
+}
+
+func writeFile(t *testing.T, file string, content []byte) {
+	if err := os.WriteFile(file, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestIntegrationSSH runs commands over a session between the client and
+// the server: a simple echo, a shell pipeline, and the transfer of a file
+// from the server.
+func TestIntegrationSSH(t *testing.T) {
+	keys := newSSHTestKeys(t)
+	// Client and server run on the same host, so the server reads the file
+	// written here.
+	transferred := path.Join(keys.dir, "transfer.txt")
+	content := strings.Repeat("SCION SSH file transfer\n", 1000)
+	writeFile(t, transferred, []byte(content))
+
+	cases := []struct {
+		name     string
+		command  []string
+		outMatch func(string) error
+	}{
+		{
+			name:     "session",
+			command:  []string{"echo", "Hello SCION SSH!"},
+			outMatch: integration.RegExp("(?m)^Hello SCION SSH!$"),
+		},
+		{
+			name:     "exec",
+			command:  []string{"printf 'a\\nb\\nc\\n' | wc -l"},
+			outMatch: integration.RegExp(`(?m)^\s*3$`),
+		},
+		{
+			name:    "transfer",
+			command: []string{"cat", transferred},
+			outMatch: func(out string) error {
+				if !strings.Contains(out, content) {
+					return fmt.Errorf("transferred file not in output, got %d bytes, expected %d",
+						len(out), len(content))
+				}
+				return nil
+			},
+		},
+	}
+	for i, tc := range cases {
+		serverPortOffset := 2200 + 100*i
+		t.Run(tc.name, func(t *testing.T) {
+			in := integration.NewAppsIntegration(
+				integration.AppBinPath(sshBin),
+				integration.AppBinPath(sshdBin),
+				keys.clientArgs(tc.command...),
+				keys.serverArgs(),
+			)
+			in.ClientDelay = 250 * time.Millisecond
+			in.ClientOutMatch = tc.outMatch
+			in.ClientErrMatch = integration.NoPanic
+
+			iaPairs := integration.DefaultIAPairs()
+			integration.AssignUniquePorts(iaPairs, serverPortOffset, 1)
+			if err := in.Run(t, iaPairs); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestIntegrationSSHPathSelection checks that a session can be established
+// with each of the client's path selectors.
+func TestIntegrationSSHPathSelection(t *testing.T) {
+	keys := newSSHTestKeys(t)
+	for i, selector := range []string{"default", "round-robin", "random"} {
+		serverPortOffset := 2600 + 100*i
+		t.Run(selector, func(t *testing.T) {
+			args := append([]string{"--selector", selector}, keys.clientArgs("echo", selector)...)
+			in := integration.NewAppsIntegration(
+				integration.AppBinPath(sshBin),
+				integration.AppBinPath(sshdBin),
+				args,
+				keys.serverArgs(),
+			)
+			in.ClientDelay = 250 * time.Millisecond
+			in.ClientOutMatch = integration.RegExp(fmt.Sprintf("(?m)^%s$", regexp.QuoteMeta(selector)))
+			in.ClientErrMatch = integration.NoPanic
+
+			iaPairs := integration.DefaultIAPairs()
+			integration.AssignUniquePorts(iaPairs, serverPortOffset, 1)
+			if err := in.Run(t, iaPairs); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestIntegrationSSHSCP copies a file from and to the server with scp, using
+// the client as the ssh program.
+func TestIntegrationSSHSCP(t *testing.T) {
+	keys := newSSHTestKeys(t)
+	content := strings.Repeat("SCION SCP file transfer\n", 1000)
+	remoteFile := path.Join(keys.dir, "remote.txt")
+	writeFile(t, remoteFile, []byte(content))
+	localFile := path.Join(keys.dir, "local.txt")
+	writeFile(t, localFile, []byte(content))
+
+	cases := []struct {
+		name     string
+		src, dst string
+		check    func(stdout string) error
+	}{
+		{
+			name: "download",
+			src:  scpRemote(remoteFile),
+			dst:  "/dev/stdout",
+			check: func(out string) error {
+				if !strings.Contains(out, content) {
+					return fmt.Errorf("copied file not in output, got %d bytes, expected %d",
+						len(out), len(content))
+				}
+				return nil
+			},
+		},
+		{
+			name: "upload",
+			src:  localFile,
+			dst:  scpRemote(path.Join(keys.dir, "uploaded.txt")),
+			// Client and server run on the same host, the uploaded file is
+			// there once the client has exited.
+			check: func(string) error {
+				uploaded, err := os.ReadFile(path.Join(keys.dir, "uploaded.txt"))
+				if err != nil {
+					return err
+				}
+				if string(uploaded) != content {
+					return fmt.Errorf("uploaded file differs, got %d bytes, expected %d",
+						len(uploaded), len(content))
+				}
+				return os.Remove(path.Join(keys.dir, "uploaded.txt"))
+			},
+		},
+	}
+	for i, tc := range cases {
+		serverPortOffset := 2900 + 100*i
+		t.Run(tc.name, func(t *testing.T) {
+			args := []string{
+				"-O", // legacy protocol, the server has no sftp subsystem
+				"-S", integration.AppBinPath(sshBin),
+				// scp passes -c and -i on to the ssh program, where -c is the
+				// client configuration file.
+				"-c", keys.emptyConfig,
+				"-i", keys.identity,
+				"-o", "StrictHostKeyChecking=no",
+				"-P", integration.ServerPortReplace,
+				tc.src, tc.dst,
+			}
+			in := integration.NewAppsIntegration("scp", integration.AppBinPath(sshdBin), args, keys.serverArgs())
+			in.ClientDelay = 250 * time.Millisecond
+			in.ClientOutMatch = tc.check
+			in.ClientErrMatch = integration.NoPanic
+
+			iaPairs := integration.DefaultIAPairs()
+			integration.AssignUniquePorts(iaPairs, serverPortOffset, 1)
+			if err := in.Run(t, iaPairs); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// scpRemote returns the scp argument for file on the server. The address is
+// bracketed so that scp does not split it at the colons of the IA.
+func scpRemote(file string) string {
+	return "[" + integration.DstIAReplace + ",[" + integration.DstHostReplace + "]]:" + file
+}
+
+// TestIntegrationSSHFailover pins two paths to the server and reports the
+// path in use as down during a session. The connection must switch to the
+// other pinned path and the session must continue on it.
+func TestIntegrationSSHFailover(t *testing.T) {
+	keys := newSSHTestKeys(t)
+
+	pairs := integration.DefaultIAPairs()
+	if len(pairs) == 0 {
+		t.Skip("no IA pairs")
+	}
+	// The pan host context is initialized once, all pairs tried here must
+	// have the same source.
+	src := pairs[0].Src
+	daemon, err := sintegration.GetSCIONDAddress(sintegration.GenFile(sintegration.SCIONDAddressesFile), src.IA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SCION_DAEMON_ADDRESS", daemon)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var dst *snet.UDPAddr
+	var paths []*pan.Path
+	for _, pair := range pairs {
+		if pair.Src.IA != src.IA || pair.Dst.IA == src.IA {
+			continue
+		}
+		paths, err = pan.QueryPaths(ctx, pan.IA(pair.Dst.IA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) >= 2 {
+			dst = pair.Dst
+			break
+		}
+	}
+	if dst == nil {
+		t.Skip("no destination with at least two paths from", src.IA)
+	}
+	integration.AssignUniquePorts([]sintegration.IAPair{{Src: src, Dst: dst}}, 3100, 1)
+
+	in := integration.NewAppsIntegration("", integration.AppBinPath(sshdBin), nil, keys.serverArgs())
+	server, err := sintegration.StartServer(in, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	remote, err := pan.ParseUDPAddr(dst.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dialFailover(ctx, t, keys, remote, paths[:2])
+	defer client.Close()
+
+	before := client.path()
+	if before == nil {
+		t.Fatal("no path in use")
+	}
+	client.run(t, "echo before")
+
+	client.sel.PathDown(before.Fingerprint, pan.PathInterface{})
+	client.run(t, "echo after")
+
+	after := client.path()
+	if after == nil || after.Fingerprint == before.Fingerprint {
+		t.Fatalf("path not switched after path down notification, still using %s", before)
+	}
+	if after.Fingerprint != paths[0].Fingerprint && after.Fingerprint != paths[1].Fingerprint {
+		t.Fatalf("switched to a path that was not pinned: %s", after)
+	}
+}
+
+type failoverClient struct {
+	*ssh.Client
+	sess *pan.QUICSession
+	sel  *failoverSelector
+}
+
+// dialFailover connects to the server over QUIC, like the client does, but
+// using only the given paths.
+func dialFailover(ctx context.Context, t *testing.T, keys sshTestKeys,
+	remote pan.UDPAddr, paths []*pan.Path) *failoverClient {
+
+	t.Helper()
+	pinned := make(pan.Pinned, len(paths))
+	for i, p := range paths {
+		pinned[i] = p.Fingerprint
+	}
+	sel := &failoverSelector{}
+	tlsConf := &tls.Config{
+		NextProtos:         []string{quicutil.SingleStreamProto},
+		InsecureSkipVerify: true,
+	}
+	sess, err := pan.DialQUIC(ctx, remote, "", tlsConf, nil,
+		pan.WithPolicy(pinned), pan.WithSelector(sel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := quicutil.NewSingleStream(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := os.ReadFile(keys.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localUser, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ClientConfig{
+		User:            localUser.Username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(stream, remote.String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &failoverClient{
+		Client: ssh.NewClient(conn, chans, reqs),
+		sess:   sess,
+		sel:    sel,
+	}
+}
+
+func (c *failoverClient) path() *pan.Path {
+	return c.sess.Conn.GetPath()
+}
+
+// run runs the echo command in a new session and checks its output.
+func (c *failoverClient) run(t *testing.T, command string) {
+	t.Helper()
+	session, err := c.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	out, err := session.Output(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.TrimPrefix(command, "echo ") + "\n"
+	if string(out) != expected {
+		t.Fatalf("unexpected output of %q: %q", command, out)
+	}
+}
+
+// failoverSelector uses the first path until it is notified down, then
+// switches to the next one.
+type failoverSelector struct {
+	mutex   sync.Mutex
+	paths   []*pan.Path
+	current int
+}
+
+func (s *failoverSelector) Path() *pan.Path {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.paths) == 0 {
+		return nil
+	}
+	return s.paths[s.current]
+}
+
+func (s *failoverSelector) Initialize(local, remote pan.UDPAddr, paths []*pan.Path) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.paths = paths
+	s.current = 0
+}
+
+func (s *failoverSelector) Refresh(paths []*pan.Path) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Keep the current path if it is still available.
+	var current pan.PathFingerprint
+	if len(s.paths) > 0 {
+		current = s.paths[s.current].Fingerprint
+	}
+	s.paths = paths
+	s.current = 0
+	for i, p := range paths {
+		if p.Fingerprint == current {
+			s.current = i
+		}
+	}
+}
+
+func (s *failoverSelector) PathDown(pf pan.PathFingerprint, pi pan.PathInterface) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.paths) == 0 || s.paths[s.current].Fingerprint != pf {
+		return
+	}
+	s.current = (s.current + 1) % len(s.paths)
+}
+
+func (s *failoverSelector) Close() error {
+	return nil
+}
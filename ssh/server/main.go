@@ -94,7 +94,7 @@ func main() {
 		Certificates: quicutil.MustGenerateSelfSignedCert(),
 		NextProtos:   []string{quicutil.SingleStreamProto},
 	}
-	ql, err := pan.ListenQUIC(context.Background(), local, nil, tlsConf, nil)
+	ql, err := pan.ListenQUIC(context.Background(), tlsConf, nil, pan.WithLocalAddr(local))
 	if err != nil {
 		golog.Panicf("Failed to listen (%v)", err)
 	}
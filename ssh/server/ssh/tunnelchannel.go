@@ -21,7 +21,6 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"net/netip"
 	"sync"
 
 	"golang.org/x/crypto/ssh"
@@ -91,7 +90,7 @@ func handleSCIONQUICTunnel(perms *ssh.Permissions, newChannel ssh.NewChannel) er
 		NextProtos:         []string{quicutil.SingleStreamProto},
 		InsecureSkipVerify: true,
 	}
-	sess, err := pan.DialQUIC(ctx, netip.AddrPort{}, remote, nil, nil, "", tlsConf, nil)
+	sess, err := pan.DialQUIC(ctx, remote, "", tlsConf, nil)
 	if err != nil {
 		return fmt.Errorf("could not open remote connection: %w", err)
 	}
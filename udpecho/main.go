@@ -0,0 +1,342 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// scion-udp-echo is a load generator for latency measurements over SCION.
+// The client sends requests at a fixed rate to an echo responder and reports
+// latency histograms per path.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+const (
+	// headerLen is the length of the request header: sequence number and send
+	// timestamp (unix nanoseconds).
+	headerLen = 16
+	// maxLatency is the highest latency tracked in the histograms.
+	maxLatency = time.Minute
+	// batchSize is the maximum number of messages read/written by the server
+	// in a single system call.
+	batchSize = 64
+)
+
+func main() {
+	var (
+		listen      pan.IPPortValue
+		remote      string
+		rate        int
+		duration    time.Duration
+		size        int
+		timeout     time.Duration
+		multipath   bool
+		interactive bool
+		sequence    string
+		preference  string
+		sockets     int
+	)
+	flag.Var(&listen, "listen", "[Server] local IP:port to listen on")
+	flag.IntVar(&sockets, "sockets", 1, "[Server] number of sockets sharing the listen port, each served by its own goroutine")
+	flag.StringVar(&remote, "remote", "", "[Client] address of the echo server")
+	flag.IntVar(&rate, "rate", 100, "[Client] requests per second")
+	flag.DurationVar(&duration, "duration", 10*time.Second, "[Client] duration of the test")
+	flag.IntVar(&size, "size", 64, fmt.Sprintf("[Client] size of the requests in bytes, at least %d", headerLen))
+	flag.DurationVar(&timeout, "timeout", time.Second, "[Client] time to wait for outstanding replies")
+	flag.BoolVar(&multipath, "multipath", false, "[Client] send requests round-robin over all paths")
+	flag.BoolVar(&interactive, "interactive", false, "[Client] Prompt user for interactive path selection")
+	flag.StringVar(&sequence, "sequence", "", "[Client] Sequence of space separated hop predicates to specify path")
+	flag.StringVar(&preference, "preference", "", "[Client] Preference sorting order for paths. "+
+		"Comma-separated list of available sorting options: "+
+		strings.Join(pan.AvailablePreferencePolicies, "|"))
+	flag.Parse()
+
+	if (listen.Get().Port() > 0) == (remote != "") {
+		fmt.Fprintln(os.Stderr, "Either specify -listen for server or -remote for client")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if listen.Get().Port() > 0 {
+		err = runServer(listen.Get(), sockets)
+	} else {
+		if rate <= 0 || size < headerLen || duration <= 0 {
+			log.Fatalf("invalid parameters: rate must be positive, size at least %d bytes", headerLen)
+		}
+		var policy pan.Policy
+		policy, err = pan.PolicyFromCommandline(sequence, preference, interactive)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = runClient(remote, policy, multipath, rate, duration, size, timeout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServer echoes all requests back to the sender, on the path on which they
+// were received. With more than one socket, the sockets share the listen port
+// and each is served by its own goroutine.
+func runServer(listen netip.AddrPort, sockets int) error {
+	var conns []pan.ListenConn
+	if sockets > 1 {
+		var err error
+		conns, err = pan.ListenUDPMulti(context.Background(), sockets, pan.WithLocalAddr(listen))
+		if err != nil {
+			return err
+		}
+	} else {
+		conn, err := pan.ListenUDP(context.Background(), pan.WithLocalAddr(listen))
+		if err != nil {
+			return err
+		}
+		conns = []pan.ListenConn{conn}
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	fmt.Println(conns[0].LocalAddr())
+
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn pan.ListenConn) {
+			errs <- serve(conn)
+		}(conn)
+	}
+	return <-errs
+}
+
+// serve echoes the requests received on conn.
+func serve(conn pan.ListenConn) error {
+	msgs := make([]pan.Message, batchSize)
+	for i := range msgs {
+		msgs[i].Buffer = make([]byte, 9000)
+	}
+	replies := make([]pan.Message, batchSize)
+	for {
+		n, err := conn.ReadBatch(msgs)
+		if err != nil {
+			return err
+		}
+		for i, m := range msgs[:n] {
+			replies[i] = pan.Message{Buffer: m.Buffer[:m.N], Addr: m.Addr, Path: m.Path}
+		}
+		if _, err := conn.WriteBatch(replies[:n]); err != nil {
+			log.Println("error writing replies:", err)
+		}
+	}
+}
+
+// pathResult collects the results for one path.
+type pathResult struct {
+	path      *pan.Path
+	sent      int64
+	histogram *hdrhistogram.Histogram
+}
+
+// request is an outstanding request.
+type request struct {
+	sent time.Time
+	path *pathResult
+}
+
+type client struct {
+	conn      pan.Conn
+	scheduler *pan.RoundRobinScheduler // nil if not in multipath mode
+
+	mutex       sync.Mutex
+	results     map[pan.PathFingerprint]*pathResult
+	order       []*pathResult
+	outstanding map[uint64]request
+}
+
+func runClient(remote string, policy pan.Policy, multipath bool,
+	rate int, duration time.Duration, size int, timeout time.Duration) error {
+
+	remoteAddr, err := pan.ResolveUDPAddr(context.Background(), remote)
+	if err != nil {
+		return err
+	}
+	c := &client{
+		results:     make(map[pan.PathFingerprint]*pathResult),
+		outstanding: make(map[uint64]request),
+	}
+	if multipath {
+		c.scheduler = pan.NewRoundRobinScheduler()
+		c.conn, err = pan.DialMultiPathUDP(context.Background(), remoteAddr, c.scheduler, pan.WithPolicy(policy))
+	} else {
+		c.conn, err = pan.DialUDP(context.Background(), remoteAddr, pan.WithPolicy(policy))
+	}
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.receive()
+	}()
+
+	if err := c.send(rate, duration, size); err != nil {
+		return err
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	<-done
+
+	c.report(os.Stdout)
+	return nil
+}
+
+// send sends requests at the given rate, for the given duration.
+func (c *client) send(rate int, duration time.Duration, size int) error {
+	buf := make([]byte, size)
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	end := time.Now().Add(duration)
+	for seq := uint64(0); time.Now().Before(end); seq++ {
+		<-ticker.C
+		var path *pan.Path
+		if c.scheduler != nil {
+			if paths := c.scheduler.Schedule(); len(paths) > 0 {
+				path = paths[0]
+			}
+		} else {
+			path = c.conn.GetPath()
+		}
+		now := time.Now()
+		binary.BigEndian.PutUint64(buf[0:8], seq)
+		binary.BigEndian.PutUint64(buf[8:16], uint64(now.UnixNano()))
+
+		c.mutex.Lock()
+		r := c.result(path)
+		r.sent++
+		c.outstanding[seq] = request{sent: now, path: r}
+		c.mutex.Unlock()
+
+		var err error
+		if path != nil {
+			_, err = c.conn.WriteVia(path, buf)
+		} else {
+			_, err = c.conn.Write(buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive reads replies until the read deadline expires.
+func (c *client) receive() {
+	buf := make([]byte, 9000)
+	for {
+		n, err := c.conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		} else if err != nil {
+			log.Println("error reading reply:", err)
+			continue
+		}
+		if n < headerLen {
+			continue
+		}
+		now := time.Now()
+		seq := binary.BigEndian.Uint64(buf[0:8])
+
+		c.mutex.Lock()
+		if req, ok := c.outstanding[seq]; ok {
+			delete(c.outstanding, seq)
+			_ = req.path.histogram.RecordValue(now.Sub(req.sent).Microseconds())
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// result returns the pathResult for path, creating it if necessary. Must be
+// called with the mutex held.
+func (c *client) result(path *pan.Path) *pathResult {
+	var pf pan.PathFingerprint
+	if path != nil {
+		pf = path.Fingerprint
+	}
+	r, ok := c.results[pf]
+	if !ok {
+		r = &pathResult{
+			path:      path,
+			histogram: hdrhistogram.New(1, maxLatency.Microseconds(), 3),
+		}
+		c.results[pf] = r
+		c.order = append(c.order, r)
+	}
+	return r
+}
+
+func (c *client) report(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	total := hdrhistogram.New(1, maxLatency.Microseconds(), 3)
+	var totalSent int64
+	for _, r := range c.order {
+		name := "(local AS)"
+		if r.path != nil {
+			name = r.path.String()
+		}
+		fmt.Fprintf(w, "Path %s\n", name)
+		printHistogram(w, r.sent, r.histogram)
+		total.Merge(r.histogram)
+		totalSent += r.sent
+	}
+	if len(c.order) > 1 {
+		fmt.Fprintln(w, "Total")
+		printHistogram(w, totalSent, total)
+	}
+}
+
+func printHistogram(w io.Writer, sent int64, h *hdrhistogram.Histogram) {
+	received := h.TotalCount()
+	loss := 0.0
+	if sent > 0 {
+		loss = 100 * float64(sent-received) / float64(sent)
+	}
+	fmt.Fprintf(w, "  sent %d, received %d, loss %.2f%%\n", sent, received, loss)
+	if received == 0 {
+		return
+	}
+	us := func(v int64) time.Duration { return time.Duration(v) * time.Microsecond }
+	fmt.Fprintf(w, "  latency min %v, mean %v, max %v\n",
+		us(h.Min()), us(int64(h.Mean())), us(h.Max()))
+	for _, q := range []float64{50, 90, 99, 99.9} {
+		fmt.Fprintf(w, "  p%-5g %v\n", q, us(h.ValueAtQuantile(q)))
+	}
+}
@@ -56,6 +56,7 @@ var CMD_WEB = "srvroot"
 var CMD_BRT = "r"
 var CMD_SCG = "sgen"
 var CMD_SCC = "sgenc"
+var CMD_WHK = "webhook"
 
 // appsRoot is the root location of scionlab apps.
 var GOPATH = os.Getenv("GOPATH")
@@ -86,6 +87,7 @@ type CmdOptions struct {
 	BrowseRoot    string
 	ScionGen      string
 	ScionGenCache string
+	Webhook       string
 }
 
 func (o *CmdOptions) AbsPathCmdOptions() {
@@ -133,6 +135,8 @@ func ParseFlags() CmdOptions {
 		"Path to read SCION gen directory of infrastructure config")
 	scionGenCache := flag.String(CMD_SCC, defaultScionGenCache(),
 		"Path to read SCION gen-cache directory of infrastructure run-time config")
+	webhook := flag.String(CMD_WHK, "",
+		"URL to which the outcomes of the tests and path down events are posted as JSON")
 	flag.Parse()
 	// recompute root args to use the proper relative defaults if undefined
 	if !isFlagUsed(CMD_WEB) {
@@ -147,7 +151,7 @@ func ParseFlags() CmdOptions {
 	if !isFlagUsed(CMD_SCC) {
 		*scionGenCache = defaultScionGenCache()
 	}
-	options := CmdOptions{*addr, *port, *staticRoot, *browseRoot, *scionGen, *scionGenCache}
+	options := CmdOptions{*addr, *port, *staticRoot, *browseRoot, *scionGen, *scionGenCache, *webhook}
 	options.AbsPathCmdOptions()
 	return options
 }
@@ -0,0 +1,231 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	model "github.com/netsec-ethz/scion-apps/webapp/models"
+)
+
+// webhookQueueLen is the number of outcomes queued for the webhook; further
+// outcomes are dropped while the webhook does not keep up.
+const webhookQueueLen = 100
+
+var webhookTimeout = 5 * time.Second
+
+// Outcome is the outcome of a test run or an event, as posted to the webhook.
+type Outcome struct {
+	Time     time.Time          `json:"time"`
+	Kind     string             `json:"kind"` // bwtester, echo, traceroute or pathdown
+	ClientIA string             `json:"client_ia,omitempty"`
+	ServerIA string             `json:"server_ia,omitempty"`
+	Server   string             `json:"server,omitempty"`
+	Path     string             `json:"path,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Values   map[string]float64 `json:"values,omitempty"`
+}
+
+// Exporter exports the outcomes of the test runs, and the path down events
+// of the paths probed by webapp, as OpenMetrics metrics and to a webhook.
+type Exporter struct {
+	registry      *prometheus.Registry
+	runs          *prometheus.CounterVec
+	bwThroughput  *prometheus.GaugeVec
+	echoRTT       *prometheus.GaugeVec
+	echoLoss      *prometheus.GaugeVec
+	pathDownTotal prometheus.Counter
+
+	webhook string
+	queue   chan Outcome
+}
+
+// NewExporter creates an Exporter posting the outcomes to the webhook URL,
+// unless it is empty.
+func NewExporter(webhook string) *Exporter {
+	e := &Exporter{
+		registry: prometheus.NewRegistry(),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webapp_test_runs_total",
+			Help: "Test runs, per app, server and result.",
+		}, []string{"app", "server", "result"}),
+		bwThroughput: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "webapp_bwtest_throughput_bps",
+			Help: "Achieved throughput of the last bwtest, per server and direction.",
+		}, []string{"server", "direction"}),
+		echoRTT: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "webapp_echo_rtt_seconds",
+			Help: "Response time of the last echo, per server.",
+		}, []string{"server"}),
+		echoLoss: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "webapp_echo_packet_loss_ratio",
+			Help: "Packet loss of the last echo, per server.",
+		}, []string{"server"}),
+		pathDownTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "webapp_path_down_events_total",
+			Help: "SCMP path down notifications received on the paths probed by webapp.",
+		}),
+		webhook: webhook,
+	}
+	e.registry.MustRegister(e.runs, e.bwThroughput, e.echoRTT, e.echoLoss, e.pathDownTotal)
+	if webhook != "" {
+		e.queue = make(chan Outcome, webhookQueueLen)
+		go e.postOutcomes()
+	}
+	return e
+}
+
+// MetricsHandler serves the metrics in the OpenMetrics or Prometheus text
+// format, as negotiated.
+func (e *Exporter) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// WatchPathEvents exports the path down events until ctx is done.
+func (e *Exporter) WatchPathEvents(ctx context.Context) error {
+	events, err := pan.SubscribeEvents(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for ev := range events {
+			if ev.Type != pan.EventPathDown {
+				continue
+			}
+			e.pathDownTotal.Inc()
+			e.notify(Outcome{
+				Time:     ev.Time,
+				Kind:     "pathdown",
+				ServerIA: ev.Destination.String(),
+				Path:     string(ev.Fingerprint),
+				Error:    fmt.Sprintf("interface %s#%d down", ev.Interface.IA, ev.Interface.IfID),
+			})
+		}
+	}()
+	return nil
+}
+
+// ExportBwTest exports the outcome of a bwtest run.
+func (e *Exporter) ExportBwTest(d model.BwTestItem) {
+	server := fmt.Sprintf("%s,%s", d.SIa, d.SAddr)
+	e.runs.WithLabelValues("bwtester", server, result(d.Error)).Inc()
+	if d.Error == "" {
+		e.bwThroughput.WithLabelValues(server, "cs").Set(float64(d.CSThroughput))
+		e.bwThroughput.WithLabelValues(server, "sc").Set(float64(d.SCThroughput))
+	}
+	e.notify(Outcome{
+		Time:     time.UnixMilli(d.Inserted),
+		Kind:     "bwtester",
+		ClientIA: d.CIa,
+		ServerIA: d.SIa,
+		Server:   server,
+		Path:     d.Path,
+		Error:    d.Error,
+		Values: map[string]float64{
+			"cs_bandwidth_bps":  float64(d.CSBandwidth),
+			"cs_throughput_bps": float64(d.CSThroughput),
+			"sc_bandwidth_bps":  float64(d.SCBandwidth),
+			"sc_throughput_bps": float64(d.SCThroughput),
+		},
+	})
+}
+
+// ExportEcho exports the outcome of an echo run.
+func (e *Exporter) ExportEcho(d model.EchoItem) {
+	server := fmt.Sprintf("%s,%s", d.SIa, d.SAddr)
+	e.runs.WithLabelValues("echo", server, result(d.Error)).Inc()
+	if d.Error == "" {
+		e.echoRTT.WithLabelValues(server).Set(float64(d.ResponseTime) / 1000)
+		e.echoLoss.WithLabelValues(server).Set(float64(d.PktLoss) / 100)
+	}
+	e.notify(Outcome{
+		Time:     time.UnixMilli(d.Inserted),
+		Kind:     "echo",
+		ClientIA: d.CIa,
+		ServerIA: d.SIa,
+		Server:   server,
+		Path:     d.Path,
+		Error:    d.Error,
+		Values: map[string]float64{
+			"rtt_ms":          float64(d.ResponseTime),
+			"packet_loss_pct": float64(d.PktLoss),
+		},
+	})
+}
+
+// ExportTraceroute exports the outcome of a traceroute run.
+func (e *Exporter) ExportTraceroute(d model.TracerouteItem) {
+	server := fmt.Sprintf("%s,%s", d.SIa, d.SAddr)
+	e.runs.WithLabelValues("traceroute", server, result(d.Error)).Inc()
+	e.notify(Outcome{
+		Time:     time.UnixMilli(d.Inserted),
+		Kind:     "traceroute",
+		ClientIA: d.CIa,
+		ServerIA: d.SIa,
+		Server:   server,
+		Path:     d.Path,
+		Error:    d.Error,
+	})
+}
+
+func result(err string) string {
+	if err != "" {
+		return "error"
+	}
+	return "ok"
+}
+
+// notify queues the outcome for the webhook, if any.
+func (e *Exporter) notify(o Outcome) {
+	if e.queue == nil {
+		return
+	}
+	select {
+	case e.queue <- o:
+	default:
+		log.Warn("Webhook queue full, dropping outcome", "kind", o.Kind)
+	}
+}
+
+// postOutcomes posts the queued outcomes to the webhook, one at a time.
+func (e *Exporter) postOutcomes() {
+	client := &http.Client{Timeout: webhookTimeout}
+	for o := range e.queue {
+		body, err := json.Marshal(o)
+		if err != nil {
+			log.Error("Encoding webhook outcome", "err", err)
+			continue
+		}
+		resp, err := client.Post(e.webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Warn("Posting to webhook", "err", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Warn("Webhook rejected outcome", "status", resp.Status)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2024 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/scionproto/scion/pkg/addr"
+	"github.com/scionproto/scion/pkg/private/common"
+	"github.com/scionproto/scion/pkg/snet"
+	snetpath "github.com/scionproto/scion/pkg/snet/path"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	. "github.com/netsec-ethz/scion-apps/webapp/util"
+)
+
+// probeRounds is the number of traceroute and echo requests sent per probe.
+const probeRounds = 3
+
+// probeRoundTimeout is the time to wait for the replies of one round.
+const probeRoundTimeout = time.Second
+
+// ProbeHop holds the latency and loss to one interface on a probed path.
+type ProbeHop struct {
+	IA   string
+	IfID uint64
+	// RTT is the average round trip time in ms of the replies, -1 if none
+	// were received.
+	RTT float32
+	// Loss is the fraction of requests without a reply.
+	Loss float32
+}
+
+// PathProbe is the result of probing a path, see PathProbeHandler.
+type PathProbe struct {
+	Fingerprint string
+	Hops        []ProbeHop
+	// RTT and Loss are the round trip time and loss of the echo requests to
+	// the destination host.
+	RTT  float32
+	Loss float32
+	// Inserted is the time of the probe, in ms since epoch.
+	Inserted int64
+}
+
+// pathProber holds the pinger used to probe paths. The pinger is bound to the
+// local AS of the SCION daemon it was opened with, so only paths from this AS
+// can be probed.
+type pathProber struct {
+	mutex  sync.Mutex
+	ia     addr.IA
+	pinger *pan.Pinger
+}
+
+var prober pathProber
+
+// get returns the pinger for the local AS localIA, opening it with the SCION
+// daemon at sciondAddress if needed.
+func (p *pathProber) get(localIA addr.IA, sciondAddress string) (*pan.Pinger, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.pinger != nil {
+		if p.ia != localIA {
+			return nil, fmt.Errorf("path probing is only available from %s", p.ia)
+		}
+		return p.pinger, nil
+	}
+	// pan exits if the SCION daemon is not reachable, check it first.
+	sciondConn, err := connect(sciondAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer sciondConn.Close()
+	ia, err := sciondConn.LocalIA(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if ia != localIA {
+		return nil, fmt.Errorf("SCION daemon %s is in %s, not in %s", sciondAddress, ia, localIA)
+	}
+	if len(sciondAddress) > 0 {
+		os.Setenv("SCION_DAEMON_ADDRESS", sciondAddress)
+	}
+	pinger, err := pan.NewPinger(context.Background(), netip.AddrPort{})
+	if err != nil {
+		return nil, err
+	}
+	p.ia, p.pinger = localIA, pinger
+	return pinger, nil
+}
+
+// PathProbeHandler probes the path with the given fingerprint from the client
+// IA to the server host, with SCMP traceroute requests to each interface on
+// the path and echo requests to the host, and returns the latency and loss
+// per interface.
+func PathProbeHandler(w http.ResponseWriter, r *http.Request, cfg ASConfigs) {
+	r.ParseForm()
+	CIa := r.PostFormValue("ia_cli")
+	SIa := r.PostFormValue("ia_ser")
+	SAddr := r.PostFormValue("addr_ser")
+	fingerprint := r.PostFormValue("fingerprint")
+
+	localIA, err := addr.ParseIA(CIa)
+	if CheckError(err) {
+		returnError(w, err)
+		return
+	}
+	remoteIA, err := pan.ParseIA(SIa)
+	if CheckError(err) {
+		returnError(w, err)
+		return
+	}
+	remoteIP, err := netip.ParseAddr(SAddr)
+	if CheckError(err) {
+		returnError(w, err)
+		return
+	}
+	pinger, err := prober.get(localIA, cfg[CIa].Sciond)
+	if CheckError(err) {
+		returnError(w, err)
+		return
+	}
+	path, err := findPath(r.Context(), remoteIA, fingerprint)
+	if CheckError(err) {
+		returnError(w, err)
+		return
+	}
+	remote := pan.UDPAddr{IA: remoteIA, IP: remoteIP}
+	probe := probePath(r.Context(), pinger, remote, path)
+	probe.Fingerprint = fingerprint
+	log.Debug("PathProbeHandler:", "probe", probe)
+
+	probeJSON, err := json.Marshal(probe)
+	if CheckError(err) {
+		returnError(w, err)
+		return
+	}
+	fmt.Fprint(w, string(probeJSON))
+}
+
+// findPath returns the path to dst with the fingerprint, as returned by
+// PathTopoHandler.
+func findPath(ctx context.Context, dst pan.IA, fingerprint string) (*pan.Path, error) {
+	paths, err := pan.QueryPaths(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		if p.Metadata != nil && pathFingerprint(p.Metadata.Interfaces) == fingerprint {
+			return p, nil
+		}
+	}
+	return nil, errors.New("path not found, update the paths")
+}
+
+// pathFingerprint returns the fingerprint of the path with the interfaces, in
+// the format used by getPathsJSON.
+func pathFingerprint(interfaces []pan.PathInterface) string {
+	var p snetpath.Path
+	for _, intf := range interfaces {
+		p.Meta.Interfaces = append(p.Meta.Interfaces, snet.PathInterface{
+			IA: addr.IA(intf.IA),
+			ID: common.IFIDType(intf.IfID),
+		})
+	}
+	fp := snet.Fingerprint(p).String()
+	if len(fp) > 16 {
+		fp = fp[:16]
+	}
+	return fp
+}
+
+// probePath sends probeRounds rounds of traceroute and echo requests over the
+// path and summarizes the replies.
+func probePath(ctx context.Context, pinger *pan.Pinger, remote pan.UDPAddr, path *pan.Path) PathProbe {
+	var hopRounds [][]pan.TracerouteHop
+	var pings []pan.PingResult
+	for i := 0; i < probeRounds; i++ {
+		roundCtx, cancel := context.WithTimeout(ctx, probeRoundTimeout)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pings = append(pings, pinger.Ping(roundCtx, remote, path, 0))
+		}()
+		hops, err := pinger.Traceroute(roundCtx, remote, path)
+		wg.Wait()
+		cancel()
+		if err != nil {
+			log.Error("Traceroute failed", "err", err)
+			continue
+		}
+		hopRounds = append(hopRounds, hops)
+	}
+	probe := summarizeProbes(path.Metadata.Interfaces, hopRounds, pings)
+	probe.Inserted = time.Now().UnixNano() / 1e6
+	return probe
+}
+
+// summarizeProbes returns the average round trip time and the loss for each
+// interface and for the destination host.
+func summarizeProbes(interfaces []pan.PathInterface, hopRounds [][]pan.TracerouteHop,
+	pings []pan.PingResult) PathProbe {
+
+	var probe PathProbe
+	for i, intf := range interfaces {
+		var rtts []time.Duration
+		for _, hops := range hopRounds {
+			if i < len(hops) && hops[i].Err == nil {
+				rtts = append(rtts, hops[i].RTT)
+			}
+		}
+		rtt, loss := rttLoss(rtts, len(hopRounds))
+		probe.Hops = append(probe.Hops, ProbeHop{
+			IA:   intf.IA.String(),
+			IfID: uint64(intf.IfID),
+			RTT:  rtt,
+			Loss: loss,
+		})
+	}
+	var rtts []time.Duration
+	for _, p := range pings {
+		if p.Err == nil {
+			rtts = append(rtts, p.RTT)
+		}
+	}
+	probe.RTT, probe.Loss = rttLoss(rtts, len(pings))
+	return probe
+}
+
+// rttLoss returns the average of the round trip times in ms, or -1 if there
+// are none, and the fraction of the sent requests without a reply.
+func rttLoss(rtts []time.Duration, sent int) (float32, float32) {
+	if sent == 0 {
+		return -1, 1
+	}
+	loss := float32(sent-len(rtts)) / float32(sent)
+	if len(rtts) == 0 {
+		return -1, loss
+	}
+	var sum time.Duration
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	return float32(sum.Nanoseconds()) / float32(len(rtts)) / 1e6, loss
+}
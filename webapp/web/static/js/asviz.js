@@ -55,12 +55,18 @@ function setPaths(type, idx, open) {
             addSegments(resUp, idx, num, colorSegUp, type);
         } else if (type == 'PATH') {
             addPaths(resPath, idx, num, colorPaths, type);
+            if (typeof startPathProbe === 'function') {
+                startPathProbe(idx);
+            }
         }
         self.segType = type;
         self.segNum = idx;
     } else {
         console.log(type + idx + ' closed');
         removePaths();
+        if (typeof stopPathProbe === 'function') {
+            stopPathProbe();
+        }
         self.segType = undefined;
         self.segNum = undefined;
     }
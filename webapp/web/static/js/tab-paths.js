@@ -18,6 +18,9 @@ var iaLocations = [];
 var iaGeoLoc;
 var g = {};
 var jPathColors = [];
+var jPaths = [];
+var probeTimer;
+var probeInterval = 5000;
 
 function setupDebug(src, dst) {
     var src = $('#ia_cli').val();
@@ -696,6 +699,9 @@ function requestPaths() {
                 }
             }
 
+            jPaths = data.paths || [];
+            stopPathProbe();
+
             jTopo = get_json_path_links(resPath, resCore, resUp, resDown);
             $('#path-info').html(
                     get_path_html(data.paths, resCore, resUp, resDown, true));
@@ -748,3 +754,87 @@ function loadPathData(src, dst) {
     // load path topology
     handleMapTopologySwitch(true);
 }
+
+/*
+ * Probes the path with index idx with traceroute and echo requests, refreshed
+ * every probeInterval until stopPathProbe is called.
+ */
+function startPathProbe(idx) {
+    stopPathProbe();
+    var entry = jPaths[idx];
+    if (!entry) {
+        return;
+    }
+    $('#path-probe').html(
+            `<span class='badge'>Probing PATH ${idx + 1}...</span>`);
+    requestPathProbe(idx, entry.Fingerprint);
+    probeTimer = setInterval(function() {
+        requestPathProbe(idx, entry.Fingerprint);
+    }, probeInterval);
+}
+
+function stopPathProbe() {
+    if (probeTimer) {
+        clearInterval(probeTimer);
+        probeTimer = undefined;
+    }
+    $('#path-probe').empty();
+}
+
+function requestPathProbe(idx, fingerprint) {
+    var form_data = $('#command-form').serializeArray();
+    form_data.push({
+        name : 'fingerprint',
+        value : fingerprint
+    });
+    $.ajax({
+        url : 'probepath',
+        type : 'post',
+        dataType : "json",
+        data : form_data,
+        success : function(data, textStatus, jqXHR) {
+            if (!probeTimer) {
+                return; // probing stopped meanwhile
+            }
+            if (data.err) {
+                $('#path-probe').html(
+                        `<span class='badge'>PATH ${idx + 1}: ${data.err}</span>`);
+                return;
+            }
+            $('#path-probe').html(get_probe_html(idx, data));
+        },
+        error : function(jqXHR, textStatus, errorThrown) {
+            showError(this.url + ' ' + textStatus + ': ' + errorThrown);
+        },
+    });
+}
+
+function formatProbeRtt(rtt) {
+    return rtt < 0 ? '-' : rtt.toFixed(1) + ' ms';
+}
+
+function formatProbeLoss(loss) {
+    return Math.round(loss * 100) + '%';
+}
+
+function get_probe_html(idx, probe) {
+    var hcolor = getPathColor(formatPathJson(jPaths, idx));
+    var style = `style='background-color: ${hcolor}; '`;
+    var updated = new Date(probe.Inserted);
+    var html = `<span ${style} class='path-text badge'>PATH ${idx + 1}</span>
+        <span class='badge'>${updated.toLocaleTimeString()}</span>
+        <table class='path-probe-table'>
+        <tr><th>#</th><th>IA</th><th>IfID</th><th>RTT</th><th>Loss</th></tr>`;
+    for (var i = 0; i < probe.Hops.length; i++) {
+        var hop = probe.Hops[i];
+        html += `<tr><td>${i + 1}</td><td>${hop.IA}</td><td>${hop.IfID}</td>
+            <td>${formatProbeRtt(hop.RTT)}</td>
+            <td>${formatProbeLoss(hop.Loss)}</td></tr>`;
+    }
+    html += `<tr><td></td><td>${$('#ia_ser').val()}</td>
+        <td>${$('#addr_ser').val()}</td>
+        <td>${formatProbeRtt(probe.RTT)}</td>
+        <td>${formatProbeLoss(probe.Loss)}</td></tr>`;
+    html += `</table>`;
+    return html;
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -89,6 +90,9 @@ type Page struct {
 
 var options lib.CmdOptions
 
+// exporter exports the test outcomes as metrics and to the webhook
+var exporter *lib.Exporter
+
 func ensurePath(srcpath, staticDir string) string {
 	dir := path.Join(srcpath, staticDir)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -156,6 +160,10 @@ func main() {
 	appsBuildCheck("echo")
 	appsBuildCheck("traceroute")
 
+	exporter = lib.NewExporter(options.Webhook)
+	err = exporter.WatchPathEvents(context.Background())
+	CheckError(err)
+
 	initServeHandlers()
 	log.Info(fmt.Sprintf("Browser access: at http://%s:%d.", options.Addr, options.Port))
 	checkPath(options.BrowseRoot)
@@ -207,6 +215,7 @@ func initServeHandlers() {
 	http.HandleFunc("/gettraceroutebytime", getTracerouteByTimeHandler)
 	http.HandleFunc("/getias", getIAsHandler)
 	http.HandleFunc("/setuseropt", setUserOptionsHandler)
+	http.Handle("/metrics", exporter.MetricsHandler())
 
 	//ported from scion-viz
 	http.HandleFunc("/config", lib.ConfigHandler)
@@ -214,6 +223,7 @@ func initServeHandlers() {
 	http.HandleFunc("/locations", lib.LocationsHandler)
 	http.HandleFunc("/geolocate", lib.GeolocateHandler)
 	http.HandleFunc("/getpathtopo", getPathInfoHandler)
+	http.HandleFunc("/probepath", probePathHandler)
 	http.HandleFunc("/getastopo", getAsTopoHandler)
 	http.HandleFunc("/gettrc", getTrcInfoHandler)
 }
@@ -629,6 +639,7 @@ func writeCmdOutput(w http.ResponseWriter, reader io.Reader, stdin io.WriteClose
 			d.Error = err.Error()
 		}
 		lib.WriteCmdCsv(d, &options, appSel)
+		exporter.ExportBwTest(d)
 	}
 
 	if appSel == "echo" {
@@ -648,6 +659,7 @@ func writeCmdOutput(w http.ResponseWriter, reader io.Reader, stdin io.WriteClose
 			d.Error = err.Error()
 		}
 		lib.WriteCmdCsv(d, &options, appSel)
+		exporter.ExportEcho(d)
 	}
 
 	if appSel == "traceroute" {
@@ -667,6 +679,7 @@ func writeCmdOutput(w http.ResponseWriter, reader io.Reader, stdin io.WriteClose
 			d.Error = err.Error()
 		}
 		lib.WriteCmdCsv(d, &options, appSel)
+		exporter.ExportTraceroute(d)
 	}
 }
 
@@ -694,6 +707,10 @@ func getPathInfoHandler(w http.ResponseWriter, r *http.Request) {
 	lib.PathTopoHandler(w, r, &options, asCfg)
 }
 
+func probePathHandler(w http.ResponseWriter, r *http.Request) {
+	lib.PathProbeHandler(w, r, asCfg)
+}
+
 func getAsTopoHandler(w http.ResponseWriter, r *http.Request) {
 	lib.AsTopoHandler(w, r, &options, asCfg)
 }